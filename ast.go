@@ -0,0 +1,393 @@
+package mark2pdf
+
+import "strings"
+
+// Position indica la posizione di un nodo nel sorgente Markdown.
+// Linee e colonne partono da 1; i nodi creati da codice hanno posizione zero.
+type Position struct {
+	StartLine   int
+	StartColumn int
+	EndLine     int
+	EndColumn   int
+}
+
+// Pos restituisce la posizione del nodo
+func (p Position) Pos() Position {
+	return p
+}
+
+// Node è un nodo dell'AST del documento
+type Node interface {
+	Pos() Position
+	Children() []Node
+}
+
+// Block è un nodo di blocco: paragrafi, titoli, liste, tabelle, ...
+type Block interface {
+	Node
+	isBlock()
+}
+
+// Inline è un nodo inline: testo, enfasi, link, immagini, ...
+type Inline interface {
+	Node
+	isInline()
+}
+
+// Alignment è l'allineamento di una colonna di tabella
+type Alignment int
+
+// Allineamenti delle colonne
+const (
+	AlignNone Alignment = iota
+	AlignLeft
+	AlignCenter
+	AlignRight
+)
+
+// Document è la radice dell'AST
+type Document struct {
+	Position
+	Blocks []Block
+	Meta   map[string]string // chiavi del front matter YAML (title, lang, ...)
+}
+
+// Paragraph è un paragrafo di testo
+type Paragraph struct {
+	Position
+	Inlines []Inline
+}
+
+// Heading è un titolo di livello 1-6
+type Heading struct {
+	Position
+	Level      int
+	Inlines    []Inline
+	Unnumbered bool // escluso dalla numerazione: {-} o {.unnumbered} alla fine del titolo
+}
+
+// ThematicBreak è una linea orizzontale
+type ThematicBreak struct {
+	Position
+}
+
+// CodeBlock è un blocco di codice, indentato o delimitato da ``` o ~~~
+type CodeBlock struct {
+	Position
+	Info    string // info string dopo il fence di apertura
+	Literal string // contenuto, con "\n" finale
+	Fenced  bool
+}
+
+// HTMLBlock è un blocco di HTML grezzo
+type HTMLBlock struct {
+	Position
+	Literal string
+}
+
+// BlockQuote è una citazione
+type BlockQuote struct {
+	Position
+	Blocks []Block
+}
+
+// List è una lista puntata o numerata
+type List struct {
+	Position
+	Ordered    bool
+	Start      int  // numero del primo item (liste ordinate)
+	Tight      bool // item senza linee vuote tra loro
+	BulletChar byte // '-', '+' o '*' (liste puntate)
+	Delimiter  byte // '.' o ')' (liste ordinate)
+	Items      []*ListItem
+}
+
+// ListItem è un item di lista; Task indica una checkbox GFM
+type ListItem struct {
+	Position
+	Task    bool
+	Checked bool
+	Blocks  []Block
+}
+
+// Table è una tabella GFM; la prima riga è l'intestazione
+type Table struct {
+	Position
+	Align []Alignment
+	Rows  []*TableRow
+}
+
+// TableRow è una riga di tabella
+type TableRow struct {
+	Position
+	Header bool
+	Cells  []*TableCell
+}
+
+// TableCell è una cella di tabella
+type TableCell struct {
+	Position
+	Inlines []Inline
+}
+
+// Text è testo semplice
+type Text struct {
+	Position
+	Value string
+}
+
+// SoftBreak è un a capo nel sorgente, reso come spazio
+type SoftBreak struct {
+	Position
+}
+
+// LineBreak è un a capo forzato (hard line break)
+type LineBreak struct {
+	Position
+}
+
+// CodeSpan è codice inline
+type CodeSpan struct {
+	Position
+	Value string
+}
+
+// Emphasis è testo in corsivo
+type Emphasis struct {
+	Position
+	Inlines []Inline
+}
+
+// Strong è testo in grassetto
+type Strong struct {
+	Position
+	Inlines []Inline
+}
+
+// Strikethrough è testo barrato (GFM)
+type Strikethrough struct {
+	Position
+	Inlines []Inline
+}
+
+// Link è un collegamento; Inlines è il testo del link
+type Link struct {
+	Position
+	Destination string
+	Title       string
+	Inlines     []Inline
+}
+
+// Image è un'immagine; Inlines è la descrizione (testo alternativo)
+type Image struct {
+	Position
+	Destination string
+	Title       string
+	Inlines     []Inline
+}
+
+// HTMLInline è HTML grezzo inline
+type HTMLInline struct {
+	Position
+	Literal string
+}
+
+// ColorSpan è testo colorato ({red}...{/red})
+type ColorSpan struct {
+	Position
+	Color   Color
+	Inlines []Inline
+}
+
+func (*Paragraph) isBlock()     {}
+func (*Heading) isBlock()       {}
+func (*ThematicBreak) isBlock() {}
+func (*CodeBlock) isBlock()     {}
+func (*HTMLBlock) isBlock()     {}
+func (*BlockQuote) isBlock()    {}
+func (*List) isBlock()          {}
+func (*Table) isBlock()         {}
+
+func (*Text) isInline()          {}
+func (*SoftBreak) isInline()     {}
+func (*LineBreak) isInline()     {}
+func (*CodeSpan) isInline()      {}
+func (*Emphasis) isInline()      {}
+func (*Strong) isInline()        {}
+func (*Strikethrough) isInline() {}
+func (*Link) isInline()          {}
+func (*Image) isInline()         {}
+func (*HTMLInline) isInline()    {}
+func (*ColorSpan) isInline()     {}
+
+func blockNodes(blocks []Block) []Node {
+	nodes := make([]Node, len(blocks))
+	for i, b := range blocks {
+		nodes[i] = b
+	}
+	return nodes
+}
+
+func inlineNodes(inlines []Inline) []Node {
+	nodes := make([]Node, len(inlines))
+	for i, in := range inlines {
+		nodes[i] = in
+	}
+	return nodes
+}
+
+// Children restituisce i blocchi del documento
+func (n *Document) Children() []Node { return blockNodes(n.Blocks) }
+
+// Children restituisce gli inline del paragrafo
+func (n *Paragraph) Children() []Node { return inlineNodes(n.Inlines) }
+
+// Children restituisce gli inline del titolo
+func (n *Heading) Children() []Node { return inlineNodes(n.Inlines) }
+
+// Children restituisce nil: la linea non ha figli
+func (n *ThematicBreak) Children() []Node { return nil }
+
+// Children restituisce nil: il contenuto è in Literal
+func (n *CodeBlock) Children() []Node { return nil }
+
+// Children restituisce nil: il contenuto è in Literal
+func (n *HTMLBlock) Children() []Node { return nil }
+
+// Children restituisce i blocchi della citazione
+func (n *BlockQuote) Children() []Node { return blockNodes(n.Blocks) }
+
+// Children restituisce gli item della lista
+func (n *List) Children() []Node {
+	nodes := make([]Node, len(n.Items))
+	for i, item := range n.Items {
+		nodes[i] = item
+	}
+	return nodes
+}
+
+// Children restituisce i blocchi dell'item
+func (n *ListItem) Children() []Node { return blockNodes(n.Blocks) }
+
+// Children restituisce le righe della tabella
+func (n *Table) Children() []Node {
+	nodes := make([]Node, len(n.Rows))
+	for i, row := range n.Rows {
+		nodes[i] = row
+	}
+	return nodes
+}
+
+// Children restituisce le celle della riga
+func (n *TableRow) Children() []Node {
+	nodes := make([]Node, len(n.Cells))
+	for i, cell := range n.Cells {
+		nodes[i] = cell
+	}
+	return nodes
+}
+
+// Children restituisce gli inline della cella
+func (n *TableCell) Children() []Node { return inlineNodes(n.Inlines) }
+
+// Children restituisce nil: il testo è in Value
+func (n *Text) Children() []Node { return nil }
+
+// Children restituisce nil
+func (n *SoftBreak) Children() []Node { return nil }
+
+// Children restituisce nil
+func (n *LineBreak) Children() []Node { return nil }
+
+// Children restituisce nil: il codice è in Value
+func (n *CodeSpan) Children() []Node { return nil }
+
+// Children restituisce gli inline in corsivo
+func (n *Emphasis) Children() []Node { return inlineNodes(n.Inlines) }
+
+// Children restituisce gli inline in grassetto
+func (n *Strong) Children() []Node { return inlineNodes(n.Inlines) }
+
+// Children restituisce gli inline barrati
+func (n *Strikethrough) Children() []Node { return inlineNodes(n.Inlines) }
+
+// Children restituisce il testo del link
+func (n *Link) Children() []Node { return inlineNodes(n.Inlines) }
+
+// Children restituisce la descrizione dell'immagine
+func (n *Image) Children() []Node { return inlineNodes(n.Inlines) }
+
+// Children restituisce nil: il contenuto è in Literal
+func (n *HTMLInline) Children() []Node { return nil }
+
+// Children restituisce gli inline colorati
+func (n *ColorSpan) Children() []Node { return inlineNodes(n.Inlines) }
+
+// Language restituisce il linguaggio del blocco di codice (prima parola dell'info string)
+func (n *CodeBlock) Language() string {
+	if fields := strings.Fields(n.Info); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// WalkStatus indica come proseguire la visita dell'AST
+type WalkStatus int
+
+// Valori restituiti da un Walker
+const (
+	WalkContinue     WalkStatus = iota // visita i figli e prosegue
+	WalkSkipChildren                   // salta i figli del nodo corrente
+	WalkStop                           // interrompe la visita
+)
+
+// Walker è chiamato due volte per ogni nodo: entrando (entering=true) e uscendo
+type Walker func(n Node, entering bool) (WalkStatus, error)
+
+// Walk visita l'AST in profondità a partire da n.
+// Un errore restituito dal Walker interrompe la visita ed è restituito da Walk.
+func Walk(n Node, fn Walker) error {
+	_, err := walk(n, fn)
+	return err
+}
+
+func walk(n Node, fn Walker) (WalkStatus, error) {
+	status, err := fn(n, true)
+	if err != nil || status == WalkStop {
+		return WalkStop, err
+	}
+	if status != WalkSkipChildren {
+		for _, child := range n.Children() {
+			if s, err := walk(child, fn); err != nil || s == WalkStop {
+				return WalkStop, err
+			}
+		}
+	}
+	status, err = fn(n, false)
+	if err != nil || status == WalkStop {
+		return WalkStop, err
+	}
+	return WalkContinue, nil
+}
+
+// TextContent restituisce il testo semplice di un nodo e dei suoi discendenti
+func TextContent(n Node) string {
+	var sb strings.Builder
+	_ = Walk(n, func(n Node, entering bool) (WalkStatus, error) {
+		if !entering {
+			return WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *Text:
+			sb.WriteString(n.Value)
+		case *CodeSpan:
+			sb.WriteString(n.Value)
+		case *CodeBlock:
+			sb.WriteString(n.Literal)
+		case *SoftBreak, *LineBreak:
+			sb.WriteString(" ")
+		}
+		return WalkContinue, nil
+	})
+	return sb.String()
+}
@@ -0,0 +1,111 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSourcePositions(t *testing.T) {
+	doc := NewMarkdownParser("# Title\n\nSome **bold** and\n[link](http://x.y)\n\n- one\n- two\n").Parse()
+
+	heading := doc.Blocks[0].(*Heading)
+	paragraph := doc.Blocks[1].(*Paragraph)
+	list := doc.Blocks[2].(*List)
+
+	tests := []struct {
+		name     string
+		node     Node
+		expected Position
+	}{
+		{"heading", heading, Position{1, 1, 1, 7}},
+		{"heading text", heading.Inlines[0], Position{1, 3, 1, 7}},
+		{"paragraph", paragraph, Position{3, 1, 4, 18}},
+		{"strong", paragraph.Inlines[1], Position{3, 6, 3, 13}},
+		{"strong text", paragraph.Inlines[1].(*Strong).Inlines[0], Position{3, 8, 3, 11}},
+		{"link on second line", paragraph.Inlines[4], Position{4, 1, 4, 18}},
+		{"list", list, Position{6, 1, 7, 5}},
+		{"second item", list.Items[1], Position{7, 1, 7, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.node.Pos(); got != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestWalk(t *testing.T) {
+	doc := NewMarkdownParser("# A *b*\n\n> c\n\nd\n").Parse()
+
+	var entered, exited int
+	err := Walk(doc, func(n Node, entering bool) (WalkStatus, error) {
+		if entering {
+			entered++
+		} else {
+			exited++
+		}
+		return WalkContinue, nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Document, Heading, Text, Emphasis, Text, BlockQuote, Paragraph, Text, Paragraph, Text
+	if entered != 10 || exited != 10 {
+		t.Errorf("Expected 10 nodes entered and exited, got %d and %d", entered, exited)
+	}
+
+	var texts []string
+	Walk(doc, func(n Node, entering bool) (WalkStatus, error) {
+		if _, ok := n.(*BlockQuote); ok {
+			return WalkSkipChildren, nil
+		}
+		if text, ok := n.(*Text); ok && entering {
+			texts = append(texts, text.Value)
+		}
+		return WalkContinue, nil
+	})
+	if len(texts) != 3 || texts[2] != "d" {
+		t.Errorf("Expected blockquote children to be skipped, got %q", texts)
+	}
+
+	stop := errors.New("stop")
+	visited := 0
+	err = Walk(doc, func(n Node, entering bool) (WalkStatus, error) {
+		visited++
+		if _, ok := n.(*Emphasis); ok {
+			return WalkStop, stop
+		}
+		return WalkContinue, nil
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("Expected walker error to be returned, got %v", err)
+	}
+	if visited != 5 {
+		t.Errorf("Expected walk to stop after 5 visits, got %d", visited)
+	}
+}
+
+func TestConvertFromAST(t *testing.T) {
+	doc := &Document{Blocks: []Block{
+		&Heading{Level: 1, Inlines: []Inline{&Text{Value: "Generated"}}},
+		&Paragraph{Inlines: []Inline{
+			&Text{Value: "Built "},
+			&Strong{Inlines: []Inline{&Text{Value: "without"}}},
+			&Text{Value: " Markdown"},
+		}},
+		&List{Items: []*ListItem{
+			{Blocks: []Block{&Paragraph{Inlines: []Inline{&Text{Value: "item"}}}}},
+		}},
+	}}
+
+	data, err := NewConverterFromAST(doc).Convert()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		t.Errorf("Expected a PDF document, got %q", data[:min(len(data), 8)])
+	}
+}
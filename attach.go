@@ -0,0 +1,196 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Relazioni di un allegato con il documento (/AFRelationship di PDF/A-3)
+const (
+	RelationSource      = "Source"      // file da cui il documento è prodotto, ad esempio il Markdown
+	RelationData        = "Data"        // dati rappresentati nel documento, ad esempio una tabella CSV
+	RelationAlternative = "Alternative" // altra rappresentazione del contenuto
+	RelationSupplement  = "Supplement"  // rappresentazione aggiuntiva, ad esempio per l'accessibilità
+	RelationUnspecified = "Unspecified"
+)
+
+// Attachment è un file incorporato nel PDF, che il lettore elenca tra gli allegati
+type Attachment struct {
+	Name         string    // nome del file, ad esempio "offerta.md"
+	Data         []byte    // contenuto del file
+	MIMEType     string    // tipo MIME; "" = dedotto dall'estensione del nome
+	Description  string    // descrizione mostrata dal lettore
+	Modified     time.Time // data di modifica; zero = la data del documento, se c'è
+	Relationship string    // relazione con il documento (RelationSource, ...); "" = RelationUnspecified
+}
+
+// annotation è l'icona di un allegato in una pagina (/FileAttachment)
+type annotation struct {
+	file *Attachment
+	page int
+	rect [4]float64
+	elem *structElem // elemento Annot nei PDF taggati
+	num  int         // numero di oggetto, assegnato da Finish
+}
+
+// mimeTypes associa le estensioni più comuni al tipo MIME; la tabella è fissa,
+// e non quella del sistema, perché lo stesso documento produca lo stesso PDF ovunque
+var mimeTypes = map[string]string{
+	".md":       "text/markdown",
+	".markdown": "text/markdown",
+	".txt":      "text/plain",
+	".csv":      "text/csv",
+	".html":     "text/html",
+	".css":      "text/css",
+	".json":     "application/json",
+	".xml":      "application/xml",
+	".yaml":     "application/yaml",
+	".yml":      "application/yaml",
+	".pdf":      "application/pdf",
+	".zip":      "application/zip",
+	".png":      "image/png",
+	".jpg":      "image/jpeg",
+	".jpeg":     "image/jpeg",
+	".gif":      "image/gif",
+	".svg":      "image/svg+xml",
+	".ttf":      "font/ttf",
+}
+
+// AttachFile incorpora un file nel PDF (/EmbeddedFiles). I nomi devono essere
+// diversi; in PDF/A gli allegati richiedono il livello PDFA3B, in cui ogni allegato
+// è associato al documento con la sua relazione.
+func (p *PDFWriter) AttachFile(file *Attachment) error {
+	_, err := p.attach(file)
+	return err
+}
+
+// AttachFileAt incorpora un file come AttachFile e lo segnala con l'icona di una
+// graffetta nella pagina corrente, con l'angolo in alto a sinistra in (x, y): il
+// lettore apre il file al clic sull'icona. Un file già incorporato con lo stesso
+// nome è riusato.
+func (p *PDFWriter) AttachFileAt(file *Attachment, x, y float64) error {
+	a := p.attachment(file.Name)
+	if a == nil {
+		var err error
+		if a, err = p.attach(file); err != nil {
+			return err
+		}
+	}
+	if p.currentBuf == nil {
+		p.newPage()
+	}
+	annot := &annotation{file: a, page: p.currentPage, rect: [4]float64{x, y - 18, x + 12, y}}
+	if elem := p.newStruct("Annot"); elem != nil {
+		elem.kids = append(elem.kids, structKid{annot: annot})
+		elem.attach()
+		annot.elem = elem
+	}
+	p.annotations = append(p.annotations, annot)
+	return nil
+}
+
+// attach registra una copia dell'allegato
+func (p *PDFWriter) attach(file *Attachment) (*Attachment, error) {
+	a := *file
+	if a.Name == "" {
+		return nil, errors.New("allegato senza nome")
+	}
+	if p.attachment(a.Name) != nil {
+		return nil, fmt.Errorf("allegato %q già presente", a.Name)
+	}
+	switch a.Relationship {
+	case "":
+		a.Relationship = RelationUnspecified
+	case RelationSource, RelationData, RelationAlternative, RelationSupplement, RelationUnspecified:
+	default:
+		return nil, fmt.Errorf("relazione dell'allegato non valida: %q", a.Relationship)
+	}
+	if a.MIMEType == "" {
+		a.MIMEType = mimeTypes[strings.ToLower(path.Ext(a.Name))]
+	}
+	if a.MIMEType == "" {
+		a.MIMEType = "application/octet-stream"
+	}
+	p.attachments = append(p.attachments, &a)
+	return &a, nil
+}
+
+// attachment restituisce l'allegato con il nome indicato, nil se non c'è
+func (p *PDFWriter) attachment(name string) *Attachment {
+	for _, a := range p.attachments {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// embeddedFiles restituisce l'albero dei nomi /EmbeddedFiles del catalogo, con
+// le chiavi in ordine come richiesto dagli alberi dei nomi; nums sono i file
+// specification degli allegati
+func (p *PDFWriter) embeddedFiles(catalogObjNum int, nums map[*Attachment]int) string {
+	files := append([]*Attachment(nil), p.attachments...)
+	sort.Slice(files, func(i, j int) bool {
+		return bytes.Compare(textString(files[i].Name), textString(files[j].Name)) < 0
+	})
+	var names strings.Builder
+	for _, a := range files {
+		fmt.Fprintf(&names, "%s %d 0 R ", p.output.str(catalogObjNum, a.Name), nums[a])
+	}
+	return "<< /EmbeddedFiles << /Names [" + names.String() + "] >> >>"
+}
+
+// writeAttachment scrive il file specification num di un allegato e lo stream
+// del file, nell'oggetto successivo
+func (p *PDFWriter) writeAttachment(a *Attachment, num int) {
+	objects := p.output
+	name := objects.str(num, a.Name)
+	spec := fmt.Sprintf("<< /Type /Filespec /F %s /UF %s /EF << /F %d 0 R /UF %d 0 R >> /AFRelationship /%s ",
+		name, name, num+1, num+1, a.Relationship)
+	if a.Description != "" {
+		spec += "/Desc " + objects.str(num, a.Description) + " "
+	}
+	objects.object(num, spec+">>")
+
+	params := fmt.Sprintf("/Size %d /CheckSum %s", len(a.Data), objects.byteString(num+1, md5Sum(a.Data)))
+	modified := a.Modified
+	if modified.IsZero() {
+		modified = p.created
+	}
+	if !modified.IsZero() {
+		params += " /ModDate " + objects.str(num+1, pdfDate(modified))
+	}
+	objects.flateStream(num+1, fmt.Sprintf(" /Type /EmbeddedFile /Subtype %s /Params << %s >>", nameString(pdfName(a.MIMEType)), params), a.Data)
+}
+
+// md5Sum restituisce l'impronta MD5 di /CheckSum
+func md5Sum(data []byte) []byte {
+	sum := md5.Sum(data)
+	return sum[:]
+}
+
+// annotationDict restituisce il dizionario dell'icona di un allegato; spec è il
+// file specification, appearance l'aspetto dell'icona e key la chiave nel parent tree
+func (p *PDFWriter) annotationDict(a *annotation, spec, appearance, key int) string {
+	contents := a.file.Description
+	if contents == "" {
+		contents = a.file.Name
+	}
+	// Printable (/F 4) and with an appearance stream, as PDF/A requires
+	dict := fmt.Sprintf("<< /Type /Annot /Subtype /FileAttachment /Rect [%.2f %.2f %.2f %.2f] /FS %d 0 R /Contents %s /Name /Paperclip /F 4 /AP << /N %d 0 R >> ",
+		a.rect[0], a.rect[1], a.rect[2], a.rect[3], spec, p.output.str(a.num, contents), appearance)
+	if a.elem != nil {
+		dict += fmt.Sprintf("/StructParent %d ", key)
+	}
+	return dict + ">>"
+}
+
+// paperclip è l'aspetto delle icone degli allegati, in un riquadro di 12 × 18 punti
+const paperclip = "0.25 0.25 0.25 RG 1.2 w 1 J\n" +
+	"4 5 m 4 13 l 4 15.5 8 15.5 8 13 c 8 3.5 l 8 0.8 2 0.8 2 3.5 c 2 14 l 2 17.4 10 17.4 10 14 c 10 6 l S\n"
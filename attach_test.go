@@ -0,0 +1,225 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// embeddedFiles restituisce i nomi degli allegati elencati nel catalogo di un PDF,
+// nell'ordine dell'albero dei nomi, e i loro file specification
+func embeddedFiles(t *testing.T, data []byte) ([]string, map[string]pdfDict, *pdfReader) {
+	t.Helper()
+	r, err := readPDF(data)
+	if err != nil {
+		t.Fatalf("readPDF failed: %v", err)
+	}
+	names, _ := r.resolve(r.root()["Names"]).(pdfDict)
+	tree, _ := r.resolve(names["EmbeddedFiles"]).(pdfDict)
+	entries, _ := r.resolve(tree["Names"]).(pdfArray)
+	var list []string
+	specs := make(map[string]pdfDict)
+	for i := 0; i+1 < len(entries); i += 2 {
+		name := textValue(r.resolve(entries[i]).(pdfString))
+		list = append(list, name)
+		specs[name], _ = r.resolve(entries[i+1]).(pdfDict)
+	}
+	return list, specs, r
+}
+
+func TestAttachFile(t *testing.T) {
+	p := NewPDFWriter()
+	p.SetCreationDate(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	p.writeText("Report", 10, false)
+	source := []byte("# Report\n\nTesto\n")
+	if err := p.AttachFile(&Attachment{Name: "report.md", Data: source, Description: "Sorgente", Relationship: RelationSource}); err != nil {
+		t.Fatalf("AttachFile failed: %v", err)
+	}
+	if err := p.AttachFile(&Attachment{Name: "dati.bin", Data: []byte{1, 2, 3}}); err != nil {
+		t.Fatalf("AttachFile failed: %v", err)
+	}
+	data, err := p.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	names, specs, r := embeddedFiles(t, data)
+	if want := []string{"dati.bin", "report.md"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("Expected the attachments sorted by name %q, got %q", want, names)
+	}
+	spec := specs["report.md"]
+	if spec["AFRelationship"] != pdfName("Source") || textValue(r.resolve(spec["Desc"]).(pdfString)) != "Sorgente" {
+		t.Errorf("Expected the relationship and the description, got %v", spec)
+	}
+	ef, _ := r.resolve(spec["EF"]).(pdfDict)
+	stream, ok := r.resolve(ef["F"]).(*pdfStream)
+	if !ok {
+		t.Fatalf("Expected the embedded file stream, got %v", ef)
+	}
+	if content, err := r.decode(stream); err != nil || !bytes.Equal(content, source) {
+		t.Errorf("Expected %q, got %q (%v)", source, content, err)
+	}
+	params, _ := r.resolve(stream.dict["Params"]).(pdfDict)
+	if stream.dict["Subtype"] != pdfName("text/markdown") || textValue(r.resolve(params["ModDate"]).(pdfString)) != "D:20240301120000+00'00'" {
+		t.Errorf("Expected the MIME type and the date of the document, got %v", stream.dict)
+	}
+	other, _ := r.resolve(specs["dati.bin"]["EF"]).(pdfDict)
+	if s, _ := r.resolve(other["F"]).(*pdfStream); s == nil || s.dict["Subtype"] != pdfName("application/octet-stream") {
+		t.Error("Expected application/octet-stream for an unknown extension")
+	}
+
+	for _, invalid := range []*Attachment{
+		{Name: "report.md"},
+		{Name: ""},
+		{Name: "x.txt", Relationship: "Other"},
+	} {
+		if err := p.AttachFile(invalid); err == nil {
+			t.Errorf("Expected an error for %+v", invalid)
+		}
+	}
+}
+
+func TestAttachFileEncrypted(t *testing.T) {
+	content := []byte("a,b\n1,2\n")
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		setup func(c *Converter)
+	}{
+		{"classic", func(c *Converter) {}},
+		{"compact", func(c *Converter) { c.SetCompact(true) }},
+		{"linearized", func(c *Converter) { c.SetLinearized(true) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			converter := NewConverter("# Dati\n")
+			tt.setup(converter)
+			converter.SetEncryption(&Encryption{UserPassword: "secret", Algorithm: EncryptRC4})
+			if err := converter.AttachFile(&Attachment{Name: "dati.csv", Data: content, Modified: modified}); err != nil {
+				t.Fatalf("AttachFile failed: %v", err)
+			}
+			data, err := converter.Convert()
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			// The /Params of the embedded file stream are never packed in an object stream
+			for _, plain := range []string{fmt.Sprintf("<%x>", md5Sum(content)), fmt.Sprintf("<%x>", textString(pdfDate(modified)))} {
+				if bytes.Contains(data, []byte(plain)) {
+					t.Errorf("Expected %s to be encrypted", plain)
+				}
+			}
+			if !bytes.Contains(data, []byte("/CheckSum <")) {
+				t.Error("Expected the checksum in the embedded file stream")
+			}
+		})
+	}
+}
+
+func TestAttachFileAt(t *testing.T) {
+	for _, tagged := range []bool{false, true} {
+		p := NewPDFWriter()
+		p.SetTagged(tagged)
+		p.writeText("Allegato:", 10, false)
+		if err := p.AttachFileAt(&Attachment{Name: "dati.csv", Data: []byte("a,b\n1,2\n")}, 100, 700); err != nil {
+			t.Fatalf("AttachFileAt failed: %v", err)
+		}
+		data, err := p.Build()
+		if err != nil {
+			t.Fatalf("Build failed: %v", err)
+		}
+		for _, expected := range []string{"/Annots [", "/Subtype /FileAttachment /Rect [100.00 682.00 112.00 700.00]", "/Name /Paperclip /F 4 /AP << /N "} {
+			if !bytes.Contains(data, []byte(expected)) {
+				t.Errorf("tagged=%v: expected %q in the output", tagged, expected)
+			}
+		}
+		// In tagged PDFs the annotation belongs to an Annot element, reached from the parent tree
+		for _, expected := range []string{"/StructParent 1 ", "/S /Annot", "/Type /OBJR", "/ParentTreeNextKey 2"} {
+			if bytes.Contains(data, []byte(expected)) != tagged {
+				t.Errorf("tagged=%v: unexpected presence of %q", tagged, expected)
+			}
+		}
+		if names, _, _ := embeddedFiles(t, data); !reflect.DeepEqual(names, []string{"dati.csv"}) {
+			t.Errorf("Expected the file among the attachments, got %q", names)
+		}
+	}
+}
+
+func TestAttachSource(t *testing.T) {
+	dir := t.TempDir()
+	annex, err := NewConverter("Allegato\n").Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "img"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{"img/logo.png": testPNG(t, 255), "annex.pdf": annex, "extra.pdf": annex} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	markdown := "# Offerta\n\n![Logo](img/logo.png) ![Remoto](https://example.com/a.png) ![Mancante](none.png)\n\n![[annex.pdf]]\n\n![Logo](./img/logo.png)\n"
+	converter := NewConverter(markdown)
+	converter.SetBaseDir(dir)
+	converter.SetAttachSource("offerta.md")
+	if err := converter.AppendPDF(filepath.Join(dir, "extra.pdf"), ""); err != nil {
+		t.Fatalf("AppendPDF failed: %v", err)
+	}
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	names, specs, r := embeddedFiles(t, data)
+	if want := []string{"annex.pdf", "extra.pdf", "img/logo.png", "offerta.md"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("Expected %q, got %q", want, names)
+	}
+	ef, _ := r.resolve(specs["offerta.md"]["EF"]).(pdfDict)
+	stream, _ := r.resolve(ef["F"]).(*pdfStream)
+	if content, _ := r.decode(stream); string(content) != markdown {
+		t.Errorf("Expected the Markdown source, got %q", content)
+	}
+}
+
+func TestAttachPDFA(t *testing.T) {
+	newConverter := func() *Converter {
+		converter := NewConverter("---\ntitle: Relazione\n---\n\nTesto\n")
+		converter.SetPDFA(true)
+		if err := converter.EmbedFont("Helvetica", testFont("Test-Helvetica", 600)); err != nil {
+			t.Fatalf("EmbedFont failed: %v", err)
+		}
+		converter.SetAttachSource("relazione.md")
+		return converter
+	}
+
+	// PDF/A-2b does not allow the attachment
+	_, err := newConverter().Convert()
+	var pdfaErr *PDFAError
+	if !errors.As(err, &pdfaErr) || pdfaErr.Level != PDFA2B || !strings.HasPrefix(err.Error(), "PDF/A-2b non conforme: gli allegati") {
+		t.Fatalf("Expected a PDF/A-2b violation for the attachment, got %v", err)
+	}
+
+	// Embedded files other than PDF/A documents require PDF/A-3
+	converter := newConverter()
+	if err := converter.SetPDFALevel(PDFA3B); err != nil {
+		t.Fatalf("SetPDFALevel failed: %v", err)
+	}
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	for _, expected := range []string{"<pdfaid:part>3</pdfaid:part>", "<pdfaid:conformance>B</pdfaid:conformance>", "/AF [", "/AFRelationship /Source"} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("Expected %q in the output", expected)
+		}
+	}
+
+	if err := converter.SetPDFALevel("1a"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+}
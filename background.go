@@ -0,0 +1,136 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Background è una pagina di un PDF esistente, ad esempio una carta intestata,
+// disegnata sotto il contenuto delle pagine del documento. Top e Bottom riservano
+// allo sfondo le aree in alto e in basso della pagina: il contenuto resta tra le due.
+type Background struct {
+	PDF    []byte  // file PDF
+	Page   int     // pagina del PDF da usare, da 1; 0 = la prima
+	Pages  string  // pagine del documento: "" = tutte, "1" = la prima, "2-" = dalla seconda
+	Top    float64 // spazio in punti dal bordo superiore in cui il contenuto non entra; 0 = margine del tema
+	Bottom float64 // spazio in punti dal bordo inferiore in cui il contenuto non entra; 0 = margine del tema
+
+	ranges pageRanges
+	form   string     // risorsa del Form XObject
+	box    [4]float64 // riquadro della pagina importata
+}
+
+// AddBackground aggiunge uno sfondo alle pagine del documento. Va chiamato prima
+// di scrivere il contenuto, perché le aree riservate valgono per le pagine nuove;
+// con più sfondi, ad esempio uno per la prima pagina e uno per le seguenti, ogni
+// pagina usa quelli che la includono.
+func (p *PDFWriter) AddBackground(background *Background) error {
+	bg := *background
+	ranges, err := parsePageRanges(bg.Pages)
+	if err != nil {
+		return err
+	}
+	bg.ranges = ranges
+	if bg.form, bg.box, err = p.importPage(bg.PDF, max(bg.Page, 1)); err != nil {
+		return err
+	}
+	p.backgrounds = append(p.backgrounds, &bg)
+	return nil
+}
+
+// DrawPDFPage disegna la pagina n (da 1) di un PDF esistente nel rettangolo con
+// l'angolo in alto a sinistra in (x, y). Come per DrawImage, una pagina disegnata
+// più volte è incorporata una sola volta.
+func (p *PDFWriter) DrawPDFPage(data []byte, n int, x, y, width, height float64) error {
+	name, box, err := p.importPage(data, n)
+	if err != nil {
+		return err
+	}
+	if p.currentBuf == nil {
+		p.newPage()
+	}
+	sx, sy := width/(box[2]-box[0]), height/(box[3]-box[1])
+	p.useResource(name)
+	p.beginMarked(artifact)
+	p.currentBuf.WriteString(fmt.Sprintf("q %.4f 0 0 %.4f %.2f %.2f cm /%s Do Q\n", sx, sy, x-box[0]*sx, y-height-box[1]*sy, name))
+	p.endMarked()
+	return nil
+}
+
+// importPage registra la pagina n (da 1) di un PDF come Form XObject, con le sue
+// risorse; restituisce il nome della risorsa e il riquadro della pagina
+func (p *PDFWriter) importPage(data []byte, n int) (string, [4]float64, error) {
+	imp, err := p.importPDF(data)
+	if err != nil {
+		return "", [4]float64{}, err
+	}
+	pages, err := imp.reader.pages()
+	if err != nil {
+		return "", [4]float64{}, fmt.Errorf("PDF importato: %w", err)
+	}
+	if n < 1 || n > len(pages) {
+		return "", [4]float64{}, fmt.Errorf("pagina %d inesistente: il PDF importato ha %d pagine", n, len(pages))
+	}
+	return p.pageForm(imp, pages[n-1], n)
+}
+
+// pageForm registra una pagina, la n-esima del file importato, come Form XObject
+func (p *PDFWriter) pageForm(imp *pdfImport, page pdfDict, n int) (string, [4]float64, error) {
+	content, err := imp.reader.pageContent(page)
+	if err != nil {
+		return "", [4]float64{}, fmt.Errorf("PDF importato, pagina %d: %w", n, err)
+	}
+	entries := make(pdfDict)
+	for _, key := range []string{"Resources", "Group"} {
+		if v, ok := page[key]; ok {
+			entries[key] = v
+		}
+	}
+	// Every object is read now, so a damaged file is reported before writing starts
+	if err := imp.reader.closure(entries); err != nil {
+		return "", [4]float64{}, fmt.Errorf("PDF importato, pagina %d: %w", n, err)
+	}
+	box := imp.reader.pageBox(page)
+	if box[2] <= box[0] || box[3] <= box[1] {
+		return "", [4]float64{}, fmt.Errorf("PDF importato, pagina %d: riquadro della pagina vuoto", n)
+	}
+	form := &xobject{
+		dict:     fmt.Sprintf(" /Type /XObject /Subtype /Form /BBox [%g %g %g %g]", box[0], box[1], box[2], box[3]),
+		data:     content,
+		flate:    true,
+		origin:   fmt.Sprintf("%x/%d", imp.key, n),
+		imported: imp,
+		entries:  entries,
+		fonts:    imp.reader.unembeddedFonts(entries["Resources"]),
+	}
+	return p.addXObject("Fm", form), box, nil
+}
+
+// pageArea restituisce l'ordinata in cui inizia il contenuto della pagina
+// (da 0) e il margine inferiore, tenendo conto delle aree riservate agli sfondi
+func (p *PDFWriter) pageArea(page int) (top, bottom float64) {
+	top, bottom = p.pageHeight-p.margin, p.margin
+	for _, bg := range p.backgrounds {
+		if bg.ranges.includes(page + 1) {
+			top = min(top, p.pageHeight-bg.Top)
+			bottom = max(bottom, bg.Bottom)
+		}
+	}
+	return top, bottom
+}
+
+// drawBackground disegna uno sfondo scalato per riempire la pagina i, centrato
+func (p *PDFWriter) drawBackground(out *bytes.Buffer, page int, bg *Background) {
+	p.usePageResource(page, bg.form)
+	width, height := bg.box[2]-bg.box[0], bg.box[3]-bg.box[1]
+	scale := min(p.pageWidth/width, p.pageHeight/height)
+	tx := (p.pageWidth-width*scale)/2 - bg.box[0]*scale
+	ty := (p.pageHeight-height*scale)/2 - bg.box[1]*scale
+	if p.structRoot != nil {
+		out.WriteString("/Artifact <</Type /Pagination>> BDC\n")
+	}
+	fmt.Fprintf(out, "q %.4f 0 0 %.4f %.2f %.2f cm /%s Do Q\n", scale, scale, tx, ty, bg.form)
+	if p.structRoot != nil {
+		out.WriteString("EMC\n")
+	}
+}
@@ -0,0 +1,129 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testLetterhead restituisce una carta intestata di una pagina, scritta in modalità compatta
+func testLetterhead(t *testing.T) []byte {
+	t.Helper()
+	converter := NewConverter("# ACME S.p.A.\n\n**Via Roma 1, Milano**\n")
+	converter.SetCompact(true)
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	return data
+}
+
+func TestBackground(t *testing.T) {
+	letterhead := testLetterhead(t)
+	converter := NewConverter(fillerLines(120))
+	converter.SetDebug(true)
+	if err := converter.AddBackground(&Background{PDF: letterhead, Pages: "1", Top: 200, Bottom: 100}); err != nil {
+		t.Fatalf("AddBackground failed: %v", err)
+	}
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+
+	pages := pageStreams(t, data)
+	if len(pages) < 2 {
+		t.Fatalf("Expected at least 2 pages, got %d", len(pages))
+	}
+	if !strings.HasPrefix(pages[0], "q 1.0000 0 0 1.0000 0.00 0.00 cm /Fm1 Do Q\n") {
+		t.Errorf("Expected the letterhead behind the first page, got %q", pages[0][:60])
+	}
+	if strings.Contains(pages[1], "/Fm1 Do") {
+		t.Error("Expected no letterhead on the second page")
+	}
+	// The content stays out of the reserved areas on the first page only
+	positions := regexp.MustCompile(`[\d.]+ ([\d.]+) Td`)
+	for i, limits := range [][2]float64{{841.89 - 200, 100}, {841.89 - 50, 50}} {
+		matches := positions.FindAllStringSubmatch(pages[i], -1)
+		first, _ := strconv.ParseFloat(matches[0][1], 64)
+		last, _ := strconv.ParseFloat(matches[len(matches)-1][1], 64)
+		if first != limits[0] || last < limits[1] {
+			t.Errorf("Page %d: expected the text between %.2f and %.2f, got %.2f to %.2f", i+1, limits[0], limits[1], first, last)
+		}
+	}
+
+	// The letterhead page becomes a Form XObject with its own fonts
+	form := regexp.MustCompile(`/Subtype /Form /BBox \[0 0 595.28 841.89\] /Resources << /Font << /F1 \d+ 0 R /F2 \d+ 0 R >> >> /Filter /FlateDecode`)
+	if !form.Match(data) {
+		t.Error("Expected the imported page as a Form XObject with its resources")
+	}
+	if n := bytes.Count(data, []byte("/BaseFont /Helvetica-Bold")); n != 1 {
+		t.Errorf("Expected the letterhead font copied once, got %d", n)
+	}
+}
+
+func TestBackgroundOutputs(t *testing.T) {
+	letterhead := testLetterhead(t)
+	tests := []struct {
+		name      string
+		setup     func(c *Converter)
+		encrypted bool
+	}{
+		{"compact", func(c *Converter) { c.SetCompact(true) }, false},
+		{"linearized tagged", func(c *Converter) {
+			c.SetLinearized(true)
+			c.SetTagged(true)
+		}, false},
+		{"encrypted", func(c *Converter) {
+			c.SetEncryption(&Encryption{UserPassword: "secret", Algorithm: EncryptAES128})
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			converter := NewConverter("# Lettera\n\n" + fillerLines(120))
+			tt.setup(converter)
+			if err := converter.AddBackground(&Background{PDF: letterhead}); err != nil {
+				t.Fatalf("AddBackground failed: %v", err)
+			}
+			data, err := converter.Convert()
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			if n := bytes.Count(data, []byte("/Subtype /Form")); n != 1 {
+				t.Errorf("Expected one Form XObject shared by all pages, got %d", n)
+			}
+			if _, err := readPDF(data); (err == nil) == tt.encrypted {
+				t.Errorf("Expected readPDF to fail only on encrypted output, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDrawPDFPage(t *testing.T) {
+	letterhead := testLetterhead(t)
+	p := NewPDFWriter()
+	p.SetDebug(true)
+	for i := 0; i < 2; i++ {
+		if err := p.DrawPDFPage(letterhead, 1, 100, 800, 297.64, 420.945); err != nil {
+			t.Fatalf("DrawPDFPage failed: %v", err)
+		}
+	}
+	data, err := p.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if n := bytes.Count(data, []byte("q 0.5000 0 0 0.5000 100.00 379.06 cm /Fm1 Do Q")); n != 2 {
+		t.Errorf("Expected the page drawn twice at half size, got %d", n)
+	}
+	if n := bytes.Count(data, []byte("/Subtype /Form")); n != 1 {
+		t.Errorf("Expected the page imported once, got %d", n)
+	}
+
+	if err := p.DrawPDFPage(letterhead, 2, 0, 0, 10, 10); err == nil {
+		t.Error("Expected an error for a page that does not exist")
+	}
+	if err := NewPDFWriter().AddBackground(&Background{PDF: []byte("not a PDF")}); err == nil {
+		t.Error("Expected an error for data that is not a PDF")
+	}
+}
@@ -0,0 +1,1124 @@
+package mark2pdf
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// codeIndent è il numero di colonne che rende un blocco di codice indentato
+const codeIndent = 4
+
+// nodeType identifica il tipo di un nodo dell'albero interno del parser
+type nodeType int
+
+const (
+	nodeDocument nodeType = iota
+	nodeBlockQuote
+	nodeList
+	nodeItem
+	nodeParagraph
+	nodeHeading
+	nodeThematicBreak
+	nodeCodeBlock
+	nodeHTMLBlock
+	nodeTable
+	nodeTableRow
+	nodeTableCell
+	nodeExtContainer // blocco di un'estensione che contiene altri blocchi
+	nodeExtLeaf      // blocco di un'estensione che raccoglie linee
+
+	nodeText
+	nodeSoftBreak
+	nodeLineBreak
+	nodeCode
+	nodeEmph
+	nodeStrong
+	nodeStrikethrough
+	nodeLink
+	nodeImage
+	nodeHTMLInline
+	nodeExtInline // nodo prodotto da un'estensione inline
+)
+
+// listData contiene i dati di un marker di lista
+type listData struct {
+	ordered      bool
+	bulletChar   byte
+	start        int
+	delimiter    byte
+	padding      int
+	markerOffset int
+	tight        bool
+}
+
+// mdNode è un nodo dell'albero interno costruito dal parser CommonMark
+type mdNode struct {
+	typ                                       nodeType
+	parent, firstChild, lastChild, prev, next *mdNode
+
+	open                bool
+	startLine, startCol int
+	endLine, endCol     int
+	content             []byte       // contenuto raw accumulato dalle linee
+	lines               []lineOrigin // origine nel sorgente delle linee di content
+	literal             string       // testo, codice, html
+
+	// Intervallo nel contenuto del blocco padre (nodi inline)
+	srcStart, srcEnd int
+	hasSrc           bool
+
+	level         int    // heading
+	info          string // code block
+	fenced        bool
+	fenceChar     byte
+	fenceLength   int
+	fenceOffset   int
+	htmlBlockType int
+	list          *listData
+	unnumbered    bool // heading con la classe {-}
+	task          bool // list item GFM con checkbox
+	checked       bool
+	dest, title   string      // link e immagini
+	ext           BlockParser // blocchi delle estensioni
+	inline        Inline      // nodi inline delle estensioni
+	align         []string    // tabelle
+	header        bool        // riga di intestazione tabella
+}
+
+// lineOrigin associa l'inizio di una linea del contenuto di un blocco alla
+// sua posizione nel sorgente
+type lineOrigin struct {
+	offset    int // offset nel contenuto
+	line, col int
+}
+
+// sourcePos converte un offset nel contenuto del blocco in linea e colonna del sorgente
+func (n *mdNode) sourcePos(offset int) (line, col int) {
+	if len(n.lines) == 0 {
+		return n.startLine, n.startCol
+	}
+	origin := n.lines[0]
+	for _, o := range n.lines[1:] {
+		if o.offset > offset {
+			break
+		}
+		origin = o
+	}
+	return origin.line, origin.col + offset - origin.offset
+}
+
+// trimContent rimuove i primi k byte dal contenuto, mantenendo le origini delle linee
+func (n *mdNode) trimContent(k int) {
+	n.content = n.content[k:]
+	lines := make([]lineOrigin, 0, len(n.lines))
+	for i, o := range n.lines {
+		o.offset -= k
+		if o.offset < 0 {
+			if i+1 < len(n.lines) && n.lines[i+1].offset-k <= 0 {
+				continue
+			}
+			o.col -= o.offset
+			o.offset = 0
+		}
+		lines = append(lines, o)
+	}
+	n.lines = lines
+}
+
+func newNode(typ nodeType, line, col int) *mdNode {
+	return &mdNode{typ: typ, open: true, startLine: line, startCol: col}
+}
+
+func (n *mdNode) appendChild(child *mdNode) {
+	child.unlink()
+	child.parent = n
+	if n.lastChild != nil {
+		n.lastChild.next = child
+		child.prev = n.lastChild
+		n.lastChild = child
+	} else {
+		n.firstChild = child
+		n.lastChild = child
+	}
+}
+
+func (n *mdNode) insertAfter(sibling *mdNode) {
+	sibling.unlink()
+	sibling.next = n.next
+	if sibling.next != nil {
+		sibling.next.prev = sibling
+	}
+	sibling.prev = n
+	n.next = sibling
+	sibling.parent = n.parent
+	if sibling.next == nil && sibling.parent != nil {
+		sibling.parent.lastChild = sibling
+	}
+}
+
+func (n *mdNode) unlink() {
+	if n.prev != nil {
+		n.prev.next = n.next
+	} else if n.parent != nil {
+		n.parent.firstChild = n.next
+	}
+	if n.next != nil {
+		n.next.prev = n.prev
+	} else if n.parent != nil {
+		n.parent.lastChild = n.prev
+	}
+	n.parent = nil
+	n.next = nil
+	n.prev = nil
+}
+
+// linkReference è una definizione di link reference ([label]: url "title")
+type linkReference struct {
+	dest  string
+	title string
+}
+
+// blockParser implementa la fase di parsing dei blocchi di CommonMark
+// (container e leaf block), seguendo l'algoritmo di riferimento.
+type blockParser struct {
+	doc                  *mdNode
+	tip                  *mdNode
+	oldtip               *mdNode
+	currentLine          string
+	lineNumber           int
+	offset               int
+	column               int
+	nextNonspace         int
+	nextNonspaceColumn   int
+	indent               int
+	indented             bool
+	blank                bool
+	partiallyConsumedTab bool
+	allClosed            bool
+	lastMatchedContainer *mdNode
+	lastLineLength       int
+	thematicBreakKill    int // nella riga nessun separatore orizzontale inizia prima di qui
+	refmap               map[string]linkReference
+	gfm                  bool // tabelle GFM
+	starts               []blockStartFunc
+	extensions           bool // ci sono estensioni di blocco
+}
+
+var (
+	reMaybeSpecial      = regexp.MustCompile(`^[#` + "`" + `~*+_=<>0-9-]`)
+	reMaybeSpecialGFM   = regexp.MustCompile(`^[#` + "`" + `~*+_=<>0-9|:-]`)
+	reATXHeadingMarker  = regexp.MustCompile(`^#{1,6}(?:[ \t]+|$)`)
+	reCodeFence         = regexp.MustCompile("^(?:`{3,}|~{3,})")
+	reClosingCodeFence  = regexp.MustCompile("^(?:`{3,}|~{3,})[ \t]*$")
+	reSetextHeadingLine = regexp.MustCompile(`^(?:=+|-+)[ \t]*$`)
+	reBulletListMarker  = regexp.MustCompile(`^[*+-]`)
+	reOrderedListMarker = regexp.MustCompile(`^(\d{1,9})([.)])`)
+	reTableDelimiterRow = regexp.MustCompile(`^\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+	reTaskMarker        = regexp.MustCompile(`^\[([ xX])\](?:[ \t]+|$)`)
+	reHeadingClass      = regexp.MustCompile(`\s+\{(?:-|\.unnumbered)\}\s*$`)
+
+	reHTMLBlockOpen = []*regexp.Regexp{
+		nil,
+		regexp.MustCompile(`(?i)^<(?:script|pre|textarea|style)(?:\s|>|$)`),
+		regexp.MustCompile(`^<!--`),
+		regexp.MustCompile(`^<[?]`),
+		regexp.MustCompile(`^<![A-Za-z]`),
+		regexp.MustCompile(`^<!\[CDATA\[`),
+		regexp.MustCompile(`(?i)^<[/]?(?:address|article|aside|base|basefont|blockquote|body|caption|center|col|colgroup|dd|details|dialog|dir|div|dl|dt|fieldset|figcaption|figure|footer|form|frame|frameset|h[123456]|head|header|hr|html|iframe|legend|li|link|main|menu|menuitem|nav|noframes|ol|optgroup|option|p|param|search|section|summary|table|tbody|td|tfoot|th|thead|title|tr|track|ul)(?:\s|[/]?[>]|$)`),
+		regexp.MustCompile(`(?i)^(?:` + openTag + `|` + closeTag + `)\s*$`),
+	}
+	reHTMLBlockClose = []*regexp.Regexp{
+		nil,
+		regexp.MustCompile(`(?i)</(?:script|pre|textarea|style)>`),
+		regexp.MustCompile(`-->`),
+		regexp.MustCompile(`\?>`),
+		regexp.MustCompile(`>`),
+		regexp.MustCompile(`\]\]>`),
+	}
+)
+
+// newBlockParser crea un parser di blocchi
+func newBlockParser(gfm bool, exts []BlockExtension) *blockParser {
+	doc := newNode(nodeDocument, 1, 1)
+	bp := &blockParser{
+		doc:        doc,
+		tip:        doc,
+		oldtip:     doc,
+		allClosed:  true,
+		refmap:     make(map[string]linkReference),
+		gfm:        gfm,
+		extensions: len(exts) > 0,
+	}
+
+	// Merge the extensions into the built-in starts by priority
+	starts := append([]blockStart(nil), blockStarts...)
+	for _, ext := range exts {
+		starts = append(starts, blockStart{ext.Priority, startExtension(ext)})
+	}
+	sort.SliceStable(starts, func(i, j int) bool {
+		return starts[i].priority > starts[j].priority
+	})
+	for _, start := range starts {
+		bp.starts = append(bp.starts, start.fn)
+	}
+	return bp
+}
+
+// parse esegue il parsing dei blocchi e restituisce il documento
+func (bp *blockParser) parse(input string) *mdNode {
+	input = strings.ReplaceAll(input, "\r\n", "\n")
+	input = strings.ReplaceAll(input, "\r", "\n")
+	lines := strings.Split(input, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	for _, line := range lines {
+		bp.incorporateLine(line)
+	}
+	for bp.tip != nil {
+		bp.finalize(bp.tip, len(lines))
+	}
+	return bp.doc
+}
+
+func peekByte(s string, i int) int {
+	if i < len(s) {
+		return int(s[i])
+	}
+	return -1
+}
+
+func isSpaceOrTab(c int) bool {
+	return c == ' ' || c == '\t'
+}
+
+// findNextNonspace trova il prossimo carattere non spazio nella linea corrente
+func (bp *blockParser) findNextNonspace() {
+	line := bp.currentLine
+	i := bp.offset
+	cols := bp.column
+	c := -1
+	for i < len(line) {
+		c = int(line[i])
+		if c == ' ' {
+			i++
+			cols++
+		} else if c == '\t' {
+			i++
+			cols += 4 - (cols % 4)
+		} else {
+			break
+		}
+	}
+	bp.blank = i >= len(line)
+	bp.nextNonspace = i
+	bp.nextNonspaceColumn = cols
+	bp.indent = bp.nextNonspaceColumn - bp.column
+	bp.indented = bp.indent >= codeIndent
+}
+
+func (bp *blockParser) advanceNextNonspace() {
+	bp.offset = bp.nextNonspace
+	bp.column = bp.nextNonspaceColumn
+	bp.partiallyConsumedTab = false
+}
+
+// advanceOffset avanza di count caratteri (o colonne se columns è true)
+func (bp *blockParser) advanceOffset(count int, columns bool) {
+	line := bp.currentLine
+	for count > 0 && bp.offset < len(line) {
+		if line[bp.offset] == '\t' {
+			charsToTab := 4 - (bp.column % 4)
+			if columns {
+				bp.partiallyConsumedTab = charsToTab > count
+				charsToAdvance := charsToTab
+				if charsToTab > count {
+					charsToAdvance = count
+				}
+				bp.column += charsToAdvance
+				if !bp.partiallyConsumedTab {
+					bp.offset++
+				}
+				count -= charsToAdvance
+			} else {
+				bp.partiallyConsumedTab = false
+				bp.column += charsToTab
+				bp.offset++
+				count--
+			}
+		} else {
+			bp.partiallyConsumedTab = false
+			bp.offset++
+			bp.column++
+			count--
+		}
+	}
+}
+
+// addLine aggiunge il resto della linea corrente al blocco aperto
+func (bp *blockParser) addLine() {
+	bp.tip.lines = append(bp.tip.lines, lineOrigin{offset: len(bp.tip.content), line: bp.lineNumber, col: bp.offset + 1})
+	if bp.partiallyConsumedTab {
+		bp.offset++
+		charsToTab := 4 - (bp.column % 4)
+		bp.tip.content = append(bp.tip.content, strings.Repeat(" ", charsToTab)...)
+	}
+	if bp.offset < len(bp.currentLine) {
+		bp.tip.content = append(bp.tip.content, bp.currentLine[bp.offset:]...)
+	}
+	bp.tip.content = append(bp.tip.content, '\n')
+}
+
+// addChild aggiunge un blocco figlio, chiudendo i blocchi che non possono contenerlo
+func (bp *blockParser) addChild(typ nodeType, offset int) *mdNode {
+	for !canContain(bp.tip.typ, typ) {
+		bp.finalize(bp.tip, bp.lineNumber-1)
+	}
+	child := newNode(typ, bp.lineNumber, offset+1)
+	bp.tip.appendChild(child)
+	bp.tip = child
+	return child
+}
+
+// closeUnmatchedBlocks chiude i blocchi non corrispondenti alla linea corrente
+func (bp *blockParser) closeUnmatchedBlocks() {
+	if !bp.allClosed {
+		for bp.oldtip != bp.lastMatchedContainer {
+			parent := bp.oldtip.parent
+			bp.finalize(bp.oldtip, bp.lineNumber-1)
+			bp.oldtip = parent
+		}
+		bp.allClosed = true
+	}
+}
+
+// incorporateLine analizza una linea e aggiorna l'albero dei blocchi
+func (bp *blockParser) incorporateLine(line string) {
+	container := bp.doc
+	bp.oldtip = bp.tip
+	bp.offset = 0
+	bp.column = 0
+	bp.blank = false
+	bp.partiallyConsumedTab = false
+	bp.thematicBreakKill = 0
+	bp.lineNumber++
+
+	if strings.IndexByte(line, 0) >= 0 {
+		line = strings.ReplaceAll(line, "\x00", "�")
+	}
+	bp.currentLine = line
+
+	// 1. Match the line against the open blocks
+	for container.lastChild != nil && container.lastChild.open {
+		container = container.lastChild
+		bp.findNextNonspace()
+
+		switch bp.continueBlock(container) {
+		case 0: // matched
+			continue
+		case 1: // failed to match
+			container = container.parent
+		case 2: // line fully consumed (closing code fence)
+			return
+		}
+		break
+	}
+
+	bp.allClosed = container == bp.oldtip
+	bp.lastMatchedContainer = container
+
+	matchedLeaf := container.typ != nodeParagraph && container.typ != nodeTable && acceptsLines(container.typ)
+
+	// 2. Look for new block starts
+	maybeSpecial := reMaybeSpecial
+	if bp.gfm {
+		maybeSpecial = reMaybeSpecialGFM
+	}
+	for !matchedLeaf {
+		bp.findNextNonspace()
+
+		if !bp.indented && !bp.extensions && !maybeSpecial.MatchString(line[bp.nextNonspace:]) {
+			bp.advanceNextNonspace()
+			break
+		}
+
+		matched := false
+		for _, start := range bp.starts {
+			res := start(bp, container)
+			if res == 1 {
+				container = bp.tip
+				matched = true
+				break
+			} else if res == 2 {
+				container = bp.tip
+				matchedLeaf = true
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			bp.advanceNextNonspace()
+			break
+		}
+	}
+
+	// 3. Add the remaining text to the appropriate container
+	if !bp.allClosed && !bp.blank && bp.tip.typ == nodeParagraph {
+		// Lazy paragraph continuation
+		bp.addLine()
+	} else {
+		bp.closeUnmatchedBlocks()
+
+		if acceptsLines(container.typ) {
+			bp.addLine()
+			if container.typ == nodeHTMLBlock && container.htmlBlockType >= 1 && container.htmlBlockType <= 5 &&
+				reHTMLBlockClose[container.htmlBlockType].MatchString(line[min(bp.offset, len(line)):]) {
+				bp.lastLineLength = len(line)
+				bp.finalize(container, bp.lineNumber)
+			}
+		} else if bp.offset < len(line) && !bp.blank {
+			bp.addChild(nodeParagraph, bp.offset)
+			bp.advanceNextNonspace()
+			bp.addLine()
+		}
+	}
+	bp.lastLineLength = len(line)
+}
+
+// finalize chiude un blocco e ne elabora il contenuto
+func (bp *blockParser) finalize(block *mdNode, lineNumber int) {
+	above := block.parent
+	block.open = false
+	block.endLine = lineNumber
+	block.endCol = bp.lastLineLength
+
+	switch block.typ {
+	case nodeParagraph:
+		hasReferenceDefs := false
+		for len(block.content) > 0 && block.content[0] == '[' {
+			pos := parseReference(string(block.content), bp.refmap)
+			if pos == 0 {
+				break
+			}
+			block.trimContent(pos)
+			hasReferenceDefs = true
+		}
+		if hasReferenceDefs && isBlank(string(block.content)) {
+			block.unlink()
+		}
+
+	case nodeCodeBlock:
+		content := string(block.content)
+		if block.fenced {
+			newline := strings.IndexByte(content, '\n')
+			firstLine := content[:newline]
+			block.info = unescapeInline(strings.TrimSpace(firstLine))
+			block.literal = content[newline+1:]
+		} else {
+			lines := strings.Split(content, "\n")
+			for len(lines) > 0 && strings.Trim(lines[len(lines)-1], " \t") == "" {
+				lines = lines[:len(lines)-1]
+			}
+			block.literal = strings.Join(lines, "\n") + "\n"
+		}
+		block.content = nil
+
+	case nodeHTMLBlock:
+		literal := string(block.content)
+		for {
+			trimmed := strings.TrimRight(literal, " ")
+			if !strings.HasSuffix(trimmed, "\n") {
+				break
+			}
+			literal = trimmed[:len(trimmed)-1]
+		}
+		block.literal = literal
+		block.content = nil
+
+	case nodeList:
+		block.list.tight = true
+		for item := block.firstChild; item != nil; item = item.next {
+			if item.next != nil && endsWithBlankLine(item) {
+				block.list.tight = false
+				break
+			}
+			for sub := item.firstChild; sub != nil; sub = sub.next {
+				if sub.next != nil && endsWithBlankLine(sub) {
+					block.list.tight = false
+					break
+				}
+			}
+			if !block.list.tight {
+				break
+			}
+		}
+		if block.lastChild != nil {
+			block.endLine = block.lastChild.endLine
+			block.endCol = block.lastChild.endCol
+		}
+
+	case nodeItem:
+		if block.lastChild != nil {
+			block.endLine = block.lastChild.endLine
+			block.endCol = block.lastChild.endCol
+		} else {
+			block.endLine = block.startLine
+			block.endCol = block.list.markerOffset + block.list.padding
+		}
+
+	case nodeTable:
+		bp.finalizeTable(block)
+
+	case nodeExtLeaf:
+		// Drop the opening line
+		content := string(block.content)
+		block.literal = content[strings.IndexByte(content, '\n')+1:]
+		block.content = nil
+	}
+
+	bp.tip = above
+}
+
+// endsWithBlankLine indica se un blocco è seguito da una linea vuota
+func endsWithBlankLine(block *mdNode) bool {
+	return block.next != nil && block.endLine != block.next.startLine-1
+}
+
+func isBlank(s string) bool {
+	return strings.Trim(s, " \t\n\r\f\v") == ""
+}
+
+// canContain indica se un blocco di tipo parent può contenere un blocco di tipo child
+func canContain(parent, child nodeType) bool {
+	switch parent {
+	case nodeDocument, nodeBlockQuote, nodeItem, nodeExtContainer:
+		return child != nodeItem
+	case nodeList:
+		return child == nodeItem
+	}
+	return false
+}
+
+// acceptsLines indica se un blocco accetta linee di testo
+func acceptsLines(typ nodeType) bool {
+	switch typ {
+	case nodeParagraph, nodeCodeBlock, nodeHTMLBlock, nodeTable, nodeExtLeaf:
+		return true
+	}
+	return false
+}
+
+// continueBlock verifica se la linea corrente continua il blocco aperto.
+// Restituisce 0 se continua, 1 se non continua, 2 se la linea è stata consumata.
+func (bp *blockParser) continueBlock(container *mdNode) int {
+	line := bp.currentLine
+
+	switch container.typ {
+	case nodeDocument, nodeList:
+		return 0
+
+	case nodeBlockQuote:
+		if !bp.indented && peekByte(line, bp.nextNonspace) == '>' {
+			bp.advanceNextNonspace()
+			bp.advanceOffset(1, false)
+			if isSpaceOrTab(peekByte(line, bp.offset)) {
+				bp.advanceOffset(1, true)
+			}
+			return 0
+		}
+		return 1
+
+	case nodeItem:
+		if bp.blank {
+			if container.firstChild == nil {
+				// Blank line after empty list item
+				return 1
+			}
+			bp.advanceNextNonspace()
+		} else if bp.indent >= container.list.markerOffset+container.list.padding {
+			bp.advanceOffset(container.list.markerOffset+container.list.padding, true)
+		} else {
+			return 1
+		}
+		return 0
+
+	case nodeHeading, nodeThematicBreak:
+		return 1
+
+	case nodeCodeBlock:
+		if container.fenced {
+			if bp.indent <= 3 && peekByte(line, bp.nextNonspace) == int(container.fenceChar) {
+				match := reClosingCodeFence.FindString(line[bp.nextNonspace:])
+				fence := strings.TrimRight(match, " \t")
+				if match != "" && len(fence) >= container.fenceLength && strings.Trim(fence, string(container.fenceChar)) == "" {
+					bp.lastLineLength = bp.offset + bp.indent + len(fence)
+					bp.finalize(container, bp.lineNumber)
+					return 2
+				}
+			}
+			// Skip optional spaces of fence offset
+			for i := container.fenceOffset; i > 0 && isSpaceOrTab(peekByte(line, bp.offset)); i-- {
+				bp.advanceOffset(1, true)
+			}
+		} else {
+			if bp.indent >= codeIndent {
+				bp.advanceOffset(codeIndent, true)
+			} else if bp.blank {
+				bp.advanceNextNonspace()
+			} else {
+				return 1
+			}
+		}
+		return 0
+
+	case nodeHTMLBlock:
+		if bp.blank && (container.htmlBlockType == 6 || container.htmlBlockType == 7) {
+			return 1
+		}
+		return 0
+
+	case nodeParagraph, nodeTable:
+		if bp.blank {
+			return 1
+		}
+		return 0
+
+	case nodeExtContainer, nodeExtLeaf:
+		switch container.ext.Continue(line[min(bp.offset, len(line)):]) {
+		case BlockClose:
+			for bp.tip != container {
+				bp.finalize(bp.tip, bp.lineNumber-1)
+			}
+			bp.lastLineLength = len(line)
+			bp.finalize(container, bp.lineNumber)
+			return 2
+		case BlockEnd:
+			return 1
+		}
+		return 0
+	}
+
+	return 1
+}
+
+// blockStartFunc riconosce l'inizio di un nuovo blocco. Restituisce 0 se non
+// corrisponde, 1 se ha aperto un container, 2 se ha aperto un leaf block.
+type blockStartFunc func(bp *blockParser, container *mdNode) int
+
+// blockStart è una funzione di inizio blocco con la sua priorità
+type blockStart struct {
+	priority int
+	fn       blockStartFunc
+}
+
+// blockStarts sono gli inizi di blocco built-in, in ordine di precedenza
+var blockStarts = []blockStart{
+	{900, startBlockQuote},
+	{800, startATXHeading},
+	{700, startFencedCode},
+	{600, startHTMLBlock},
+	{500, startTable},
+	{400, startSetextHeading},
+	{300, startThematicBreak},
+	{200, startListItem},
+	{100, startIndentedCode},
+}
+
+func startBlockQuote(bp *blockParser, container *mdNode) int {
+	if bp.indented || peekByte(bp.currentLine, bp.nextNonspace) != '>' {
+		return 0
+	}
+	bp.advanceNextNonspace()
+	bp.advanceOffset(1, false)
+	if isSpaceOrTab(peekByte(bp.currentLine, bp.offset)) {
+		bp.advanceOffset(1, true)
+	}
+	bp.closeUnmatchedBlocks()
+	bp.addChild(nodeBlockQuote, bp.nextNonspace)
+	return 1
+}
+
+func startATXHeading(bp *blockParser, container *mdNode) int {
+	if bp.indented {
+		return 0
+	}
+	match := reATXHeadingMarker.FindString(bp.currentLine[bp.nextNonspace:])
+	if match == "" {
+		return 0
+	}
+	bp.advanceNextNonspace()
+	bp.advanceOffset(len(match), false)
+	bp.closeUnmatchedBlocks()
+	heading := bp.addChild(nodeHeading, bp.nextNonspace)
+	heading.level = len(strings.TrimRight(match, " \t"))
+
+	// Remove the optional closing sequence of #s
+	content := bp.currentLine[bp.offset:]
+	trimmed := strings.TrimRight(content, " \t")
+	stripped := strings.TrimRight(trimmed, "#")
+	if stripped == "" || strings.HasSuffix(stripped, " ") || strings.HasSuffix(stripped, "\t") {
+		if stripped != trimmed {
+			content = strings.TrimRight(stripped, " \t")
+		}
+	}
+	heading.content = []byte(content)
+	heading.lines = []lineOrigin{{offset: 0, line: bp.lineNumber, col: bp.offset + 1}}
+	bp.advanceOffset(len(bp.currentLine)-bp.offset, false)
+	return 2
+}
+
+func startFencedCode(bp *blockParser, container *mdNode) int {
+	if bp.indented {
+		return 0
+	}
+	rest := bp.currentLine[bp.nextNonspace:]
+	match := reCodeFence.FindString(rest)
+	if match == "" {
+		return 0
+	}
+	// A backtick fence cannot have backticks in its info string
+	if match[0] == '`' && strings.Contains(rest[len(match):], "`") {
+		return 0
+	}
+	bp.closeUnmatchedBlocks()
+	code := bp.addChild(nodeCodeBlock, bp.nextNonspace)
+	code.fenced = true
+	code.fenceLength = len(match)
+	code.fenceChar = match[0]
+	code.fenceOffset = bp.indent
+	bp.advanceNextNonspace()
+	bp.advanceOffset(len(match), false)
+	return 2
+}
+
+func startHTMLBlock(bp *blockParser, container *mdNode) int {
+	if bp.indented || peekByte(bp.currentLine, bp.nextNonspace) != '<' {
+		return 0
+	}
+	s := bp.currentLine[bp.nextNonspace:]
+	for blockType := 1; blockType <= 7; blockType++ {
+		if !reHTMLBlockOpen[blockType].MatchString(s) {
+			continue
+		}
+		// Type 7 cannot interrupt a paragraph (including a lazy continuation)
+		if blockType == 7 && (container.typ == nodeParagraph ||
+			(!bp.allClosed && !bp.blank && bp.tip.typ == nodeParagraph)) {
+			continue
+		}
+		bp.closeUnmatchedBlocks()
+		// Spaces are part of the HTML block, so the offset is not adjusted
+		html := bp.addChild(nodeHTMLBlock, bp.offset)
+		html.htmlBlockType = blockType
+		return 2
+	}
+	return 0
+}
+
+func startSetextHeading(bp *blockParser, container *mdNode) int {
+	if bp.indented || container.typ != nodeParagraph {
+		return 0
+	}
+	match := reSetextHeadingLine.FindString(bp.currentLine[bp.nextNonspace:])
+	if match == "" {
+		return 0
+	}
+	bp.closeUnmatchedBlocks()
+	// Resolve link reference definitions first
+	for len(container.content) > 0 && container.content[0] == '[' {
+		pos := parseReference(string(container.content), bp.refmap)
+		if pos == 0 {
+			break
+		}
+		container.trimContent(pos)
+	}
+	if len(container.content) == 0 {
+		return 0
+	}
+	heading := newNode(nodeHeading, container.startLine, container.startCol)
+	heading.level = 2
+	if match[0] == '=' {
+		heading.level = 1
+	}
+	heading.content = container.content
+	heading.lines = container.lines
+	container.insertAfter(heading)
+	container.unlink()
+	bp.tip = heading
+	bp.advanceOffset(len(bp.currentLine)-bp.offset, false)
+	return 2
+}
+
+func startThematicBreak(bp *blockParser, container *mdNode) int {
+	if bp.indented || bp.nextNonspace < bp.thematicBreakKill || !bp.scanThematicBreak(bp.nextNonspace) {
+		return 0
+	}
+	bp.closeUnmatchedBlocks()
+	bp.addChild(nodeThematicBreak, bp.nextNonspace)
+	bp.advanceOffset(len(bp.currentLine)-bp.offset, false)
+	return 2
+}
+
+// scanThematicBreak indica se la riga da pos in poi è un separatore orizzontale;
+// se non lo è, ricorda il carattere che lo esclude, perché falliscono anche le
+// scansioni che iniziano più avanti ma prima di esso (marcatori di liste annidate)
+func (bp *blockParser) scanThematicBreak(pos int) bool {
+	line := bp.currentLine
+	if pos >= len(line) {
+		return false
+	}
+	c := line[pos]
+	if c != '*' && c != '_' && c != '-' {
+		bp.thematicBreakKill = pos
+		return false
+	}
+	count := 0
+	i := pos
+	for ; i < len(line); i++ {
+		if line[i] == c {
+			count++
+		} else if line[i] != ' ' && line[i] != '\t' {
+			break
+		}
+	}
+	if count >= 3 && i == len(line) {
+		return true
+	}
+	bp.thematicBreakKill = i
+	return false
+}
+
+func startListItem(bp *blockParser, container *mdNode) int {
+	if bp.indented && container.typ != nodeList {
+		return 0
+	}
+	data := bp.parseListMarker(container)
+	if data == nil {
+		return 0
+	}
+	bp.closeUnmatchedBlocks()
+
+	// Add the list if needed
+	if bp.tip.typ != nodeList || !listsMatch(container.list, data) {
+		list := bp.addChild(nodeList, bp.nextNonspace)
+		list.list = data
+	}
+
+	item := bp.addChild(nodeItem, bp.nextNonspace)
+	item.list = data
+	return 1
+}
+
+func startIndentedCode(bp *blockParser, container *mdNode) int {
+	if !bp.indented || bp.tip.typ == nodeParagraph || bp.tip.typ == nodeTable || bp.blank {
+		return 0
+	}
+	bp.advanceOffset(codeIndent, true)
+	bp.closeUnmatchedBlocks()
+	bp.addChild(nodeCodeBlock, bp.offset)
+	return 2
+}
+
+// listsMatch indica se due marker appartengono alla stessa lista
+func listsMatch(a, b *listData) bool {
+	return a != nil && a.ordered == b.ordered && a.delimiter == b.delimiter && a.bulletChar == b.bulletChar
+}
+
+// parseListMarker riconosce un marker di lista e calcola il padding del contenuto
+func (bp *blockParser) parseListMarker(container *mdNode) *listData {
+	if bp.indent >= codeIndent {
+		return nil
+	}
+	rest := bp.currentLine[bp.nextNonspace:]
+	data := &listData{tight: true, markerOffset: bp.indent}
+
+	var marker string
+	if m := reBulletListMarker.FindString(rest); m != "" {
+		marker = m
+		data.bulletChar = m[0]
+	} else if m := reOrderedListMarker.FindStringSubmatch(rest); m != nil {
+		start, _ := strconv.Atoi(m[1])
+		if container.typ == nodeParagraph && start != 1 {
+			return nil
+		}
+		marker = m[0]
+		data.ordered = true
+		data.start = start
+		data.delimiter = m[2][0]
+	} else {
+		return nil
+	}
+
+	// Make sure we have spaces after the marker
+	nextc := peekByte(bp.currentLine, bp.nextNonspace+len(marker))
+	if !(nextc == -1 || nextc == '\t' || nextc == ' ') {
+		return nil
+	}
+
+	// If it interrupts a paragraph, the first line must not be blank
+	if container.typ == nodeParagraph && isBlank(bp.currentLine[bp.nextNonspace+len(marker):]) {
+		return nil
+	}
+
+	bp.advanceNextNonspace()
+	bp.advanceOffset(len(marker), true)
+	spacesStartCol := bp.column
+	spacesStartOffset := bp.offset
+	for {
+		bp.advanceOffset(1, true)
+		nextc = peekByte(bp.currentLine, bp.offset)
+		if !(bp.column-spacesStartCol < 5 && isSpaceOrTab(nextc)) {
+			break
+		}
+	}
+	blankItem := peekByte(bp.currentLine, bp.offset) == -1
+	spacesAfterMarker := bp.column - spacesStartCol
+	if spacesAfterMarker >= 5 || spacesAfterMarker < 1 || blankItem {
+		data.padding = len(marker) + 1
+		bp.column = spacesStartCol
+		bp.offset = spacesStartOffset
+		if isSpaceOrTab(peekByte(bp.currentLine, bp.offset)) {
+			bp.advanceOffset(1, true)
+		}
+	} else {
+		data.padding = len(marker) + spacesAfterMarker
+	}
+	return data
+}
+
+// startTable riconosce una tabella GFM: l'ultima linea del paragrafo corrente
+// è l'intestazione e la linea corrente è la riga delimitatrice.
+func startTable(bp *blockParser, container *mdNode) int {
+	if !bp.gfm || bp.indented || container.typ != nodeParagraph {
+		return 0
+	}
+	rest := bp.currentLine[bp.nextNonspace:]
+	if !strings.Contains(rest, "|") || !reTableDelimiterRow.MatchString(rest) {
+		return 0
+	}
+
+	content := strings.TrimSuffix(string(container.content), "\n")
+	headerStart := strings.LastIndexByte(content, '\n') + 1
+	headerLine := content[headerStart:]
+	delimiters, _ := splitTableRow(rest)
+	if headerCells, _ := splitTableRow(headerLine); len(headerCells) != len(delimiters) {
+		return 0
+	}
+
+	bp.closeUnmatchedBlocks()
+	headerLineNumber, headerCol := container.sourcePos(headerStart)
+	table := newNode(nodeTable, headerLineNumber, headerCol)
+	table.content = []byte(headerLine + "\n")
+	table.lines = []lineOrigin{{offset: 0, line: headerLineNumber, col: headerCol}}
+	table.align = make([]string, len(delimiters))
+	for i, cell := range delimiters {
+		left := strings.HasPrefix(cell, ":")
+		right := strings.HasSuffix(cell, ":")
+		switch {
+		case left && right:
+			table.align[i] = "center"
+		case right:
+			table.align[i] = "right"
+		case left:
+			table.align[i] = "left"
+		}
+	}
+
+	if headerStart > 0 {
+		// The lines before the header stay a paragraph
+		container.content = []byte(content[:headerStart])
+		for i, o := range container.lines {
+			if o.offset >= headerStart {
+				container.lines = container.lines[:i]
+				break
+			}
+		}
+		container.insertAfter(table)
+		bp.finalize(container, bp.lineNumber-2)
+	} else {
+		container.insertAfter(table)
+		container.unlink()
+	}
+	bp.tip = table
+	bp.advanceOffset(len(bp.currentLine)-bp.offset, false)
+	return 2
+}
+
+// finalizeTable divide le linee della tabella in righe e celle
+func (bp *blockParser) finalizeTable(table *mdNode) {
+	lines := strings.Split(strings.TrimSuffix(string(table.content), "\n"), "\n")
+	table.content = nil
+
+	for i, line := range lines {
+		if i == 1 {
+			// The delimiter row only contributes the alignment
+			continue
+		}
+		origin := lineOrigin{line: table.startLine + i, col: table.startCol}
+		if i < len(table.lines) {
+			origin = table.lines[i]
+		}
+		row := newNode(nodeTableRow, origin.line, origin.col)
+		row.header = i == 0
+		row.open = false
+		row.endLine = origin.line
+		row.endCol = origin.col + len(line) - 1
+		cells, offsets := splitTableRow(line)
+		for j := range table.align {
+			cell := newNode(nodeTableCell, row.startLine, row.endCol)
+			cell.open = false
+			if j < len(cells) {
+				cell.content = []byte(cells[j])
+				cell.startCol = origin.col + offsets[j]
+				cell.lines = []lineOrigin{{offset: 0, line: origin.line, col: cell.startCol}}
+			}
+			cell.endLine = origin.line
+			cell.endCol = cell.startCol + len(cell.content) - 1
+			if len(cell.content) == 0 {
+				cell.endCol = cell.startCol
+			}
+			row.appendChild(cell)
+		}
+		table.appendChild(row)
+	}
+}
+
+// splitTableRow divide una riga di tabella in celle, rispettando i pipe escapati.
+// Restituisce anche l'offset di ogni cella nella riga.
+func splitTableRow(line string) (cells []string, offsets []int) {
+	start := len(line) - len(strings.TrimLeft(line, " \t"))
+	end := len(strings.TrimRight(line, " \t"))
+	if start < end && line[start] == '|' {
+		start++
+	}
+	if end > start && line[end-1] == '|' && (end-2 < start || line[end-2] != '\\') {
+		end--
+	}
+
+	var cell strings.Builder
+	cellStart := start
+	flush := func(next int) {
+		raw := cell.String()
+		cells = append(cells, strings.TrimSpace(raw))
+		offsets = append(offsets, cellStart+len(raw)-len(strings.TrimLeft(raw, " \t")))
+		cell.Reset()
+		cellStart = next
+	}
+	for i := start; i < end; i++ {
+		if line[i] == '\\' && i+1 < end && line[i+1] == '|' {
+			cell.WriteByte('|')
+			i++
+			continue
+		}
+		if line[i] == '|' {
+			flush(i + 1)
+			continue
+		}
+		cell.WriteByte(line[i])
+	}
+	flush(end)
+	return cells, offsets
+}
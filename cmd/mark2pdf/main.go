@@ -4,6 +4,10 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/beinux3/Mark2PDF"
 )
@@ -14,6 +18,37 @@ func main() {
 	// Define command line flags
 	inputFile := flag.String("input", "", "Input Markdown file (required)")
 	outputFile := flag.String("output", "", "Output PDF file (required)")
+	themeName := flag.String("theme", "default", "Built-in theme name or path to a JSON theme file")
+	language := flag.String("lang", "", "Document language for hyphenation (overrides the front matter)")
+	password := flag.String("password", "", "User password required to open the PDF (enables encryption)")
+	ownerPassword := flag.String("owner-password", "", "Owner password granting full permissions (enables encryption)")
+	algorithm := flag.String("encryption", mark2pdf.EncryptAES256, "Encryption algorithm: rc4, aes128 or aes256")
+	noPrint := flag.Bool("no-print", false, "Deny printing (enables encryption)")
+	noCopy := flag.Bool("no-copy", false, "Deny copying text and images (enables encryption)")
+	noModify := flag.Bool("no-modify", false, "Deny modifying the document (enables encryption)")
+	noAnnotate := flag.Bool("no-annotate", false, "Deny adding annotations and filling forms (enables encryption)")
+	tagged := flag.Bool("tagged", false, "Produce a tagged PDF with a logical structure for screen readers")
+	noBookmarks := flag.Bool("no-bookmarks", false, "Do not add bookmarks for the headings")
+	compact := flag.Bool("compact", false, "Write a smaller PDF 1.5 file with object streams and a cross-reference stream")
+	linearize := flag.Bool("linearize", false, "Write a linearized PDF whose first page displays before the download completes")
+	compression := flag.String("compression", mark2pdf.CompressionDefault, "Stream compression level: none, fast, best or default")
+	debug := flag.Bool("debug", false, "Write uncompressed, readable page content streams for troubleshooting")
+	watermark := flag.String("watermark", "", "Watermark text drawn diagonally behind the content, e.g. DRAFT (overrides the front matter)")
+	watermarkPages := flag.String("watermark-pages", "", "Pages that get the watermark, e.g. 1 or 2-5 or 3- (default all)")
+	letterhead := flag.String("letterhead", "", "PDF file whose first page is drawn as background (letterhead)")
+	letterheadPages := flag.String("letterhead-pages", "", "Pages that get the letterhead, e.g. 1 or 2- (default all)")
+	letterheadTop := flag.Float64("letterhead-top", 0, "Space in points reserved for the letterhead at the top of the page")
+	letterheadBottom := flag.Float64("letterhead-bottom", 0, "Space in points reserved for the letterhead at the bottom of the page")
+	var appendPDFs appendFlags
+	flag.Var(&appendPDFs, "append", "Append the pages of a PDF file, optionally a range: file.pdf or file.pdf:2-5 (repeatable)")
+	attachSource := flag.Bool("attach-source", false, "Embed the Markdown source and the local files it references in the PDF")
+	var attachments attachFlags
+	flag.Var(&attachments, "attach", "Embed a file in the PDF as an attachment (repeatable)")
+	timestamp := flag.Bool("timestamp", false, "Write the current time as creation date (SOURCE_DATE_EPOCH is used when set)")
+	pdfa := flag.Bool("pdfa", false, "Produce a PDF/A-2b archival file (requires embedded fonts)")
+	pdfaLevel := flag.String("pdfa-level", mark2pdf.PDFA2B, "PDF/A level with -pdfa: 2b, or 3b for documents with attachments")
+	var embedFonts fontFlags
+	flag.Var(&embedFonts, "embed-font", "Embed a TrueType font in place of a standard font: Name=file.ttf (repeatable)")
 	showVersion := flag.Bool("version", false, "Show version information")
 	help := flag.Bool("help", false, "Show help message")
 
@@ -41,10 +76,69 @@ func main() {
 		os.Exit(1)
 	}
 
+	theme, err := loadTheme(*themeName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Convert the file
 	fmt.Printf("Converting '%s' to '%s'...\n", *inputFile, *outputFile)
 
-	err := mark2pdf.ConvertFile(*inputFile, *outputFile)
+	data, err := os.ReadFile(*inputFile)
+	if err == nil {
+		converter := mark2pdf.NewConverter(string(data))
+		converter.SetTheme(theme)
+		converter.SetBaseDir(filepath.Dir(*inputFile))
+		if *attachSource {
+			converter.SetAttachSource(filepath.Base(*inputFile))
+		}
+		converter.SetLanguage(*language)
+		if *password != "" || *ownerPassword != "" || *noPrint || *noCopy || *noModify || *noAnnotate {
+			converter.SetEncryption(&mark2pdf.Encryption{
+				UserPassword:  *password,
+				OwnerPassword: *ownerPassword,
+				Algorithm:     *algorithm,
+				NoPrint:       *noPrint,
+				NoCopy:        *noCopy,
+				NoModify:      *noModify,
+				NoAnnotate:    *noAnnotate,
+			})
+		}
+		converter.SetTagged(*tagged)
+		converter.SetBookmarks(!*noBookmarks)
+		converter.SetCompact(*compact)
+		converter.SetLinearized(*linearize)
+		if *timestamp && os.Getenv("SOURCE_DATE_EPOCH") == "" {
+			converter.SetCreationDate(time.Now())
+		}
+		converter.SetPDFA(*pdfa)
+		converter.SetDebug(*debug)
+		if *watermark != "" {
+			w := mark2pdf.NewWatermark(*watermark)
+			w.Pages = *watermarkPages
+			converter.SetWatermark(w)
+		}
+		err = converter.SetCompression(*compression)
+		if err == nil && *pdfa {
+			err = converter.SetPDFALevel(*pdfaLevel)
+		}
+		if err == nil && *letterhead != "" {
+			err = addLetterhead(converter, *letterhead, *letterheadPages, *letterheadTop, *letterheadBottom)
+		}
+		if err == nil {
+			err = appendPDFs.apply(converter)
+		}
+		if err == nil {
+			err = attachments.apply(converter)
+		}
+		if err == nil {
+			err = embedFonts.apply(converter)
+		}
+		if err == nil {
+			err = converter.ConvertToFile(*outputFile)
+		}
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error during conversion: %v\n", err)
 		os.Exit(1)
@@ -59,6 +153,111 @@ func main() {
 	}
 }
 
+// addLetterhead legge il PDF della carta intestata e lo usa come sfondo
+func addLetterhead(converter *mark2pdf.Converter, path, pages string, top, bottom float64) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return converter.AddBackground(&mark2pdf.Background{PDF: data, Pages: pages, Top: top, Bottom: bottom})
+}
+
+// appendFlags raccoglie le opzioni -append ripetute
+type appendFlags []string
+
+func (f *appendFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *appendFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// pageList riconosce un elenco di pagine dopo i due punti di -append
+var pageList = regexp.MustCompile(`^[0-9][0-9,\- ]*$`)
+
+// apply aggiunge in fondo al documento i PDF indicati, con le pagine dopo i due punti
+func (f appendFlags) apply(converter *mark2pdf.Converter) error {
+	for _, value := range f {
+		file, pages := value, ""
+		if i := strings.LastIndex(value, ":"); i >= 0 && pageList.MatchString(value[i+1:]) {
+			file, pages = value[:i], value[i+1:]
+		}
+		if err := converter.AppendPDF(file, pages); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attachFlags raccoglie le opzioni -attach ripetute
+type attachFlags []string
+
+func (f *attachFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *attachFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// apply incorpora i file indicati nel PDF, con il loro nome
+func (f attachFlags) apply(converter *mark2pdf.Converter) error {
+	for _, file := range f {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := converter.AttachFile(&mark2pdf.Attachment{Name: filepath.Base(file), Data: data}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fontFlags raccoglie le opzioni -embed-font ripetute
+type fontFlags []string
+
+func (f *fontFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *fontFlags) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("expected Name=file.ttf, got %q", value)
+	}
+	*f = append(*f, value)
+	return nil
+}
+
+// apply legge i file dei font e li incorpora nel convertitore
+func (f fontFlags) apply(converter *mark2pdf.Converter) error {
+	for _, value := range f {
+		name, file, _ := strings.Cut(value, "=")
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := converter.EmbedFont(name, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadTheme restituisce un tema incluso per nome o lo carica da un file JSON
+func loadTheme(name string) (*mark2pdf.Theme, error) {
+	if theme, ok := mark2pdf.BuiltinTheme(name); ok {
+		return theme, nil
+	}
+	if _, err := os.Stat(name); err != nil {
+		return nil, fmt.Errorf("unknown theme '%s' (built-in themes: %s)", name, strings.Join(mark2pdf.ThemeNames(), ", "))
+	}
+	return mark2pdf.LoadTheme(name)
+}
+
 func printHelp() {
 	fmt.Println("Mark2PDF - Convert Markdown to PDF")
 	fmt.Printf("Version: %s\n\n", version)
@@ -70,6 +269,62 @@ func printHelp() {
 	fmt.Println("        Input Markdown file (required)")
 	fmt.Println("  -output string")
 	fmt.Println("        Output PDF file (required)")
+	fmt.Println("  -theme string")
+	fmt.Printf("        Built-in theme (%s) or JSON theme file (default \"default\")\n", strings.Join(mark2pdf.ThemeNames(), ", "))
+	fmt.Println("  -lang string")
+	fmt.Printf("        Document language for hyphenation (%s); overrides lang in the front matter\n", strings.Join(mark2pdf.HyphenationLanguages(), ", "))
+	fmt.Println("  -password string")
+	fmt.Println("        User password required to open the PDF")
+	fmt.Println("  -owner-password string")
+	fmt.Println("        Owner password granting full permissions (random if omitted)")
+	fmt.Println("  -encryption string")
+	fmt.Println("        Encryption algorithm: rc4, aes128 or aes256 (default \"aes256\")")
+	fmt.Println("  -no-print, -no-copy, -no-modify, -no-annotate")
+	fmt.Println("        Deny printing, copying, modifying or annotating")
+	fmt.Println("        Any password or permission option encrypts the PDF")
+	fmt.Println("  -tagged")
+	fmt.Println("        Produce a tagged PDF (headings, lists, tables, links) for screen readers")
+	fmt.Println("  -no-bookmarks")
+	fmt.Println("        Do not add bookmarks for the headings (numbered as in the text)")
+	fmt.Println("  -compact")
+	fmt.Println("        Write a smaller PDF 1.5 file (object streams, cross-reference stream)")
+	fmt.Println("  -linearize")
+	fmt.Println("        Write a linearized PDF (fast web view): browsers show page one before")
+	fmt.Println("        the whole file is downloaded")
+	fmt.Println("  -compression string")
+	fmt.Println("        Stream compression level: none, fast, best or default (default \"default\")")
+	fmt.Println("  -debug")
+	fmt.Println("        Write page content streams uncompressed, to inspect the PDF operators")
+	fmt.Println("  -watermark string")
+	fmt.Println("        Draw a rotated, semi-transparent text behind the content of every page")
+	fmt.Println("        (e.g. DRAFT); overrides the watermark front matter key")
+	fmt.Println("  -watermark-pages string")
+	fmt.Println("        Pages that get the watermark: 1, 2-5, 3- or a list such as 1,4-6")
+	fmt.Println("  -letterhead file.pdf")
+	fmt.Println("        Draw the first page of a PDF (e.g. a letterhead) behind the content")
+	fmt.Println("  -letterhead-pages string")
+	fmt.Println("        Pages that get the letterhead: 1, 2-5, 3- or a list such as 1,4-6")
+	fmt.Println("  -letterhead-top, -letterhead-bottom float")
+	fmt.Println("        Space in points kept free for the letterhead at the top and bottom")
+	fmt.Println("  -append file.pdf[:pages]")
+	fmt.Println("        Append the pages of a PDF (e.g. a signed scan or a datasheet), all or")
+	fmt.Println("        a range such as 2-5; repeatable. Markdown can insert them in place")
+	fmt.Println("        with a ![[file.pdf]] or ![[file.pdf|2-5]] paragraph")
+	fmt.Println("  -attach-source")
+	fmt.Println("        Embed the Markdown source and the local images and PDFs it references,")
+	fmt.Println("        for traceability")
+	fmt.Println("  -attach file")
+	fmt.Println("        Embed a file in the PDF as an attachment; repeatable")
+	fmt.Println("  -timestamp")
+	fmt.Println("        Write the current time as creation date; without it the output is")
+	fmt.Println("        reproducible (SOURCE_DATE_EPOCH, when set, provides the date)")
+	fmt.Println("  -pdfa")
+	fmt.Println("        Produce a PDF/A-2b archival file; every font used must be embedded")
+	fmt.Println("  -pdfa-level string")
+	fmt.Println("        PDF/A level with -pdfa: 2b, or 3b to allow attachments (default \"2b\")")
+	fmt.Println("  -embed-font Name=file.ttf")
+	fmt.Println("        Embed a TrueType font in place of a standard font (Helvetica,")
+	fmt.Println("        Helvetica-Bold, Times-Roman, Courier, ...); repeatable")
 	fmt.Println("  -version")
 	fmt.Println("        Show version information")
 	fmt.Println("  -help")
@@ -78,6 +333,24 @@ func printHelp() {
 	fmt.Println("Examples:")
 	fmt.Println("  mark2pdf -input README.md -output README.pdf")
 	fmt.Println("  mark2pdf -input document.md -output document.pdf")
+	fmt.Println("  mark2pdf -input paper.md -output paper.pdf -theme academic")
+	fmt.Println("  mark2pdf -input notes.md -output notes.pdf -theme mytheme.json")
+	fmt.Println("  mark2pdf -input tesi.md -output tesi.pdf -theme academic -lang it")
+	fmt.Println("  mark2pdf -input report.md -output report.pdf -password secret -no-print -no-copy")
+	fmt.Println("  mark2pdf -input guide.md -output guide.pdf -tagged -lang en")
+	fmt.Println("  mark2pdf -input manual.md -output manual.pdf -tagged -compact")
+	fmt.Println("  mark2pdf -input manual.md -output manual.pdf -linearize")
+	fmt.Println("  mark2pdf -input report.md -output report.pdf -timestamp")
+	fmt.Println("  mark2pdf -input manual.md -output manual.pdf -compression best")
+	fmt.Println("  mark2pdf -input contract.md -output contract.pdf -watermark DRAFT -watermark-pages 2-")
+	fmt.Println("  mark2pdf -input letter.md -output letter.pdf -letterhead acme.pdf -letterhead-pages 1 -letterhead-top 150")
+	fmt.Println("  mark2pdf -input offer.md -output offer.pdf -append signed.pdf -append datasheet.pdf:1-2")
+	fmt.Println("  mark2pdf -input report.md -output report.pdf -attach-source -attach data.csv")
+	fmt.Println("  mark2pdf -input report.md -output report.pdf -attach-source -pdfa -pdfa-level 3b \\")
+	fmt.Println("      -embed-font Helvetica=DejaVuSans.ttf -embed-font Helvetica-Bold=DejaVuSans-Bold.ttf")
+	fmt.Println("  mark2pdf -input layout.md -output layout.pdf -debug")
+	fmt.Println("  mark2pdf -input archive.md -output archive.pdf -pdfa -embed-font Helvetica=DejaVuSans.ttf \\")
+	fmt.Println("      -embed-font Helvetica-Bold=DejaVuSans-Bold.ttf -embed-font Courier=DejaVuSansMono.ttf")
 	fmt.Println()
 	fmt.Println("For more information, visit: https://github.com/beinux3/Mark2PDF")
 }
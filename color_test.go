@@ -57,45 +57,48 @@ func abs(x float64) float64 {
 
 func TestColorInlineElements(t *testing.T) {
 	parser := NewMarkdownParser("{red}red text{/red} and {blue}blue text{/blue}")
-	elements := parser.Parse()
+	doc := parser.Parse()
 
-	if len(elements) != 1 {
-		t.Fatalf("Expected 1 element, got %d", len(elements))
+	if len(doc.Blocks) != 1 {
+		t.Fatalf("Expected 1 block, got %d", len(doc.Blocks))
 	}
 
-	if elements[0].Type != "p" {
-		t.Errorf("Expected paragraph, got %s", elements[0].Type)
+	paragraph, ok := doc.Blocks[0].(*Paragraph)
+	if !ok {
+		t.Fatalf("Expected paragraph, got %T", doc.Blocks[0])
 	}
 
-	children := elements[0].Children
+	children := paragraph.Inlines
 	if len(children) != 3 { // red + text + blue
 		t.Fatalf("Expected 3 children, got %d", len(children))
 	}
 
 	// First should be colored red
-	if children[0].Type != "color" {
-		t.Errorf("Expected color element, got %s", children[0].Type)
+	red, ok := children[0].(*ColorSpan)
+	if !ok {
+		t.Fatalf("Expected color element, got %T", children[0])
 	}
-	if children[0].Content != "red text" {
-		t.Errorf("Expected 'red text', got '%s'", children[0].Content)
+	if got := TextContent(red); got != "red text" {
+		t.Errorf("Expected 'red text', got '%s'", got)
 	}
-	if children[0].Color == nil || children[0].Color.R != 1.0 {
+	if red.Color.R != 1.0 {
 		t.Error("Expected red color")
 	}
 
 	// Second should be plain text " and "
-	if children[1].Type != "text" {
-		t.Errorf("Expected text element, got %s", children[1].Type)
+	if _, ok := children[1].(*Text); !ok {
+		t.Errorf("Expected text element, got %T", children[1])
 	}
 
 	// Third should be colored blue
-	if children[2].Type != "color" {
-		t.Errorf("Expected color element, got %s", children[2].Type)
+	blue, ok := children[2].(*ColorSpan)
+	if !ok {
+		t.Fatalf("Expected color element, got %T", children[2])
 	}
-	if children[2].Content != "blue text" {
-		t.Errorf("Expected 'blue text', got '%s'", children[2].Content)
+	if got := TextContent(blue); got != "blue text" {
+		t.Errorf("Expected 'blue text', got '%s'", got)
 	}
-	if children[2].Color == nil || children[2].Color.B != 1.0 {
+	if blue.Color.B != 1.0 {
 		t.Error("Expected blue color")
 	}
 }
@@ -116,13 +119,13 @@ func TestNewColor(t *testing.T) {
 
 func TestColorWithFormatting(t *testing.T) {
 	parser := NewMarkdownParser("{red}red text{/red} with **bold**")
-	elements := parser.Parse()
+	doc := parser.Parse()
 
-	if len(elements) != 1 {
-		t.Fatalf("Expected 1 element, got %d", len(elements))
+	if len(doc.Blocks) != 1 {
+		t.Fatalf("Expected 1 block, got %d", len(doc.Blocks))
 	}
 
-	children := elements[0].Children
+	children := doc.Blocks[0].(*Paragraph).Inlines
 	if len(children) == 0 {
 		t.Fatal("Expected children elements")
 	}
@@ -131,10 +134,10 @@ func TestColorWithFormatting(t *testing.T) {
 	hasColor := false
 	hasBold := false
 	for _, child := range children {
-		if child.Type == "color" && child.Color != nil {
+		switch child.(type) {
+		case *ColorSpan:
 			hasColor = true
-		}
-		if child.Type == "bold" {
+		case *Strong:
 			hasBold = true
 		}
 	}
@@ -0,0 +1,72 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+)
+
+// Livelli di compressione degli stream (FlateDecode)
+const (
+	CompressionDefault = "default"
+	CompressionNone    = "none" // blocchi non compressi: file grandi, scrittura più veloce
+	CompressionFast    = "fast"
+	CompressionBest    = "best"
+)
+
+// compressionLevels associa i livelli ai livelli di zlib
+var compressionLevels = map[string]int{
+	"":                 zlib.DefaultCompression,
+	CompressionDefault: zlib.DefaultCompression,
+	CompressionNone:    zlib.NoCompression,
+	CompressionFast:    zlib.BestSpeed,
+	CompressionBest:    zlib.BestCompression,
+}
+
+// SetCompression imposta il livello di compressione degli stream: CompressionNone,
+// CompressionFast, CompressionBest o CompressionDefault
+func (p *PDFWriter) SetCompression(level string) error {
+	if _, ok := compressionLevels[level]; !ok {
+		return fmt.Errorf("livello di compressione sconosciuto %q (disponibili: %s, %s, %s, %s)",
+			level, CompressionNone, CompressionFast, CompressionBest, CompressionDefault)
+	}
+	p.compression = level
+	return nil
+}
+
+// SetDebug scrive gli stream di contenuto delle pagine senza compressione, con un
+// commento che indica la pagina, per leggerne gli operatori con un editor di testo
+func (p *PDFWriter) SetDebug(enabled bool) {
+	p.debug = enabled
+}
+
+// deflate comprime i dati con zlib (FlateDecode) al livello indicato
+func deflate(data []byte, level int) ([]byte, error) {
+	var compressed bytes.Buffer
+	w, err := zlib.NewWriterLevel(&compressed, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+// deflate comprime i dati al livello del documento; un errore del compressore
+// interrompe la scrittura come un errore di scrittura
+func (o *objectWriter) deflate(data []byte) []byte {
+	compressed, err := deflate(data, o.level)
+	if err != nil && o.err == nil {
+		o.err = fmt.Errorf("compressione dello stream: %w", err)
+	}
+	return compressed
+}
+
+// flateStream scrive un oggetto stream compresso; dict contiene le voci prima di /Filter
+func (o *objectWriter) flateStream(num int, dict string, data []byte) {
+	o.stream(num, dict+" /Filter /FlateDecode", o.deflate(data))
+}
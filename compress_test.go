@@ -0,0 +1,71 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCompressionLevels(t *testing.T) {
+	markdown := "# Manuale\n\n" + fillerLines(200)
+	expected, err := NewConverter(markdown).Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+
+	sizes := make(map[string]int)
+	for _, level := range []string{CompressionNone, CompressionFast, CompressionBest, CompressionDefault} {
+		t.Run(level, func(t *testing.T) {
+			converter := NewConverter(markdown)
+			if err := converter.SetCompression(level); err != nil {
+				t.Fatalf("SetCompression failed: %v", err)
+			}
+			data, err := converter.Convert()
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			if !reflect.DeepEqual(pageLines(t, data), pageLines(t, expected)) {
+				t.Error("Expected the same page content at every level")
+			}
+			sizes[level] = len(data)
+		})
+	}
+	if sizes[CompressionNone] <= sizes[CompressionFast] || sizes[CompressionFast] < sizes[CompressionBest] {
+		t.Errorf("Expected none > fast >= best, got %v", sizes)
+	}
+	if sizes[CompressionDefault] != len(expected) {
+		t.Errorf("Expected the default level to match the default output, got %d and %d", sizes[CompressionDefault], len(expected))
+	}
+
+	if err := NewConverter(markdown).SetCompression("maximum"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+}
+
+func TestDebugContentStreams(t *testing.T) {
+	converter := NewConverter("# Titolo\n\n" + fillerLines(60))
+	converter.SetDebug(true)
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	for _, expected := range []string{"stream\n% page 1\n", "stream\n% page 2\n", "BT\n", "(Titolo) Tj"} {
+		if !bytes.Contains(data, []byte(expected)) {
+			t.Errorf("Expected %q in the debug output", expected)
+		}
+	}
+	// Only the page contents are left uncompressed
+	if n := bytes.Count(data, []byte("/Filter /FlateDecode")); n != 0 {
+		t.Errorf("Expected no compressed streams, got %d", n)
+	}
+}
+
+func TestCompressionError(t *testing.T) {
+	var buf bytes.Buffer
+	o := newObjectWriter(&buf, nil, false)
+	o.level = 42 // not a zlib level
+	o.flateStream(1, "", []byte("q Q"))
+	if err := o.finish(2, "/Size 2"); err == nil {
+		t.Error("Expected the compressor error to be returned")
+	}
+}
@@ -0,0 +1,254 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+)
+
+// Algoritmi di cifratura (Encryption.Algorithm)
+const (
+	EncryptRC4    = "rc4"    // RC4 a 128 bit (revisione 3), PDF 1.4
+	EncryptAES128 = "aes128" // AES a 128 bit (revisione 4), PDF 1.6
+	EncryptAES256 = "aes256" // AES a 256 bit (revisione 6), PDF 2.0
+)
+
+// Encryption configura la cifratura del PDF con lo standard security handler.
+// I permessi negati valgono per chi apre il documento con la password utente;
+// la password proprietario dà sempre accesso completo.
+type Encryption struct {
+	UserPassword  string // password per aprire il documento; vuota = apertura senza password
+	OwnerPassword string // password con tutti i permessi; vuota = casuale, i permessi non si possono rimuovere
+	Algorithm     string // EncryptRC4, EncryptAES128 o EncryptAES256 (default)
+
+	NoPrint    bool // vieta la stampa
+	NoCopy     bool // vieta la copia di testo e immagini
+	NoModify   bool // vieta le modifiche e il riassemblaggio delle pagine
+	NoAnnotate bool // vieta annotazioni e compilazione dei moduli
+}
+
+// permissions restituisce il valore /P: i bit 1-2 sono zero, gli altri permessi concessi
+func (e *Encryption) permissions() int32 {
+	p := uint32(0xFFFFFFFC)
+	deny := func(bits ...int) {
+		for _, bit := range bits {
+			p &^= 1 << (bit - 1)
+		}
+	}
+	if e.NoPrint {
+		deny(3, 12)
+	}
+	if e.NoModify {
+		deny(4, 11)
+	}
+	if e.NoCopy {
+		deny(5)
+	}
+	if e.NoAnnotate {
+		deny(6, 9)
+	}
+	return int32(p)
+}
+
+// version restituisce la versione PDF richiesta dall'algoritmo
+func (e *Encryption) version() string {
+	switch e.Algorithm {
+	case EncryptRC4:
+		return "1.4"
+	case EncryptAES128:
+		return "1.6"
+	}
+	return "1.7"
+}
+
+// securityHandler cifra gli stream e le stringhe degli oggetti del documento
+type securityHandler struct {
+	revision int
+	key      []byte // chiave del documento
+	dict     string // dizionario /Encrypt
+}
+
+// passwordPadding completa le password a 32 byte (revisioni 2-4)
+var passwordPadding = []byte{
+	0x28, 0xBF, 0x4E, 0x5E, 0x4E, 0x75, 0x8A, 0x41, 0x64, 0x00, 0x4E, 0x56, 0xFF, 0xFA, 0x01, 0x08,
+	0x2E, 0x2E, 0x00, 0xB6, 0xD0, 0x68, 0x3E, 0x80, 0x2F, 0x0C, 0xA9, 0xFE, 0x64, 0x53, 0x69, 0x7A,
+}
+
+// newSecurityHandler calcola chiavi e dizionario /Encrypt; id è il primo elemento di /ID del trailer
+func newSecurityHandler(e *Encryption, id []byte) (*securityHandler, error) {
+	owner := []byte(e.OwnerPassword)
+	if len(owner) == 0 {
+		owner = randomBytes(16)
+	}
+	user := []byte(e.UserPassword)
+	p := e.permissions()
+
+	switch e.Algorithm {
+	case EncryptRC4, EncryptAES128:
+		o := ownerEntry(owner, user)
+		key := documentKey(user, o, p, id)
+		s := &securityHandler{revision: 3, key: key}
+		filter := "/V 2 /R 3 /Length 128"
+		if e.Algorithm == EncryptAES128 {
+			s.revision = 4
+			filter = "/V 4 /R 4 /Length 128 /CF << /StdCF << /Type /CryptFilter /CFM /AESV2 /AuthEvent /DocOpen /Length 16 >> >> /StmF /StdCF /StrF /StdCF"
+		}
+		s.dict = fmt.Sprintf("<< /Filter /Standard %s /O <%x> /U <%x> /P %d >>", filter, o, userEntry(key, id), p)
+		return s, nil
+
+	case EncryptAES256, "":
+		// Passwords are UTF-8, at most 127 bytes
+		user, owner = user[:min(len(user), 127)], owner[:min(len(owner), 127)]
+		key := randomBytes(32)
+		salts := randomBytes(32) // user validation, user key, owner validation, owner key
+		u := append(append(hashR6(user, salts[0:8], nil), salts[0:8]...), salts[8:16]...)
+		ue := aesNoPadding(hashR6(user, salts[8:16], nil), key)
+		o := append(append(hashR6(owner, salts[16:24], u), salts[16:24]...), salts[24:32]...)
+		oe := aesNoPadding(hashR6(owner, salts[24:32], u), key)
+		perms := make([]byte, 16)
+		binary.LittleEndian.PutUint32(perms, uint32(p))
+		copy(perms[4:], []byte{0xFF, 0xFF, 0xFF, 0xFF, 'T', 'a', 'd', 'b'})
+		copy(perms[12:], randomBytes(4))
+		s := &securityHandler{revision: 6, key: key}
+		s.dict = fmt.Sprintf("<< /Filter /Standard /V 5 /R 6 /Length 256 "+
+			"/CF << /StdCF << /Type /CryptFilter /CFM /AESV3 /AuthEvent /DocOpen /Length 32 >> >> /StmF /StdCF /StrF /StdCF "+
+			"/O <%x> /U <%x> /OE <%x> /UE <%x> /P %d /Perms <%x> >>", o, u, oe, ue, p, aesNoPadding(key, perms))
+		return s, nil
+	}
+	return nil, fmt.Errorf("algoritmo di cifratura sconosciuto %q (disponibili: %s, %s, %s)",
+		e.Algorithm, EncryptRC4, EncryptAES128, EncryptAES256)
+}
+
+// encrypt cifra lo stream o la stringa dell'oggetto objNum
+func (s *securityHandler) encrypt(objNum int, data []byte) []byte {
+	switch s.revision {
+	case 3:
+		c, _ := rc4.NewCipher(s.objectKey(objNum))
+		out := make([]byte, len(data))
+		c.XORKeyStream(out, data)
+		return out
+	case 4:
+		return aesCBC(s.objectKey(objNum), data)
+	}
+	return aesCBC(s.key, data)
+}
+
+// objectKey deriva la chiave di un oggetto dalla chiave del documento (revisioni 3 e 4)
+func (s *securityHandler) objectKey(objNum int) []byte {
+	b := append(append([]byte(nil), s.key...), byte(objNum), byte(objNum>>8), byte(objNum>>16), 0, 0)
+	if s.revision == 4 {
+		b = append(b, "sAlT"...)
+	}
+	sum := md5.Sum(b)
+	return sum[:min(len(s.key)+5, 16)]
+}
+
+// padPassword tronca o completa una password a 32 byte
+func padPassword(password []byte) []byte {
+	return append(append([]byte(nil), password[:min(len(password), 32)]...), passwordPadding...)[:32]
+}
+
+// rc4Rounds cifra data con RC4 20 volte, con la chiave combinata in XOR con 0..19
+func rc4Rounds(key, data []byte) []byte {
+	out := append([]byte(nil), data...)
+	k := make([]byte, len(key))
+	for i := 0; i < 20; i++ {
+		for j := range key {
+			k[j] = key[j] ^ byte(i)
+		}
+		c, _ := rc4.NewCipher(k)
+		c.XORKeyStream(out, out)
+	}
+	return out
+}
+
+// md5Rounds applica MD5 e poi altre 50 volte ai primi 16 byte
+func md5Rounds(data []byte) []byte {
+	sum := md5.Sum(data)
+	for i := 0; i < 50; i++ {
+		sum = md5.Sum(sum[:16])
+	}
+	return sum[:]
+}
+
+// ownerEntry calcola il valore /O (revisioni 3 e 4)
+func ownerEntry(owner, user []byte) []byte {
+	return rc4Rounds(md5Rounds(padPassword(owner)), padPassword(user))
+}
+
+// documentKey calcola la chiave del documento dalla password utente (revisioni 3 e 4)
+func documentKey(user, o []byte, p int32, id []byte) []byte {
+	b := append(padPassword(user), o...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(p))
+	return md5Rounds(append(b, id...))
+}
+
+// userEntry calcola il valore /U (revisioni 3 e 4); gli ultimi 16 byte sono arbitrari
+func userEntry(key, id []byte) []byte {
+	sum := md5.Sum(append(append([]byte(nil), passwordPadding...), id...))
+	return append(rc4Rounds(key, sum[:]), make([]byte, 16)...)
+}
+
+// hashR6 è l'hash delle password della revisione 6 (ISO 32000-2, algoritmo 2.B)
+func hashR6(password, salt, udata []byte) []byte {
+	sum := sha256.Sum256(bytes.Join([][]byte{password, salt, udata}, nil))
+	k := sum[:]
+	var e []byte
+	for i := 0; i < 64 || int(e[len(e)-1]) > i-32; i++ {
+		k1 := bytes.Repeat(bytes.Join([][]byte{password, k, udata}, nil), 64)
+		block, _ := aes.NewCipher(k[:16])
+		e = make([]byte, len(k1))
+		cipher.NewCBCEncrypter(block, k[16:32]).CryptBlocks(e, k1)
+		// The first 16 bytes of E as a big-endian number, modulo 3
+		sum := 0
+		for _, b := range e[:16] {
+			sum += int(b)
+		}
+		var h hash.Hash
+		switch sum % 3 {
+		case 0:
+			h = sha256.New()
+		case 1:
+			h = sha512.New384()
+		default:
+			h = sha512.New()
+		}
+		h.Write(e)
+		k = h.Sum(nil)
+	}
+	return k[:32]
+}
+
+// aesCBC cifra data con AES-CBC e padding PKCS#5; il vettore iniziale casuale precede il testo cifrato
+func aesCBC(key, data []byte) []byte {
+	block, _ := aes.NewCipher(key)
+	n := aes.BlockSize - len(data)%aes.BlockSize
+	plain := append(append([]byte(nil), data...), bytes.Repeat([]byte{byte(n)}, n)...)
+	out := randomBytes(aes.BlockSize)
+	out = append(out, make([]byte, len(plain))...)
+	cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).CryptBlocks(out[aes.BlockSize:], plain)
+	return out
+}
+
+// aesNoPadding cifra blocchi interi con AES-CBC, vettore iniziale nullo e senza padding
+func aesNoPadding(key, data []byte) []byte {
+	block, _ := aes.NewCipher(key)
+	out := make([]byte, len(data))
+	cipher.NewCBCEncrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(out, data)
+	return out
+}
+
+// randomBytes restituisce n byte casuali
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return b
+}
@@ -0,0 +1,171 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rc4"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// encryptEntry restituisce il valore esadecimale di una voce del dizionario /Encrypt
+func encryptEntry(t *testing.T, data []byte, key string) []byte {
+	t.Helper()
+	m := regexp.MustCompile(`/` + key + ` <([0-9a-f]*)>`).FindSubmatch(data)
+	if m == nil {
+		t.Fatalf("Missing /%s in the encryption dictionary", key)
+	}
+	b, _ := hex.DecodeString(string(m[1]))
+	return b
+}
+
+// firstContentStream restituisce numero e dati cifrati del primo stream di contenuto
+func firstContentStream(t *testing.T, data []byte) (int, []byte) {
+	t.Helper()
+	m := regexp.MustCompile(`(\d+) 0 obj\n<< /Length (\d+) /Filter /FlateDecode >>\nstream\n`).FindSubmatchIndex(data)
+	if m == nil {
+		t.Fatal("No content stream found")
+	}
+	objNum, _ := strconv.Atoi(string(data[m[2]:m[3]]))
+	length, _ := strconv.Atoi(string(data[m[4]:m[5]]))
+	return objNum, data[m[1] : m[1]+length]
+}
+
+// aesDecrypt decifra AES-CBC con il vettore iniziale in testa e toglie il padding
+func aesDecrypt(t *testing.T, key, data []byte) []byte {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil || len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		t.Fatalf("Invalid AES data (%d bytes): %v", len(data), err)
+	}
+	out := make([]byte, len(data)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, data[:aes.BlockSize]).CryptBlocks(out, data[aes.BlockSize:])
+	return out[:len(out)-int(out[len(out)-1])]
+}
+
+func TestEncryption(t *testing.T) {
+	for _, algorithm := range []string{EncryptRC4, EncryptAES128, EncryptAES256} {
+		t.Run(algorithm, func(t *testing.T) {
+			converter := NewConverter("# Secret\n\nConfidential report\n")
+			converter.SetEncryption(&Encryption{UserPassword: "user", OwnerPassword: "owner", Algorithm: algorithm, NoPrint: true})
+			data, err := converter.Convert()
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			if bytes.Contains(data, []byte("Confidential")) {
+				t.Fatal("Expected the content to be encrypted")
+			}
+			m := regexp.MustCompile(`/P (-?\d+)`).FindSubmatch(data)
+			if m == nil {
+				t.Fatal("Missing /P in the encryption dictionary")
+			}
+			p, _ := strconv.Atoi(string(m[1]))
+			if p&(1<<2) != 0 {
+				t.Errorf("Expected printing to be denied, got /P %d", p)
+			}
+
+			o, u := encryptEntry(t, data, "O"), encryptEntry(t, data, "U")
+			objNum, stream := firstContentStream(t, data)
+			var plain []byte
+			if algorithm == EncryptAES256 {
+				if !bytes.Equal(hashR6([]byte("user"), u[32:40], nil), u[:32]) {
+					t.Fatal("User password does not validate")
+				}
+				if !bytes.Equal(hashR6([]byte("owner"), o[32:40], u[:48]), o[:32]) {
+					t.Fatal("Owner password does not validate")
+				}
+				block, _ := aes.NewCipher(hashR6([]byte("user"), u[40:48], nil))
+				key := make([]byte, 32)
+				cipher.NewCBCDecrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(key, encryptEntry(t, data, "UE"))
+
+				perms := make([]byte, 16)
+				block, _ = aes.NewCipher(key)
+				block.Decrypt(perms, encryptEntry(t, data, "Perms"))
+				if int32(binary.LittleEndian.Uint32(perms)) != int32(p) || string(perms[9:12]) != "adb" {
+					t.Errorf("Invalid /Perms %x", perms)
+				}
+				plain = aesDecrypt(t, key, stream)
+			} else {
+				id, _ := hex.DecodeString(regexp.MustCompile(`/ID \[<([0-9a-f]+)>`).FindStringSubmatch(string(data))[1])
+				key := documentKey([]byte("user"), o, int32(p), id)
+				if !bytes.Equal(userEntry(key, id)[:16], u[:16]) {
+					t.Fatal("User password does not validate")
+				}
+				s := &securityHandler{revision: 3, key: key}
+				if algorithm == EncryptAES128 {
+					s.revision = 4
+					plain = aesDecrypt(t, s.objectKey(objNum), stream)
+				} else {
+					c, _ := rc4.NewCipher(s.objectKey(objNum))
+					plain = make([]byte, len(stream))
+					c.XORKeyStream(plain, stream)
+				}
+			}
+
+			r, err := zlib.NewReader(bytes.NewReader(plain))
+			if err != nil {
+				t.Fatalf("Decrypted stream is not valid: %v", err)
+			}
+			content, _ := io.ReadAll(r)
+			if !strings.Contains(string(content), "(Confidential report) Tj") {
+				t.Errorf("Expected the decrypted text, got %q", content)
+			}
+		})
+	}
+}
+
+func TestEncryptionPermissions(t *testing.T) {
+	tests := []struct {
+		name       string
+		encryption Encryption
+		expected   int32
+	}{
+		{"all allowed", Encryption{}, -4},
+		{"no print", Encryption{NoPrint: true}, -4 &^ (1<<2 | 1<<11)},
+		{"no copy", Encryption{NoCopy: true}, -4 &^ (1 << 4)},
+		{"no modify or annotate", Encryption{NoModify: true, NoAnnotate: true}, -4 &^ (1<<3 | 1<<10 | 1<<5 | 1<<8)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.encryption.permissions(); got != tt.expected {
+				t.Errorf("Expected /P %d, got %d", tt.expected, got)
+			}
+		})
+	}
+
+	converter := NewConverter("text\n")
+	converter.SetEncryption(&Encryption{Algorithm: "des"})
+	if _, err := converter.Convert(); err == nil {
+		t.Error("Expected an error for an unknown algorithm")
+	}
+}
+
+func TestEncryptionStreamedID(t *testing.T) {
+	// With streaming the identifier is computed before the pages: documents with
+	// the same metadata and passwords must not share the key
+	ids := make(map[string]bool)
+	for _, markdown := range []string{"# Relazione\n\nPrimo testo\n", "# Relazione\n\nSecondo testo\n"} {
+		converter := NewConverter("---\ntitle: Relazione\nauthor: Mario Rossi\n---\n\n" + markdown)
+		converter.SetEncryption(&Encryption{UserPassword: "user", OwnerPassword: "owner", Algorithm: EncryptRC4})
+		var out bytes.Buffer
+		if err := converter.ConvertToWriter(&out); err != nil {
+			t.Fatalf("ConvertToWriter failed: %v", err)
+		}
+		m := regexp.MustCompile(`/ID \[<([0-9a-f]+)>`).FindSubmatch(out.Bytes())
+		if m == nil {
+			t.Fatal("Missing the trailer /ID")
+		}
+		ids[string(m[1])] = true
+	}
+	if len(ids) != 2 {
+		t.Errorf("Expected different identifiers, got %v", ids)
+	}
+}
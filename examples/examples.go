@@ -9,7 +9,8 @@ import (
 
 func main() {
 	fmt.Println("Mark2PDF - Examples Generator")
-	fmt.Println("==============================\n")
+	fmt.Println("==============================")
+	fmt.Println()
 
 	examples := []struct {
 		name     string
@@ -199,7 +200,8 @@ def hello(name):
 		},
 	}
 
-	fmt.Println("Generating examples...\n")
+	fmt.Println("Generating examples...")
+	fmt.Println()
 
 	for _, ex := range examples {
 		fmt.Printf("  • %s...", ex.name)
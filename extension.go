@@ -0,0 +1,230 @@
+package mark2pdf
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// InlineNode è la base da incorporare nei nodi inline personalizzati
+// prodotti dalle estensioni del parser
+type InlineNode struct {
+	Position
+}
+
+func (*InlineNode) isInline() {}
+
+// Children restituisce nil; i nodi con figli ridefiniscono il metodo
+func (*InlineNode) Children() []Node { return nil }
+
+// BlockNode è la base da incorporare nei blocchi personalizzati
+// prodotti dalle estensioni del parser
+type BlockNode struct {
+	Position
+}
+
+func (*BlockNode) isBlock() {}
+
+// Children restituisce nil; i blocchi con figli ridefiniscono il metodo
+func (*BlockNode) Children() []Node { return nil }
+
+// setPos imposta la posizione di un nodo creato da un'estensione
+func (p *Position) setPos(pos Position) {
+	*p = pos
+}
+
+// InlineExtension aggiunge una sintassi inline al parser.
+//
+// Parse è chiamata quando il testo contiene uno dei caratteri di Triggers e
+// restituisce il nodo prodotto e il numero di byte consumati, oppure nil se
+// il testo non corrisponde. Le estensioni con Priority positiva sono provate
+// prima della regola built-in per lo stesso carattere (link, enfasi, code
+// span, ...), le altre solo se la regola built-in non corrisponde. A parità
+// di priorità vale l'ordine di registrazione.
+type InlineExtension struct {
+	Triggers string
+	Priority int
+	Parse    func(ctx *InlineContext) (Inline, int)
+}
+
+// InlineContext dà accesso al testo alla posizione di un carattere trigger
+type InlineContext struct {
+	ip *inlineParser
+}
+
+// Rest restituisce il testo del blocco a partire dal carattere trigger
+func (ctx *InlineContext) Rest() string {
+	return ctx.ip.subject[ctx.ip.pos:]
+}
+
+// Previous restituisce il carattere che precede il trigger, '\n' a inizio blocco
+func (ctx *InlineContext) Previous() rune {
+	if ctx.ip.pos == 0 {
+		return '\n'
+	}
+	r, _ := utf8.DecodeLastRuneInString(ctx.ip.subject[:ctx.ip.pos])
+	return r
+}
+
+// ParseInlines analizza Rest()[start:end] come contenuto inline, ad esempio
+// il testo racchiuso tra i delimitatori di un'estensione
+func (ctx *InlineContext) ParseInlines(start, end int) []Inline {
+	ip := ctx.ip
+	sub := &inlineParser{
+		subject:    ip.subject[ip.pos+start : ip.pos+end],
+		offset:     ip.offset + ip.pos + start,
+		block:      ip.block,
+		refmap:     ip.refmap,
+		gfm:        ip.gfm,
+		extensions: ip.extensions,
+		triggers:   ip.triggers,
+	}
+	node := &mdNode{typ: nodeParagraph}
+	sub.parseAll(node)
+	setInlinePositions(ip.block, node)
+	return buildInlines(node)
+}
+
+// BlockContinuation indica come una linea prosegue un blocco personalizzato
+type BlockContinuation int
+
+// Valori restituiti da BlockParser.Continue
+const (
+	BlockContinue BlockContinuation = iota // la linea appartiene al blocco
+	BlockClose                             // la linea chiude il blocco ed è consumata
+	BlockEnd                               // il blocco termina e la linea è analizzata normalmente
+)
+
+// BlockParser gestisce un blocco personalizzato aperto da una BlockExtension.
+//
+// Continue riceve ogni linea successiva a quella di apertura, senza i marker
+// dei container esterni (ad esempio "> "). Close costruisce il nodo finale:
+// children sono i blocchi contenuti (estensioni container), lines le linee
+// raccolte (estensioni leaf). Se Close restituisce nil il blocco è scartato.
+type BlockParser interface {
+	Continue(line string) BlockContinuation
+	Close(children []Block, lines []string) Block
+}
+
+// BlockExtension aggiunge una sintassi di blocco al parser.
+//
+// Open riceve la linea che potrebbe aprire il blocco, senza l'indentazione
+// iniziale, e restituisce nil se non corrisponde. Un blocco Container contiene
+// altri blocchi Markdown; altrimenti raccoglie le linee così come sono.
+//
+// Priority ordina Open rispetto ai blocchi built-in: citazioni 900, titoli ATX
+// 800, codice delimitato 700, HTML 600, tabelle 500, titoli setext 400, linee
+// orizzontali 300, liste 200, codice indentato 100. A parità di priorità i
+// blocchi built-in sono provati per primi.
+type BlockExtension struct {
+	Priority  int
+	Container bool
+	Open      func(line string) BlockParser
+}
+
+// RegisterInlineExtension aggiunge una sintassi inline al parser
+func (mp *MarkdownParser) RegisterInlineExtension(ext InlineExtension) {
+	mp.inlineExtensions = append(mp.inlineExtensions, ext)
+}
+
+// RegisterBlockExtension aggiunge una sintassi di blocco al parser
+func (mp *MarkdownParser) RegisterBlockExtension(ext BlockExtension) {
+	mp.blockExtensions = append(mp.blockExtensions, ext)
+}
+
+// newInlineParser prepara il parser inline con le estensioni ordinate per priorità
+func newInlineParser(refmap map[string]linkReference, gfm bool, exts []InlineExtension) *inlineParser {
+	ip := &inlineParser{refmap: refmap, gfm: gfm}
+	ip.extensions = append(ip.extensions, exts...)
+	sort.SliceStable(ip.extensions, func(i, j int) bool {
+		return ip.extensions[i].Priority > ip.extensions[j].Priority
+	})
+	for _, ext := range ip.extensions {
+		for i := 0; i < len(ext.Triggers); i++ {
+			ip.triggers[ext.Triggers[i]] = true
+		}
+	}
+	return ip
+}
+
+// parseExtension prova le estensioni per il carattere c: before seleziona
+// quelle da provare prima della regola built-in
+func (ip *inlineParser) parseExtension(block *mdNode, c byte, before bool) bool {
+	if !ip.triggers[c] {
+		return false
+	}
+	for _, ext := range ip.extensions {
+		if (ext.Priority > 0) != before || strings.IndexByte(ext.Triggers, c) < 0 {
+			continue
+		}
+		node, n := ext.Parse(&InlineContext{ip: ip})
+		if node == nil || n <= 0 {
+			continue
+		}
+		block.appendChild(&mdNode{typ: nodeExtInline, inline: node})
+		ip.pos = min(ip.pos+n, len(ip.subject))
+		return true
+	}
+	return false
+}
+
+// startExtension crea la funzione di inizio blocco per un'estensione
+func startExtension(ext BlockExtension) blockStartFunc {
+	return func(bp *blockParser, container *mdNode) int {
+		if bp.indented || bp.blank {
+			return 0
+		}
+		parser := ext.Open(bp.currentLine[bp.nextNonspace:])
+		if parser == nil {
+			return 0
+		}
+		bp.closeUnmatchedBlocks()
+		typ := nodeExtLeaf
+		if ext.Container {
+			typ = nodeExtContainer
+		}
+		node := bp.addChild(typ, bp.nextNonspace)
+		node.ext = parser
+		// The opening line belongs to the extension, not to the content
+		bp.advanceNextNonspace()
+		bp.advanceOffset(len(bp.currentLine)-bp.offset, false)
+		if ext.Container {
+			return 1
+		}
+		return 2
+	}
+}
+
+// colorExtension è la sintassi {red}testo{/red} e {color:#FF0000}testo{/color},
+// registrata per default
+func colorExtension() InlineExtension {
+	return InlineExtension{Triggers: "{", Parse: parseColorSpan}
+}
+
+func parseColorSpan(ctx *InlineContext) (Inline, int) {
+	rest := ctx.Rest()
+	closeBrace := strings.IndexByte(rest, '}')
+	if closeBrace == -1 {
+		return nil, 0
+	}
+	colorTag := rest[1:closeBrace]
+
+	colorName := colorTag
+	endTag := "{/" + colorTag + "}"
+	if strings.HasPrefix(colorTag, "color:") {
+		colorName = strings.TrimPrefix(colorTag, "color:")
+		endTag = "{/color}"
+	}
+
+	color := parseColorName(colorName)
+	if color == nil {
+		return nil, 0
+	}
+	endIdx := strings.Index(rest[closeBrace+1:], endTag)
+	if endIdx == -1 {
+		return nil, 0
+	}
+
+	end := closeBrace + 1 + endIdx
+	return &ColorSpan{Color: *color, Inlines: ctx.ParseInlines(closeBrace+1, end)}, end + len(endTag)
+}
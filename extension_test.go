@@ -0,0 +1,230 @@
+package mark2pdf
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// mention è un nodo inline personalizzato (@utente)
+type mention struct {
+	InlineNode
+	User string
+}
+
+func mentionExtension() InlineExtension {
+	return InlineExtension{
+		Triggers: "@",
+		Parse: func(ctx *InlineContext) (Inline, int) {
+			if prev := ctx.Previous(); unicode.IsLetter(prev) || unicode.IsDigit(prev) {
+				return nil, 0 // part of an email address
+			}
+			rest := ctx.Rest()
+			n := 1
+			for n < len(rest) && (unicode.IsLetter(rune(rest[n])) || unicode.IsDigit(rune(rest[n]))) {
+				n++
+			}
+			if n == 1 {
+				return nil, 0
+			}
+			return &mention{User: rest[1:n]}, n
+		},
+	}
+}
+
+// admonition è un blocco personalizzato ::: tipo ... :::
+type admonition struct {
+	BlockNode
+	Kind   string
+	Blocks []Block
+}
+
+func (a *admonition) Children() []Node { return blockNodes(a.Blocks) }
+
+type admonitionParser struct{ kind string }
+
+func (p *admonitionParser) Continue(line string) BlockContinuation {
+	if strings.TrimSpace(line) == ":::" {
+		return BlockClose
+	}
+	return BlockContinue
+}
+
+func (p *admonitionParser) Close(children []Block, lines []string) Block {
+	return &admonition{Kind: p.kind, Blocks: children}
+}
+
+func admonitionExtension() BlockExtension {
+	return BlockExtension{
+		Priority:  750,
+		Container: true,
+		Open: func(line string) BlockParser {
+			if !strings.HasPrefix(line, ":::") || strings.TrimSpace(line) == ":::" {
+				return nil
+			}
+			return &admonitionParser{kind: strings.TrimSpace(line[3:])}
+		},
+	}
+}
+
+// mathBlock è un blocco leaf personalizzato $$ ... $$
+type mathBlock struct {
+	BlockNode
+	Lines []string
+}
+
+type mathParser struct{}
+
+func (mathParser) Continue(line string) BlockContinuation {
+	if strings.TrimSpace(line) == "$$" {
+		return BlockClose
+	}
+	return BlockContinue
+}
+
+func (mathParser) Close(children []Block, lines []string) Block {
+	return &mathBlock{Lines: lines}
+}
+
+func TestInlineExtension(t *testing.T) {
+	parser := NewMarkdownParser("ping @alice and *@bob*, not a@b.example\n")
+	parser.RegisterInlineExtension(mentionExtension())
+	doc := parser.Parse()
+
+	var users []string
+	var positions []Position
+	_ = Walk(doc, func(n Node, entering bool) (WalkStatus, error) {
+		if m, ok := n.(*mention); ok && entering {
+			users = append(users, m.User)
+			positions = append(positions, m.Pos())
+		}
+		return WalkContinue, nil
+	})
+
+	if strings.Join(users, ",") != "alice,bob" {
+		t.Errorf("Expected mentions alice,bob, got %q", users)
+	}
+	if len(positions) > 0 && positions[0] != (Position{1, 6, 1, 11}) {
+		t.Errorf("Expected position of @alice 1:6-1:11, got %+v", positions[0])
+	}
+}
+
+func TestInlineExtensionPriority(t *testing.T) {
+	// [[Page]] wiki links compete with the built-in link syntax
+	wiki := func(priority int) InlineExtension {
+		return InlineExtension{
+			Triggers: "[",
+			Priority: priority,
+			Parse: func(ctx *InlineContext) (Inline, int) {
+				rest := ctx.Rest()
+				end := strings.Index(rest, "]]")
+				if !strings.HasPrefix(rest, "[[") || end == -1 {
+					return nil, 0
+				}
+				page := rest[2:end]
+				return &Link{Destination: page + ".md", Inlines: []Inline{&Text{Value: page}}}, end + 2
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		priority int
+		expected string
+	}{
+		{"before built-in", 1, "<p><a href=\"Home.md\">Home</a></p>\n"},
+		{"after built-in", 0, "<p>[<a href=\"/home\">Home</a>]</p>\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := NewMarkdownParser("[[Home]]\n\n[Home]: /home\n")
+			parser.RegisterInlineExtension(wiki(tt.priority))
+			got := renderSpecHTML(parser.Parse())
+			if got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestBlockExtension(t *testing.T) {
+	parser := NewMarkdownParser("::: warning\nBe **careful**\n\n- one\n:::\n\n$$\nx^2\n  + y\n$$\nafter\n")
+	parser.RegisterBlockExtension(admonitionExtension())
+	parser.RegisterBlockExtension(BlockExtension{
+		Open: func(line string) BlockParser {
+			if strings.TrimSpace(line) != "$$" {
+				return nil
+			}
+			return mathParser{}
+		},
+	})
+	doc := parser.Parse()
+
+	if len(doc.Blocks) != 3 {
+		t.Fatalf("Expected 3 blocks, got %d", len(doc.Blocks))
+	}
+
+	adm, ok := doc.Blocks[0].(*admonition)
+	if !ok {
+		t.Fatalf("Expected *admonition, got %T", doc.Blocks[0])
+	}
+	if adm.Kind != "warning" || len(adm.Blocks) != 2 {
+		t.Errorf("Expected warning with 2 blocks, got %q with %d", adm.Kind, len(adm.Blocks))
+	}
+	if adm.Pos() != (Position{1, 1, 5, 3}) {
+		t.Errorf("Expected admonition position 1:1-5:3, got %+v", adm.Pos())
+	}
+	if text := TextContent(adm.Blocks[0]); text != "Be careful" {
+		t.Errorf("Expected %q, got %q", "Be careful", text)
+	}
+
+	math, ok := doc.Blocks[1].(*mathBlock)
+	if !ok {
+		t.Fatalf("Expected *mathBlock, got %T", doc.Blocks[1])
+	}
+	if strings.Join(math.Lines, "|") != "x^2|  + y" {
+		t.Errorf("Expected lines %q, got %q", "x^2|  + y", math.Lines)
+	}
+
+	if p, ok := doc.Blocks[2].(*Paragraph); !ok || inlineText(p.Inlines) != "after" {
+		t.Errorf("Expected paragraph \"after\", got %#v", doc.Blocks[2])
+	}
+}
+
+func TestBlockExtensionUnclosed(t *testing.T) {
+	parser := NewMarkdownParser("> ::: note\n> inside\n\noutside\n")
+	parser.RegisterBlockExtension(admonitionExtension())
+	doc := parser.Parse()
+
+	quote, ok := doc.Blocks[0].(*BlockQuote)
+	if !ok || len(quote.Blocks) != 1 {
+		t.Fatalf("Expected block quote with one block, got %#v", doc.Blocks[0])
+	}
+	if adm, ok := quote.Blocks[0].(*admonition); !ok || TextContent(adm) != "inside" {
+		t.Errorf("Expected admonition with \"inside\", got %#v", quote.Blocks[0])
+	}
+	if len(doc.Blocks) != 2 {
+		t.Errorf("Expected 2 blocks, got %d", len(doc.Blocks))
+	}
+}
+
+func TestConvertWithExtensions(t *testing.T) {
+	converter := NewConverter("::: tip\nHello @carol\n:::\n")
+	converter.Parser().RegisterInlineExtension(mentionExtension())
+	converter.Parser().RegisterBlockExtension(admonitionExtension())
+	converter.RegisterInlineRenderer(&mention{}, func(ctx *RenderContext, node Inline) []TextPart {
+		return []TextPart{{Text: "@" + node.(*mention).User, Font: FontBold}}
+	})
+
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+
+	// The admonition has no renderer: its paragraph is rendered by default
+	content := pdfContent(t, data)
+	if !strings.Contains(content, "(Hello) Tj") || !strings.Contains(content, "( @carol) Tj") {
+		t.Errorf("Expected rendered admonition content, got:\n%s", content)
+	}
+}
@@ -0,0 +1,56 @@
+package mark2pdf
+
+import (
+	"regexp"
+	"strings"
+)
+
+// frontMatterKey riconosce una linea "chiave: valore" del front matter
+var frontMatterKey = regexp.MustCompile(`^([A-Za-z0-9_-]+)\s*:(.*)$`)
+
+// splitFrontMatter separa il front matter YAML delimitato da "---" all'inizio del
+// documento. Sono lette solo le chiavi di primo livello con valori scalari; liste e
+// mappe annidate sono ignorate. Le linee del front matter sono sostituite da linee
+// vuote, così le posizioni dei nodi restano quelle del file.
+func splitFrontMatter(source string) (map[string]string, string) {
+	lines := strings.SplitAfter(source, "\n")
+	if len(lines) < 2 || strings.TrimRight(lines[0], " \t\r\n") != "---" {
+		return nil, source
+	}
+
+	meta := make(map[string]string)
+	for i := 1; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r\n")
+		switch {
+		case line == "---" || line == "...":
+			blank := strings.Repeat("\n", i+1)
+			return meta, blank + strings.Join(lines[i+1:], "")
+		case strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#"):
+			// Blank lines and comments
+		case line[0] == ' ' || line[0] == '\t' || strings.HasPrefix(line, "- "):
+			// Nested values and list items
+		default:
+			m := frontMatterKey.FindStringSubmatch(line)
+			if m == nil {
+				// Not YAML: the dashes are a thematic break
+				return nil, source
+			}
+			meta[m[1]] = frontMatterValue(m[2])
+		}
+	}
+	return nil, source
+}
+
+// frontMatterValue toglie commenti e virgolette da un valore scalare
+func frontMatterValue(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+		if end := strings.IndexByte(value[1:], value[0]); end >= 0 {
+			return value[1 : end+1]
+		}
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value
+}
@@ -0,0 +1,38 @@
+package mark2pdf
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFrontMatter(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		meta   string
+		blocks int
+	}{
+		{"keys", "---\ntitle: \"My: Report\"\nlang: it # Italian\ntags:\n  - a\n---\n# Hi\n", "map[lang:it tags: title:My: Report]", 1},
+		{"dots", "---\nauthor: Ann\n...\ntext\n", "map[author:Ann]", 1},
+		{"thematic break", "---\nnot yaml\n---\n", "map[]", 2},
+		{"unclosed", "---\ntitle: x\n", "map[]", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := NewMarkdownParser(tt.input).Parse()
+			if got := fmt.Sprint(doc.Meta); got != tt.meta {
+				t.Errorf("Expected meta %s, got %s", tt.meta, got)
+			}
+			if len(doc.Blocks) != tt.blocks {
+				t.Errorf("Expected %d blocks, got %d", tt.blocks, len(doc.Blocks))
+			}
+		})
+	}
+
+	// Positions still refer to the lines of the file
+	doc := NewMarkdownParser("---\nlang: en\n---\n\n# Title\n").Parse()
+	if doc.Blocks[0].Pos().StartLine != 5 {
+		t.Errorf("Expected heading on line 5, got %d", doc.Blocks[0].Pos().StartLine)
+	}
+}
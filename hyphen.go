@@ -0,0 +1,273 @@
+package mark2pdf
+
+import (
+	"bufio"
+	"embed"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:embed patterns/*.txt
+var patternFiles embed.FS
+
+// softHyphen segna nel testo un punto in cui la parola può essere divisa
+const softHyphen = '\u00AD'
+
+// hyphenLanguage descrive i pattern di una lingua e i frammenti minimi di default
+type hyphenLanguage struct {
+	file              string
+	leftMin, rightMin int
+}
+
+// hyphenLanguages sono le lingue con pattern inclusi
+var hyphenLanguages = map[string]hyphenLanguage{
+	"en-us": {"patterns/en-us.txt", 2, 3},
+	"it":    {"patterns/it.txt", 2, 2},
+}
+
+// hyphenAliases associa i codici di lingua generici ai pattern inclusi
+var hyphenAliases = map[string]string{
+	"en": "en-us",
+}
+
+// hyphenPatterns sono i pattern di Liang e le eccezioni di una lingua, caricati una sola volta
+type hyphenPatterns struct {
+	patterns   map[string][]byte // lettere del pattern -> valori tra le lettere
+	maxLen     int               // lunghezza massima di un pattern in caratteri
+	exceptions map[string][]int  // parola -> posizioni (in caratteri) dei trattini
+}
+
+var loadedPatterns sync.Map // nome file -> *hyphenPatterns
+
+// Hyphenator divide le parole in sillabe con l'algoritmo di Liang usato da TeX
+type Hyphenator struct {
+	// LeftMin e RightMin sono i caratteri minimi prima del primo e dopo l'ultimo trattino
+	LeftMin, RightMin int
+
+	*hyphenPatterns
+}
+
+// NewHyphenator crea un divisore in sillabe per la lingua indicata ("en", "en-US", "it", ...)
+func NewHyphenator(lang string) (*Hyphenator, error) {
+	language, ok := lookupHyphenLanguage(lang)
+	if !ok {
+		return nil, fmt.Errorf("sillabazione non disponibile per la lingua %q (disponibili: %s)",
+			lang, strings.Join(HyphenationLanguages(), ", "))
+	}
+	patterns, err := loadHyphenPatterns(language.file)
+	if err != nil {
+		return nil, err
+	}
+	return &Hyphenator{LeftMin: language.leftMin, RightMin: language.rightMin, hyphenPatterns: patterns}, nil
+}
+
+// HyphenationLanguages restituisce le lingue con sillabazione disponibile
+func HyphenationLanguages() []string {
+	names := make([]string, 0, len(hyphenLanguages))
+	for name := range hyphenLanguages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupHyphenLanguage trova i pattern di una lingua, ripiegando sulla lingua
+// senza regione ("it-CH" -> "it", "en-GB" -> "en-us")
+func lookupHyphenLanguage(lang string) (hyphenLanguage, bool) {
+	lang = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(lang), "_", "-"))
+	for _, name := range []string{lang, strings.SplitN(lang, "-", 2)[0]} {
+		if alias, ok := hyphenAliases[name]; ok {
+			name = alias
+		}
+		if language, ok := hyphenLanguages[name]; ok {
+			return language, true
+		}
+	}
+	return hyphenLanguage{}, false
+}
+
+// loadHyphenPatterns legge un file di pattern nel formato di TeX:
+// \patterns{...} con pattern come "hy3ph" e \hyphenation{...} con eccezioni come "ta-ble"
+func loadHyphenPatterns(file string) (*hyphenPatterns, error) {
+	if cached, ok := loadedPatterns.Load(file); ok {
+		return cached.(*hyphenPatterns), nil
+	}
+	f, err := patternFiles.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hp := &hyphenPatterns{patterns: make(map[string][]byte), exceptions: make(map[string][]int)}
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '%'); i >= 0 {
+			line = line[:i]
+		}
+		for _, field := range strings.Fields(line) {
+			switch {
+			case field == `\patterns{` || field == `\hyphenation{`:
+				section = field
+			case field == "}":
+				section = ""
+			case section == `\patterns{`:
+				hp.addPattern(field)
+			case section == `\hyphenation{`:
+				hp.addException(field)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	cached, _ := loadedPatterns.LoadOrStore(file, hp)
+	return cached.(*hyphenPatterns), nil
+}
+
+// addPattern aggiunge un pattern: le cifre sono i valori tra le lettere
+func (hp *hyphenPatterns) addPattern(pattern string) {
+	var letters []rune
+	values := []byte{0}
+	for _, r := range pattern {
+		if r >= '0' && r <= '9' {
+			values[len(values)-1] = byte(r - '0')
+			continue
+		}
+		letters = append(letters, r)
+		values = append(values, 0)
+	}
+	hp.patterns[string(letters)] = values
+	hp.maxLen = max(hp.maxLen, len(letters))
+}
+
+// addException aggiunge una parola con i trattini nei soli punti di divisione ammessi
+func (hp *hyphenPatterns) addException(word string) {
+	var positions []int
+	n := 0
+	for _, r := range word {
+		if r == '-' {
+			positions = append(positions, n)
+			continue
+		}
+		n++
+	}
+	hp.exceptions[strings.ReplaceAll(word, "-", "")] = positions
+}
+
+// Hyphenate divide una parola nelle parti tra cui si può inserire un trattino
+func (h *Hyphenator) Hyphenate(word string) []string {
+	var parts []string
+	start := 0
+	for _, pos := range h.points(word) {
+		parts = append(parts, word[start:pos])
+		start = pos
+	}
+	return append(parts, word[start:])
+}
+
+// points restituisce gli offset in byte della parola in cui si può andare a capo,
+// nel rispetto di LeftMin e RightMin
+func (h *Hyphenator) points(word string) []int {
+	runes := []rune(strings.ToLower(word))
+	n := len(runes)
+	if n < h.LeftMin+h.RightMin || n < 2 || n != utf8.RuneCountInString(word) {
+		return nil
+	}
+
+	// values[i] is the value between characters i-1 and i; odd values allow a break
+	values := make([]byte, n+1)
+	if positions, ok := h.exceptions[string(runes)]; ok {
+		for _, pos := range positions {
+			values[pos] = 1
+		}
+	} else {
+		text := append(append([]rune{'.'}, runes...), '.')
+		for i := range text {
+			for j := i + 1; j <= len(text) && j-i <= h.maxLen; j++ {
+				pattern, ok := h.patterns[string(text[i:j])]
+				if !ok {
+					continue
+				}
+				for k, v := range pattern {
+					// text[i+k] is runes[i+k-1]: the value before it is values[i+k-1]
+					if pos := i + k - 1; pos >= 0 && pos <= n {
+						values[pos] = max(values[pos], v)
+					}
+				}
+			}
+		}
+	}
+
+	var points []int
+	offset := 0
+	for i, r := range []rune(word) {
+		if i >= max(h.LeftMin, 1) && i <= n-max(h.RightMin, 1) && values[i]%2 == 1 {
+			points = append(points, offset)
+		}
+		offset += utf8.RuneLen(r)
+	}
+	return points
+}
+
+// wordBreak è un punto di divisione di una parola
+type wordBreak struct {
+	pos    int  // offset in byte nel testo senza soft hyphen
+	hyphen bool // a capo qui aggiunge un trattino; false dopo un trattino esplicito
+}
+
+// wordBreaks toglie i soft hyphen dalla parola e restituisce i punti in cui può
+// essere divisa: i soft hyphen se presenti, altrimenti i trattini espliciti e,
+// con h, la sillabazione delle sequenze di lettere
+func wordBreaks(word string, h *Hyphenator) (string, []wordBreak) {
+	if strings.ContainsRune(word, softHyphen) {
+		var sb strings.Builder
+		var breaks []wordBreak
+		for _, r := range word {
+			if r == softHyphen {
+				if n := len(breaks); sb.Len() > 0 && (n == 0 || breaks[n-1].pos != sb.Len()) {
+					breaks = append(breaks, wordBreak{pos: sb.Len(), hyphen: true})
+				}
+				continue
+			}
+			sb.WriteRune(r)
+		}
+		text := sb.String()
+		// A soft hyphen at the end of the word is not a break point
+		if n := len(breaks); n > 0 && breaks[n-1].pos == len(text) {
+			breaks = breaks[:n-1]
+		}
+		return text, breaks
+	}
+
+	var breaks []wordBreak
+	start := -1 // start of the current run of letters
+	flush := func(end int) {
+		if h != nil && start >= 0 {
+			for _, pos := range h.points(word[start:end]) {
+				breaks = append(breaks, wordBreak{pos: start + pos, hyphen: true})
+			}
+		}
+		start = -1
+	}
+	for i, r := range word {
+		switch {
+		case unicode.IsLetter(r) || ((r == '\'' || r == '’') && start >= 0):
+			if start < 0 {
+				start = i
+			}
+		case r == '-' && i > 0 && i+1 < len(word):
+			flush(i)
+			breaks = append(breaks, wordBreak{pos: i + 1})
+		default:
+			flush(i)
+		}
+	}
+	flush(len(word))
+	return word, breaks
+}
@@ -0,0 +1,105 @@
+package mark2pdf
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestHyphenate(t *testing.T) {
+	tests := []struct {
+		lang     string
+		leftMin  int
+		word     string
+		expected string
+	}{
+		{"en-US", 0, "hyphenation", "hy-phen-a-tion"},
+		{"en", 0, "Algorithm", "Al-go-rithm"},
+		{"en", 0, "table", "ta-ble"},    // exception
+		{"en", 0, "project", "project"}, // exception without break points
+		{"en_GB", 0, "computer", "com-puter"},
+		{"en", 3, "hyphenation", "hyphen-a-tion"},
+		{"it", 0, "calcolatore", "cal-co-la-to-re"},
+		{"it-IT", 0, "dell’amico", "del-l’a-mi-co"},
+		{"it", 0, "ai", "ai"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.lang+"/"+tt.word, func(t *testing.T) {
+			h, err := NewHyphenator(tt.lang)
+			if err != nil {
+				t.Fatalf("NewHyphenator failed: %v", err)
+			}
+			if tt.leftMin > 0 {
+				h.LeftMin = tt.leftMin
+			}
+			got := strings.Join(h.Hyphenate(tt.word), "-")
+			if got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+
+	if _, err := NewHyphenator("fr"); err == nil {
+		t.Error("Expected an error for a language without patterns")
+	}
+}
+
+func TestWordBreaks(t *testing.T) {
+	en, _ := NewHyphenator("en")
+	tests := []struct {
+		name     string
+		word     string
+		h        *Hyphenator
+		text     string
+		expected string
+	}{
+		{"soft hyphens", "co\u00adop\u00ader\u00ad", en, "cooper", "[{2 true} {4 true}]"},
+		{"explicit hyphen", "well-known", nil, "well-known", "[{5 false}]"},
+		{"punctuation", "(hyphenation),", en, "(hyphenation),", "[{3 true} {7 true} {8 true}]"},
+		{"no hyphenator", "hyphenation", nil, "hyphenation", "[]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, breaks := wordBreaks(tt.word, tt.h)
+			if text != tt.text || fmt.Sprint(breaks) != tt.expected {
+				t.Errorf("Expected %q %s, got %q %v", tt.text, tt.expected, text, breaks)
+			}
+		})
+	}
+}
+
+func TestConvertHyphenated(t *testing.T) {
+	theme, err := ParseTheme([]byte(`{"margin": 200, "line_breaking": "optimal", "body": {"justify": true, "hyphenate": true}}`))
+	if err != nil {
+		t.Fatalf("ParseTheme failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		language string
+		markdown string
+		hyphens  bool
+	}{
+		{"front matter", "", "---\nlang: it\n---\n", true},
+		{"option", "it", "", true},
+		{"no language", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text := tt.markdown + strings.Repeat("La sillabazione permette di giustificare colonne strette. ", 4) + "\n"
+			converter := NewConverter(text)
+			converter.SetTheme(theme)
+			converter.SetLanguage(tt.language)
+			data, err := converter.Convert()
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			if got := strings.Contains(pdfContent(t, data), "-) Tj"); got != tt.hyphens {
+				t.Errorf("Expected hyphenated lines %v, got %v", tt.hyphens, got)
+			}
+		})
+	}
+}
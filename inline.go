@@ -0,0 +1,976 @@
+package mark2pdf
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Espressioni per tag HTML inline (CommonMark, sezione Raw HTML)
+const (
+	tagName            = `[A-Za-z][A-Za-z0-9-]*`
+	attributeName      = `[a-zA-Z_:][a-zA-Z0-9:._-]*`
+	unquotedValue      = "[^\"'=<>`\\x00-\\x20]+"
+	singleQuotedValue  = `'[^']*'`
+	doubleQuotedValue  = `"[^"]*"`
+	attributeValue     = `(?:` + unquotedValue + `|` + singleQuotedValue + `|` + doubleQuotedValue + `)`
+	attributeValueSpec = `(?:\s*=\s*` + attributeValue + `)`
+	attribute          = `(?:\s+` + attributeName + attributeValueSpec + `?)`
+	openTag            = `<` + tagName + attribute + `*\s*/?>`
+	closeTag           = `</` + tagName + `\s*[>]`
+	htmlComment        = `<!-->|<!--->|<!--[\s\S]*?-->`
+	processingInstr    = `[<][?][\s\S]*?[?][>]`
+	declaration        = `<![A-Za-z][^>]*>`
+	cdata              = `<!\[CDATA\[[\s\S]*?\]\]>`
+	htmlTag            = `(?:` + openTag + `|` + closeTag + `|` + htmlComment + `|` + processingInstr + `|` + declaration + `|` + cdata + `)`
+)
+
+var (
+	reHTMLTag                = regexp.MustCompile(`^` + htmlTag)
+	reEmailAutolink          = regexp.MustCompile(`^<([a-zA-Z0-9.!#$%&'*+/=?^_` + "`" + `{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*)>`)
+	reAutolink               = regexp.MustCompile(`^<[A-Za-z][A-Za-z0-9.+-]{1,31}:[^<>\x00-\x20]*>`)
+	reLinkTitle              = regexp.MustCompile(`^(?:"(?:\\[\s\S]|[^\\"\x00])*"|'(?:\\[\s\S]|[^\\'\x00])*'|\((?:\\[\s\S]|[^\\()\x00])*\))`)
+	reLinkDestinationBraces  = regexp.MustCompile(`^<(?:[^<>\n\\\x00]|\\.)*>`)
+	reLinkLabel              = regexp.MustCompile(`^\[(?:[^\\\[\]]|\\[\s\S]){0,1000}\]`)
+	reSpnl                   = regexp.MustCompile(`^ *(?:\n *)?`)
+	reSpaceAtEndOfLine       = regexp.MustCompile(`^ *(?:\n|$)`)
+	reWWWAutolink            = regexp.MustCompile(`(?:^|[\s*_~(])((?:https?://|www\.)[a-zA-Z0-9_-]+(?:\.[a-zA-Z0-9_-]+)*[^\s<]*)`)
+	reEmailAutolinkLiteral   = regexp.MustCompile(`(?:^|[\s*_~(])([a-zA-Z0-9._+-]+@[a-zA-Z0-9_-]+(?:\.[a-zA-Z0-9_-]+)+)`)
+	reTrailingEntityAutolink = regexp.MustCompile(`&[a-zA-Z0-9]+;$`)
+)
+
+// delimiter è un elemento dello stack dei delimitatori (*, _, ~)
+type delimiter struct {
+	cc         byte
+	numdelims  int
+	origdelims int
+	node       *mdNode
+	previous   *delimiter
+	next       *delimiter
+	canOpen    bool
+	canClose   bool
+}
+
+// bracket è un elemento dello stack delle parentesi quadre aperte ([ o ![)
+type bracket struct {
+	node              *mdNode
+	previous          *bracket
+	previousDelimiter *delimiter
+	index             int
+	image             bool
+	active            bool
+	bracketAfter      bool
+}
+
+// inlineParser implementa il parsing inline di CommonMark con lo stack dei
+// delimitatori per enfasi, link e immagini.
+type inlineParser struct {
+	subject    string
+	pos        int
+	offset     int // offset di subject nel contenuto del blocco
+	delimiters *delimiter
+	brackets   *bracket
+	refmap     map[string]linkReference
+	gfm        bool    // strikethrough e autolink estesi
+	block      *mdNode // blocco di cui si analizza il contenuto
+	extensions []InlineExtension
+	triggers   [256]bool    // caratteri che attivano un'estensione
+	destFails  map[int]bool // inizi di destinazioni di link che non possono chiudersi
+}
+
+// parseInlines esegue il parsing inline del contenuto di un blocco
+func (ip *inlineParser) parseInlines(block *mdNode) {
+	content := string(block.content)
+	ip.subject = strings.TrimSpace(content)
+	ip.offset = len(content) - len(strings.TrimLeft(content, " \t\n\r\v\f"))
+	ip.pos = 0
+	ip.delimiters = nil
+	ip.brackets = nil
+	ip.destFails = nil
+	ip.block = block
+	block.content = nil
+
+	ip.parseAll(block)
+	setInlinePositions(block, block)
+}
+
+// parseAll analizza tutto il subject aggiungendo i nodi inline a parent
+func (ip *inlineParser) parseAll(parent *mdNode) {
+	for ip.parseInline(parent) {
+	}
+	ip.processEmphasis(nil)
+	mergeTextNodes(parent)
+	if ip.gfm {
+		linkifyTextNodes(parent)
+	}
+}
+
+// setSrc registra l'intervallo di sorgente [start, end) di un nodo appena creato
+// e dei suoi figli che non ne hanno uno proprio
+func (ip *inlineParser) setSrc(n *mdNode, start, end int) {
+	if n.hasSrc {
+		return
+	}
+	n.srcStart, n.srcEnd, n.hasSrc = ip.offset+start, ip.offset+end, true
+	for c := n.firstChild; c != nil; c = c.next {
+		ip.setSrc(c, start, end)
+	}
+}
+
+// setInlinePositions converte gli intervalli dei nodi inline in linee e colonne del sorgente
+func setInlinePositions(block, parent *mdNode) {
+	for n := parent.firstChild; n != nil; n = n.next {
+		n.startLine, n.startCol = block.sourcePos(n.srcStart)
+		if n.srcEnd > n.srcStart {
+			n.endLine, n.endCol = block.sourcePos(n.srcEnd - 1)
+		} else {
+			n.endLine, n.endCol = n.startLine, n.startCol
+		}
+		setInlinePositions(block, n)
+	}
+}
+
+func (ip *inlineParser) peek() int {
+	if ip.pos < len(ip.subject) {
+		return int(ip.subject[ip.pos])
+	}
+	return -1
+}
+
+// match applica re alla posizione corrente e avanza in caso di successo
+func (ip *inlineParser) match(re *regexp.Regexp) (string, bool) {
+	loc := re.FindStringIndex(ip.subject[ip.pos:])
+	if loc == nil {
+		return "", false
+	}
+	m := ip.subject[ip.pos+loc[0] : ip.pos+loc[1]]
+	ip.pos += loc[1]
+	return m, true
+}
+
+func textNode(s string) *mdNode {
+	n := &mdNode{typ: nodeText, literal: s}
+	return n
+}
+
+// isSpecialChar indica se c può iniziare un costrutto inline
+func (ip *inlineParser) isSpecialChar(c byte) bool {
+	switch c {
+	case '\n', '`', '[', ']', '\\', '!', '<', '&', '*', '_':
+		return true
+	case '~':
+		return ip.gfm
+	}
+	return ip.triggers[c]
+}
+
+// parseInline analizza il prossimo costrutto inline; false a fine testo
+func (ip *inlineParser) parseInline(block *mdNode) bool {
+	c := ip.peek()
+	if c == -1 {
+		return false
+	}
+
+	start := ip.pos
+	// Extensions with a positive priority run before the built-in rules
+	res := ip.parseExtension(block, byte(c), true)
+	if !res {
+		switch c {
+		case '\n':
+			res = ip.parseNewline(block)
+		case '\\':
+			res = ip.parseBackslash(block)
+		case '`':
+			res = ip.parseBackticks(block)
+		case '*', '_':
+			res = ip.handleDelim(byte(c), block)
+		case '~':
+			if ip.gfm {
+				res = ip.handleDelim(byte(c), block)
+			}
+		case '[':
+			res = ip.parseOpenBracket(block)
+		case '!':
+			res = ip.parseBang(block)
+		case ']':
+			res = ip.parseCloseBracket(block)
+		case '<':
+			res = ip.parseAutolink(block) || ip.parseHTMLTag(block)
+		case '&':
+			res = ip.parseEntity(block)
+		default:
+			res = ip.parseString(block)
+		}
+	}
+	if !res {
+		res = ip.parseExtension(block, byte(c), false)
+	}
+
+	if !res {
+		ip.pos++
+		block.appendChild(textNode(string(rune(c))))
+	}
+
+	// Nodes created by this step are at the end of the block
+	for n := block.lastChild; n != nil && !n.hasSrc; n = n.prev {
+		ip.setSrc(n, start, ip.pos)
+	}
+	return true
+}
+
+// parseString consuma il testo fino al prossimo carattere speciale
+func (ip *inlineParser) parseString(block *mdNode) bool {
+	start := ip.pos
+	for ip.pos < len(ip.subject) && !ip.isSpecialChar(ip.subject[ip.pos]) {
+		ip.pos++
+	}
+	if ip.pos == start {
+		return false
+	}
+	block.appendChild(textNode(ip.subject[start:ip.pos]))
+	return true
+}
+
+// parseNewline gestisce soft break e hard break (due spazi finali)
+func (ip *inlineParser) parseNewline(block *mdNode) bool {
+	ip.pos++
+	last := block.lastChild
+	if last != nil && last.typ == nodeText && strings.HasSuffix(last.literal, " ") {
+		hardbreak := strings.HasSuffix(last.literal, "  ")
+		trimmed := strings.TrimRight(last.literal, " ")
+		if last.hasSrc && last.srcEnd-last.srcStart == len(last.literal) {
+			last.srcEnd -= len(last.literal) - len(trimmed)
+		}
+		last.literal = trimmed
+		if hardbreak {
+			block.appendChild(&mdNode{typ: nodeLineBreak})
+		} else {
+			block.appendChild(&mdNode{typ: nodeSoftBreak})
+		}
+	} else {
+		block.appendChild(&mdNode{typ: nodeSoftBreak})
+	}
+	// Gobble leading spaces in the next line
+	for ip.pos < len(ip.subject) && ip.subject[ip.pos] == ' ' {
+		ip.pos++
+	}
+	return true
+}
+
+// parseBackslash gestisce escape e hard break con backslash
+func (ip *inlineParser) parseBackslash(block *mdNode) bool {
+	ip.pos++
+	if ip.peek() == '\n' {
+		ip.pos++
+		block.appendChild(&mdNode{typ: nodeLineBreak})
+	} else if ip.pos < len(ip.subject) && isASCIIPunctuation(ip.subject[ip.pos]) {
+		block.appendChild(textNode(ip.subject[ip.pos : ip.pos+1]))
+		ip.pos++
+	} else {
+		block.appendChild(textNode("\\"))
+	}
+	return true
+}
+
+// parseBackticks gestisce i code span
+func (ip *inlineParser) parseBackticks(block *mdNode) bool {
+	start := ip.pos
+	for ip.pos < len(ip.subject) && ip.subject[ip.pos] == '`' {
+		ip.pos++
+	}
+	ticks := ip.subject[start:ip.pos]
+	afterOpenTicks := ip.pos
+
+	for ip.pos < len(ip.subject) {
+		if ip.subject[ip.pos] != '`' {
+			ip.pos++
+			continue
+		}
+		runStart := ip.pos
+		for ip.pos < len(ip.subject) && ip.subject[ip.pos] == '`' {
+			ip.pos++
+		}
+		if ip.pos-runStart == len(ticks) {
+			contents := strings.ReplaceAll(ip.subject[afterOpenTicks:runStart], "\n", " ")
+			// Strip one space on each side, unless the span is only spaces
+			if len(contents) > 2 && contents[0] == ' ' && contents[len(contents)-1] == ' ' && strings.Trim(contents, " ") != "" {
+				contents = contents[1 : len(contents)-1]
+			}
+			block.appendChild(&mdNode{typ: nodeCode, literal: contents})
+			return true
+		}
+	}
+
+	// No matching closing sequence: the ticks are literal text
+	ip.pos = afterOpenTicks
+	block.appendChild(textNode(ticks))
+	return true
+}
+
+// isUnicodeWhitespace indica se r è uno spazio Unicode secondo CommonMark
+func isUnicodeWhitespace(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\f' || r == '\r' || unicode.Is(unicode.Zs, r)
+}
+
+// isUnicodePunctuation indica se r è punteggiatura o simbolo Unicode
+func isUnicodePunctuation(r rune) bool {
+	if r < 128 {
+		return isASCIIPunctuation(byte(r))
+	}
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// scanDelims conta i delimitatori alla posizione corrente e determina se
+// possono aprire o chiudere enfasi (regole left/right-flanking)
+func (ip *inlineParser) scanDelims(cc byte) (numdelims int, canOpen, canClose bool) {
+	start := ip.pos
+	for i := ip.pos; i < len(ip.subject) && ip.subject[i] == cc; i++ {
+		numdelims++
+	}
+	if numdelims == 0 {
+		return 0, false, false
+	}
+
+	charBefore := '\n'
+	if start > 0 {
+		charBefore, _ = utf8.DecodeLastRuneInString(ip.subject[:start])
+	}
+	charAfter := '\n'
+	if start+numdelims < len(ip.subject) {
+		charAfter, _ = utf8.DecodeRuneInString(ip.subject[start+numdelims:])
+	}
+
+	afterIsWhitespace := isUnicodeWhitespace(charAfter)
+	afterIsPunctuation := isUnicodePunctuation(charAfter)
+	beforeIsWhitespace := isUnicodeWhitespace(charBefore)
+	beforeIsPunctuation := isUnicodePunctuation(charBefore)
+
+	leftFlanking := !afterIsWhitespace && (!afterIsPunctuation || beforeIsWhitespace || beforeIsPunctuation)
+	rightFlanking := !beforeIsWhitespace && (!beforeIsPunctuation || afterIsWhitespace || afterIsPunctuation)
+
+	if cc == '_' {
+		canOpen = leftFlanking && (!rightFlanking || beforeIsPunctuation)
+		canClose = rightFlanking && (!leftFlanking || afterIsPunctuation)
+	} else {
+		canOpen = leftFlanking
+		canClose = rightFlanking
+	}
+	return numdelims, canOpen, canClose
+}
+
+// handleDelim aggiunge una sequenza di delimitatori come testo e la registra nello stack
+func (ip *inlineParser) handleDelim(cc byte, block *mdNode) bool {
+	numdelims, canOpen, canClose := ip.scanDelims(cc)
+	if numdelims == 0 {
+		return false
+	}
+	start := ip.pos
+	ip.pos += numdelims
+	node := textNode(ip.subject[start:ip.pos])
+	block.appendChild(node)
+
+	// GFM strikethrough uses only one or two tildes
+	if cc == '~' && numdelims > 2 {
+		return true
+	}
+
+	if canOpen || canClose {
+		ip.delimiters = &delimiter{
+			cc:         cc,
+			numdelims:  numdelims,
+			origdelims: numdelims,
+			node:       node,
+			previous:   ip.delimiters,
+			canOpen:    canOpen,
+			canClose:   canClose,
+		}
+		if ip.delimiters.previous != nil {
+			ip.delimiters.previous.next = ip.delimiters
+		}
+	}
+	return true
+}
+
+func (ip *inlineParser) removeDelimiter(d *delimiter) {
+	if d.previous != nil {
+		d.previous.next = d.next
+	}
+	if d.next == nil {
+		// top of stack
+		ip.delimiters = d.previous
+	} else {
+		d.next.previous = d.previous
+	}
+}
+
+func removeDelimitersBetween(bottom, top *delimiter) {
+	if bottom.next != top {
+		bottom.next = top
+		top.previous = bottom
+	}
+}
+
+// openersBottomKey indicizza il limite inferiore della ricerca degli opener
+type openersBottomKey struct {
+	cc      byte
+	canOpen bool
+	mod     int
+}
+
+// processEmphasis risolve enfasi e strikethrough sopra stackBottom
+func (ip *inlineParser) processEmphasis(stackBottom *delimiter) {
+	openersBottom := make(map[openersBottomKey]*delimiter)
+
+	// Find the first closer above stackBottom
+	closer := ip.delimiters
+	for closer != nil && closer.previous != stackBottom {
+		closer = closer.previous
+	}
+
+	for closer != nil {
+		if !closer.canClose {
+			closer = closer.next
+			continue
+		}
+
+		key := openersBottomKey{cc: closer.cc, canOpen: closer.canOpen, mod: closer.origdelims % 3}
+		bottom, ok := openersBottom[key]
+		if !ok {
+			bottom = stackBottom
+		}
+
+		// Look back for the first matching opener
+		opener := closer.previous
+		openerFound := false
+		for opener != nil && opener != stackBottom && opener != bottom {
+			if closer.cc == '~' {
+				if opener.cc == '~' && opener.canOpen && opener.origdelims == closer.origdelims {
+					openerFound = true
+					break
+				}
+			} else {
+				oddMatch := (closer.canOpen || opener.canClose) && closer.origdelims%3 != 0 &&
+					(opener.origdelims+closer.origdelims)%3 == 0
+				if opener.cc == closer.cc && opener.canOpen && !oddMatch {
+					openerFound = true
+					break
+				}
+			}
+			opener = opener.previous
+		}
+
+		oldCloser := closer
+		if !openerFound {
+			closer = closer.next
+		} else {
+			useDelims := 1
+			typ := nodeEmph
+			switch {
+			case closer.cc == '~':
+				useDelims = closer.numdelims
+				typ = nodeStrikethrough
+			case closer.numdelims >= 2 && opener.numdelims >= 2:
+				useDelims = 2
+				typ = nodeStrong
+			}
+
+			openerInl := opener.node
+			closerInl := closer.node
+			opener.numdelims -= useDelims
+			closer.numdelims -= useDelims
+			openerInl.literal = openerInl.literal[:len(openerInl.literal)-useDelims]
+			closerInl.literal = closerInl.literal[:len(closerInl.literal)-useDelims]
+
+			// Build contents for the new element
+			emph := &mdNode{typ: typ, hasSrc: true}
+			emph.srcStart = openerInl.srcEnd - useDelims
+			emph.srcEnd = closerInl.srcStart + useDelims
+			openerInl.srcEnd -= useDelims
+			closerInl.srcStart += useDelims
+			for tmp := openerInl.next; tmp != nil && tmp != closerInl; {
+				next := tmp.next
+				emph.appendChild(tmp)
+				tmp = next
+			}
+			openerInl.insertAfter(emph)
+
+			removeDelimitersBetween(opener, closer)
+
+			if opener.numdelims == 0 {
+				openerInl.unlink()
+				ip.removeDelimiter(opener)
+			}
+			if closer.numdelims == 0 {
+				closerInl.unlink()
+				next := closer.next
+				ip.removeDelimiter(closer)
+				closer = next
+			}
+		}
+
+		if !openerFound {
+			// Set lower bound for future searches for openers
+			openersBottom[key] = oldCloser.previous
+			if !oldCloser.canOpen {
+				// A closer that can't open can be removed once no opener matches
+				ip.removeDelimiter(oldCloser)
+			}
+		}
+	}
+
+	// Remove all delimiters above stackBottom
+	for ip.delimiters != nil && ip.delimiters != stackBottom {
+		ip.removeDelimiter(ip.delimiters)
+	}
+}
+
+func (ip *inlineParser) addBracket(node *mdNode, index int, image bool) {
+	if ip.brackets != nil {
+		ip.brackets.bracketAfter = true
+	}
+	ip.brackets = &bracket{
+		node:              node,
+		previous:          ip.brackets,
+		previousDelimiter: ip.delimiters,
+		index:             index,
+		image:             image,
+		active:            true,
+	}
+}
+
+func (ip *inlineParser) removeBracket() {
+	ip.brackets = ip.brackets.previous
+}
+
+func (ip *inlineParser) parseOpenBracket(block *mdNode) bool {
+	start := ip.pos
+	ip.pos++
+	node := textNode("[")
+	block.appendChild(node)
+	ip.addBracket(node, start, false)
+	return true
+}
+
+func (ip *inlineParser) parseBang(block *mdNode) bool {
+	start := ip.pos
+	ip.pos++
+	if ip.peek() == '[' {
+		ip.pos++
+		node := textNode("![")
+		block.appendChild(node)
+		ip.addBracket(node, start+1, true)
+	} else {
+		block.appendChild(textNode("!"))
+	}
+	return true
+}
+
+// parseCloseBracket chiude un link o un'immagine inline, reference o shortcut
+func (ip *inlineParser) parseCloseBracket(block *mdNode) bool {
+	ip.pos++
+	start := ip.pos
+
+	opener := ip.brackets
+	if opener == nil {
+		block.appendChild(textNode("]"))
+		return true
+	}
+	if !opener.active {
+		block.appendChild(textNode("]"))
+		ip.removeBracket()
+		return true
+	}
+
+	isImage := opener.image
+	var dest, title string
+	matched := false
+
+	// Inline link?
+	savepos := ip.pos
+	if ip.peek() == '(' {
+		ip.pos++
+		ip.spnl()
+		if d, ok := ip.parseLinkDestination(); ok {
+			dest = d
+			beforeTitle := ip.pos
+			ip.spnl()
+			if ip.pos > beforeTitle {
+				if t, ok := ip.parseLinkTitle(); ok {
+					title = t
+				}
+			}
+			ip.spnl()
+			if ip.peek() == ')' {
+				ip.pos++
+				matched = true
+			}
+		}
+		if !matched {
+			ip.pos = savepos
+		}
+	}
+
+	if !matched {
+		// Reference link: full, collapsed or shortcut
+		beforeLabel := ip.pos
+		n := ip.parseLinkLabel()
+		reflabel := ""
+		if n > 2 {
+			reflabel = ip.subject[beforeLabel : beforeLabel+n]
+		} else if !opener.bracketAfter {
+			reflabel = ip.subject[opener.index:start]
+		}
+		if n == 0 {
+			ip.pos = savepos
+		}
+		if reflabel != "" {
+			if ref, ok := ip.refmap[normalizeReference(reflabel)]; ok {
+				dest = ref.dest
+				title = ref.title
+				matched = true
+			}
+		}
+	}
+
+	if !matched {
+		ip.removeBracket()
+		ip.pos = start
+		block.appendChild(textNode("]"))
+		return true
+	}
+
+	typ := nodeLink
+	if isImage {
+		typ = nodeImage
+	}
+	node := &mdNode{typ: typ, dest: dest, title: title}
+	node.srcStart, node.srcEnd, node.hasSrc = opener.node.srcStart, ip.offset+ip.pos, true
+	for tmp := opener.node.next; tmp != nil; {
+		next := tmp.next
+		node.appendChild(tmp)
+		tmp = next
+	}
+	block.appendChild(node)
+	ip.processEmphasis(opener.previousDelimiter)
+	ip.removeBracket()
+	opener.node.unlink()
+
+	// No links in links: deactivate earlier link openers
+	if !isImage {
+		for o := ip.brackets; o != nil; o = o.previous {
+			if !o.image {
+				o.active = false
+			}
+		}
+	}
+	return true
+}
+
+// spnl salta spazi e al più un a capo
+func (ip *inlineParser) spnl() {
+	ip.match(reSpnl)
+}
+
+// parseLinkDestination analizza la destinazione di un link
+func (ip *inlineParser) parseLinkDestination() (string, bool) {
+	if m, ok := ip.match(reLinkDestinationBraces); ok {
+		return unescapeInline(m[1 : len(m)-1]), true
+	}
+	if ip.peek() == '<' {
+		return "", false
+	}
+
+	savepos := ip.pos
+	if ip.destFails[savepos] {
+		return "", false
+	}
+	var opens []int // parentesi aperte non ancora chiuse
+	c := -1
+	for ip.pos < len(ip.subject) {
+		c = int(ip.subject[ip.pos])
+		if c == '\\' && ip.pos+1 < len(ip.subject) && isASCIIPunctuation(ip.subject[ip.pos+1]) {
+			ip.pos += 2
+		} else if c == '(' {
+			opens = append(opens, ip.pos)
+			ip.pos++
+		} else if c == ')' {
+			if len(opens) < 1 {
+				break
+			}
+			ip.pos++
+			opens = opens[:len(opens)-1]
+		} else if c <= ' ' {
+			break
+		} else {
+			ip.pos++
+		}
+	}
+	if ip.pos == savepos && c != ')' {
+		return "", false
+	}
+	if len(opens) != 0 {
+		// A destination starting after an open parenthesis other than the last
+		// one stops at the same place with parentheses still open: later links
+		// of the same subject fail without scanning again
+		if ip.destFails == nil {
+			ip.destFails = make(map[int]bool)
+		}
+		ip.destFails[savepos] = true
+		for _, open := range opens[:len(opens)-1] {
+			ip.destFails[open+1] = true
+		}
+		return "", false
+	}
+	return unescapeInline(ip.subject[savepos:ip.pos]), true
+}
+
+// parseLinkTitle analizza il titolo di un link tra virgolette o parentesi
+func (ip *inlineParser) parseLinkTitle() (string, bool) {
+	m, ok := ip.match(reLinkTitle)
+	if !ok {
+		return "", false
+	}
+	return unescapeInline(m[1 : len(m)-1]), true
+}
+
+// parseLinkLabel restituisce la lunghezza della label alla posizione corrente, 0 se assente
+func (ip *inlineParser) parseLinkLabel() int {
+	loc := reLinkLabel.FindStringIndex(ip.subject[ip.pos:])
+	if loc == nil || loc[1] > 1001 {
+		return 0
+	}
+	ip.pos += loc[1]
+	return loc[1]
+}
+
+// parseAutolink gestisce gli autolink <http://...> e <mail@example.com>
+func (ip *inlineParser) parseAutolink(block *mdNode) bool {
+	if m, ok := ip.match(reEmailAutolink); ok {
+		dest := m[1 : len(m)-1]
+		node := &mdNode{typ: nodeLink, dest: "mailto:" + dest}
+		node.appendChild(textNode(dest))
+		block.appendChild(node)
+		return true
+	}
+	if m, ok := ip.match(reAutolink); ok {
+		dest := m[1 : len(m)-1]
+		node := &mdNode{typ: nodeLink, dest: dest}
+		node.appendChild(textNode(dest))
+		block.appendChild(node)
+		return true
+	}
+	return false
+}
+
+// parseHTMLTag gestisce l'HTML inline
+func (ip *inlineParser) parseHTMLTag(block *mdNode) bool {
+	m, ok := ip.match(reHTMLTag)
+	if !ok {
+		return false
+	}
+	block.appendChild(&mdNode{typ: nodeHTMLInline, literal: m})
+	return true
+}
+
+// parseEntity decodifica un'entità HTML
+func (ip *inlineParser) parseEntity(block *mdNode) bool {
+	decoded, n := decodeEntity(ip.subject[ip.pos:])
+	if n == 0 {
+		return false
+	}
+	ip.pos += n
+	block.appendChild(textNode(decoded))
+	return true
+}
+
+// parseReference analizza una definizione di link reference all'inizio di s.
+// Restituisce il numero di byte consumati, 0 se non è una definizione valida.
+func parseReference(s string, refmap map[string]linkReference) int {
+	ip := &inlineParser{subject: s}
+
+	n := ip.parseLinkLabel()
+	if n == 0 {
+		return 0
+	}
+	rawLabel := s[:n]
+
+	if ip.peek() != ':' {
+		return 0
+	}
+	ip.pos++
+
+	ip.spnl()
+	dest, ok := ip.parseLinkDestination()
+	if !ok {
+		return 0
+	}
+
+	beforeTitle := ip.pos
+	ip.spnl()
+	title := ""
+	titleFound := false
+	if ip.pos != beforeTitle {
+		title, titleFound = ip.parseLinkTitle()
+	}
+	if !titleFound {
+		title = ""
+		ip.pos = beforeTitle
+	}
+
+	// Make sure we're at line end
+	atLineEnd := true
+	if _, ok := ip.match(reSpaceAtEndOfLine); !ok {
+		if !titleFound {
+			atLineEnd = false
+		} else {
+			// Discard the title and check if the destination is at line end
+			title = ""
+			ip.pos = beforeTitle
+			_, atLineEnd = ip.match(reSpaceAtEndOfLine)
+		}
+	}
+	if !atLineEnd {
+		return 0
+	}
+
+	label := normalizeReference(rawLabel)
+	if label == "" {
+		return 0
+	}
+	if _, exists := refmap[label]; !exists {
+		refmap[label] = linkReference{dest: dest, title: title}
+	}
+	return ip.pos
+}
+
+// normalizeReference normalizza una label: senza parentesi, spazi compressi, case folding
+func normalizeReference(label string) string {
+	label = strings.TrimSpace(label[1 : len(label)-1])
+	label = strings.Join(strings.Fields(label), " ")
+	label = strings.ToLower(label)
+	label = strings.ReplaceAll(label, "ß", "ss")
+	return strings.ToUpper(label)
+}
+
+// mergeTextNodes unisce i nodi di testo adiacenti e rimuove quelli vuoti
+func mergeTextNodes(block *mdNode) {
+	n := block.firstChild
+	for n != nil {
+		next := n.next
+		if n.typ == nodeText {
+			if next != nil && next.typ == nodeText {
+				// A builder keeps long runs of text nodes linear
+				var sb strings.Builder
+				sb.WriteString(n.literal)
+				for next != nil && next.typ == nodeText {
+					sb.WriteString(next.literal)
+					n.srcEnd = next.srcEnd
+					after := next.next
+					next.unlink()
+					next = after
+				}
+				n.literal = sb.String()
+			}
+			if n.literal == "" {
+				n.unlink()
+			}
+		} else if n.firstChild != nil {
+			mergeTextNodes(n)
+		}
+		n = next
+	}
+}
+
+// linkifyTextNodes trasforma URL e indirizzi email nel testo in link (autolink estesi GFM)
+func linkifyTextNodes(block *mdNode) {
+	for n := block.firstChild; n != nil; n = n.next {
+		switch n.typ {
+		case nodeLink, nodeImage, nodeCode, nodeHTMLInline:
+			continue
+		case nodeText:
+			n = linkifyText(n)
+		default:
+			if n.firstChild != nil {
+				linkifyTextNodes(n)
+			}
+		}
+	}
+}
+
+// linkifyText divide un nodo di testo attorno al primo autolink trovato e
+// restituisce l'ultimo nodo prodotto, così la scansione prosegue dal resto
+func linkifyText(n *mdNode) *mdNode {
+	text := n.literal
+	start, end, dest := findAutolinkLiteral(text)
+	if start < 0 {
+		return n
+	}
+
+	link := &mdNode{typ: nodeLink, dest: dest}
+	link.appendChild(textNode(text[start:end]))
+	rest := textNode(text[end:])
+
+	// Split the source range too, when the text maps one to one onto the source
+	link.srcStart, link.srcEnd, link.hasSrc = n.srcStart, n.srcEnd, n.hasSrc
+	rest.srcStart, rest.srcEnd, rest.hasSrc = n.srcStart, n.srcEnd, n.hasSrc
+	if n.srcEnd-n.srcStart == len(text) {
+		link.srcStart, link.srcEnd = n.srcStart+start, n.srcStart+end
+		rest.srcStart = link.srcEnd
+		n.srcEnd = link.srcStart
+	}
+	link.firstChild.srcStart, link.firstChild.srcEnd, link.firstChild.hasSrc = link.srcStart, link.srcEnd, link.hasSrc
+
+	n.literal = text[:start]
+	n.insertAfter(link)
+	last := link
+	if end < len(text) {
+		link.insertAfter(rest)
+		last = linkifyText(rest)
+	}
+	if n.literal == "" {
+		n.unlink()
+	}
+	return last
+}
+
+// findAutolinkLiteral trova il primo URL (www., http://, https://) o email nel testo
+func findAutolinkLiteral(text string) (start, end int, dest string) {
+	start = -1
+	if m := reWWWAutolink.FindStringSubmatchIndex(text); m != nil {
+		start, end = m[2], m[3]
+		end = start + trimAutolinkTrailing(text[start:end])
+		dest = text[start:end]
+		if strings.HasPrefix(dest, "www.") {
+			dest = "http://" + dest
+		}
+	}
+	if m := reEmailAutolinkLiteral.FindStringSubmatchIndex(text); m != nil && (start < 0 || m[2] < start) {
+		s, e := m[2], m[3]
+		last := text[e-1]
+		if last != '-' && last != '_' {
+			if last == '.' {
+				e--
+			}
+			return s, e, "mailto:" + text[s:e]
+		}
+	}
+	return start, end, dest
+}
+
+// trimAutolinkTrailing rimuove la punteggiatura finale da un autolink esteso
+func trimAutolinkTrailing(link string) int {
+	for {
+		trimmed := strings.TrimRight(link, "?!.,:*_~'\"")
+		if strings.HasSuffix(trimmed, ")") && strings.Count(trimmed, ")") > strings.Count(trimmed, "(") {
+			trimmed = trimmed[:len(trimmed)-1]
+		}
+		if m := reTrailingEntityAutolink.FindStringIndex(trimmed); m != nil {
+			trimmed = trimmed[:m[0]]
+		}
+		if trimmed == link {
+			return len(link)
+		}
+		link = trimmed
+	}
+}
@@ -0,0 +1,132 @@
+package mark2pdf
+
+import (
+	"strings"
+	"testing"
+)
+
+// inlineText concatena il testo di una lista di elementi inline
+func inlineText(inlines []Inline) string {
+	var sb strings.Builder
+	for _, inline := range inlines {
+		switch n := inline.(type) {
+		case *LineBreak:
+			sb.WriteString("\n")
+		case *SoftBreak:
+			sb.WriteString(" ")
+		default:
+			sb.WriteString(TextContent(n))
+		}
+	}
+	return sb.String()
+}
+
+// paragraphInlines parsea input e restituisce gli inline dell'unico paragrafo
+func paragraphInlines(t *testing.T, input string) []Inline {
+	t.Helper()
+	doc := NewMarkdownParser(input).Parse()
+	if len(doc.Blocks) != 1 {
+		t.Fatalf("Expected 1 block, got %d", len(doc.Blocks))
+	}
+	p, ok := doc.Blocks[0].(*Paragraph)
+	if !ok {
+		t.Fatalf("Expected paragraph, got %T", doc.Blocks[0])
+	}
+	return p.Inlines
+}
+
+func TestBackslashEscapes(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"escaped asterisks", `\*not italic\*`, "*not italic*"},
+		{"escaped underscores", `snake\_case\_name`, "snake_case_name"},
+		{"escaped color tag", `\{red}literal\{/red}`, "{red}literal{/red}"},
+		{"escaped backslash", `C:\\path`, `C:\path`},
+		{"not punctuation", `\a stays`, `\a stays`},
+		{"escape inside bold", `**2 \* 3**`, "2 * 3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := inlineText(paragraphInlines(t, tt.input)); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestEscapedColorIsNotColored(t *testing.T) {
+	for _, inline := range paragraphInlines(t, `\{red}text{/red}`) {
+		if _, ok := inline.(*ColorSpan); ok {
+			t.Errorf("Expected no color element, got %+v", inline)
+		}
+	}
+}
+
+func TestEntityDecoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"named", "Fish &amp; Chips", "Fish & Chips"},
+		{"copyright", "&copy; 2024", "© 2024"},
+		{"decimal", "a &#8212; b", "a — b"},
+		{"hex", "&#x41;&#X42;", "AB"},
+		{"invalid codepoint", "&#0;", "\uFFFD"},
+		{"unknown entity", "&notanentity;", "&notanentity;"},
+		{"missing semicolon", "&amp text", "&amp text"},
+		{"in link text", "[A &amp; B](http://x.y)", "A & B"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := inlineText(paragraphInlines(t, tt.input)); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestHardLineBreaks(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"two spaces", "first  \nsecond", "first\nsecond"},
+		{"backslash", "first\\\nsecond", "first\nsecond"},
+		{"soft break", "first\nsecond", "first second"},
+		{"trailing spaces at end", "only line   ", "only line"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := inlineText(paragraphInlines(t, tt.input)); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestEscapeStringWinAnsi(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(a)", `\(a\)`},
+		{"©", `\251`},
+		{"—", `\227`},
+		{"€", `\200`},
+		{"日", " "},
+	}
+
+	for _, tt := range tests {
+		if got := escapeString(tt.input); got != tt.expected {
+			t.Errorf("escapeString(%q): expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
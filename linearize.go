@@ -0,0 +1,433 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// rawObject è un oggetto trattenuto per la scrittura linearizzata
+type rawObject struct {
+	body   string // corpo dell'oggetto, o voci del dizionario dopo /Length per gli stream
+	data   []byte // dati dello stream, non cifrati
+	stream bool
+	plain  bool // mai cifrato, come il dizionario /Encrypt
+}
+
+var (
+	objectRef = regexp.MustCompile(`\b(\d+) 0 R\b`)
+	rootRef   = regexp.MustCompile(`/Root (\d+) 0 R`)
+	encryptTo = regexp.MustCompile(`/Encrypt (\d+) 0 R`)
+	pagesRef  = regexp.MustCompile(`/Pages (\d+) 0 R`)
+	kidsArray = regexp.MustCompile(`/Kids \[([^\]]*)\]`)
+)
+
+// SetLinearized attiva l'output linearizzato ("fast web view"): la prima pagina e
+// gli oggetti che le servono sono all'inizio del file, preceduti dal dizionario di
+// linearizzazione e dalle tabelle di hint, così un visualizzatore può mostrarla
+// prima di aver scaricato tutto il documento. Il file è scritto per intero alla
+// fine e usa tabelle xref classiche anche con SetCompact.
+func (p *PDFWriter) SetLinearized(enabled bool) {
+	p.linearized = enabled
+}
+
+// refs restituisce i numeri degli oggetti a cui rimanda un corpo
+func refs(body string) []int {
+	var nums []int
+	for _, m := range objectRef.FindAllStringSubmatch(body, -1) {
+		n, _ := strconv.Atoi(m[1])
+		nums = append(nums, n)
+	}
+	return nums
+}
+
+// firstRef restituisce il numero dell'oggetto catturato da re, 0 se assente
+func firstRef(re *regexp.Regexp, s string) int {
+	m := re.FindStringSubmatch(s)
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+// linearize scrive gli oggetti trattenuti nelle sezioni di un file linearizzato:
+// dizionario di linearizzazione e xref della prima pagina, catalogo, stream di
+// hint, oggetti della prima pagina, pagine successive, oggetti condivisi, altri
+// oggetti e xref principale. Gli oggetti sono rinumerati: quelli della prima
+// pagina hanno i numeri più alti, le pagine successive partono da 1.
+func (o *objectWriter) linearize(trailer string) error {
+	catalog := firstRef(rootRef, trailer)
+	encrypt := firstRef(encryptTo, trailer)
+	pagesRoot := firstRef(pagesRef, o.recorded[catalog].body)
+	pages := refs(kidsArray.FindStringSubmatch(o.recorded[pagesRoot].body)[1])
+	isPage := make(map[int]bool, len(pages))
+	for _, page := range pages {
+		isPage[page] = true
+	}
+
+	// Objects needed by a page: everything it references, apart from the page
+	// tree, the catalog and the other pages
+	closure := func(page int) []int {
+		seen := map[int]bool{page: true}
+		order := []int{page}
+		for i := 0; i < len(order); i++ {
+			for _, ref := range refs(o.recorded[order[i]].body) {
+				if seen[ref] || isPage[ref] || ref == catalog || ref == pagesRoot || o.recorded[ref] == nil {
+					continue
+				}
+				seen[ref] = true
+				order = append(order, ref)
+			}
+		}
+		return order
+	}
+
+	first := closure(pages[0])
+	inFirst := make(map[int]bool, len(first))
+	for _, num := range first {
+		inFirst[num] = true
+	}
+	later := make([][]int, len(pages))
+	users := make(map[int]int)
+	for i := 1; i < len(pages); i++ {
+		later[i] = closure(pages[i])
+		for _, num := range later[i] {
+			users[num]++
+		}
+	}
+	own := make([][]int, len(pages))
+	var shared []int
+	seenShared := make(map[int]bool)
+	for i := 1; i < len(pages); i++ {
+		for _, num := range later[i] {
+			switch {
+			case inFirst[num]:
+			case users[num] == 1:
+				own[i] = append(own[i], num)
+			case !seenShared[num]:
+				seenShared[num] = true
+				shared = append(shared, num)
+			}
+		}
+	}
+	placed := map[int]bool{catalog: true, encrypt: true}
+	for _, part := range append([][]int{first, shared}, own...) {
+		for _, num := range part {
+			placed[num] = true
+		}
+	}
+	var rest []int
+	for num := range o.recorded {
+		if !placed[num] {
+			rest = append(rest, num)
+		}
+	}
+	sort.Ints(rest)
+
+	// Numbering: remaining pages, shared and other objects from 1, then the first
+	// page section with the linearization dictionary, catalog and hint stream
+	renumber := make(map[int]int, len(o.recorded))
+	next := 1
+	number := func(nums ...int) {
+		for _, num := range nums {
+			if num != 0 {
+				renumber[num] = next
+				next++
+			}
+		}
+	}
+	for _, page := range own[1:] {
+		number(page...)
+	}
+	number(shared...)
+	number(rest...)
+	mainSize := next
+	linNum := next
+	next++
+	number(catalog, encrypt)
+	hintNum := next
+	next++
+	number(first...)
+	size := next
+
+	serialized := make(map[int][]byte, len(o.recorded))
+	for old, obj := range o.recorded {
+		serialized[old] = o.serialize(renumber[old], obj, renumber)
+	}
+	length := func(nums []int) int {
+		n := 0
+		for _, num := range nums {
+			n += len(serialized[num])
+		}
+		return n
+	}
+
+	// Fixed-width placeholders: the sizes of the linearization dictionary and of
+	// the first page trailer do not depend on the values filled in later
+	linDict := func(fileLen, hintPos, hintLen, firstEnd, mainXref int) []byte {
+		return objectBytes(linNum, fmt.Sprintf("<< /Linearized 1 /L %10d /H [%10d %10d] /O %d /E %10d /N %d /T %10d >>",
+			fileLen, hintPos, hintLen, renumber[pages[0]], firstEnd, len(pages), mainXref))
+	}
+	trailer = objectRef.ReplaceAllStringFunc(trailer, func(ref string) string {
+		n, _ := strconv.Atoi(strings.Fields(ref)[0])
+		return fmt.Sprintf("%d 0 R", renumber[n])
+	})
+	firstXref := func(offsets map[int]int, mainXrefPos int) string {
+		var xref strings.Builder
+		fmt.Fprintf(&xref, "xref\n%d %d\n", mainSize, size-mainSize)
+		for num := mainSize; num < size; num++ {
+			fmt.Fprintf(&xref, "%010d 00000 n \n", offsets[num])
+		}
+		fmt.Fprintf(&xref, "trailer\n<< /Size %d /Prev %10d %s >>\nstartxref\n0\n%%%%EOF\n", size, mainXrefPos, trailer)
+		return xref.String()
+	}
+
+	// Offsets of the objects after the hint stream are computed first as if the
+	// hint stream were absent, as the hint tables require
+	firstXrefPos := o.pos + len(linDict(0, 0, 0, 0, 0))
+	openSection := []int{catalog}
+	if encrypt != 0 {
+		openSection = append(openSection, encrypt)
+	}
+	hintPos := firstXrefPos + len(firstXref(nil, 0)) + length(openSection)
+	offsets := make(map[int]int) // by old object number, without the hint stream
+	pos := hintPos
+	var afterHint []int
+	for _, part := range append(append([][]int{first}, own[1:]...), shared, rest) {
+		for _, num := range part {
+			offsets[num] = pos
+			pos += len(serialized[num])
+			afterHint = append(afterHint, num)
+		}
+	}
+	firstEnd := hintPos + length(first)
+
+	hint := linearizationHints(pages, first, own, later, shared, offsets, renumber, length)
+	hintObj := o.serialize(hintNum, &rawObject{body: fmt.Sprintf(" /S %d /Filter /FlateDecode", hint.sharedStart), data: o.deflate(hint.data), stream: true}, renumber)
+
+	// Final offsets and the main cross-reference table
+	final := make(map[int]int, size) // by new object number
+	final[linNum] = o.pos
+	at := firstXrefPos + len(firstXref(nil, 0))
+	for _, num := range openSection {
+		final[renumber[num]] = at
+		at += len(serialized[num])
+	}
+	final[hintNum] = hintPos
+	for _, num := range afterHint {
+		final[renumber[num]] = offsets[num] + len(hintObj)
+	}
+	mainXrefPos := pos + len(hintObj)
+	var mainXref strings.Builder
+	fmt.Fprintf(&mainXref, "xref\n0 %d", mainSize)
+	mainXrefFirst := mainXrefPos + mainXref.Len()
+	mainXref.WriteString("\n0000000000 65535 f \n")
+	for num := 1; num < mainSize; num++ {
+		fmt.Fprintf(&mainXref, "%010d 00000 n \n", final[num])
+	}
+	fmt.Fprintf(&mainXref, "trailer\n<< /Size %d >>\nstartxref\n%d\n%%%%EOF\n", mainSize, firstXrefPos)
+	fileLen := mainXrefPos + mainXref.Len()
+
+	o.write(linDict(fileLen, hintPos, len(hintObj), firstEnd+len(hintObj), mainXrefFirst))
+	o.writeString(firstXref(final, mainXrefPos))
+	for _, num := range openSection {
+		o.write(serialized[num])
+	}
+	o.write(hintObj)
+	for _, num := range afterHint {
+		o.write(serialized[num])
+	}
+	o.writeString(mainXref.String())
+	return o.err
+}
+
+// serialize scrive un oggetto trattenuto con il numero definitivo num, rinumerando
+// i riferimenti e cifrando stringhe e stream se il documento è cifrato
+func (o *objectWriter) serialize(num int, obj *rawObject, renumber map[int]int) []byte {
+	body := objectRef.ReplaceAllStringFunc(obj.body, func(ref string) string {
+		n, _ := strconv.Atoi(strings.Fields(ref)[0])
+		return fmt.Sprintf("%d 0 R", renumber[n])
+	})
+	data := obj.data
+	if o.security != nil && !obj.plain {
+		body = encryptStrings(body, func(b []byte) []byte { return o.security.encrypt(num, b) })
+		if obj.stream {
+			data = o.security.encrypt(num, data)
+		}
+	}
+	if obj.stream {
+		return streamBytes(num, body, data)
+	}
+	return objectBytes(num, body)
+}
+
+// encryptStrings cifra le stringhe esadecimali <...> di un corpo, lasciando i dizionari << >>
+func encryptStrings(body string, encrypt func([]byte) []byte) string {
+	var out strings.Builder
+	for i := 0; i < len(body); i++ {
+		if body[i] != '<' {
+			out.WriteByte(body[i])
+			continue
+		}
+		if i+1 < len(body) && body[i+1] == '<' {
+			out.WriteString("<<")
+			i++
+			continue
+		}
+		end := strings.IndexByte(body[i:], '>')
+		if end < 0 {
+			out.WriteString(body[i:])
+			break
+		}
+		plain, err := hex.DecodeString(body[i+1 : i+end])
+		if err != nil {
+			out.WriteString(body[i : i+end+1])
+		} else {
+			fmt.Fprintf(&out, "<%x>", encrypt(plain))
+		}
+		i += end
+	}
+	return out.String()
+}
+
+// bitWriter scrive interi in campi di bit, il formato delle tabelle di hint
+type bitWriter struct {
+	buf   bytes.Buffer
+	cur   byte
+	nbits int
+}
+
+func (w *bitWriter) write(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.cur = w.cur<<1 | byte(v>>i&1)
+		w.nbits++
+		if w.nbits == 8 {
+			w.buf.WriteByte(w.cur)
+			w.cur, w.nbits = 0, 0
+		}
+	}
+}
+
+// flush completa il byte corrente: ogni riga delle tabelle inizia a un confine di byte
+func (w *bitWriter) flush() {
+	if w.nbits > 0 {
+		w.write(0, 8-w.nbits)
+	}
+}
+
+// hintStream contiene le tabelle di hint e la posizione di quella degli oggetti condivisi
+type hintStream struct {
+	data        []byte
+	sharedStart int
+}
+
+// linearizationHints costruisce la tabella di hint delle pagine e quella degli
+// oggetti condivisi. Ogni oggetto della prima pagina e ogni oggetto condiviso è
+// un gruppo a sé; gli offset sono calcolati senza lo stream di hint.
+func linearizationHints(pages, first []int, own, later [][]int, shared []int, offsets, renumber map[int]int, length func([]int) int) hintStream {
+	// Shared object identifiers: the first page objects, then the shared section
+	ids := make(map[int]int, len(first)+len(shared))
+	groups := append(append([]int{}, first...), shared...)
+	for i, num := range groups {
+		ids[num] = i
+	}
+	counts := make([]int, len(pages))
+	lengths := make([]int, len(pages))
+	sharedRefs := make([][]int, len(pages))
+	usedLater := make(map[int]bool)
+	for i := 1; i < len(pages); i++ {
+		counts[i], lengths[i] = len(own[i]), length(own[i])
+		for _, num := range later[i] {
+			if id, ok := ids[num]; ok {
+				sharedRefs[i] = append(sharedRefs[i], id)
+				usedLater[num] = true
+			}
+		}
+	}
+	counts[0], lengths[0] = len(first), length(first)
+	for _, num := range first {
+		if usedLater[num] {
+			sharedRefs[0] = append(sharedRefs[0], ids[num])
+		}
+	}
+
+	minCount, maxCount := counts[0], counts[0]
+	minLength, maxLength := lengths[0], lengths[0]
+	maxShared, maxID := 0, len(groups)-1
+	for i := range pages {
+		minCount, maxCount = min(minCount, counts[i]), max(maxCount, counts[i])
+		minLength, maxLength = min(minLength, lengths[i]), max(maxLength, lengths[i])
+		maxShared = max(maxShared, len(sharedRefs[i]))
+	}
+	countBits := bits.Len(uint(maxCount - minCount))
+	lengthBits := bits.Len(uint(maxLength - minLength))
+	sharedBits := bits.Len(uint(maxShared))
+	idBits := bits.Len(uint(max(maxID, 0)))
+
+	// Page offset hint table; content streams are described by the page length
+	w := &bitWriter{}
+	w.write(minCount, 32)
+	w.write(offsets[pages[0]], 32)
+	w.write(countBits, 16)
+	w.write(minLength, 32)
+	w.write(lengthBits, 16)
+	w.write(0, 32) // least content stream offset in a page
+	w.write(0, 16)
+	w.write(minLength, 32) // least content stream length
+	w.write(lengthBits, 16)
+	w.write(sharedBits, 16)
+	w.write(idBits, 16)
+	w.write(0, 16) // no fractional positions of shared objects
+	w.write(1, 16)
+	row := func(value func(i int) int, n int) {
+		for i := range pages {
+			w.write(value(i), n)
+		}
+		w.flush()
+	}
+	row(func(i int) int { return counts[i] - minCount }, countBits)
+	row(func(i int) int { return lengths[i] - minLength }, lengthBits)
+	row(func(i int) int { return len(sharedRefs[i]) }, sharedBits)
+	for i := range pages {
+		for _, id := range sharedRefs[i] {
+			w.write(id, idBits)
+		}
+	}
+	w.flush()
+	// Numerators of the shared object positions and content stream offsets take 0 bits
+	row(func(i int) int { return lengths[i] - minLength }, lengthBits)
+	sharedStart := w.buf.Len()
+
+	// Shared object hint table
+	minGroup, maxGroup := length(groups[:1]), length(groups[:1])
+	for _, num := range groups {
+		minGroup, maxGroup = min(minGroup, length([]int{num})), max(maxGroup, length([]int{num}))
+	}
+	groupBits := bits.Len(uint(maxGroup - minGroup))
+	firstShared, firstSharedPos := 0, 0
+	if len(shared) > 0 {
+		firstShared, firstSharedPos = renumber[shared[0]], offsets[shared[0]]
+	}
+	w.write(firstShared, 32)
+	w.write(firstSharedPos, 32)
+	w.write(len(first), 32)
+	w.write(len(groups), 32)
+	w.write(0, 16) // one object per group
+	w.write(minGroup, 32)
+	w.write(groupBits, 16)
+	for _, num := range groups {
+		w.write(length([]int{num})-minGroup, groupBits)
+	}
+	w.flush()
+	for range groups {
+		w.write(0, 1) // no MD5 signatures
+	}
+	w.flush()
+	return hintStream{data: w.buf.Bytes(), sharedStart: sharedStart}
+}
@@ -0,0 +1,130 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// linearizationParams legge il dizionario di linearizzazione: /L, /H, /O, /E, /N e /T
+func linearizationParams(t *testing.T, data []byte) map[string]int {
+	t.Helper()
+	m := regexp.MustCompile(`^%PDF-1\.\d\n[^\n]*\n\d+ 0 obj\n<< /Linearized 1 /L +(\d+) /H \[ *(\d+) +(\d+)\] /O (\d+) /E +(\d+) /N (\d+) /T +(\d+) >>`).FindSubmatch(data)
+	if m == nil {
+		t.Fatal("Expected the linearization dictionary as the first object")
+	}
+	params := make(map[string]int)
+	for i, key := range []string{"L", "H", "HLength", "O", "E", "N", "T"} {
+		params[key], _ = strconv.Atoi(string(m[i+1]))
+	}
+	return params
+}
+
+// xrefTables restituisce gli offset di tutte le sezioni xref classiche, per numero di oggetto
+func xrefTables(t *testing.T, data []byte) map[int]int {
+	t.Helper()
+	offsets := make(map[int]int)
+	for _, m := range regexp.MustCompile(`xref\n(\d+) (\d+)\n`).FindAllSubmatchIndex(data, -1) {
+		start, _ := strconv.Atoi(string(data[m[2]:m[3]]))
+		n, _ := strconv.Atoi(string(data[m[4]:m[5]]))
+		for i := 0; i < n; i++ {
+			entry := string(data[m[1]+20*i : m[1]+20*i+20])
+			if strings.HasSuffix(entry, "n \n") {
+				offsets[start+i], _ = strconv.Atoi(entry[:10])
+			}
+		}
+	}
+	return offsets
+}
+
+func TestLinearizedOutput(t *testing.T) {
+	markdown := "# Manuale\n\n" + fillerLines(300)
+
+	expected, err := NewConverter(markdown).Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	converter := NewConverter(markdown)
+	converter.SetLinearized(true)
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+
+	params := linearizationParams(t, data)
+	if params["L"] != len(data) {
+		t.Errorf("Expected /L %d, got %d", len(data), params["L"])
+	}
+	pages := pageLines(t, expected)
+	if params["N"] != len(pages) {
+		t.Errorf("Expected /N %d, got %d", len(pages), params["N"])
+	}
+
+	offsets := xrefTables(t, data)
+	for num, offset := range offsets {
+		if !bytes.HasPrefix(data[offset:], []byte(strconv.Itoa(num)+" 0 obj\n")) {
+			t.Errorf("Object %d is not at offset %d", num, offset)
+		}
+	}
+	if !bytes.HasPrefix(data[offsets[params["O"]]:], []byte(strconv.Itoa(params["O"])+" 0 obj\n<< /Type /Page ")) {
+		t.Errorf("Expected /O to be the first page object")
+	}
+	if hint := data[params["H"]:]; !regexp.MustCompile(`^\d+ 0 obj\n<< /Length \d+ /S \d+ `).Match(hint) ||
+		!bytes.HasSuffix(data[:params["H"]+params["HLength"]], []byte("endobj\n")) {
+		t.Errorf("Expected the hint stream at /H, got %q", hint[:40])
+	}
+	if !bytes.HasPrefix(data[params["T"]:], []byte("\n0000000000 65535 f \n")) {
+		t.Errorf("Expected /T before the first entry of the main xref table")
+	}
+
+	// The first page can be rendered from the bytes before /E
+	if got := pageLines(t, data[:params["E"]]); len(got) == 0 || got[len(got)-1][0] != "Manuale" {
+		t.Errorf("Expected the first page before /E, got %q", got)
+	}
+	var got [][]string
+	for _, page := range pageLines(t, data) {
+		if len(page) > 0 {
+			got = append(got, page)
+		}
+	}
+	if len(got) != len(pages) || got[len(got)-1][0] != pages[len(pages)-1][0] {
+		t.Errorf("Expected the same %d pages in order, got %d", len(pages), len(got))
+	}
+}
+
+func TestLinearizedEncryptedTagged(t *testing.T) {
+	converter := NewConverter("---\ntitle: Prova\n---\n# Titolo\n\n" + fillerLines(200))
+	converter.SetLinearized(true)
+	converter.SetTagged(true)
+	converter.SetCompact(true)
+	converter.SetEncryption(&Encryption{UserPassword: "secret", Algorithm: EncryptAES128})
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+
+	linearizationParams(t, data)
+	if bytes.Contains(data, []byte("/ObjStm")) {
+		t.Error("Expected no object streams in a linearized file")
+	}
+	offsets := xrefTables(t, data)
+	for num, offset := range offsets {
+		if !bytes.HasPrefix(data[offset:], []byte(strconv.Itoa(num)+" 0 obj\n")) {
+			t.Errorf("Object %d is not at offset %d", num, offset)
+		}
+	}
+	trailer := regexp.MustCompile(`/Encrypt (\d+) 0 R`).FindSubmatch(data)
+	if trailer == nil {
+		t.Fatal("Expected /Encrypt in the first page trailer")
+	}
+	num, _ := strconv.Atoi(string(trailer[1]))
+	if !bytes.HasPrefix(data[offsets[num]:], []byte(strconv.Itoa(num)+" 0 obj\n<< /Filter /Standard")) {
+		t.Error("Expected the encryption dictionary unencrypted")
+	}
+	if bytes.Contains(data, []byte(fmt.Sprintf("<%x>", textString("Prova")))) {
+		t.Error("Expected the title to be encrypted")
+	}
+}
@@ -0,0 +1,350 @@
+package mark2pdf
+
+import "math"
+
+// Algoritmi di divisione in righe selezionabili con Theme.LineBreaking
+const (
+	LineBreakGreedy  = "greedy"  // riempie ogni riga il più possibile (first-fit)
+	LineBreakOptimal = "optimal" // Knuth–Plass: minimizza la bruttezza dell'intero paragrafo
+)
+
+// itemKind distingue gli elementi del modello box/glue/penalty di Knuth–Plass
+type itemKind int
+
+const (
+	itemBox     itemKind = iota // testo indivisibile
+	itemGlue                    // spazio estensibile, possibile punto di a capo
+	itemPenalty                 // punto di a capo con un costo
+)
+
+// Penalità dei punti di a capo, con i valori usati da TeX
+const (
+	forcedBreak     = -10000 // a capo obbligato
+	hyphenPenalty   = 50     // divisione di una parola con trattino aggiunto
+	exHyphenPenalty = 50     // a capo dopo un trattino esplicito
+)
+
+// lineItem è un elemento del paragrafo da dividere in righe
+type lineItem struct {
+	kind    itemKind
+	part    TextPart // testo della box, stile dello spazio per la glue
+	width   float64
+	stretch float64
+	shrink  float64
+	fill    bool    // glue che si estende senza limiti (fine paragrafo)
+	penalty float64 // costo del punto di a capo
+	flagged bool    // penalty di divisione di una parola: due righe consecutive sono penalizzate
+}
+
+// Parametri del paragrafo ottimale, con i valori usati da TeX
+const (
+	linePenalty     = 10
+	fitnessDemerits = 3000
+	flaggedDemerits = 3000 // due righe consecutive che terminano con un trattino
+	firstTolerance  = 2    // rapporto di estensione massimo nel primo tentativo
+	secondTolerance = 10   // tolleranza del secondo tentativo, prima del greedy
+)
+
+// lineItems converte le parti di testo in box (parole), glue (spazi) e penalty
+// (a capo forzati e divisioni delle parole, con la sillabazione di h se non è nil).
+// Il paragrafo termina con una glue infinita e un a capo forzato.
+func (p *PDFWriter) lineItems(parts []TextPart, fontSize float64, h *Hyphenator) []lineItem {
+	var items []lineItem
+	space := false // a space separates the next word from the previous one
+
+	addBox := func(part TextPart) {
+		// A space at the start of the paragraph or after a forced break is dropped;
+		// between words it takes the style of the word that follows
+		if space && len(items) > 0 && items[len(items)-1].kind == itemBox {
+			glue := TextPart{Text: " ", Font: part.Font, Size: part.Size, Color: part.Color}
+			if items[len(items)-1].part.elem == part.elem {
+				// Spaces belong to a link only inside it
+				glue.elem = part.elem
+			}
+			w := p.partWidth(glue, fontSize)
+			items = append(items, lineItem{kind: itemGlue, part: glue, width: w, stretch: w / 2, shrink: w / 3})
+		}
+		space = false
+
+		// Word fragments are separated by penalties where the word can be divided
+		text, breaks := wordBreaks(part.Text, h)
+		start := 0
+		for _, b := range breaks {
+			fragment := part
+			fragment.Text = text[start:b.pos]
+			items = append(items, lineItem{kind: itemBox, part: fragment, width: p.partWidth(fragment, fontSize)})
+			penalty := lineItem{kind: itemPenalty, penalty: exHyphenPenalty, flagged: true}
+			if b.hyphen {
+				hyphen := TextPart{Text: "-", Font: part.Font, Size: part.Size, Color: part.Color, elem: part.elem}
+				penalty = lineItem{kind: itemPenalty, part: hyphen, width: p.partWidth(hyphen, fontSize), penalty: hyphenPenalty, flagged: true}
+			}
+			items = append(items, penalty)
+			start = b.pos
+		}
+		part.Text = text[start:]
+		items = append(items, lineItem{kind: itemBox, part: part, width: p.partWidth(part, fontSize)})
+	}
+	addBreak := func() {
+		items = append(items,
+			lineItem{kind: itemGlue, fill: true},
+			lineItem{kind: itemPenalty, penalty: forcedBreak})
+	}
+
+	for _, part := range parts {
+		if part.Break {
+			addBreak()
+			space = false
+			continue
+		}
+		words := splitWords(part.Text)
+		if startsWithSpace(part.Text) || (len(words) == 0 && part.Text != "") {
+			space = true
+		}
+		for i, word := range words {
+			w := part
+			w.Text = word
+			space = space || i > 0
+			addBox(w)
+		}
+		if len(words) > 0 && endsWithSpace(part.Text) {
+			space = true
+		}
+	}
+	addBreak()
+	return items
+}
+
+// breakLines restituisce gli indici degli elementi in cui andare a capo;
+// widths(i) è la larghezza disponibile per la riga i
+func breakLines(items []lineItem, widths func(line int) float64, algorithm string) []int {
+	if algorithm == LineBreakOptimal {
+		for _, tolerance := range []float64{firstTolerance, secondTolerance} {
+			if breaks := optimalBreaks(items, widths, tolerance); breaks != nil {
+				return breaks
+			}
+		}
+	}
+	return greedyBreaks(items, widths)
+}
+
+// isBreakpoint indica se si può andare a capo all'elemento i
+func isBreakpoint(items []lineItem, i int) bool {
+	switch items[i].kind {
+	case itemGlue:
+		return i > 0 && items[i-1].kind == itemBox
+	case itemPenalty:
+		return true
+	}
+	return false
+}
+
+// greedyBreaks va a capo all'ultimo punto possibile prima di superare la larghezza
+func greedyBreaks(items []lineItem, widths func(line int) float64) []int {
+	var breaks []int
+	start, last := 0, -1
+	width := 0.0
+	for i := 0; i < len(items); i++ {
+		item := items[i]
+		if item.kind == itemPenalty && item.penalty <= forcedBreak {
+			breaks = append(breaks, i)
+			start, last, width = lineStart(items, i+1), -1, 0
+			i = start - 1
+			continue
+		}
+		switch {
+		case item.kind == itemGlue && isBreakpoint(items, i):
+			last = i
+		case item.kind == itemPenalty && width+item.width <= widths(len(breaks)):
+			// A word can be divided here if the line still fits with the hyphen
+			last = i
+		}
+		if item.kind == itemBox && width+item.width > widths(len(breaks)) && last >= start {
+			// Break at the last glue and re-scan the rest of the line
+			breaks = append(breaks, last)
+			start, last, width = lineStart(items, last+1), -1, 0
+			i = start - 1
+			continue
+		}
+		if item.kind != itemPenalty {
+			width += item.width
+		}
+	}
+	return breaks
+}
+
+// lineStart salta glue e penalty all'inizio di una riga
+func lineStart(items []lineItem, i int) int {
+	for i < len(items) && items[i].kind != itemBox && !(items[i].kind == itemPenalty && items[i].penalty <= forcedBreak) {
+		i++
+	}
+	return i
+}
+
+// breakNode è un punto di a capo attivo dell'algoritmo di Knuth–Plass
+type breakNode struct {
+	position int
+	line     int
+	fitness  int
+	demerits float64
+	flagged  bool       // la riga termina con una parola divisa
+	totals   lineTotals // somme dall'inizio del paragrafo fino all'inizio della riga successiva
+	previous *breakNode
+}
+
+// lineTotals sono le somme di larghezze, estensioni e contrazioni
+type lineTotals struct {
+	width, stretch, shrink float64
+	fills                  int
+}
+
+// optimalBreaks implementa l'algoritmo total-fit di Knuth e Plass: tra tutte le
+// divisioni con rapporto di estensione entro tolerance sceglie quella con la
+// somma minima dei demeriti delle righe. Restituisce nil se non ce n'è nessuna.
+func optimalBreaks(items []lineItem, widths func(line int) float64, tolerance float64) []int {
+	active := []*breakNode{{position: -1, fitness: 1}}
+	var sum lineTotals
+
+	for i, item := range items {
+		if isBreakpoint(items, i) {
+			active = tryBreak(items, i, sum, active, widths, tolerance)
+			if len(active) == 0 {
+				return nil
+			}
+		}
+		switch item.kind {
+		case itemBox:
+			sum.width += item.width
+		case itemGlue:
+			sum.width += item.width
+			sum.stretch += item.stretch
+			sum.shrink += item.shrink
+			if item.fill {
+				sum.fills++
+			}
+		}
+	}
+
+	// The paragraph ends with a forced break: pick the best node there
+	var best *breakNode
+	for _, node := range active {
+		if best == nil || node.demerits < best.demerits {
+			best = node
+		}
+	}
+	if best == nil || best.position != len(items)-1 {
+		return nil
+	}
+	var breaks []int
+	for node := best; node.previous != nil; node = node.previous {
+		breaks = append([]int{node.position}, breaks...)
+	}
+	return breaks
+}
+
+// tryBreak valuta il punto di a capo i rispetto ai nodi attivi e restituisce
+// i nodi attivi aggiornati
+func tryBreak(items []lineItem, i int, sum lineTotals, active []*breakNode, widths func(line int) float64, tolerance float64) []*breakNode {
+	item := items[i]
+	forced := item.kind == itemPenalty && item.penalty <= forcedBreak
+	penalty := 0.0
+	line := sum // the hyphen at a penalty break belongs to the line
+	if item.kind == itemPenalty {
+		penalty = item.penalty
+		line.width += item.width
+	}
+
+	var best [4]*breakNode
+	var kept []*breakNode
+	for _, a := range active {
+		ratio := adjustmentRatio(line, a.totals, widths(a.line))
+		// Once a line from a is overfull, or a forced break ends it, a is no longer active
+		if ratio >= -1 && !forced {
+			kept = append(kept, a)
+		}
+		if ratio < -1 || ratio > tolerance {
+			continue
+		}
+
+		badness := 100 * math.Pow(math.Abs(ratio), 3)
+		demerits := math.Pow(linePenalty+badness, 2)
+		switch {
+		case penalty >= 0:
+			demerits += penalty * penalty
+		case !forced:
+			demerits -= penalty * penalty
+		}
+		fitness := fitnessClass(ratio)
+		if a.position >= 0 && math.Abs(float64(fitness-a.fitness)) > 1 {
+			demerits += fitnessDemerits
+		}
+		if item.flagged && a.flagged {
+			demerits += flaggedDemerits
+		}
+		demerits += a.demerits
+
+		if best[fitness] == nil || demerits < best[fitness].demerits {
+			best[fitness] = &breakNode{position: i, line: a.line + 1, fitness: fitness, demerits: demerits, flagged: item.flagged, previous: a}
+		}
+	}
+
+	// New nodes start their line after the glue and penalties following the break
+	after := lineStart(items, i+1)
+	totals := sum
+	for j := i; j < after && j < len(items); j++ {
+		switch items[j].kind {
+		case itemBox:
+			totals.width += items[j].width
+		case itemGlue:
+			totals.width += items[j].width
+			totals.stretch += items[j].stretch
+			totals.shrink += items[j].shrink
+			if items[j].fill {
+				totals.fills++
+			}
+		}
+	}
+	for _, node := range best {
+		if node != nil {
+			node.totals = totals
+			kept = append(kept, node)
+		}
+	}
+	return kept
+}
+
+// adjustmentRatio calcola di quanto la riga tra from e sum deve estendersi
+// (positivo) o contrarsi (negativo) per occupare la larghezza width
+func adjustmentRatio(sum, from lineTotals, width float64) float64 {
+	natural := sum.width - from.width
+	switch {
+	case natural < width:
+		if sum.fills > from.fills {
+			return 0
+		}
+		stretch := sum.stretch - from.stretch
+		if stretch <= 0 {
+			return math.Inf(1)
+		}
+		return (width - natural) / stretch
+	case natural > width:
+		shrink := sum.shrink - from.shrink
+		if shrink <= 0 {
+			return math.Inf(-1)
+		}
+		return (width - natural) / shrink
+	}
+	return 0
+}
+
+// fitnessClass classifica una riga: 0 compressa, 1 normale, 2 larga, 3 molto larga
+func fitnessClass(ratio float64) int {
+	switch {
+	case ratio < -0.5:
+		return 0
+	case ratio <= 0.5:
+		return 1
+	case ratio <= 1:
+		return 2
+	}
+	return 3
+}
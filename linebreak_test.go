@@ -0,0 +1,105 @@
+package mark2pdf
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// paragraphItems costruisce un paragrafo di box larghe widths separate da glue
+// di larghezza, estensibilità e contrattilità 1
+func paragraphItems(widths ...float64) []lineItem {
+	var items []lineItem
+	for i, w := range widths {
+		if i > 0 {
+			items = append(items, lineItem{kind: itemGlue, width: 1, stretch: 1, shrink: 1})
+		}
+		items = append(items, lineItem{kind: itemBox, width: w})
+	}
+	return append(items, lineItem{kind: itemGlue, fill: true}, lineItem{kind: itemPenalty, penalty: forcedBreak})
+}
+
+func TestBreakLines(t *testing.T) {
+	width := func(int) float64 { return 10 }
+	tests := []struct {
+		name      string
+		items     []lineItem
+		algorithm string
+		expected  []int
+	}{
+		// Greedy leaves "3 3" stretched by 3 spaces; the optimal breaker shrinks "3 3 3" instead
+		{"greedy", paragraphItems(3, 3, 3, 6, 3), LineBreakGreedy, []int{3, 7, 10}},
+		{"optimal", paragraphItems(3, 3, 3, 6, 3), LineBreakOptimal, []int{5, 10}},
+		{"single line", paragraphItems(2, 2), LineBreakOptimal, []int{4}},
+		// No feasible division: the optimal breaker falls back to greedy
+		{"overfull word", paragraphItems(3, 12, 3), LineBreakOptimal, []int{1, 3, 6}},
+		{
+			"forced break",
+			append(paragraphItems(2)[:1], append([]lineItem{{kind: itemGlue, fill: true}, {kind: itemPenalty, penalty: forcedBreak}}, paragraphItems(2)...)...),
+			LineBreakOptimal,
+			[]int{2, 5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := breakLines(tt.items, width, tt.algorithm)
+			if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Errorf("Expected breaks %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestJustify(t *testing.T) {
+	tests := []struct {
+		name         string
+		extra        float64
+		stretch      float64
+		glues, chars int
+		wordSpacing  float64
+		charSpacing  float64
+	}{
+		{"within stretch", 3, 6, 3, 20, 1, 0},
+		{"char spacing", 10, 6, 3, 21, 2, 0.2},
+		{"char spacing capped", 20, 2, 2, 11, 8.5, 0.3},
+		{"no spaces", 1, 0, 0, 11, 0, 0.1},
+		{"shrink", -2, 6, 4, 20, -0.5, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws, cs := justify(tt.extra, tt.stretch, tt.glues, tt.chars, 10)
+			if math.Abs(ws-tt.wordSpacing) > 1e-9 || math.Abs(cs-tt.charSpacing) > 1e-9 {
+				t.Errorf("Expected Tw %v and Tc %v, got %v and %v", tt.wordSpacing, tt.charSpacing, ws, cs)
+			}
+		})
+	}
+}
+
+func TestConvertJustified(t *testing.T) {
+	theme, err := ParseTheme([]byte(`{"line_breaking": "optimal", "body": {"justify": true}}`))
+	if err != nil {
+		t.Fatalf("ParseTheme failed: %v", err)
+	}
+	converter := NewConverter(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 12) + "\n")
+	converter.SetTheme(theme)
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+
+	// Every line but the last is justified, and spacing is reset after each line
+	content := pdfContent(t, data)
+	lines := strings.Count(content, " Td\n")
+	if lines < 3 {
+		t.Fatalf("Expected a paragraph of several lines, got %d", lines)
+	}
+	if got := strings.Count(content, " Tw ") - strings.Count(content, "0 Tw 0 Tc\n"); got != lines-1 {
+		t.Errorf("Expected %d justified lines, got %d", lines-1, got)
+	}
+	if got := strings.Count(content, "0 Tw 0 Tc\n"); got != lines-1 {
+		t.Errorf("Expected %d spacing resets, got %d", lines-1, got)
+	}
+}
@@ -1,369 +1,1034 @@
 package mark2pdf
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Converter converte Markdown in PDF
 type Converter struct {
-	pdf    *PDFWriter
-	parser *MarkdownParser
+	pdf       *PDFWriter
+	parser    *MarkdownParser
+	doc       *Document // AST già costruito, se fornito
+	renderers *rendererRegistry
+	ctx       *RenderContext
+	theme     *Theme
+	prev      Block // blocco precedente e successivo allo stesso livello
+	next      Block
+	language  string              // lingua impostata con SetLanguage
+	hyphens   *Hyphenator         // sillabazione della lingua del documento, nil se non disponibile
+	numbers   map[*Heading]string // numeri dei titoli con Theme.Numbering
+	watermark *Watermark          // filigrana impostata con SetWatermark
+	baseDir   string              // cartella dei file inseriti con ![[file.pdf]]
+	appended  []pdfAppendix       // PDF aggiunti in fondo con AppendPDF
+	source    string              // nome del Markdown incorporato con SetAttachSource; "" = nessuno
+	bookmarks bool                // segnalibri per i titoli
+	breaks    []*pendingBreak     // elementi HTML con page-break-after ancora aperti
+}
+
+// pdfAppendix è un PDF da aggiungere in fondo al documento
+type pdfAppendix struct {
+	data  []byte
+	pages string
+	title string
 }
 
 // NewConverter crea un nuovo convertitore
 func NewConverter(markdown string) *Converter {
-	return &Converter{
-		pdf:    NewPDFWriter(),
-		parser: NewMarkdownParser(markdown),
+	return newConverter(NewMarkdownParser(markdown), nil)
+}
+
+// NewConverterFromAST crea un convertitore per un AST già costruito,
+// generato da codice o ottenuto da MarkdownParser.Parse ed eventualmente modificato
+func NewConverterFromAST(doc *Document) *Converter {
+	return newConverter(nil, doc)
+}
+
+func newConverter(parser *MarkdownParser, doc *Document) *Converter {
+	c := &Converter{
+		pdf:       NewPDFWriter(),
+		parser:    parser,
+		doc:       doc,
+		renderers: newRendererRegistry(),
+		bookmarks: true,
+	}
+	c.ctx = &RenderContext{conv: c}
+	c.SetTheme(DefaultTheme())
+	return c
+}
+
+// SetTheme imposta il tema usato per font, dimensioni, colori e spaziature
+func (c *Converter) SetTheme(theme *Theme) {
+	c.theme = theme
+	c.pdf.margin = theme.Margin
+	body := theme.resolve(theme.Body)
+	c.pdf.lineHeight = body.LineHeight
+	c.pdf.leading = body.Leading
+	c.pdf.grid = 0
+	if theme.BaselineGrid {
+		c.pdf.grid = body.lineAdvance()
+	}
+	c.pdf.fontSizes["normal"] = theme.Body.Size
+	c.pdf.fontSizes["code"] = theme.resolve(theme.Code).Size
+	for level := 1; level <= 6; level++ {
+		c.pdf.fontSizes[fmt.Sprintf("h%d", level)] = theme.resolve(theme.heading(level)).Size
+	}
+}
+
+// SetEncryption cifra il PDF con password e permessi; nil lo lascia in chiaro
+func (c *Converter) SetEncryption(encryption *Encryption) {
+	c.pdf.encryption = encryption
+}
+
+// SetPDFA attiva l'output PDF/A-2b per l'archiviazione: Convert restituisce un
+// *PDFAError se il documento non può essere conforme (font non incorporati,
+// cifratura, allegati)
+func (c *Converter) SetPDFA(enabled bool) {
+	c.pdf.pdfa = enabled
+	c.pdf.pdfaLevel = PDFA2B
+}
+
+// SetPDFALevel attiva l'output PDF/A al livello indicato: PDFA2B, o PDFA3B per i
+// documenti con allegati
+func (c *Converter) SetPDFALevel(level string) error {
+	return c.pdf.SetPDFALevel(level)
+}
+
+// EmbedFont incorpora un font TrueType al posto di un font standard del tema
+// ("Helvetica", "Helvetica-Bold", "Times-Roman", "Courier", ...)
+func (c *Converter) EmbedFont(baseFont string, data []byte) error {
+	return c.pdf.EmbedFont(baseFont, data)
+}
+
+// SetTagged attiva il PDF taggato per l'accessibilità: il contenuto è collegato a
+// un albero di struttura logica (titoli, paragrafi, liste, tabelle, link, figure)
+// che i lettori di schermo seguono nell'ordine di lettura
+func (c *Converter) SetTagged(enabled bool) {
+	c.pdf.SetTagged(enabled)
+}
+
+// SetCompact scrive un PDF 1.5 più piccolo: gli oggetti senza stream sono compressi
+// in object stream e la tabella xref è sostituita da un cross-reference stream
+func (c *Converter) SetCompact(enabled bool) {
+	c.pdf.compact = enabled
+}
+
+// SetLinearized produce un PDF linearizzato ("fast web view"), di cui un browser
+// può mostrare la prima pagina prima di averlo scaricato per intero
+func (c *Converter) SetLinearized(enabled bool) {
+	c.pdf.SetLinearized(enabled)
+}
+
+// SetCompression imposta il livello di compressione degli stream: "none", "fast",
+// "best" o "default"
+func (c *Converter) SetCompression(level string) error {
+	return c.pdf.SetCompression(level)
+}
+
+// SetDebug scrive gli stream di contenuto delle pagine non compressi, per
+// ispezionarne gli operatori quando si analizza l'impaginazione
+func (c *Converter) SetDebug(enabled bool) {
+	c.pdf.SetDebug(enabled)
+}
+
+// SetWatermark imposta una filigrana sulle pagine, ad esempio NewWatermark("BOZZA");
+// prevale sulla chiave watermark del front matter
+func (c *Converter) SetWatermark(w *Watermark) {
+	c.watermark = w
+}
+
+// AddBackground disegna una pagina di un PDF esistente, ad esempio una carta
+// intestata, sotto il contenuto delle pagine indicate, tenendo il testo fuori
+// dalle aree riservate in alto e in basso
+func (c *Converter) AddBackground(bg *Background) error {
+	return c.pdf.AddBackground(bg)
+}
+
+// AppendPDF aggiunge in fondo al documento le pagine di un file PDF, ad esempio
+// una scansione firmata o una scheda tecnica; pages seleziona le pagine ("" =
+// tutte, "2-5", "1,3-"). Il nome del file è la voce dei segnalibri delle pagine
+// aggiunte, sotto cui compaiono i segnalibri del file.
+func (c *Converter) AppendPDF(path, pages string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("errore lettura file: %w", err)
+	}
+	if _, err := parsePageRanges(pages); err != nil {
+		return err
+	}
+	// The file is read now, so an invalid PDF is reported before converting
+	if _, err := c.pdf.importPDF(data); err != nil {
+		return err
+	}
+	c.appended = append(c.appended, pdfAppendix{data: data, pages: pages, title: filepath.Base(path)})
+	return nil
+}
+
+// AttachFile incorpora un file nel PDF, ad esempio i dati da cui è prodotto un
+// report, con tipo MIME e descrizione
+func (c *Converter) AttachFile(file *Attachment) error {
+	return c.pdf.AttachFile(file)
+}
+
+// SetAttachSource incorpora nel PDF, per la tracciabilità, il Markdown con il nome
+// indicato (ad esempio "offerta.md") e i file locali a cui rimanda: le immagini e i
+// PDF inseriti con ![[file.pdf]] o AppendPDF. I file mancanti e gli indirizzi web
+// sono ignorati; "" disattiva. I convertitori creati da un AST incorporano solo i file.
+func (c *Converter) SetAttachSource(name string) {
+	c.source = name
+}
+
+// SetBaseDir imposta la cartella rispetto a cui sono risolti i percorsi relativi
+// della direttiva ![[file.pdf]]; "" = la cartella di lavoro
+func (c *Converter) SetBaseDir(dir string) {
+	c.baseDir = dir
+}
+
+// insertPDF inserisce nel punto della direttiva ![[file.pdf|pagine]] le pagine del file
+func (c *Converter) insertPDF(path, pages string) error {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.baseDir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("errore lettura file: %w", err)
 	}
+	if err := c.pdf.InsertPDF(data, pages, filepath.Base(path)); err != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// render renderizza il documento e aggiunge in fondo i PDF di AppendPDF
+func (c *Converter) render(doc *Document) error {
+	c.breaks = nil
+	if err := c.renderBlocks(doc.Blocks); err != nil {
+		return err
+	}
+	// Appended files are not part of the last section
+	c.pdf.closeBookmarks()
+	for _, appendix := range c.appended {
+		if err := c.pdf.InsertPDF(appendix.data, appendix.pages, appendix.title); err != nil {
+			return fmt.Errorf("%s: %w", appendix.title, err)
+		}
+	}
+	return nil
+}
+
+// SetBookmarks attiva o disattiva i segnalibri dei titoli, annidati secondo il
+// livello e con gli stessi numeri di Theme.Numbering; sono attivi di default
+func (c *Converter) SetBookmarks(enabled bool) {
+	c.bookmarks = enabled
+}
+
+// SetCreationDate scrive una data di creazione nei metadati del PDF; senza data
+// (e senza SOURCE_DATE_EPOCH) conversioni uguali producono file identici
+func (c *Converter) SetCreationDate(t time.Time) {
+	c.pdf.SetCreationDate(t)
+}
+
+// SetLanguage imposta la lingua del documento ("en", "it", ...), usata per la
+// sillabazione; prevale sulla chiave lang del front matter
+func (c *Converter) SetLanguage(lang string) {
+	c.language = lang
+}
+
+// Parser restituisce il parser Markdown, ad esempio per registrare estensioni
+// prima di Convert; è nil per i convertitori creati da un AST
+func (c *Converter) Parser() *MarkdownParser {
+	return c.parser
 }
 
 // Convert esegue la conversione e restituisce i byte del PDF
 func (c *Converter) Convert() ([]byte, error) {
-	elements := c.parser.Parse()
+	doc, err := c.prepare()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.render(doc); err != nil {
+		return nil, err
+	}
+
+	return c.pdf.Build()
+}
 
-	for _, elem := range elements {
-		if err := c.renderElement(elem); err != nil {
+// prepare analizza il documento e imposta sillabazione, numerazione, metadati e filigrana
+func (c *Converter) prepare() (*Document, error) {
+	doc := c.doc
+	if doc == nil {
+		doc = c.parser.Parse()
+	}
+
+	c.hyphens = c.hyphenator(doc)
+	c.numbers = HeadingNumbers(doc, c.theme.Numbering)
+	c.pdf.WriteMetadata(doc.Meta["title"], doc.Meta["author"])
+	c.pdf.lang = c.documentLanguage(doc)
+	if w := c.documentWatermark(doc); w != nil {
+		if err := c.pdf.AddWatermark(w); err != nil {
 			return nil, err
 		}
 	}
+	if err := c.attachSource(doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
 
-	return c.pdf.Build()
+// attachSource incorpora il Markdown e i file locali a cui rimanda, con SetAttachSource
+func (c *Converter) attachSource(doc *Document) error {
+	if c.source == "" {
+		return nil
+	}
+	if c.parser != nil && c.pdf.attachment(c.source) == nil {
+		err := c.pdf.AttachFile(&Attachment{Name: c.source, Data: []byte(c.parser.source), MIMEType: "text/markdown", Relationship: RelationSource})
+		if err != nil {
+			return err
+		}
+	}
+	var assets []string
+	Walk(doc, func(n Node, entering bool) (WalkStatus, error) {
+		if image, ok := n.(*Image); ok && entering {
+			assets = append(assets, image.Destination)
+		} else if block, ok := n.(Block); ok && entering {
+			if path, _, ok := pdfDirective(block); ok {
+				assets = append(assets, path)
+			}
+		}
+		return WalkContinue, nil
+	})
+	// Every file once, in order of appearance
+	var names []string
+	files := make(map[string][]byte)
+	add := func(name string, data []byte) {
+		if _, ok := files[name]; !ok {
+			files[name] = data
+			names = append(names, name)
+		}
+	}
+	for _, asset := range assets {
+		if name, data, ok := c.localFile(asset); ok {
+			add(name, data)
+		}
+	}
+	for _, appendix := range c.appended {
+		add(appendix.title, appendix.data)
+	}
+	for _, name := range names {
+		if c.pdf.attachment(name) != nil {
+			continue
+		}
+		if err := c.pdf.AttachFile(&Attachment{Name: name, Data: files[name], Relationship: RelationSource}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// localFile legge il file locale a cui rimanda un indirizzo del documento;
+// restituisce il nome con cui incorporarlo: il percorso relativo o, per i file
+// fuori dalla cartella del documento, il nome del file
+func (c *Converter) localFile(dest string) (string, []byte, bool) {
+	if dest == "" || strings.Contains(dest, "://") || strings.HasPrefix(dest, "data:") || strings.HasPrefix(dest, "mailto:") {
+		return "", nil, false
+	}
+	path := filepath.FromSlash(dest)
+	name := filepath.ToSlash(filepath.Clean(path))
+	if filepath.IsAbs(path) || strings.HasPrefix(name, "../") {
+		name = filepath.Base(path)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.baseDir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, false
+	}
+	return name, data, true
 }
 
-// ConvertToFile converte e salva in un file
+// documentWatermark restituisce la filigrana impostata con SetWatermark o quella
+// delle chiavi watermark e watermark-pages del front matter
+func (c *Converter) documentWatermark(doc *Document) *Watermark {
+	if c.watermark != nil {
+		return c.watermark
+	}
+	text := doc.Meta["watermark"]
+	if text == "" {
+		return nil
+	}
+	w := NewWatermark(text)
+	w.Pages = doc.Meta["watermark-pages"]
+	return w
+}
+
+// documentLanguage restituisce la lingua impostata con SetLanguage o quella del front matter
+func (c *Converter) documentLanguage(doc *Document) string {
+	if c.language != "" {
+		return c.language
+	}
+	if lang := doc.Meta["lang"]; lang != "" {
+		return lang
+	}
+	return doc.Meta["language"]
+}
+
+// hyphenator prepara la sillabazione per la lingua del documento; senza lingua o
+// senza pattern per la lingua le parole sono divise solo ai soft hyphen
+func (c *Converter) hyphenator(doc *Document) *Hyphenator {
+	h, err := NewHyphenator(c.documentLanguage(doc))
+	if err != nil {
+		return nil
+	}
+	if c.theme.HyphenMinLeft > 0 {
+		h.LeftMin = c.theme.HyphenMinLeft
+	}
+	if c.theme.HyphenMinRight > 0 {
+		h.RightMin = c.theme.HyphenMinRight
+	}
+	return h
+}
+
+// ConvertToFile converte e salva in un file, scritto pagina per pagina; in caso
+// di errore il file incompleto è rimosso
 func (c *Converter) ConvertToFile(filename string) error {
-	data, err := c.Convert()
+	f, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
-
-	return os.WriteFile(filename, data, 0644)
+	err = c.ConvertToWriter(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filename)
+	}
+	return err
 }
 
-// ConvertToWriter converte e scrive su un Writer
+// ConvertToWriter converte e scrive su un Writer senza tenere in memoria l'intero
+// documento: ogni pagina è scritta appena completata. Un errore durante la
+// conversione lascia su w un PDF incompleto.
 func (c *Converter) ConvertToWriter(w io.Writer) error {
-	data, err := c.Convert()
+	doc, err := c.prepare()
 	if err != nil {
 		return err
 	}
-
-	_, err = w.Write(data)
-	return err
+	out := bufio.NewWriter(w)
+	if err := c.pdf.StreamTo(out); err != nil {
+		return err
+	}
+	if err := c.render(doc); err != nil {
+		return err
+	}
+	if err := c.pdf.Finish(); err != nil {
+		return err
+	}
+	return out.Flush()
 }
 
-// renderElement renderizza un singolo elemento markdown
-func (c *Converter) renderElement(elem MarkdownElement) error {
-	switch elem.Type {
-	case "h1":
-		c.pdf.addSpace(10)
-		c.renderInlineElements(elem.Children, c.pdf.GetFontSize("h1"))
-		c.pdf.addSpace(5)
-
-	case "h2":
-		c.pdf.addSpace(8)
-		c.renderInlineElements(elem.Children, c.pdf.GetFontSize("h2"))
-		c.pdf.addSpace(4)
-
-	case "h3":
-		c.pdf.addSpace(6)
-		c.renderInlineElements(elem.Children, c.pdf.GetFontSize("h3"))
-		c.pdf.addSpace(3)
-
-	case "h4":
-		c.pdf.addSpace(5)
-		c.renderInlineElements(elem.Children, c.pdf.GetFontSize("h4"))
-		c.pdf.addSpace(2)
-
-	case "h5":
-		c.pdf.addSpace(4)
-		c.renderInlineElements(elem.Children, c.pdf.GetFontSize("h5"))
-		c.pdf.addSpace(2)
-
-	case "h6":
-		c.pdf.addSpace(3)
-		c.renderInlineElements(elem.Children, c.pdf.GetFontSize("h6"))
-		c.pdf.addSpace(2)
-
-	case "p":
-		c.renderInlineElements(elem.Children, c.pdf.GetFontSize("normal"))
-		c.pdf.addSpace(8)
-
-	case "code":
-		c.pdf.addSpace(5)
-		if elem.Language != "" {
-			c.pdf.writeText("Code ("+elem.Language+"):", c.pdf.GetFontSize("normal"), false)
-		}
-		lines := strings.Split(elem.Content, "\n")
-		for _, line := range lines {
-			c.pdf.writeText("  "+line, c.pdf.GetFontSize("code"), false)
-		}
-		c.pdf.addSpace(5)
-
-	case "list":
-		c.pdf.addSpace(3)
-		for i, item := range elem.Items {
-			// Use inline children if available, otherwise use raw item
-			if i < len(elem.ItemChildren) && len(elem.ItemChildren[i]) > 0 {
-				c.renderInlineElementsWithPrefix("  - ", elem.ItemChildren[i], c.pdf.GetFontSize("normal"))
-			} else {
-				text := "  - " + item
-				c.writeWrappedText(text, c.pdf.GetFontSize("normal"), false)
+// renderBlockDefault renderizza un singolo blocco dell'AST con lo stile del tema
+func (c *Converter) renderBlockDefault(block Block) error {
+	theme := c.theme
+	switch b := block.(type) {
+	case *Heading:
+		style := theme.resolve(theme.heading(b.Level))
+		if style.PageBreak != "" {
+			c.pdf.pageBreak(style.PageBreak == PageBreakOdd)
+		}
+		c.keepHeadingWithNext(b)
+		c.pdf.addSpace(style.SpaceBefore)
+		prefix := c.numbers[b]
+		if prefix != "" {
+			prefix += " "
+		}
+		// The heading may still move to the next page while it is laid out
+		page, top := c.renderStyledInlines(prefix, b.Inlines, style)
+		if c.bookmarks {
+			c.pdf.addBookmarkAt(prefix+strings.TrimSpace(TextContent(b)), b.Level, page, top)
+		}
+		c.pdf.addSpace(style.SpaceAfter)
+
+	case *Paragraph:
+		style := theme.resolve(theme.Body)
+		if theme.ParagraphStyle == ParagraphIndent {
+			// Consecutive paragraphs are separated by the indent, not by space
+			if _, ok := c.prev.(*Paragraph); !ok {
+				style.FirstLineIndent = 0
+			}
+			if _, ok := c.next.(*Paragraph); ok {
+				style.SpaceAfter = 0
 			}
 		}
-		c.pdf.addSpace(5)
-
-	case "ordered-list":
-		c.pdf.addSpace(3)
-		for i, item := range elem.Items {
-			prefix := fmt.Sprintf("  %d. ", i+1)
-			if i < len(elem.ItemChildren) && len(elem.ItemChildren[i]) > 0 {
-				c.renderInlineElementsWithPrefix(prefix, elem.ItemChildren[i], c.pdf.GetFontSize("normal"))
-			} else {
-				text := prefix + item
-				c.writeWrappedText(text, c.pdf.GetFontSize("normal"), false)
+		c.pdf.addSpace(style.SpaceBefore)
+		c.renderStyledInlines("", b.Inlines, style)
+		c.pdf.addSpace(style.SpaceAfter)
+
+	case *CodeBlock:
+		c.renderCodeBlock(b)
+
+	case *List:
+		c.pdf.addSpace(theme.List.SpaceBefore)
+		if err := c.renderList(b); err != nil {
+			return err
+		}
+		c.pdf.addSpace(theme.List.SpaceAfter)
+
+	case *BlockQuote:
+		c.pdf.addSpace(theme.Blockquote.SpaceBefore)
+		c.pdf.indent += theme.Blockquote.Indent
+		err := c.renderBlockquote(b)
+		c.pdf.indent -= theme.Blockquote.Indent
+		if err != nil {
+			return err
+		}
+		c.pdf.addSpace(theme.Blockquote.SpaceAfter)
+
+	case *Table:
+		c.pdf.addSpace(theme.Table.SpaceBefore)
+		c.renderTable(b)
+		c.pdf.addSpace(theme.Table.SpaceAfter)
+
+	case *ThematicBreak:
+		style := theme.Rule
+		color := ColorBlack
+		if style.BorderColor != nil {
+			color = *style.BorderColor
+		}
+		c.pdf.addSpace(style.SpaceBefore)
+		c.pdf.ensureLine()
+		x := c.pdf.margin + c.pdf.indent
+		c.pdf.DrawLine(x, c.pdf.yPosition, x+c.pdf.contentWidth(), c.pdf.yPosition, style.BorderWidth, color)
+		c.pdf.addSpace(style.SpaceAfter)
+
+	default:
+		// Blocks from parser extensions without a renderer: render their children
+		var children []Block
+		for _, child := range b.Children() {
+			if child, ok := child.(Block); ok {
+				children = append(children, child)
 			}
 		}
-		c.pdf.addSpace(5)
+		return c.renderBlocks(children)
+	}
+
+	return nil
+}
 
-	case "task-list":
-		c.pdf.addSpace(3)
-		for i, item := range elem.Items {
-			// Extract checkbox status
-			checkbox := "  [ ] "
-			if strings.HasPrefix(item, "[x]") {
-				checkbox = "  [x] "
+// renderCodeBlock renderizza un blocco di codice riga per riga, con lo sfondo del tema
+func (c *Converter) renderCodeBlock(block *CodeBlock) {
+	style := c.theme.resolve(c.theme.Code)
+	c.pdf.addSpace(style.SpaceBefore)
+	lines := strings.Split(strings.TrimSuffix(block.Literal, "\n"), "\n")
+	if len(lines) <= c.theme.KeepTogether {
+		height := 2*style.Padding + float64(len(lines))*style.lineAdvance()
+		if block.Language() != "" {
+			height += c.theme.text().lineAdvance()
+		}
+		c.pdf.keepTogether(height)
+	}
+	if language := block.Language(); language != "" {
+		c.writeStyledLine([]TextPart{{Text: "Code (" + language + "):", Font: FontRegular}}, c.theme.text())
+	}
+
+	// The background spans the available width; the code is indented inside it
+	x, width := c.pdf.margin+c.pdf.indent, c.pdf.contentWidth()
+	leading := style.lineAdvance()
+	pad := style.Padding
+	c.pdf.addSpace(pad)
+	// Code lines keep their own leading: the baseline grid would break the background
+	grid := c.pdf.grid
+	c.pdf.grid = 0
+	c.pdf.indent += style.Indent
+	for i, line := range lines {
+		if style.Background != nil {
+			c.pdf.ensureLine()
+			top := c.pdf.yPosition + style.Size*0.8 + (leading-style.Size)/2
+			height := leading
+			if i == 0 {
+				top += pad
+				height += pad
+			}
+			if i == len(lines)-1 {
+				height += pad
 			}
+			c.pdf.FillRect(x, top, width, height, *style.Background)
+		}
+		c.writeStyledLine([]TextPart{{Text: line, Font: FontRegular}}, style)
+	}
+	c.pdf.indent -= style.Indent
+	c.pdf.grid = grid
+	c.pdf.addSpace(pad + style.SpaceAfter)
+}
+
+// writeStyledLine scrive una riga senza word wrapping con font, colore e interlinea dello stile
+func (c *Converter) writeStyledLine(parts []TextPart, style Style) {
+	for i := range parts {
+		if parts[i].Color == nil {
+			parts[i].Color = style.Color
+		}
+	}
+	defer c.useLeading(style)()
+	c.pdf.writeMultiStyleText(c.styleFonts(parts, style), style.Size)
+}
+
+// useLeading imposta l'interlinea dello stile nel writer e restituisce la funzione che ripristina la precedente
+func (c *Converter) useLeading(style Style) func() {
+	lineHeight, leading := c.pdf.lineHeight, c.pdf.leading
+	c.pdf.lineHeight, c.pdf.leading = style.LineHeight, style.Leading
+	return func() { c.pdf.lineHeight, c.pdf.leading = lineHeight, leading }
+}
+
+// styleFonts applica grassetto e corsivo dello stile e sostituisce i font
+// regolare, grassetto, corsivo e monospazio con quelli delle famiglie del tema
+func (c *Converter) styleFonts(parts []TextPart, style Style) []TextPart {
+	parts = append([]TextPart(nil), parts...)
+	for i := range parts {
+		if parts[i].Size == 0 && parts[i].scale > 0 {
+			parts[i].Size = style.Size * parts[i].scale
+		}
+	}
+	if style.Bold {
+		parts = withFont(parts, FontBold)
+	}
+	if style.Italic {
+		parts = withFont(parts, FontItalic)
+	}
+	for i := range parts {
+		switch parts[i].Font {
+		case FontRegular:
+			parts[i].Font = c.pdf.familyFont(style.Font, 0)
+		case FontBold:
+			parts[i].Font = c.pdf.familyFont(style.Font, 1)
+		case FontItalic:
+			parts[i].Font = c.pdf.familyFont(style.Font, 2)
+		case FontMono:
+			parts[i].Font = c.pdf.familyFont(c.theme.InlineCode.Font, 0)
+		}
+	}
+	return parts
+}
 
-			if i < len(elem.ItemChildren) && len(elem.ItemChildren[i]) > 0 {
-				c.renderInlineElementsWithPrefix(checkbox, elem.ItemChildren[i], c.pdf.GetFontSize("normal"))
-			} else {
-				c.writeWrappedText("  "+item, c.pdf.GetFontSize("normal"), false)
+// renderList renderizza gli item di una lista, con i blocchi annidati rientrati
+func (c *Converter) renderList(list *List) error {
+	start := list.Start
+	if start == 0 && list.Ordered {
+		start = 1
+	}
+
+	for i, item := range list.Items {
+		marker := "-"
+		if list.Ordered {
+			marker = fmt.Sprintf("%d.", start+i)
+		}
+		if item.Task {
+			marker = "[ ]"
+			if item.Checked {
+				marker = "[x]"
 			}
 		}
-		c.pdf.addSpace(5)
 
-	case "blockquote":
-		c.pdf.addSpace(5)
-		text := "  | " + elem.Content
-		c.writeWrappedText(text, c.pdf.GetFontSize("normal"), false)
-		c.pdf.addSpace(5)
+		if err := c.renderListItem(item, marker); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderListItemDefault renderizza un item di lista: il marcatore, il primo
+// paragrafo sulla stessa riga e gli altri blocchi rientrati
+func (c *Converter) renderListItemDefault(item *ListItem, marker string) error {
+	prefix := "  " + marker + " "
+	label := c.pdf.newStruct("Lbl")
+	c.pdf.beginStruct("LBody")
+	defer c.pdf.endStruct()
+
+	// The first paragraph shares the line with the marker
+	blocks := item.Blocks
+	style := c.theme.text()
+	if p, ok := firstParagraph(blocks); ok {
+		parts := []TextPart{{Text: prefix, Font: FontRegular, Color: style.Color, elem: label}}
+		c.writeStyledParts(append(parts, c.convertInlineToTextParts(p.Inlines, style.Color)...), style)
+		blocks = blocks[1:]
+	} else {
+		c.writeStyledLine([]TextPart{{Text: marker, Font: FontRegular, elem: label}}, style)
+	}
 
-	case "table":
-		c.pdf.addSpace(5)
-		c.renderTable(elem)
-		c.pdf.addSpace(5)
+	return c.renderNested(blocks)
+}
 
-	case "hr":
-		c.pdf.addSpace(15)
-		c.pdf.writeLine(c.pdf.pageWidth - c.pdf.margin*2)
-		c.pdf.addSpace(15)
+// firstParagraph restituisce il primo blocco se è un paragrafo
+func firstParagraph(blocks []Block) (*Paragraph, bool) {
+	if len(blocks) == 0 {
+		return nil, false
 	}
+	p, ok := blocks[0].(*Paragraph)
+	return p, ok
+}
 
+// renderBlockquote renderizza il contenuto di una citazione
+func (c *Converter) renderBlockquote(quote *BlockQuote) error {
+	style := c.theme.resolve(c.theme.Blockquote)
+	for _, block := range quote.Blocks {
+		if p, ok := block.(*Paragraph); ok {
+			// The bar is decoration, not part of the quoted text
+			c.pdf.beginStruct("P")
+			parts := []TextPart{{Text: "  | ", Font: FontRegular, Color: style.Color, elem: artifact}}
+			c.writeStyledParts(append(parts, c.convertInlineToTextParts(p.Inlines, style.Color)...), style)
+			c.pdf.endStruct()
+			continue
+		}
+		if err := c.renderNested([]Block{block}); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// renderNested renderizza blocchi con un livello di rientro in più
+func (c *Converter) renderNested(blocks []Block) error {
+	if len(blocks) == 0 {
+		return nil
+	}
+	indent := c.theme.List.Indent
+	c.pdf.indent += indent
+	defer func() { c.pdf.indent -= indent }()
+
+	return c.renderBlocks(blocks)
+}
+
 // convertInlineToTextParts converte elementi inline in TextParts ricorsivamente
-func (c *Converter) convertInlineToTextParts(elements []InlineElement, baseColor *Color) []TextPart {
+func (c *Converter) convertInlineToTextParts(inlines []Inline, baseColor *Color) []TextPart {
 	parts := []TextPart{}
+	for _, inline := range inlines {
+		parts = append(parts, c.inlineParts(inline, baseColor)...)
+	}
+	return parts
+}
 
-	for _, elem := range elements {
-		// If element has children, process them recursively
-		if len(elem.Children) > 0 {
-			// Determine the color to inherit
-			inheritColor := baseColor
-			if elem.Type == "color" && elem.Color != nil {
-				inheritColor = elem.Color
-			}
+// defaultInlineParts converte un singolo elemento inline con lo stile di default
+func (c *Converter) defaultInlineParts(inline Inline, baseColor *Color) []TextPart {
+	switch n := inline.(type) {
+	case *Text:
+		return []TextPart{{Text: n.Value, Font: FontRegular, Color: baseColor}}
 
-			// Process children recursively
-			childParts := c.convertInlineToTextParts(elem.Children, inheritColor)
-
-			// Apply formatting based on element type
-			for i := range childParts {
-				switch elem.Type {
-				case "bold":
-					if childParts[i].Font == "F1" {
-						childParts[i].Font = "F2"
-					}
-				case "italic":
-					if childParts[i].Font == "F1" {
-						childParts[i].Font = "F3"
-					}
-				case "code":
-					childParts[i].Font = "F4"
-				case "color":
-					// Always apply the color from this element if not already set
-					if childParts[i].Color == nil {
-						childParts[i].Color = elem.Color
-					}
-				}
+	case *SoftBreak:
+		return []TextPart{{Text: " ", Font: FontRegular, Color: baseColor}}
+
+	case *LineBreak:
+		return []TextPart{{Break: true}}
+
+	case *CodeSpan:
+		color := baseColor
+		if c.theme.InlineCode.Color != nil {
+			color = c.theme.InlineCode.Color
+		}
+		return []TextPart{{Text: n.Value, Font: FontMono, Color: color, scale: c.theme.InlineCode.FontScale}}
+
+	case *Strong:
+		return withFont(c.convertInlineToTextParts(n.Inlines, baseColor), FontBold)
 
-				// Ensure color inheritance even if not explicitly set
-				if elem.Type == "color" && childParts[i].Color == nil {
-					childParts[i].Color = elem.Color
+	case *Emphasis:
+		return withFont(c.convertInlineToTextParts(n.Inlines, baseColor), FontItalic)
+
+	case *Strikethrough:
+		return c.convertInlineToTextParts(n.Inlines, baseColor)
+
+	case *ColorSpan:
+		color := n.Color
+		return c.convertInlineToTextParts(n.Inlines, &color)
+
+	case *Link:
+		style := c.theme.Link
+		if style.Color != nil {
+			baseColor = style.Color
+		}
+		parts := c.convertInlineToTextParts(n.Inlines, baseColor)
+		link := c.pdf.newStruct("Link")
+		if style.Bold {
+			parts = withFont(parts, FontBold)
+		}
+		if style.Italic {
+			parts = withFont(parts, FontItalic)
+		}
+		if style.FontScale > 0 {
+			for i := range parts {
+				if parts[i].scale == 0 {
+					parts[i].scale = style.FontScale
 				}
 			}
+		}
 
-			parts = append(parts, childParts...)
-		} else {
-			// Leaf node - convert to TextPart
-			var fontName string
-			var text string
-			var color *Color
-
-			switch elem.Type {
-			case "text":
-				fontName = "F1"
-				text = elem.Content
-				color = baseColor
-			case "bold":
-				fontName = "F2"
-				text = elem.Content
-				color = baseColor
-			case "italic":
-				fontName = "F3"
-				text = elem.Content
-				color = baseColor
-			case "code":
-				fontName = "F4"
-				text = elem.Content
-				color = baseColor
-			case "link":
-				fontName = "F1"
-				text = elem.Content + " (" + elem.URL + ")"
-				color = baseColor
-			case "image":
-				fontName = "F1"
-				text = "[Image: " + elem.Alt + "]"
-				color = baseColor
-			case "strikethrough":
-				fontName = "F1"
-				text = elem.Content
-				color = baseColor
-			case "color":
-				fontName = "F1"
-				text = elem.Content
-				color = elem.Color
-			default:
-				continue
+		// Links show their destination after the text, unless it is the text itself (autolinks)
+		text := TextContent(n)
+		if n.Destination != "" && n.Destination != text && n.Destination != "mailto:"+text {
+			parts = append(parts, TextPart{Text: " (" + n.Destination + ")", Font: FontRegular, Color: baseColor})
+		}
+		for i := range parts {
+			if parts[i].elem == nil {
+				parts[i].elem = link
 			}
+		}
+		return parts
+
+	case *Image:
+		figure := c.pdf.newStruct("Figure")
+		if figure != nil {
+			figure.alt = TextContent(n)
+			if figure.alt == "" {
+				figure.alt = n.Title
+			}
+		}
+		return []TextPart{{Text: "[Image: " + TextContent(n) + "]", Font: FontRegular, Color: baseColor, elem: figure}}
+	}
 
-			parts = append(parts, TextPart{Text: text, Font: fontName, Color: color})
+	// Inlines from parser extensions without a renderer: render their children
+	var parts []TextPart
+	for _, child := range inline.Children() {
+		if child, ok := child.(Inline); ok {
+			parts = append(parts, c.inlineParts(child, baseColor)...)
 		}
 	}
+	return parts
+}
 
+// withFont applica un font alle parti con il font normale
+func withFont(parts []TextPart, font string) []TextPart {
+	for i := range parts {
+		if parts[i].Font == FontRegular {
+			parts[i].Font = font
+		}
+	}
 	return parts
 }
 
-// renderInlineElementsWithPrefix renderizza un prefisso seguito da elementi inline
-func (c *Converter) renderInlineElementsWithPrefix(prefix string, elements []InlineElement, baseFontSize float64) {
-	// Build the complete text with formatting markers
+// renderStyledInlines renderizza un prefisso seguito da elementi inline con lo stile indicato
+// e restituisce la pagina e l'ordinata della prima riga
+func (c *Converter) renderStyledInlines(prefix string, inlines []Inline, style Style) (page int, top float64) {
 	parts := []TextPart{}
 
 	// Add prefix with regular font
 	if prefix != "" {
-		parts = append(parts, TextPart{Text: prefix, Font: "F1"})
+		parts = append(parts, TextPart{Text: prefix, Font: FontRegular, Color: style.Color})
 	}
 
 	// Convert inline elements to text parts recursively
-	parts = append(parts, c.convertInlineToTextParts(elements, nil)...)
-
-	// Render with word wrapping
-	c.writeMultiStyleTextWrapped(parts, baseFontSize)
-}
-
-// writeMultiStyleTextWrapped scrive testo multi-stile con word wrapping
-func (c *Converter) writeMultiStyleTextWrapped(parts []TextPart, fontSize float64) {
-	maxWidth := c.pdf.pageWidth - c.pdf.margin*2
-	avgCharWidth := fontSize * 0.5
-
-	currentLine := []TextPart{}
-	currentWidth := 0.0
-
-	for _, part := range parts {
-		// Split text into words
-		words := strings.Fields(part.Text)
-
-		for i, word := range words {
-			wordWidth := float64(len(word)) * avgCharWidth
-			spaceWidth := avgCharWidth * 0.5
-
-			// Add space before word (except for first word or after prefix)
-			if i > 0 || (len(currentLine) > 0 && currentLine[len(currentLine)-1].Text != "") {
-				testWidth := currentWidth + spaceWidth + wordWidth
-				if testWidth > maxWidth && len(currentLine) > 0 {
-					// Write current line
-					c.pdf.writeMultiStyleText(currentLine, fontSize)
-					currentLine = []TextPart{}
-					currentWidth = 0
-				} else {
-					// Add space to last part if same font, otherwise create new part
-					if len(currentLine) > 0 && currentLine[len(currentLine)-1].Font == part.Font {
-						currentLine[len(currentLine)-1].Text += " "
-						currentWidth += spaceWidth
-					} else {
-						currentLine = append(currentLine, TextPart{Text: " ", Font: part.Font, Color: part.Color})
-						currentWidth += spaceWidth
-					}
-				}
-			}
+	parts = append(parts, c.convertInlineToTextParts(inlines, style.Color)...)
 
-			// Check if word fits on current line
-			if currentWidth+wordWidth > maxWidth && len(currentLine) > 0 {
-				// Write current line and start new one
-				c.pdf.writeMultiStyleText(currentLine, fontSize)
-				currentLine = []TextPart{{Text: word, Font: part.Font, Color: part.Color}}
-				currentWidth = wordWidth
-			} else {
-				// Add word to current line
-				if len(currentLine) > 0 && currentLine[len(currentLine)-1].Font == part.Font {
-					// Merge with previous part if same font
-					currentLine[len(currentLine)-1].Text += word
-				} else {
-					currentLine = append(currentLine, TextPart{Text: word, Font: part.Font, Color: part.Color})
-				}
-				currentWidth += wordWidth
+	return c.writeStyledParts(parts, style)
+}
+
+// writeStyledParts scrive parti di testo con word wrapping, font e interlinea dello stile;
+// la prima riga è rientrata di style.FirstLineIndent
+func (c *Converter) writeStyledParts(parts []TextPart, style Style) (page int, top float64) {
+	defer c.useLeading(style)()
+	return c.writeMultiStyleTextWrapped(c.styleFonts(parts, style), style)
+}
+
+// writeMultiStyleTextWrapped divide le parti di testo in righe con l'algoritmo
+// del tema e le scrive; la prima riga è rientrata di style.FirstLineIndent e con
+// style.Justify tutte le righe tranne l'ultima occupano l'intera larghezza.
+// Restituisce la pagina e l'ordinata a cui è scritta la prima riga.
+func (c *Converter) writeMultiStyleTextWrapped(parts []TextPart, style Style) (page int, top float64) {
+	fontSize, indent := style.Size, style.FirstLineIndent
+	width := c.pdf.contentWidth()
+	items, breaks := c.breakParagraph(parts, style)
+
+	var lines []textLine
+	start := lineStart(items, 0)
+	for n, end := range breaks {
+		line := items[start:end:end]
+		start = lineStart(items, end+1)
+		if hyphen := items[end]; hyphen.kind == itemPenalty && hyphen.part.Text != "" {
+			// A line broken inside a word ends with the hyphen
+			line = append(line, lineItem{kind: itemBox, part: hyphen.part, width: hyphen.width})
+		}
+		if len(line) == 0 {
+			// Empty line between hard breaks
+			if n < len(breaks)-1 {
+				lines = append(lines, textLine{})
 			}
+			continue
+		}
+
+		parts, natural, stretch, glues, chars := composeLine(line)
+		text := textLine{parts: parts}
+		if n == 0 {
+			text.indent = indent
+		}
+		// Lines ending the paragraph or at a hard break stay ragged
+		if style.Justify && items[end].penalty > forcedBreak {
+			text.wordSpacing, text.charSpacing = justify(width-text.indent-natural, stretch, glues, chars, fontSize)
+		}
+		lines = append(lines, text)
+	}
+
+	page = -1
+	pageBreak := c.widowOrphanBreak(lines, fontSize)
+	for i, line := range lines {
+		if i == pageBreak {
+			c.pdf.newPage()
+		}
+		first := page < 0
+		if first {
+			c.pdf.ensureLine()
+			page, top = c.pdf.currentPage, c.pdf.yPosition
+		}
+		if line.parts == nil {
+			c.pdf.addSpace(c.pdf.lineAdvance(fontSize))
+			continue
+		}
+		c.pdf.indent += line.indent
+		c.pdf.writeSpacedText(line.parts, fontSize, line.wordSpacing, line.charSpacing)
+		c.pdf.indent -= line.indent
+		if first && c.pdf.currentPage != page {
+			// Larger parts or the baseline grid pushed the line to a new page
+			page, top = c.pdf.currentPage, c.pdf.top
+		}
+	}
+	if page < 0 {
+		c.pdf.ensureLine()
+		page, top = c.pdf.currentPage, c.pdf.yPosition
+	}
+	return page, top
+}
+
+// breakParagraph divide le parti di testo in righe con l'algoritmo del tema e
+// restituisce gli elementi del paragrafo e gli indici dei punti di a capo
+func (c *Converter) breakParagraph(parts []TextPart, style Style) ([]lineItem, []int) {
+	width := c.pdf.contentWidth()
+	var hyphens *Hyphenator
+	if style.Hyphenate {
+		hyphens = c.hyphens
+	}
+	items := c.pdf.lineItems(parts, style.Size, hyphens)
+	breaks := breakLines(items, func(line int) float64 {
+		if line == 0 {
+			return width - style.FirstLineIndent
+		}
+		return width
+	}, c.theme.LineBreaking)
+	return items, breaks
+}
+
+// textLine è una riga di testo composta, pronta per la scrittura
+type textLine struct {
+	parts                    []TextPart // nil per una riga vuota tra due a capo forzati
+	wordSpacing, charSpacing float64
+	indent                   float64
+}
+
+// composeLine unisce box e glue di una riga in parti di testo e ne restituisce
+// larghezza naturale, estensibilità, numero di spazi e di caratteri
+func composeLine(line []lineItem) (parts []TextPart, width, stretch float64, glues, chars int) {
+	for _, item := range line {
+		if item.kind == itemPenalty || item.fill {
+			continue
+		}
+		width += item.width
+		if item.kind == itemGlue {
+			stretch += item.stretch
+			glues++
+		}
+		chars += utf8.RuneCountInString(item.part.Text)
+		if last := len(parts) - 1; last >= 0 && sameStyle(parts[last], item.part) {
+			parts[last].Text += item.part.Text
+		} else {
+			parts = append(parts, item.part)
 		}
 	}
+	return parts, width, stretch, glues, chars
+}
+
+// maxCharSpacing è la spaziatura massima tra i caratteri, in frazione della dimensione del font
+const maxCharSpacing = 0.03
 
-	// Write remaining line
-	if len(currentLine) > 0 {
-		c.pdf.writeMultiStyleText(currentLine, fontSize)
+// justify distribuisce lo spazio extra di una riga: prima sugli spazi tra le parole
+// fino alla loro estensibilità, poi tra i caratteri (Tc, entro maxCharSpacing),
+// il resto di nuovo sugli spazi. Restituisce i valori di Tw e Tc.
+func justify(extra, stretch float64, glues, chars int, fontSize float64) (wordSpacing, charSpacing float64) {
+	if extra <= 0 || chars < 2 {
+		if glues > 0 {
+			wordSpacing = extra / float64(glues)
+		}
+		return wordSpacing, 0
+	}
+	words := min(extra, stretch)
+	if glues == 0 {
+		words = 0
+	}
+	rest := extra - words
+	// Tc is added after every character, spaces included; the last one is invisible
+	charSpacing = min(rest/float64(chars-1), fontSize*maxCharSpacing)
+	rest -= charSpacing * float64(chars-1)
+	if glues > 0 {
+		wordSpacing = (words + rest) / float64(glues)
 	}
+	return wordSpacing, charSpacing
+}
+
+// sameStyle indica se due parti possono essere unite nella stessa stringa
+func sameStyle(a, b TextPart) bool {
+	return a.Font == b.Font && a.Size == b.Size && sameColor(a.Color, b.Color) && a.elem == b.elem
+}
+
+// splitWords divide il testo in parole sugli spazi, senza spezzare sui non-breaking space
+func splitWords(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return r != '\u00A0' && unicode.IsSpace(r)
+	})
 }
 
-// renderInlineElements renderizza una lista di elementi inline con formattazione
-func (c *Converter) renderInlineElements(elements []InlineElement, baseFontSize float64) {
-	c.renderInlineElementsWithPrefix("", elements, baseFontSize)
+// startsWithSpace indica se il testo inizia con uno spazio separatore
+func startsWithSpace(text string) bool {
+	r, _ := utf8.DecodeRuneInString(text)
+	return r != '\u00A0' && unicode.IsSpace(r)
+}
+
+// endsWithSpace indica se il testo termina con uno spazio separatore
+func endsWithSpace(text string) bool {
+	r, _ := utf8.DecodeLastRuneInString(text)
+	return r != '\u00A0' && unicode.IsSpace(r)
+}
+
+// sameColor confronta due colori opzionali
+func sameColor(a, b *Color) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
 }
 
 // renderTable renderizza una tabella con bordi e celle
-func (c *Converter) renderTable(elem MarkdownElement) {
-	if len(elem.TableRows) == 0 {
+func (c *Converter) renderTable(table *Table) {
+	if len(table.Rows) == 0 {
 		return
 	}
 
-	fontSize := c.pdf.GetFontSize("normal")
-	cellPadding := 10.0 // Increased padding for better spacing
-	rowHeight := fontSize * 2.5
+	style := c.theme.resolve(c.theme.Table)
+	headerStyle := c.theme.resolve(c.theme.TableHeader)
+	fontSize := style.Size
+	cellPadding := style.Padding
+	rowHeight := style.lineAdvance() + cellPadding
 
 	// Calculate column widths based on content
-	maxWidth := c.pdf.pageWidth - c.pdf.margin*2
-	numCols := len(elem.TableRows[0])
+	maxWidth := c.pdf.contentWidth()
+	numCols := len(table.Rows[0].Cells)
 
 	// Calculate max width needed for each column (content only, without padding)
 	colContentWidths := make([]float64, numCols)
 	avgCharWidth := fontSize * 0.6 // More conservative estimate for character width
 
-	for _, row := range elem.TableRows {
-		for j, cell := range row {
+	for _, row := range table.Rows {
+		for j, cell := range row.Cells {
 			if j < numCols {
-				contentWidth := float64(len(cell)) * avgCharWidth
+				contentWidth := float64(len(TextContent(cell))) * avgCharWidth
 				if contentWidth > colContentWidths[j] {
 					colContentWidths[j] = contentWidth
 				}
@@ -397,110 +1062,72 @@ func (c *Converter) renderTable(elem MarkdownElement) {
 		totalWidth = maxWidth
 	}
 
+	// The header stays with the first row; other rows move to a new page when they don't fit
+	if table.Rows[0].Header && len(table.Rows) > 1 {
+		c.pdf.keepTogether(2*rowHeight + 2)
+	}
+
 	// Render each row
-	for rowIdx, row := range elem.TableRows {
+	for _, row := range table.Rows {
+		c.pdf.EnsureSpace(rowHeight)
+		c.pdf.beginStruct("TR")
 		startY := c.pdf.yPosition
-		isHeader := rowIdx == 0
+		cellStyle := style
+		if row.Header {
+			cellStyle = headerStyle
+		}
+		borderColor := ColorBlack
+		if cellStyle.BorderColor != nil {
+			borderColor = *cellStyle.BorderColor
+		}
 
 		// Draw cells for this row
-		xPos := c.pdf.margin
-		for colIdx, cell := range row {
+		xPos := c.pdf.margin + c.pdf.indent
+		for colIdx, cell := range row.Cells {
 			if colIdx >= numCols {
 				break
 			}
 
 			cellWidth := colWidths[colIdx]
+			role := "TD"
+			if row.Header {
+				role = "TH"
+			}
+			if cell := c.pdf.beginStruct(role); cell != nil {
+				if row.Header {
+					cell.attrs = "<< /O /Table /Scope /Column >>"
+				}
+				// Empty cells keep the table regular
+				cell.attach()
+			}
 
-			// Draw cell border (thicker for header)
-			if isHeader {
-				c.pdf.drawThickRect(xPos, startY, cellWidth, rowHeight)
-			} else {
-				c.pdf.drawRect(xPos, startY, cellWidth, rowHeight)
+			if cellStyle.Background != nil {
+				c.pdf.FillRect(xPos, startY, cellWidth, rowHeight, *cellStyle.Background)
+			}
+			if cellStyle.BorderWidth > 0 {
+				c.pdf.DrawRect(xPos, startY, cellWidth, rowHeight, cellStyle.BorderWidth, borderColor)
 			}
 
 			// Draw cell text with inline formatting support
 			textY := startY - rowHeight/2 - fontSize/3
-
-			// Check if we have inline elements for this cell
-			if rowIdx < len(elem.TableCellsInline) && colIdx < len(elem.TableCellsInline[rowIdx]) {
-				inlineElements := elem.TableCellsInline[rowIdx][colIdx]
-
-				// Convert inline elements to TextParts recursively
-				parts := c.convertInlineToTextParts(inlineElements, nil)
-
-				// Apply header bold if needed
-				if isHeader {
-					for i := range parts {
-						if parts[i].Font == "F1" {
-							parts[i].Font = "F2"
-						}
-					}
-				}
-
-				// Write multi-style text at position
-				if len(parts) > 0 {
-					c.pdf.writeMultiStyleTextAt(parts, xPos+cellPadding, textY, fontSize)
-				}
-			} else {
-				// Fallback to simple text rendering
-				maxCellChars := int((cellWidth - cellPadding*2) / avgCharWidth)
-				displayText := cell
-				if len(cell) > maxCellChars && maxCellChars > 3 {
-					displayText = cell[:maxCellChars-3] + "..."
-				}
-				c.pdf.writeTextAt(displayText, xPos+cellPadding, textY, fontSize, isHeader)
+			parts := c.styleFonts(c.convertInlineToTextParts(cell.Inlines, cellStyle.Color), cellStyle)
+			if len(parts) > 0 {
+				c.pdf.writeMultiStyleTextAt(parts, xPos+cellPadding, textY, fontSize)
 			}
 
+			c.pdf.endStruct()
 			xPos += cellWidth
 		}
+		c.pdf.endStruct()
 
 		// Move to next row
 		c.pdf.yPosition = startY - rowHeight
 
 		// Add extra spacing after header
-		if isHeader {
+		if row.Header {
 			c.pdf.yPosition -= 2
 		}
 	}
-
-	c.pdf.addSpace(5)
-}
-
-// writeWrappedText scrive testo con word wrapping
-func (c *Converter) writeWrappedText(text string, fontSize float64, isBold bool) {
-	maxWidth := c.pdf.pageWidth - c.pdf.margin*2
-	avgCharWidth := fontSize * 0.5 // Approssimazione della larghezza media del carattere
-
-	maxCharsPerLine := int(maxWidth / avgCharWidth)
-	if maxCharsPerLine < 10 {
-		maxCharsPerLine = 10
-	}
-
-	words := strings.Fields(text)
-	currentLine := ""
-
-	for _, word := range words {
-		testLine := currentLine
-		if testLine != "" {
-			testLine += " "
-		}
-		testLine += word
-
-		if len(testLine) <= maxCharsPerLine {
-			currentLine = testLine
-		} else {
-			// Scrive la linea corrente e inizia una nuova
-			if currentLine != "" {
-				c.pdf.writeText(currentLine, fontSize, isBold)
-			}
-			currentLine = word
-		}
-	}
-
-	// Scrive l'ultima linea
-	if currentLine != "" {
-		c.pdf.writeText(currentLine, fontSize, isBold)
-	}
 }
 
 // ConvertString è una funzione helper per conversioni veloci
@@ -517,5 +1144,6 @@ func ConvertFile(inputFile, outputFile string) error {
 	}
 
 	converter := NewConverter(string(data))
+	converter.SetBaseDir(filepath.Dir(inputFile))
 	return converter.ConvertToFile(outputFile)
 }
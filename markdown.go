@@ -2,459 +2,241 @@ package mark2pdf
 
 import (
 	"fmt"
+	"html"
 	"regexp"
+	"strconv"
 	"strings"
+	"unicode"
 )
 
-// MarkdownElement rappresenta un elemento del markdown parsato
-type MarkdownElement struct {
-	Type             string              // "h1", "h2", "h3", "h4", "h5", "h6", "p", "code", "list", "hr", "blockquote", "table"
-	Content          string              // Il contenuto testuale
-	Level            int                 // Per liste e headers
-	Items            []string            // Per liste (raw content)
-	ItemChildren     [][]InlineElement   // Inline elements per ogni item della lista
-	Ordered          bool                // Se la lista è ordinata
-	Language         string              // Per blocchi di codice
-	TableRows        [][]string          // Per tabelle (raw content)
-	TableCellsInline [][][]InlineElement // Inline elements per ogni cella della tabella [row][col][]InlineElement
-	TableAlign       []string            // Allineamento colonne tabella
-	Children         []InlineElement     // Elementi inline (bold, italic, code, link)
-}
-
-// InlineElement rappresenta elementi inline nel testo
-type InlineElement struct {
-	Type     string           // "text", "bold", "italic", "code", "link", "image", "strikethrough", "color"
-	Content  string           // Contenuto testuale (per text) o contenuto raw (per altri)
-	Children []InlineElement  // Elementi inline nested (per bold, italic, color, etc.)
-	URL      string           // Per link e immagini
-	Alt      string           // Per immagini
-	Color    *Color           // Per testo colorato
-}
-
-// MarkdownParser parsea il markdown in elementi
+// MarkdownParser parsea il markdown in un AST secondo la specifica
+// CommonMark, con le estensioni GFM (tabelle, strikethrough, task list,
+// autolink estesi) e la sintassi dei colori. Altre sintassi si aggiungono
+// con RegisterInlineExtension e RegisterBlockExtension.
 type MarkdownParser struct {
-	lines []string
+	source           string
+	strict           bool // solo CommonMark, senza estensioni GFM
+	inlineExtensions []InlineExtension
+	blockExtensions  []BlockExtension
 }
 
 // NewMarkdownParser crea un nuovo parser
 func NewMarkdownParser(markdown string) *MarkdownParser {
-	lines := strings.Split(markdown, "\n")
-	return &MarkdownParser{lines: lines}
+	return &MarkdownParser{
+		source:           markdown,
+		inlineExtensions: []InlineExtension{colorExtension()},
+	}
 }
 
-// Parse parsea il markdown e restituisce una lista di elementi
-func (mp *MarkdownParser) Parse() []MarkdownElement {
-	elements := make([]MarkdownElement, 0)
-	i := 0
+// Parse parsea il markdown e restituisce l'AST del documento
+func (mp *MarkdownParser) Parse() *Document {
+	meta, source := map[string]string(nil), mp.source
+	if !mp.strict {
+		meta, source = splitFrontMatter(source)
+	}
+	doc := buildDocument(mp.parseTree(source))
+	doc.Meta = meta
+	return doc
+}
 
-	for i < len(mp.lines) {
-		line := mp.lines[i]
-		trimmed := strings.TrimSpace(line)
+// parseTree esegue il parsing dei blocchi e poi degli inline, restituendo l'albero interno
+func (mp *MarkdownParser) parseTree(source string) *mdNode {
+	bp := newBlockParser(!mp.strict, mp.blockExtensions)
+	doc := bp.parse(source)
 
-		// Linea vuota
-		if trimmed == "" {
-			i++
-			continue
-		}
+	ip := newInlineParser(bp.refmap, !mp.strict, mp.inlineExtensions)
+	mp.processInlines(doc, ip)
+	return doc
+}
 
-		// Headers ATX style (# ## ### etc)
-		if strings.HasPrefix(trimmed, "#") {
-			elem, consumed := mp.parseHeader(i)
-			if consumed > 0 {
-				elements = append(elements, elem)
-				i += consumed
-				continue
+// processInlines esegue il parsing inline di paragrafi, titoli e celle
+func (mp *MarkdownParser) processInlines(block *mdNode, ip *inlineParser) {
+	for n := block.firstChild; n != nil; n = n.next {
+		switch n.typ {
+		case nodeItem:
+			if !mp.strict {
+				detectTaskItem(n)
 			}
-		}
-
-		// Headers Setext style (underlined with = or -)
-		if i+1 < len(mp.lines) {
-			nextLine := strings.TrimSpace(mp.lines[i+1])
-			if isSetextHeader(nextLine) {
-				level := 1
-				if strings.HasPrefix(nextLine, "-") {
-					level = 2
-				}
-				elements = append(elements, MarkdownElement{
-					Type:     "h" + string(rune('0'+level)),
-					Content:  trimmed,
-					Level:    level,
-					Children: mp.parseInline(trimmed),
-				})
-				i += 2
-				continue
+			mp.processInlines(n, ip)
+		case nodeHeading:
+			if !mp.strict {
+				detectHeadingClass(n)
+			}
+			ip.parseInlines(n)
+		case nodeParagraph, nodeTableCell:
+			ip.parseInlines(n)
+		default:
+			if n.firstChild != nil {
+				mp.processInlines(n, ip)
 			}
 		}
-
-		// Horizontal rule
-		if isHorizontalRule(trimmed) {
-			elements = append(elements, MarkdownElement{
-				Type: "hr",
-			})
-			i++
-			continue
-		}
-
-		// Code block (fenced with ``` or ~~~)
-		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
-			elem, consumed := mp.parseCodeBlock(i)
-			elements = append(elements, elem)
-			i += consumed
-			continue
-		}
-
-		// Code block (indented with 4 spaces or tab)
-		if len(line) > 0 && (strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t")) {
-			elem, consumed := mp.parseIndentedCodeBlock(i)
-			elements = append(elements, elem)
-			i += consumed
-			continue
-		}
-
-		// Blockquote
-		if strings.HasPrefix(trimmed, ">") {
-			elem, consumed := mp.parseBlockquote(i)
-			elements = append(elements, elem)
-			i += consumed
-			continue
-		}
-
-		// Table
-		if i+1 < len(mp.lines) && isTableSeparator(strings.TrimSpace(mp.lines[i+1])) {
-			elem, consumed := mp.parseTable(i)
-			elements = append(elements, elem)
-			i += consumed
-			continue
-		}
-
-		// Unordered list
-		if isUnorderedListItem(trimmed) {
-			elem, consumed := mp.parseList(i, false)
-			elements = append(elements, elem)
-			i += consumed
-			continue
-		}
-
-		// Ordered list
-		if isOrderedListItem(trimmed) {
-			elem, consumed := mp.parseList(i, true)
-			elements = append(elements, elem)
-			i += consumed
-			continue
-		}
-
-		// Task list
-		if isTaskListItem(trimmed) {
-			elem, consumed := mp.parseTaskList(i)
-			elements = append(elements, elem)
-			i += consumed
-			continue
-		}
-
-		// Paragraph - raggruppa linee consecutive non vuote
-		elem, consumed := mp.parseParagraph(i)
-		elements = append(elements, elem)
-		i += consumed
 	}
-
-	return elements
 }
 
-// parseHeader parsea un header ATX style
-func (mp *MarkdownParser) parseHeader(startIdx int) (MarkdownElement, int) {
-	line := strings.TrimSpace(mp.lines[startIdx])
-	level := 0
-
-	for _, ch := range line {
-		if ch == '#' {
-			level++
-		} else {
-			break
-		}
+// detectTaskItem riconosce le checkbox GFM ([ ] o [x]) all'inizio di un item
+func detectTaskItem(item *mdNode) {
+	para := item.firstChild
+	if para == nil || para.typ != nodeParagraph {
+		return
 	}
-
-	if level > 0 && level <= 6 {
-		content := strings.TrimSpace(line[level:])
-		// Remove trailing #
-		content = strings.TrimRight(content, "#")
-		content = strings.TrimSpace(content)
-
-		return MarkdownElement{
-			Type:     "h" + string(rune('0'+level)),
-			Content:  content,
-			Level:    level,
-			Children: mp.parseInline(content),
-		}, 1
+	m := reTaskMarker.FindSubmatch(para.content)
+	if m == nil || len(m[0]) == len(para.content) {
+		return
 	}
-
-	return MarkdownElement{}, 0
+	item.task = true
+	item.checked = m[1][0] == 'x' || m[1][0] == 'X'
+	para.trimContent(len(m[0]))
 }
 
-// parseCodeBlock parsea un blocco di codice fenced
-func (mp *MarkdownParser) parseCodeBlock(startIdx int) (MarkdownElement, int) {
-	line := strings.TrimSpace(mp.lines[startIdx])
-	fence := "```"
-	if strings.HasPrefix(line, "~~~") {
-		fence = "~~~"
-	}
-
-	// Extract language
-	language := strings.TrimSpace(strings.TrimPrefix(line, fence))
-
-	codeLines := make([]string, 0)
-	i := startIdx + 1
-
-	for i < len(mp.lines) {
-		if strings.HasPrefix(strings.TrimSpace(mp.lines[i]), fence) {
-			i++
-			break
-		}
-		codeLines = append(codeLines, mp.lines[i])
-		i++
+// detectHeadingClass riconosce la classe {-} (o {.unnumbered}) alla fine di un
+// titolo, che lo esclude dalla numerazione
+func detectHeadingClass(heading *mdNode) {
+	if loc := reHeadingClass.FindIndex(heading.content); loc != nil {
+		heading.unnumbered = true
+		heading.content = heading.content[:loc[0]]
 	}
-
-	return MarkdownElement{
-		Type:     "code",
-		Content:  strings.Join(codeLines, "\n"),
-		Language: language,
-	}, i - startIdx
 }
 
-// parseIndentedCodeBlock parsea un blocco di codice indentato
-func (mp *MarkdownParser) parseIndentedCodeBlock(startIdx int) (MarkdownElement, int) {
-	codeLines := make([]string, 0)
-	i := startIdx
-
-	for i < len(mp.lines) {
-		line := mp.lines[i]
-		if len(line) == 0 || strings.TrimSpace(line) == "" {
-			// Empty line in code block
-			codeLines = append(codeLines, "")
-			i++
-			continue
-		}
-
-		if strings.HasPrefix(line, "    ") {
-			codeLines = append(codeLines, line[4:])
-			i++
-		} else if strings.HasPrefix(line, "\t") {
-			codeLines = append(codeLines, line[1:])
-			i++
-		} else {
-			break
-		}
-	}
-
-	return MarkdownElement{
-		Type:    "code",
-		Content: strings.Join(codeLines, "\n"),
-	}, i - startIdx
+// nodePosition restituisce la posizione di un nodo dell'albero interno
+func nodePosition(n *mdNode) Position {
+	return Position{StartLine: n.startLine, StartColumn: n.startCol, EndLine: n.endLine, EndColumn: n.endCol}
 }
 
-// parseBlockquote parsea una blockquote
-func (mp *MarkdownParser) parseBlockquote(startIdx int) (MarkdownElement, int) {
-	quoteLines := make([]string, 0)
-	i := startIdx
-
-	for i < len(mp.lines) {
-		line := strings.TrimSpace(mp.lines[i])
-		if strings.HasPrefix(line, ">") {
-			content := strings.TrimSpace(strings.TrimPrefix(line, ">"))
-			quoteLines = append(quoteLines, content)
-			i++
-		} else if line == "" && i+1 < len(mp.lines) && strings.HasPrefix(strings.TrimSpace(mp.lines[i+1]), ">") {
-			quoteLines = append(quoteLines, "")
-			i++
-		} else {
-			break
-		}
-	}
-
-	content := strings.Join(quoteLines, " ")
-	return MarkdownElement{
-		Type:     "blockquote",
-		Content:  content,
-		Children: mp.parseInline(content),
-	}, i - startIdx
+// buildDocument converte l'albero interno del parser nell'AST pubblico
+func buildDocument(doc *mdNode) *Document {
+	return &Document{Position: nodePosition(doc), Blocks: buildBlocks(doc)}
 }
 
-// parseList parsea una lista (ordinata o non ordinata)
-func (mp *MarkdownParser) parseList(startIdx int, ordered bool) (MarkdownElement, int) {
-	items := make([]string, 0)
-	itemChildren := make([][]InlineElement, 0)
-	i := startIdx
-
-	for i < len(mp.lines) {
-		line := strings.TrimSpace(mp.lines[i])
-
-		if line == "" {
-			if i+1 < len(mp.lines) {
-				nextLine := strings.TrimSpace(mp.lines[i+1])
-				if (ordered && isOrderedListItem(nextLine)) || (!ordered && isUnorderedListItem(nextLine)) {
-					i++
-					continue
-				}
-			}
-			break
+// buildBlocks converte i figli di un nodo blocco
+func buildBlocks(parent *mdNode) []Block {
+	blocks := make([]Block, 0)
+	for n := parent.firstChild; n != nil; n = n.next {
+		if b := buildBlock(n); b != nil {
+			blocks = append(blocks, b)
 		}
-
-		if ordered && isOrderedListItem(line) {
-			dotIdx := strings.Index(line, ".")
-			if dotIdx > 0 && dotIdx < len(line)-1 {
-				content := strings.TrimSpace(line[dotIdx+1:])
-				items = append(items, content)
-				itemChildren = append(itemChildren, mp.parseInline(content))
-			}
-			i++
-		} else if !ordered && isUnorderedListItem(line) {
-			// Remove marker (-, *, +)
-			content := line[1:]
-			if len(content) > 0 && content[0] == ' ' {
-				content = content[1:]
-			}
-			content = strings.TrimSpace(content)
-			items = append(items, content)
-			itemChildren = append(itemChildren, mp.parseInline(content))
-			i++
-		} else {
-			break
-		}
-	}
-
-	listType := "list"
-	if ordered {
-		listType = "ordered-list"
 	}
-
-	return MarkdownElement{
-		Type:         listType,
-		Items:        items,
-		ItemChildren: itemChildren,
-		Ordered:      ordered,
-	}, i - startIdx
+	return blocks
 }
 
-// parseTaskList parsea una task list
-func (mp *MarkdownParser) parseTaskList(startIdx int) (MarkdownElement, int) {
-	items := make([]string, 0)
-	itemChildren := make([][]InlineElement, 0)
-	i := startIdx
-
-	for i < len(mp.lines) {
-		line := strings.TrimSpace(mp.lines[i])
-		if line == "" || !isTaskListItem(line) {
-			break
+// buildBlock converte un nodo blocco
+func buildBlock(n *mdNode) Block {
+	pos := nodePosition(n)
+	switch n.typ {
+	case nodeParagraph:
+		return &Paragraph{Position: pos, Inlines: buildInlines(n)}
+
+	case nodeHeading:
+		return &Heading{Position: pos, Level: n.level, Inlines: buildInlines(n), Unnumbered: n.unnumbered}
+
+	case nodeThematicBreak:
+		return &ThematicBreak{Position: pos}
+
+	case nodeCodeBlock:
+		return &CodeBlock{Position: pos, Info: n.info, Literal: n.literal, Fenced: n.fenced}
+
+	case nodeHTMLBlock:
+		return &HTMLBlock{Position: pos, Literal: n.literal}
+
+	case nodeBlockQuote:
+		return &BlockQuote{Position: pos, Blocks: buildBlocks(n)}
+
+	case nodeList:
+		list := &List{
+			Position:   pos,
+			Ordered:    n.list.ordered,
+			Start:      n.list.start,
+			Tight:      n.list.tight,
+			BulletChar: n.list.bulletChar,
+			Delimiter:  n.list.delimiter,
+		}
+		for item := n.firstChild; item != nil; item = item.next {
+			list.Items = append(list.Items, &ListItem{
+				Position: nodePosition(item),
+				Task:     item.task,
+				Checked:  item.checked,
+				Blocks:   buildBlocks(item),
+			})
 		}
+		return list
 
-		// Extract task text (after [ ] or [x])
-		taskRegex := regexp.MustCompile(`^[-*+]\s+\[([ xX])\]\s+(.+)$`)
-		matches := taskRegex.FindStringSubmatch(line)
-		if len(matches) == 3 {
-			checked := strings.ToLower(matches[1]) == "x"
-			text := matches[2]
-			displayText := ""
-			if checked {
-				displayText = "[x] " + text
-			} else {
-				displayText = "[ ] " + text
+	case nodeTable:
+		table := &Table{Position: pos}
+		for _, a := range n.align {
+			table.Align = append(table.Align, parseAlignment(a))
+		}
+		for row := n.firstChild; row != nil; row = row.next {
+			tr := &TableRow{Position: nodePosition(row), Header: row.header}
+			for cell := row.firstChild; cell != nil; cell = cell.next {
+				tr.Cells = append(tr.Cells, &TableCell{Position: nodePosition(cell), Inlines: buildInlines(cell)})
 			}
-			items = append(items, displayText)
-			itemChildren = append(itemChildren, mp.parseInline(text))
+			table.Rows = append(table.Rows, tr)
 		}
-		i++
-	}
-
-	return MarkdownElement{
-		Type:         "task-list",
-		Items:        items,
-		ItemChildren: itemChildren,
-	}, i - startIdx
-}
+		return table
 
-// parseTable parsea una tabella
-func (mp *MarkdownParser) parseTable(startIdx int) (MarkdownElement, int) {
-	rows := make([][]string, 0)
-	cellsInline := make([][][]InlineElement, 0)
-	align := make([]string, 0)
-
-	// Header row
-	headerLine := strings.TrimSpace(mp.lines[startIdx])
-	headerCells := parseTableRow(headerLine)
-	rows = append(rows, headerCells)
-
-	// Parse inline elements for header cells
-	headerInline := make([][]InlineElement, len(headerCells))
-	for i, cell := range headerCells {
-		headerInline[i] = mp.parseInline(cell)
-	}
-	cellsInline = append(cellsInline, headerInline)
-
-	// Separator row
-	if startIdx+1 < len(mp.lines) {
-		sepLine := strings.TrimSpace(mp.lines[startIdx+1])
-		align = parseTableAlignment(sepLine)
-	}
-
-	// Data rows
-	i := startIdx + 2
-	for i < len(mp.lines) {
-		line := strings.TrimSpace(mp.lines[i])
-		if line == "" || !strings.Contains(line, "|") {
-			break
+	case nodeExtContainer, nodeExtLeaf:
+		var lines []string
+		if n.literal != "" {
+			lines = strings.Split(strings.TrimSuffix(n.literal, "\n"), "\n")
 		}
-		cells := parseTableRow(line)
-		rows = append(rows, cells)
-
-		// Parse inline elements for data cells
-		rowInline := make([][]InlineElement, len(cells))
-		for j, cell := range cells {
-			rowInline[j] = mp.parseInline(cell)
+		block := n.ext.Close(buildBlocks(n), lines)
+		if p, ok := block.(interface{ setPos(Position) }); ok {
+			p.setPos(pos)
 		}
-		cellsInline = append(cellsInline, rowInline)
-
-		i++
+		return block
 	}
 
-	return MarkdownElement{
-		Type:             "table",
-		TableRows:        rows,
-		TableCellsInline: cellsInline,
-		TableAlign:       align,
-	}, i - startIdx
+	return nil
 }
 
-// parseParagraph parsea un paragrafo
-func (mp *MarkdownParser) parseParagraph(startIdx int) (MarkdownElement, int) {
-	paragraphLines := make([]string, 0)
-	i := startIdx
-
-	for i < len(mp.lines) {
-		line := strings.TrimSpace(mp.lines[i])
-		if line == "" {
-			break
-		}
+// parseAlignment converte l'allineamento di una colonna del parser
+func parseAlignment(align string) Alignment {
+	switch align {
+	case "left":
+		return AlignLeft
+	case "center":
+		return AlignCenter
+	case "right":
+		return AlignRight
+	}
+	return AlignNone
+}
 
-		// Check if next line is a special element
-		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "```") ||
-			strings.HasPrefix(line, "~~~") || isUnorderedListItem(line) ||
-			strings.HasPrefix(line, "> ") || isHorizontalRule(line) ||
-			isOrderedListItem(line) || isTaskListItem(line) {
-			break
+// buildInlines converte i figli inline di un nodo
+func buildInlines(parent *mdNode) []Inline {
+	inlines := make([]Inline, 0)
+	for n := parent.firstChild; n != nil; n = n.next {
+		pos := nodePosition(n)
+		switch n.typ {
+		case nodeText:
+			inlines = append(inlines, &Text{Position: pos, Value: n.literal})
+		case nodeSoftBreak:
+			inlines = append(inlines, &SoftBreak{Position: pos})
+		case nodeLineBreak:
+			inlines = append(inlines, &LineBreak{Position: pos})
+		case nodeCode:
+			inlines = append(inlines, &CodeSpan{Position: pos, Value: n.literal})
+		case nodeHTMLInline:
+			inlines = append(inlines, &HTMLInline{Position: pos, Literal: n.literal})
+		case nodeEmph:
+			inlines = append(inlines, &Emphasis{Position: pos, Inlines: buildInlines(n)})
+		case nodeStrong:
+			inlines = append(inlines, &Strong{Position: pos, Inlines: buildInlines(n)})
+		case nodeStrikethrough:
+			inlines = append(inlines, &Strikethrough{Position: pos, Inlines: buildInlines(n)})
+		case nodeLink:
+			inlines = append(inlines, &Link{Position: pos, Destination: n.dest, Title: n.title, Inlines: buildInlines(n)})
+		case nodeImage:
+			inlines = append(inlines, &Image{Position: pos, Destination: n.dest, Title: n.title, Inlines: buildInlines(n)})
+		case nodeExtInline:
+			if p, ok := n.inline.(interface{ setPos(Position) }); ok {
+				p.setPos(pos)
+			}
+			inlines = append(inlines, n.inline)
 		}
-
-		paragraphLines = append(paragraphLines, line)
-		i++
 	}
-
-	content := strings.Join(paragraphLines, " ")
-	return MarkdownElement{
-		Type:     "p",
-		Content:  content,
-		Children: mp.parseInline(content),
-	}, i - startIdx
+	return inlines
 }
 
-// parseInline parsea elementi inline (bold, italic, code, link, etc)
 // parseColorName converte un nome colore in un oggetto Color
 func parseColorName(colorName string) *Color {
 	colorName = strings.ToLower(strings.TrimSpace(colorName))
@@ -509,293 +291,69 @@ func parseColorName(colorName string) *Color {
 	return nil
 }
 
-func (mp *MarkdownParser) parseInline(text string) []InlineElement {
-	elements := make([]InlineElement, 0)
-	current := ""
-	i := 0
-
-	for i < len(text) {
-		// Color {colorname}text{/colorname} or {color:name}text{/color}
-		if text[i] == '{' {
-			closeBrace := strings.Index(text[i+1:], "}")
-			if closeBrace != -1 {
-				colorTag := text[i+1 : i+1+closeBrace]
-
-				// Check for {color:name} or {colorname} syntax
-				var colorName string
-				var endTag string
-
-				if strings.HasPrefix(colorTag, "color:") {
-					colorName = strings.TrimPrefix(colorTag, "color:")
-					endTag = "{/color}"
-				} else {
-					// Direct color name
-					colorName = colorTag
-					endTag = "{/" + colorName + "}"
-				}
-
-				// Try to parse color
-				color := parseColorName(colorName)
-				if color != nil {
-					if current != "" {
-						elements = append(elements, InlineElement{Type: "text", Content: current})
-						current = ""
-					}
-
-					// Find end tag
-					endIdx := strings.Index(text[i+1+closeBrace+1:], endTag)
-					if endIdx != -1 {
-						coloredText := text[i+1+closeBrace+1 : i+1+closeBrace+1+endIdx]
-
-						// Parse inline elements inside the colored text recursively
-						children := mp.parseInline(coloredText)
-
-						elements = append(elements, InlineElement{
-							Type:     "color",
-							Content:  coloredText,
-							Children: children,
-							Color:    color,
-						})
-						i = i + 1 + closeBrace + 1 + endIdx + len(endTag)
-						continue
-					}
-				}
-			}
-		}
-
-		// Bold **text** or __text__
-		if i+1 < len(text) && (text[i:i+2] == "**" || text[i:i+2] == "__") {
-			if current != "" {
-				elements = append(elements, InlineElement{Type: "text", Content: current})
-				current = ""
-			}
-
-			delimiter := text[i : i+2]
-			end := strings.Index(text[i+2:], delimiter)
-			if end != -1 {
-				boldText := text[i+2 : i+2+end]
-				elements = append(elements, InlineElement{Type: "bold", Content: boldText})
-				i += 2 + end + 2
-				continue
-			}
-		}
-
-		// Italic *text* or _text_
-		if text[i] == '*' || text[i] == '_' {
-			if current != "" {
-				elements = append(elements, InlineElement{Type: "text", Content: current})
-				current = ""
-			}
-
-			delimiter := string(text[i])
-			end := strings.Index(text[i+1:], delimiter)
-			if end != -1 && end > 0 {
-				italicText := text[i+1 : i+1+end]
-				// Check it's not part of bold
-				if !(i > 0 && text[i-1] == text[i]) && !(i+1+end+1 < len(text) && text[i+1+end+1] == text[i]) {
-					elements = append(elements, InlineElement{Type: "italic", Content: italicText})
-					i += 1 + end + 1
-					continue
-				}
-			}
-		}
-
-		// Strikethrough ~~text~~
-		if i+1 < len(text) && text[i:i+2] == "~~" {
-			if current != "" {
-				elements = append(elements, InlineElement{Type: "text", Content: current})
-				current = ""
-			}
-
-			end := strings.Index(text[i+2:], "~~")
-			if end != -1 {
-				strikeText := text[i+2 : i+2+end]
-				elements = append(elements, InlineElement{Type: "strikethrough", Content: strikeText})
-				i += 2 + end + 2
-				continue
-			}
-		}
-
-		// Inline code `text`
-		if text[i] == '`' {
-			if current != "" {
-				elements = append(elements, InlineElement{Type: "text", Content: current})
-				current = ""
-			}
-
-			end := strings.Index(text[i+1:], "`")
-			if end != -1 {
-				codeText := text[i+1 : i+1+end]
-				elements = append(elements, InlineElement{Type: "code", Content: codeText})
-				i += 1 + end + 1
-				continue
-			}
-		}
-
-		// Link [text](url) or Image ![alt](url)
-		if text[i] == '[' || (text[i] == '!' && i+1 < len(text) && text[i+1] == '[') {
-			if current != "" {
-				elements = append(elements, InlineElement{Type: "text", Content: current})
-				current = ""
-			}
-
-			isImage := text[i] == '!'
-			startPos := i
-			if isImage {
-				startPos++
-			}
-
-			closeBracket := strings.Index(text[startPos:], "](")
-			if closeBracket != -1 {
-				closeParen := strings.Index(text[startPos+closeBracket+2:], ")")
-				if closeParen != -1 {
-					linkText := text[startPos+1 : startPos+closeBracket]
-					url := text[startPos+closeBracket+2 : startPos+closeBracket+2+closeParen]
-
-					if isImage {
-						elements = append(elements, InlineElement{
-							Type: "image",
-							Alt:  linkText,
-							URL:  url,
-						})
-						i = startPos + closeBracket + 2 + closeParen + 1
-					} else {
-						elements = append(elements, InlineElement{
-							Type:    "link",
-							Content: linkText,
-							URL:     url,
-						})
-						i = startPos + closeBracket + 2 + closeParen + 1
-					}
-					continue
-				}
-			}
-		}
-
-		current += string(text[i])
-		i++
-	}
-
-	if current != "" {
-		elements = append(elements, InlineElement{Type: "text", Content: current})
-	}
-
-	return elements
-}
-
 // Helper functions
 
-func isSetextHeader(line string) bool {
-	if len(line) < 1 {
-		return false
-	}
-	return (strings.Trim(line, "=") == "" && strings.Contains(line, "=")) ||
-		(strings.Trim(line, "-") == "" && strings.Contains(line, "-"))
+// isASCIIPunctuation indica se c è un carattere di punteggiatura ASCII escapabile
+func isASCIIPunctuation(c byte) bool {
+	return (c >= '!' && c <= '/') || (c >= ':' && c <= '@') ||
+		(c >= '[' && c <= '`') || (c >= '{' && c <= '~')
 }
 
-func isHorizontalRule(line string) bool {
-	line = strings.TrimSpace(line)
-	if len(line) < 3 {
-		return false
-	}
-
-	// Remove spaces
-	cleaned := strings.ReplaceAll(line, " ", "")
-
-	// Check for ---, ***, ___
-	if len(cleaned) < 3 {
-		return false
-	}
+var entityRegex = regexp.MustCompile(`^&(?:#[xX][0-9a-fA-F]{1,6}|#[0-9]{1,7}|[A-Za-z][A-Za-z0-9]{1,31});`)
 
-	char := cleaned[0]
-	if char != '-' && char != '*' && char != '_' {
-		return false
+// decodeEntity decodifica un'entità HTML all'inizio di s (named o numerica).
+// Restituisce il testo decodificato e il numero di byte consumati, 0 se non valida.
+func decodeEntity(s string) (string, int) {
+	entity := entityRegex.FindString(s)
+	if entity == "" {
+		return "", 0
 	}
 
-	for _, c := range cleaned {
-		if c != rune(char) {
-			return false
+	if entity[1] == '#' {
+		var code int64
+		var err error
+		if entity[2] == 'x' || entity[2] == 'X' {
+			code, err = strconv.ParseInt(entity[3:len(entity)-1], 16, 32)
+		} else {
+			code, err = strconv.ParseInt(entity[2:len(entity)-1], 10, 32)
 		}
-	}
-
-	return len(cleaned) >= 3
-}
-
-func isUnorderedListItem(line string) bool {
-	line = strings.TrimSpace(line)
-	return len(line) >= 2 && (line[0] == '-' || line[0] == '*' || line[0] == '+') &&
-		(line[1] == ' ' || line[1] == '\t')
-}
-
-func isOrderedListItem(line string) bool {
-	line = strings.TrimSpace(line)
-	if len(line) < 3 {
-		return false
-	}
-
-	dotIdx := strings.Index(line, ".")
-	if dotIdx <= 0 || dotIdx >= len(line)-1 {
-		return false
-	}
-
-	numberPart := line[:dotIdx]
-	for _, ch := range numberPart {
-		if ch < '0' || ch > '9' {
-			return false
+		if err != nil || code == 0 || code > unicode.MaxRune || (code >= 0xD800 && code <= 0xDFFF) {
+			return "\uFFFD", len(entity)
 		}
+		return string(rune(code)), len(entity)
 	}
 
-	return line[dotIdx+1] == ' ' || line[dotIdx+1] == '\t'
-}
-
-func isTaskListItem(line string) bool {
-	line = strings.TrimSpace(line)
-	taskRegex := regexp.MustCompile(`^[-*+]\s+\[([ xX])\]\s+.+$`)
-	return taskRegex.MatchString(line)
-}
-
-func isTableSeparator(line string) bool {
-	if !strings.Contains(line, "|") {
-		return false
+	// Named entities: html.UnescapeString knows the full HTML5 table, but it
+	// also decodes legacy prefixes ("&notin;" vs "&not"), leaving a tail with ';'
+	decoded := html.UnescapeString(entity)
+	if decoded == entity || (len(decoded) > 1 && strings.HasSuffix(decoded, ";")) {
+		return "", 0
 	}
-	// Remove spaces and check if it contains only |, -, and :
-	cleaned := strings.ReplaceAll(line, " ", "")
-	for _, ch := range cleaned {
-		if ch != '|' && ch != '-' && ch != ':' {
-			return false
-		}
-	}
-	return strings.Count(cleaned, "-") >= 1
+	return decoded, len(entity)
 }
 
-func parseTableRow(line string) []string {
-	// Remove leading and trailing |
-	line = strings.Trim(strings.TrimSpace(line), "|")
-	cells := strings.Split(line, "|")
-
-	for i := range cells {
-		cells[i] = strings.TrimSpace(cells[i])
+// unescapeInline risolve backslash escape ed entità in un testo inline senza ulteriori parsing
+func unescapeInline(text string) string {
+	if !strings.ContainsAny(text, "\\&") {
+		return text
 	}
 
-	return cells
-}
-
-func parseTableAlignment(line string) []string {
-	cells := parseTableRow(line)
-	align := make([]string, len(cells))
-
-	for i, cell := range cells {
-		hasLeft := strings.HasPrefix(cell, ":")
-		hasRight := strings.HasSuffix(cell, ":")
-
-		if hasLeft && hasRight {
-			align[i] = "center"
-		} else if hasRight {
-			align[i] = "right"
-		} else {
-			align[i] = "left"
+	var sb strings.Builder
+	for i := 0; i < len(text); {
+		if text[i] == '\\' && i+1 < len(text) && isASCIIPunctuation(text[i+1]) {
+			sb.WriteByte(text[i+1])
+			i += 2
+			continue
 		}
+		if text[i] == '&' {
+			if decoded, n := decodeEntity(text[i:]); n > 0 {
+				sb.WriteString(decoded)
+				i += n
+				continue
+			}
+		}
+		sb.WriteByte(text[i])
+		i++
 	}
-
-	return align
+	return sb.String()
 }
@@ -0,0 +1,111 @@
+package mark2pdf
+
+import (
+	"fmt"
+)
+
+// insertedPage è una pagina del documento copiata da un PDF esistente: il suo
+// contenuto è la pagina originale, disegnata come Form XObject
+type insertedPage struct {
+	form   string     // risorsa del Form XObject
+	box    [4]float64 // riquadro della pagina originale, usato come /MediaBox
+	rotate int        // rotazione della pagina originale (/Rotate)
+}
+
+// InsertPDF aggiunge al documento le pagine di un PDF esistente, ad esempio una
+// scansione firmata o una scheda tecnica, dopo il contenuto già scritto; il
+// contenuto scritto in seguito inizia in una nuova pagina. pages seleziona le
+// pagine ("" = tutte, "2-5", "1,3-"). Le pagine mantengono formato e rotazione,
+// ricevono le filigrane ma non gli sfondi; annotazioni e campi dei moduli non sono
+// copiati. I segnalibri del file che puntano alle pagine inserite sono aggiunti a
+// quelli del documento, sotto una voce title ("" = il titolo del PDF; senza
+// titolo restano al primo livello).
+func (p *PDFWriter) InsertPDF(data []byte, pages, title string) error {
+	ranges, err := parsePageRanges(pages)
+	if err != nil {
+		return err
+	}
+	imp, err := p.importPDF(data)
+	if err != nil {
+		return err
+	}
+	source, err := imp.reader.pages()
+	if err != nil {
+		return fmt.Errorf("PDF importato: %w", err)
+	}
+
+	// The pages are imported first, so an error leaves the document as it was
+	var inserted []*insertedPage
+	position := make(map[int]int) // page of the file -> index among the inserted pages
+	for i, page := range source {
+		if !ranges.includes(i + 1) {
+			continue
+		}
+		form, box, err := p.pageForm(imp, page, i+1)
+		if err != nil {
+			return err
+		}
+		rotate, _ := intValue(imp.reader.resolve(page["Rotate"]))
+		position[i] = len(inserted)
+		inserted = append(inserted, &insertedPage{form: form, box: box, rotate: (rotate%360 + 360) % 360})
+	}
+	if len(inserted) == 0 {
+		return fmt.Errorf("nessuna pagina selezionata: il PDF importato ha %d pagine", len(source))
+	}
+	if title == "" {
+		title = imp.reader.info("Title")
+	}
+
+	first := len(p.pageContents)
+	if p.currentBuf != nil && p.currentBuf.Len() == 0 {
+		// The page just opened is still empty: the first inserted page takes its place
+		first = p.currentPage
+	}
+	figure := p.newStruct("Figure")
+	if figure != nil {
+		figure.alt = title
+		if figure.alt == "" {
+			figure.alt = "PDF"
+		}
+	}
+	if p.inserted == nil {
+		p.inserted = make(map[int]*insertedPage)
+	}
+	for j, page := range inserted {
+		if j > 0 || first == len(p.pageContents) {
+			p.newPage()
+		}
+		p.inserted[p.currentPage] = page
+		p.useResource(page.form)
+		p.beginMarked(figure)
+		p.currentBuf.WriteString(fmt.Sprintf("q /%s Do Q\n", page.form))
+		p.endMarked()
+	}
+	// The content that follows opens a new page
+	p.currentBuf = nil
+	p.yPosition = p.top
+
+	kids := imp.reader.outline(func(i int) int {
+		if j, ok := position[i]; ok {
+			return first + j
+		}
+		return -1
+	})
+	if title != "" {
+		p.addOutline(&outlineItem{title: title, page: first, kids: kids})
+	} else {
+		for _, item := range kids {
+			p.addOutline(item)
+		}
+	}
+	return nil
+}
+
+// mediaBox restituisce il riquadro della pagina i: quello della pagina originale
+// per le pagine inserite, altrimenti il formato del documento
+func (p *PDFWriter) mediaBox(i int) [4]float64 {
+	if page := p.inserted[i]; page != nil {
+		return page.box
+	}
+	return [4]float64{0, 0, p.pageWidth, p.pageHeight}
+}
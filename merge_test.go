@@ -0,0 +1,218 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testDatasheet restituisce un PDF di una pagina orizzontale ruotata, scritto a
+// mano senza tabella xref, con un segnalibro e un segnalibro figlio che usa una
+// destinazione con nome
+func testDatasheet() []byte {
+	return []byte(`%PDF-1.4
+1 0 obj << /Type /Catalog /Pages 2 0 R /Outlines 5 0 R /Dests << /details [3 0 R /XYZ 0 100 0] >> >> endobj
+2 0 obj << /Type /Pages /Kids [3 0 R] /Count 1 /MediaBox [0 0 200 100] /Rotate -270 >> endobj
+3 0 obj << /Type /Page /Parent 2 0 R /Contents 4 0 R /Resources << >> >> endobj
+4 0 obj << /Length 25 >> stream
+0 0 1 rg 10 10 50 50 re f
+endstream endobj
+5 0 obj << /Type /Outlines /First 6 0 R /Last 6 0 R /Count 1 >> endobj
+6 0 obj << /Title (Scheda) /Parent 5 0 R /First 7 0 R /Last 7 0 R /Count 1 /Dest [3 0 R /Fit] >> endobj
+7 0 obj << /Title <FEFF0044006500740074006100670107> /Parent 6 0 R /A << /S /GoTo /D /details >> >> endobj
+8 0 obj << /Title (Scheda tecnica XR-7) >> endobj
+trailer << /Root 1 0 R /Info 8 0 R >>
+%%EOF
+`)
+}
+
+func TestInsertPDF(t *testing.T) {
+	annex, err := NewConverter(fillerLines(120)).Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "annex.pdf"), annex, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	converter := NewConverter("# Offerta\n\nTesto.\n\n![[annex.pdf|2-3]]\n\n## Condizioni\n\nAltro testo.\n")
+	converter.SetBaseDir(dir)
+	converter.SetDebug(true)
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	pages := pageStreams(t, data)
+	if len(pages) != 4 {
+		t.Fatalf("Expected 4 pages, got %d", len(pages))
+	}
+	expected := []string{"(Offerta) Tj", "q /Fm1 Do Q\n", "q /Fm2 Do Q\n", "(Condizioni) Tj"}
+	for i, content := range expected {
+		if !strings.Contains(pages[i], content) {
+			t.Errorf("Page %d: expected %q, got %q", i+1, content, pages[i])
+		}
+	}
+
+	// The inserted pages are listed in the bookmarks under the file name, in the
+	// section of the directive
+	r, err := readPDF(data)
+	if err != nil {
+		t.Fatalf("readPDF failed: %v", err)
+	}
+	if _, err := r.pages(); err != nil {
+		t.Fatalf("pages failed: %v", err)
+	}
+	outline := r.outline(func(i int) int { return i })
+	want := []*outlineItem{{title: "Offerta", page: 0, kids: []*outlineItem{
+		{title: "annex.pdf", page: 1},
+		{title: "Condizioni", page: 3},
+	}}}
+	if !reflect.DeepEqual(outline, want) {
+		t.Errorf("Expected %+v, got %+v", want[0], outline[0])
+	}
+}
+
+func TestAppendPDF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scheda.pdf")
+	if err := os.WriteFile(path, testDatasheet(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	converter := NewConverter("Offerta\n")
+	converter.SetDebug(true)
+	converter.SetWatermark(NewWatermark("BOZZA"))
+	if err := converter.AppendPDF(path, ""); err != nil {
+		t.Fatalf("AppendPDF failed: %v", err)
+	}
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+
+	// The page keeps its size and rotation; the watermark is centred on it
+	if !bytes.Contains(data, []byte("/MediaBox [0 0 200 100] /Contents")) || !bytes.Contains(data, []byte("/Rotate 90 ")) {
+		t.Error("Expected the appended page with its own size and rotation")
+	}
+	pages := pageStreams(t, data)
+	if len(pages) != 2 || !strings.Contains(pages[1], " 100.00 50.00 cm\n") {
+		t.Errorf("Expected the watermark centred on the appended page, got %q", pages)
+	}
+
+	// The bookmarks of the file, with a named destination, follow the page
+	r, err := readPDF(data)
+	if err != nil {
+		t.Fatalf("readPDF failed: %v", err)
+	}
+	if _, err := r.pages(); err != nil {
+		t.Fatalf("pages failed: %v", err)
+	}
+	want := []*outlineItem{{title: "scheda.pdf", page: 1, kids: []*outlineItem{
+		{title: "Scheda", page: 1, kids: []*outlineItem{{title: "Dettagć", page: 1}}},
+	}}}
+	if outline := r.outline(func(i int) int { return i }); !reflect.DeepEqual(outline, want) {
+		t.Errorf("Expected the nested bookmarks, got %+v", outline)
+	}
+
+	// Without a title the PDFWriter uses the one of the file
+	p := NewPDFWriter()
+	if err := p.InsertPDF(testDatasheet(), "", ""); err != nil {
+		t.Fatalf("InsertPDF failed: %v", err)
+	}
+	if len(p.outline) != 1 || p.outline[0].title != "Scheda tecnica XR-7" {
+		t.Errorf("Expected the title of the file, got %+v", p.outline)
+	}
+}
+
+func TestInsertPDFOutputs(t *testing.T) {
+	tests := []struct {
+		name      string
+		setup     func(c *Converter)
+		encrypted bool
+	}{
+		{"compact", func(c *Converter) { c.SetCompact(true) }, false},
+		{"linearized tagged", func(c *Converter) {
+			c.SetLinearized(true)
+			c.SetTagged(true)
+		}, false},
+		{"encrypted", func(c *Converter) {
+			c.SetEncryption(&Encryption{UserPassword: "secret", Algorithm: EncryptAES128})
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "scheda.pdf")
+			if err := os.WriteFile(path, testDatasheet(), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			converter := NewConverter("# Offerta\n\n" + fillerLines(60))
+			tt.setup(converter)
+			if err := converter.AppendPDF(path, "1"); err != nil {
+				t.Fatalf("AppendPDF failed: %v", err)
+			}
+			var out bytes.Buffer
+			if err := converter.ConvertToWriter(&out); err != nil {
+				t.Fatalf("ConvertToWriter failed: %v", err)
+			}
+			r, err := readPDF(out.Bytes())
+			if (err == nil) == tt.encrypted {
+				t.Fatalf("Expected readPDF to fail only on encrypted output, got %v", err)
+			}
+			if tt.encrypted {
+				return
+			}
+			pages, err := r.pages()
+			if err != nil {
+				t.Fatalf("pages failed: %v", err)
+			}
+			if box := r.pageBox(pages[len(pages)-1]); box != [4]float64{0, 0, 200, 100} {
+				t.Errorf("Expected the appended page last, got %v", box)
+			}
+			// The heading, then the appended file at the first level
+			outline := r.outline(func(i int) int { return i })
+			if len(outline) != 2 || outline[0].title != "Offerta" || outline[1].page != len(pages)-1 {
+				t.Errorf("Expected a bookmark to the appended page, got %+v", outline)
+			}
+		})
+	}
+}
+
+func TestInsertPDFErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "scheda.pdf")
+	if err := os.WriteFile(path, testDatasheet(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "note.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		apply func(c *Converter) error
+	}{
+		{"missing page", func(c *Converter) error { return c.AppendPDF(path, "2-") }},
+		{"invalid range", func(c *Converter) error { return c.AppendPDF(path, "x") }},
+		{"not a PDF", func(c *Converter) error { return c.AppendPDF(filepath.Join(dir, "note.txt"), "") }},
+		{"missing file", func(c *Converter) error { return c.AppendPDF(filepath.Join(dir, "none.pdf"), "") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			converter := NewConverter("Testo\n")
+			err := tt.apply(converter)
+			if err == nil {
+				_, err = converter.Convert()
+			}
+			if err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+
+	converter := NewConverter("Testo\n\n![[none.pdf]]\n")
+	converter.SetBaseDir(dir)
+	if _, err := converter.Convert(); err == nil || !strings.Contains(err.Error(), "none.pdf") {
+		t.Errorf("Expected an error naming the missing file, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package mark2pdf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Formati dei numeri dei titoli (Numbering.Formats e Numbering.Appendix)
+const (
+	NumberDecimal    = "decimal"     // 1, 2, 3
+	NumberUpperAlpha = "upper-alpha" // A, B, C
+	NumberLowerAlpha = "lower-alpha" // a, b, c
+	NumberUpperRoman = "upper-roman" // I, II, III
+	NumberLowerRoman = "lower-roman" // i, ii, iii
+)
+
+// Numbering configura la numerazione gerarchica dei titoli ("2.3.1 Gestione degli errori")
+type Numbering struct {
+	Enabled bool `json:"enabled"`
+	// Depth è il livello più profondo numerato; 0 numera tutti i livelli
+	Depth int `json:"depth,omitempty"`
+	// Formats è il formato dei numeri di ogni livello, dal primo; l'ultimo vale
+	// anche per i livelli successivi. Senza formati i numeri sono decimali.
+	Formats []string `json:"formats,omitempty"`
+	// Appendix è il formato del primo livello dopo la direttiva "<!-- appendix -->"
+	// (o "\appendix"), da cui la numerazione ricomincia; default NumberUpperAlpha
+	Appendix string `json:"appendix,omitempty"`
+}
+
+// validate controlla profondità e formati della numerazione
+func (n Numbering) validate() error {
+	if n.Depth < 0 || n.Depth > 6 {
+		return fmt.Errorf("numbering: depth deve essere tra 0 e 6")
+	}
+	for _, format := range append([]string{n.Appendix}, n.Formats...) {
+		switch format {
+		case "", NumberDecimal, NumberUpperAlpha, NumberLowerAlpha, NumberUpperRoman, NumberLowerRoman:
+		default:
+			return fmt.Errorf("numbering: formato sconosciuto %q", format)
+		}
+	}
+	return nil
+}
+
+// format restituisce il formato del livello level (da 0), nelle appendici o no
+func (n Numbering) format(level int, appendix bool) string {
+	if appendix && level == 0 {
+		if n.Appendix != "" {
+			return n.Appendix
+		}
+		return NumberUpperAlpha
+	}
+	if len(n.Formats) == 0 {
+		return NumberDecimal
+	}
+	return n.Formats[min(level, len(n.Formats)-1)]
+}
+
+// HeadingNumbers restituisce i numeri dei titoli del documento secondo la
+// numerazione indicata: gli stessi mostrati nel PDF, utilizzabili per indici e
+// segnalibri. I titoli non numerati non sono nella mappa.
+func HeadingNumbers(doc *Document, numbering Numbering) map[*Heading]string {
+	if !numbering.Enabled {
+		return nil
+	}
+	depth := numbering.Depth
+	if depth <= 0 {
+		depth = 6
+	}
+
+	numbers := make(map[*Heading]string)
+	var counters [6]int
+	appendix := false
+	_ = Walk(doc, func(n Node, entering bool) (WalkStatus, error) {
+		if !entering {
+			return WalkContinue, nil
+		}
+		if block, ok := n.(Block); ok && blockDirective(block) == "appendix" {
+			appendix = true
+			counters = [6]int{}
+		}
+		h, ok := n.(*Heading)
+		if !ok {
+			return WalkContinue, nil
+		}
+		level := min(max(h.Level, 1), 6)
+		if h.Unnumbered || level > depth {
+			return WalkSkipChildren, nil
+		}
+		counters[level-1]++
+		for i := level; i < len(counters); i++ {
+			counters[i] = 0
+		}
+		parts := make([]string, level)
+		for i := range parts {
+			parts[i] = formatNumber(counters[i], numbering.format(i, appendix))
+		}
+		numbers[h] = strings.Join(parts, ".")
+		return WalkSkipChildren, nil
+	})
+	return numbers
+}
+
+// formatNumber scrive un numero positivo nel formato indicato
+func formatNumber(n int, format string) string {
+	if n <= 0 {
+		return strconv.Itoa(n)
+	}
+	switch format {
+	case NumberUpperAlpha, NumberLowerAlpha:
+		// A..Z, then AA, AB, ... like spreadsheet columns
+		var letters []byte
+		for ; n > 0; n = (n - 1) / 26 {
+			letters = append([]byte{byte('A' + (n-1)%26)}, letters...)
+		}
+		if format == NumberLowerAlpha {
+			return strings.ToLower(string(letters))
+		}
+		return string(letters)
+	case NumberUpperRoman, NumberLowerRoman:
+		roman := toRoman(n)
+		if format == NumberLowerRoman {
+			return strings.ToLower(roman)
+		}
+		return roman
+	}
+	return strconv.Itoa(n)
+}
+
+// toRoman scrive un numero positivo in cifre romane
+func toRoman(n int) string {
+	values := []int{1000, 900, 500, 400, 100, 90, 50, 40, 10, 9, 5, 4, 1}
+	symbols := []string{"M", "CM", "D", "CD", "C", "XC", "L", "XL", "X", "IX", "V", "IV", "I"}
+	var sb strings.Builder
+	for i, v := range values {
+		for ; n >= v; n -= v {
+			sb.WriteString(symbols[i])
+		}
+	}
+	return sb.String()
+}
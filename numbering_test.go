@@ -0,0 +1,242 @@
+package mark2pdf
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHeadingNumbers(t *testing.T) {
+	tests := []struct {
+		name      string
+		markdown  string
+		numbering Numbering
+		expected  []string // numbers of the headings in document order
+	}{
+		{
+			"hierarchical",
+			"# A\n## B\n### C\n### D\n## E\n# F\n### G\n",
+			Numbering{Enabled: true},
+			[]string{"1", "1.1", "1.1.1", "1.1.2", "1.2", "2", "2.0.1"},
+		},
+		{
+			"depth",
+			"# A\n## B\n### C\n## D\n",
+			Numbering{Enabled: true, Depth: 2},
+			[]string{"1", "1.1", "", "1.2"},
+		},
+		{
+			"unnumbered",
+			"# Preface {-}\n# A\n## B {.unnumbered}\n## C\n",
+			Numbering{Enabled: true},
+			[]string{"", "1", "", "1.1"},
+		},
+		{
+			"formats",
+			"# A\n## B\n## C\n### D\n",
+			Numbering{Enabled: true, Formats: []string{NumberUpperRoman, NumberLowerAlpha}},
+			[]string{"I", "I.a", "I.b", "I.b.a"},
+		},
+		{
+			"appendix",
+			"# A\n# B\n\n<!-- appendix -->\n\n# C\n## D\n# E\n",
+			Numbering{Enabled: true},
+			[]string{"1", "2", "A", "A.1", "B"},
+		},
+		{
+			"nested in quote",
+			"# A\n\n> ## B\n",
+			Numbering{Enabled: true},
+			[]string{"1", "1.1"},
+		},
+		{
+			"disabled",
+			"# A\n",
+			Numbering{},
+			[]string{""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := NewMarkdownParser(tt.markdown).Parse()
+			numbers := HeadingNumbers(doc, tt.numbering)
+			var got []string
+			_ = Walk(doc, func(n Node, entering bool) (WalkStatus, error) {
+				if h, ok := n.(*Heading); ok && entering {
+					got = append(got, numbers[h])
+				}
+				return WalkContinue, nil
+			})
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.expected) {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestFormatNumber(t *testing.T) {
+	tests := []struct {
+		n        int
+		format   string
+		expected string
+	}{
+		{12, NumberDecimal, "12"},
+		{1, NumberUpperAlpha, "A"},
+		{26, NumberUpperAlpha, "Z"},
+		{28, NumberLowerAlpha, "ab"},
+		{1994, NumberUpperRoman, "MCMXCIV"},
+		{4, NumberLowerRoman, "iv"},
+		{0, NumberUpperAlpha, "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d %s", tt.n, tt.format), func(t *testing.T) {
+			if got := formatNumber(tt.n, tt.format); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestHeadingClass(t *testing.T) {
+	doc := NewMarkdownParser("# Preface {-}\n\nIntro\n{.unnumbered}\n---\n\n# Set {x}\n").Parse()
+	var headings []*Heading
+	for _, block := range doc.Blocks {
+		if h, ok := block.(*Heading); ok {
+			headings = append(headings, h)
+		}
+	}
+	if len(headings) != 3 {
+		t.Fatalf("Expected 3 headings, got %d", len(headings))
+	}
+	expected := []struct {
+		text       string
+		unnumbered bool
+	}{{"Preface", true}, {"Intro", true}, {"Set {x}", false}}
+	for i, h := range headings {
+		text := TextContent(h)
+		if strings.TrimSpace(text) != strings.TrimSpace(expected[i].text) || h.Unnumbered != expected[i].unnumbered {
+			t.Errorf("Expected %q (unnumbered %v), got %q (unnumbered %v)", expected[i].text, expected[i].unnumbered, text, h.Unnumbered)
+		}
+	}
+}
+
+func TestConvertNumberedHeadings(t *testing.T) {
+	theme, err := ParseTheme([]byte(`{"numbering": {"enabled": true}}`))
+	if err != nil {
+		t.Fatalf("ParseTheme failed: %v", err)
+	}
+	converter := NewConverter("# Intro\n\n## Scope\n\ntext\n")
+	converter.SetTheme(theme)
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	content := pdfContent(t, data)
+	for _, expected := range []string{"(1 Intro) Tj", "(1.1 Scope) Tj"} {
+		if !strings.Contains(content, expected) {
+			t.Errorf("Expected %q in the page content", expected)
+		}
+	}
+
+	if _, err := ParseTheme([]byte(`{"numbering": {"formats": ["greek"]}}`)); err == nil {
+		t.Error("Expected an error for an unknown number format")
+	}
+}
+
+func TestHeadingBookmarks(t *testing.T) {
+	theme, err := ParseTheme([]byte(`{"numbering": {"enabled": true}}`))
+	if err != nil {
+		t.Fatalf("ParseTheme failed: %v", err)
+	}
+	markdown := "# Preface {-}\n\n# Intro\n\n## *Scope*\n\n" + fillerLines(80) + "\n### Terms\n\n## Goals\n\n<!-- appendix -->\n\n# Tables\n"
+	bookmarks := func(enabled bool) []*outlineItem {
+		t.Helper()
+		converter := NewConverter(markdown)
+		converter.SetTheme(theme)
+		converter.SetBookmarks(enabled)
+		data, err := converter.Convert()
+		if err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		r, err := readPDF(data)
+		if err != nil {
+			t.Fatalf("readPDF failed: %v", err)
+		}
+		if _, err := r.pages(); err != nil {
+			t.Fatalf("pages failed: %v", err)
+		}
+		return r.outline(func(i int) int { return i })
+	}
+
+	// The entries carry the numbers of the headings and follow their levels
+	want := []*outlineItem{
+		{title: "Preface", page: 0},
+		{title: "1 Intro", page: 0, kids: []*outlineItem{
+			{title: "1.1 Scope", page: 0, kids: []*outlineItem{{title: "1.1.1 Terms", page: 2}}},
+			{title: "1.2 Goals", page: 2},
+		}},
+		{title: "A Tables", page: 2},
+	}
+	if got := bookmarks(true); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %s, got %s", outlineString(want), outlineString(got))
+	}
+	if got := bookmarks(false); got != nil {
+		t.Errorf("Expected no bookmarks, got %s", outlineString(got))
+	}
+}
+
+func TestHeadingBookmarkAfterPageBreak(t *testing.T) {
+	// Without keep-with-next only the orphan control moves a wrapped heading
+	theme, err := ParseTheme([]byte(`{"keep_with_next": 0}`))
+	if err != nil {
+		t.Fatalf("ParseTheme failed: %v", err)
+	}
+	heading := strings.TrimSpace(strings.Repeat("long heading ", 12))
+	for n := 20; n < 80; n++ {
+		converter := NewConverter(fillerLines(n) + "# " + heading + "\n")
+		converter.SetTheme(theme)
+		converter.SetBookmarks(true)
+		data, err := converter.Convert()
+		if err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		r, err := readPDF(data)
+		if err != nil {
+			t.Fatalf("readPDF failed: %v", err)
+		}
+		if _, err := r.pages(); err != nil {
+			t.Fatalf("pages failed: %v", err)
+		}
+		outline := r.outline(func(i int) int { return i })
+		if len(outline) != 1 {
+			t.Fatalf("%d fillers: expected one bookmark, got %s", n, outlineString(outline))
+		}
+		headingPage := -1
+		for i, page := range pageLines(t, data) {
+			for _, line := range page {
+				if strings.HasPrefix(line, "long heading") && headingPage < 0 {
+					headingPage = i
+				}
+			}
+		}
+		if outline[0].page != headingPage {
+			t.Errorf("%d fillers: expected the bookmark on page %d, got %d", n, headingPage, outline[0].page)
+		}
+	}
+}
+
+// outlineString descrive i segnalibri in una riga, per i messaggi dei test
+func outlineString(items []*outlineItem) string {
+	var parts []string
+	for _, item := range items {
+		s := fmt.Sprintf("%q@%d", item.title, item.page)
+		if len(item.kids) > 0 {
+			s += " [" + outlineString(item.kids) + "]"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,204 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// objectStreamSize è il numero massimo di oggetti in un object stream
+const objectStreamSize = 100
+
+// objectWriter scrive gli oggetti numerati del PDF e ne registra la posizione per
+// la tabella xref. In modalità compatta gli oggetti senza stream sono raccolti in
+// object stream compressi e la tabella xref diventa un cross-reference stream.
+type objectWriter struct {
+	w        io.Writer
+	pos      int // byte scritti finora
+	err      error
+	security *securityHandler
+	compact  bool
+	level    int            // livello di compressione zlib
+	offsets  map[int]int    // posizione degli oggetti scritti direttamente
+	packed   map[int][2]int // oggetto compresso -> numero dell'object stream e indice
+	pending  []packedObject // oggetti in attesa del prossimo object stream
+
+	// In modalità linearizzata gli oggetti sono trattenuti, non cifrati, e scritti
+	// da finish nell'ordine e con la numerazione richiesti dalla linearizzazione
+	linearized bool
+	recorded   map[int]*rawObject
+}
+
+// packedObject è un oggetto senza stream destinato a un object stream
+type packedObject struct {
+	num  int
+	body string
+}
+
+func newObjectWriter(w io.Writer, security *securityHandler, compact bool) *objectWriter {
+	return &objectWriter{
+		w:        w,
+		security: security,
+		compact:  compact,
+		offsets:  make(map[int]int),
+		packed:   make(map[int][2]int),
+	}
+}
+
+// objectBytes restituisce un oggetto senza stream serializzato
+func objectBytes(num int, body string) []byte {
+	return []byte(fmt.Sprintf("%d 0 obj\n%s\nendobj\n", num, body))
+}
+
+// streamBytes restituisce un oggetto stream serializzato; dict contiene le voci dopo /Length
+func streamBytes(num int, dict string, data []byte) []byte {
+	out := []byte(fmt.Sprintf("%d 0 obj\n<< /Length %d%s >>\nstream\n", num, len(data), dict))
+	out = append(out, data...)
+	return append(out, "\nendstream\nendobj\n"...)
+}
+
+// write scrive dati grezzi; il primo errore interrompe le scritture successive
+func (o *objectWriter) write(data []byte) {
+	if o.err != nil {
+		return
+	}
+	n, err := o.w.Write(data)
+	o.pos += n
+	o.err = err
+}
+
+func (o *objectWriter) writeString(s string) {
+	o.write([]byte(s))
+}
+
+// object scrive un oggetto senza stream, o lo accoda per un object stream in modalità compatta
+func (o *objectWriter) object(num int, body string) {
+	if o.linearized {
+		o.recorded[num] = &rawObject{body: body}
+		return
+	}
+	if o.compact {
+		o.pending = append(o.pending, packedObject{num, body})
+		return
+	}
+	o.direct(num, body)
+}
+
+// direct scrive un oggetto senza stream fuori dagli object stream (ad esempio /Encrypt)
+func (o *objectWriter) direct(num int, body string) {
+	if o.linearized {
+		o.recorded[num] = &rawObject{body: body, plain: true}
+		return
+	}
+	o.offsets[num] = o.pos
+	o.write(objectBytes(num, body))
+}
+
+// stream scrive un oggetto stream, cifrato se il documento lo è; dict contiene le
+// voci dopo /Length
+func (o *objectWriter) stream(num int, dict string, data []byte) {
+	if o.linearized {
+		o.recorded[num] = &rawObject{body: dict, data: data, stream: true}
+		return
+	}
+	if o.security != nil {
+		if o.compact {
+			// The strings were left for an object stream, but streams are never packed
+			dict = encryptStrings(dict, func(b []byte) []byte { return o.security.encrypt(num, b) })
+		}
+		data = o.security.encrypt(num, data)
+	}
+	o.offsets[num] = o.pos
+	o.write(streamBytes(num, dict, data))
+}
+
+// str codifica una stringa di testo dell'oggetto num, cifrata se il documento lo è.
+// In modalità compatta resta in chiaro finché non si sa dove va l'oggetto: negli
+// object stream è protetta dalla cifratura dello stream, nei dizionari degli stream
+// la cifra stream. In modalità linearizzata è cifrata con il numero definitivo.
+func (o *objectWriter) str(num int, s string) string {
+	return o.byteString(num, textString(s))
+}
+
+// byteString codifica una stringa di byte dell'oggetto num, cifrata come in str
+func (o *objectWriter) byteString(num int, b []byte) string {
+	if o.security != nil && !o.compact && !o.linearized {
+		b = o.security.encrypt(num, b)
+	}
+	return fmt.Sprintf("<%x>", b)
+}
+
+// flushObjects scrive gli oggetti accodati in object stream numerati da next;
+// restituisce il primo numero libero
+func (o *objectWriter) flushObjects(next int) int {
+	for len(o.pending) > 0 {
+		batch := o.pending[:min(len(o.pending), objectStreamSize)]
+		o.pending = o.pending[len(batch):]
+		num := next
+		next++
+
+		var header strings.Builder
+		var body bytes.Buffer
+		for i, obj := range batch {
+			fmt.Fprintf(&header, "%d %d ", obj.num, body.Len())
+			body.WriteString(obj.body + "\n")
+			o.packed[obj.num] = [2]int{num, i}
+		}
+		data := append([]byte(header.String()), body.Bytes()...)
+		o.flateStream(num, fmt.Sprintf(" /Type /ObjStm /N %d /First %d", len(batch), header.Len()), data)
+	}
+	return next
+}
+
+// finish scrive la tabella xref e il trailer; next è il primo numero di oggetto
+// libero e trailer contiene le voci del trailer (/Root, /Info, /ID, ...)
+func (o *objectWriter) finish(next int, trailer string) error {
+	if o.linearized {
+		return o.linearize(trailer)
+	}
+	if !o.compact {
+		xrefPos := o.pos
+		var xref strings.Builder
+		xref.WriteString("xref\n")
+		xref.WriteString(fmt.Sprintf("0 %d\n", next))
+		xref.WriteString("0000000000 65535 f \n")
+		for i := 1; i < next; i++ {
+			xref.WriteString(fmt.Sprintf("%010d 00000 n \n", o.offsets[i]))
+		}
+		xref.WriteString("trailer\n")
+		xref.WriteString(fmt.Sprintf("<< /Size %d %s >>\n", next, trailer))
+		xref.WriteString(fmt.Sprintf("startxref\n%d\n%%%%EOF\n", xrefPos))
+		o.writeString(xref.String())
+		return o.err
+	}
+
+	next = o.flushObjects(next)
+	// The cross-reference stream is the last object and lists itself
+	xrefNum := next
+	size := next + 1
+	o.offsets[xrefNum] = o.pos
+	entries := make([]byte, 0, 7*size)
+	for i := 0; i < size; i++ {
+		entry := []byte{0, 0, 0, 0, 0, 0, 0}
+		if offset, ok := o.offsets[i]; ok {
+			entry[0] = 1
+			binary.BigEndian.PutUint32(entry[1:], uint32(offset))
+		} else if packed, ok := o.packed[i]; ok {
+			entry[0] = 2
+			binary.BigEndian.PutUint32(entry[1:], uint32(packed[0]))
+			binary.BigEndian.PutUint16(entry[5:], uint16(packed[1]))
+		} else {
+			binary.BigEndian.PutUint16(entry[5:], 0xFFFF) // free
+		}
+		entries = append(entries, entry...)
+	}
+	// Cross-reference streams are never encrypted
+	data := o.deflate(entries)
+	o.writeString(fmt.Sprintf("%d 0 obj\n<< /Type /XRef /Size %d /W [1 4 2] %s /Filter /FlateDecode /Length %d >>\nstream\n",
+		xrefNum, size, trailer, len(data)))
+	o.write(data)
+	o.writeString(fmt.Sprintf("\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n", o.offsets[xrefNum]))
+	return o.err
+}
@@ -0,0 +1,230 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/rc4"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// inflate decomprime uno stream FlateDecode
+func inflate(t *testing.T, data []byte) []byte {
+	t.Helper()
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Invalid stream: %v", err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Invalid stream: %v", err)
+	}
+	return out
+}
+
+// streamAt restituisce il dizionario e i dati dello stream dell'oggetto all'offset indicato
+func streamAt(t *testing.T, data []byte, offset int) (string, []byte) {
+	t.Helper()
+	m := regexp.MustCompile(`(?s)^\d+ 0 obj\n(<<.*?/Length (\d+).*?>>)\nstream\n`).FindSubmatchIndex(data[offset:])
+	if m == nil {
+		t.Fatalf("No stream at offset %d", offset)
+	}
+	length, _ := strconv.Atoi(string(data[offset+m[4] : offset+m[5]]))
+	start := offset + m[1]
+	return string(data[offset+m[2] : offset+m[3]]), data[start : start+length]
+}
+
+// xrefEntries legge le voci del cross-reference stream: tipo, secondo e terzo campo
+func xrefEntries(t *testing.T, data []byte) [][3]int {
+	t.Helper()
+	m := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(data)
+	if m == nil {
+		t.Fatal("Missing startxref")
+	}
+	xrefPos, _ := strconv.Atoi(string(m[1]))
+	dict, stream := streamAt(t, data, xrefPos)
+	if !strings.Contains(dict, "/Type /XRef") || !strings.Contains(dict, "/W [1 4 2]") {
+		t.Fatalf("Expected a cross-reference stream, got %s", dict)
+	}
+	raw := inflate(t, stream)
+	var entries [][3]int
+	for i := 0; 7*i+7 <= len(raw); i++ {
+		entry := raw[7*i : 7*i+7]
+		entries = append(entries, [3]int{int(entry[0]), int(binary.BigEndian.Uint32(entry[1:])), int(binary.BigEndian.Uint16(entry[5:]))})
+	}
+	return entries
+}
+
+// compactObjects risolve tutti gli oggetti di un PDF non cifrato con cross-reference stream
+func compactObjects(t *testing.T, data []byte) map[int]string {
+	t.Helper()
+	objects := make(map[int]string)
+	packed := make(map[int][2]int)
+	for i, entry := range xrefEntries(t, data) {
+		field := entry[1]
+		switch entry[0] {
+		case 1:
+			prefix := strconv.Itoa(i) + " 0 obj\n"
+			if !bytes.HasPrefix(data[field:], []byte(prefix)) {
+				t.Fatalf("Object %d is not at offset %d", i, field)
+			}
+			end := bytes.Index(data[field:], []byte("endobj"))
+			objects[i] = string(data[field+len(prefix) : field+end])
+		case 2:
+			packed[i] = [2]int{field, entry[2]}
+		}
+	}
+	for num, at := range packed {
+		dict, stream := streamAt(t, data, bytesIndexObject(t, data, objects, at[0]))
+		if !strings.Contains(dict, "/Type /ObjStm") {
+			t.Fatalf("Object %d is not an object stream: %s", at[0], dict)
+		}
+		first, _ := strconv.Atoi(regexp.MustCompile(`/First (\d+)`).FindStringSubmatch(dict)[1])
+		content := inflate(t, stream)
+		header := strings.Fields(string(content[:first]))
+		if n, _ := strconv.Atoi(header[2*at[1]]); n != num {
+			t.Fatalf("Expected object %d at index %d, got %d", num, at[1], n)
+		}
+		start, _ := strconv.Atoi(header[2*at[1]+1])
+		end := len(content) - first
+		if 2*at[1]+3 < len(header) {
+			end, _ = strconv.Atoi(header[2*at[1]+3])
+		}
+		objects[num] = string(content[first+start : first+end])
+	}
+	return objects
+}
+
+// bytesIndexObject restituisce l'offset di un oggetto scritto direttamente
+func bytesIndexObject(t *testing.T, data []byte, objects map[int]string, num int) int {
+	t.Helper()
+	i := bytes.Index(data, []byte("\n"+strconv.Itoa(num)+" 0 obj\n"))
+	if _, ok := objects[num]; !ok || i < 0 {
+		t.Fatalf("Object stream %d not found", num)
+	}
+	return i + 1
+}
+
+func TestCompactOutput(t *testing.T) {
+	const markdown = "# Titolo\n\nTesto con **grassetto**.\n\n- uno\n- due\n"
+
+	plain := NewConverter(markdown)
+	plain.SetTagged(true)
+	expected, err := plain.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+
+	converter := NewConverter(markdown)
+	converter.SetTagged(true)
+	converter.SetCompact(true)
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-1.5\n")) {
+		t.Errorf("Expected a PDF 1.5 header, got %q", data[:9])
+	}
+	if bytes.Contains(data, []byte("\nxref\n")) || bytes.Contains(data, []byte("/Type /Catalog")) {
+		t.Error("Expected no xref table and no uncompressed catalog")
+	}
+	if len(data) >= len(expected) {
+		t.Errorf("Expected the compact output to be smaller than %d bytes, got %d", len(expected), len(data))
+	}
+
+	objects := compactObjects(t, data)
+	if !strings.Contains(objects[1], "/Type /Catalog") {
+		t.Errorf("Expected the catalog as object 1, got %q", objects[1])
+	}
+	roles := 0
+	for _, obj := range objects {
+		if strings.Contains(obj, "/Type /StructElem") {
+			roles++
+		}
+	}
+	if roles == 0 {
+		t.Error("Expected the structure elements in the object streams")
+	}
+	if got := pageLines(t, data); len(got) == 0 || len(got[0]) == 0 || got[0][0] != "Titolo" {
+		t.Errorf("Expected the page text, got %q", got)
+	}
+}
+
+func TestCompactEncrypted(t *testing.T) {
+	converter := NewConverter("# Titolo\n\nTesto\n")
+	converter.SetCompact(true)
+	converter.SetEncryption(&Encryption{UserPassword: "secret", Algorithm: EncryptAES128})
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	encrypt := regexp.MustCompile(`/Encrypt (\d+) 0 R`).FindSubmatch(data)
+	if encrypt == nil {
+		t.Fatal("Missing /Encrypt in the trailer")
+	}
+	num, _ := strconv.Atoi(string(encrypt[1]))
+	entry := xrefEntries(t, data)[num]
+	if entry[0] != 1 || !bytes.HasPrefix(data[entry[1]:], []byte(string(encrypt[1])+" 0 obj\n<< /Filter /Standard")) {
+		t.Errorf("Expected the encryption dictionary outside the object streams, got entry %v", entry)
+	}
+	if bytes.Contains(data, []byte("/Type /Catalog")) {
+		t.Error("Expected the catalog in an encrypted object stream")
+	}
+}
+
+func TestCompactEncryptedStreamStrings(t *testing.T) {
+	// A letterhead whose Form XObject has a string in the stream dictionary
+	letterhead := []byte(`%PDF-1.4
+1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj
+2 0 obj << /Type /Pages /Kids [3 0 R] /Count 1 /MediaBox [0 0 200 100] >> endobj
+3 0 obj << /Type /Page /Parent 2 0 R /Contents 4 0 R /Resources << /XObject << /Logo 5 0 R >> >> >> endobj
+4 0 obj << /Length 8 >> stream
+/Logo Do
+endstream endobj
+5 0 obj << /Type /XObject /Subtype /Form /BBox [0 0 10 10] /Note (Riservato) /Length 14 >> stream
+0 0 10 10 re f
+endstream endobj
+trailer << /Root 1 0 R >>
+%%EOF
+`)
+	converter := NewConverter("# Titolo\n\nTesto\n")
+	converter.SetCompact(true)
+	converter.SetEncryption(&Encryption{UserPassword: "secret", Algorithm: EncryptRC4})
+	if err := converter.AddBackground(&Background{PDF: letterhead}); err != nil {
+		t.Fatalf("AddBackground failed: %v", err)
+	}
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	m := regexp.MustCompile(`(\d+) 0 obj\n<< /Length \d+ /BBox \[[^\]]*\] /Note <([0-9a-f]+)>`).FindSubmatch(data)
+	if m == nil {
+		t.Fatal("Expected the copied stream dictionary outside the object streams")
+	}
+	if bytes.Contains(data, []byte(fmt.Sprintf("<%x>", "Riservato"))) {
+		t.Fatal("Expected the string of the stream dictionary to be encrypted")
+	}
+
+	// The string is encrypted with the key of the stream object
+	num, _ := strconv.Atoi(string(m[1]))
+	note, _ := hex.DecodeString(string(m[2]))
+	o, u := encryptEntry(t, data, "O"), encryptEntry(t, data, "U")
+	p, _ := strconv.Atoi(string(regexp.MustCompile(`/P (-?\d+)`).FindSubmatch(data)[1]))
+	id, _ := hex.DecodeString(regexp.MustCompile(`/ID \[<([0-9a-f]+)>`).FindStringSubmatch(string(data))[1])
+	key := documentKey([]byte("secret"), o, int32(p), id)
+	if !bytes.Equal(userEntry(key, id)[:16], u[:16]) {
+		t.Fatal("User password does not validate")
+	}
+	s := &securityHandler{revision: 3, key: key}
+	c, _ := rc4.NewCipher(s.objectKey(num))
+	c.XORKeyStream(note, note)
+	if string(note) != "Riservato" {
+		t.Errorf("Expected the decrypted string, got %q", note)
+	}
+}
@@ -0,0 +1,86 @@
+package mark2pdf
+
+import (
+	"fmt"
+)
+
+// outlineItem è una voce dei segnalibri del documento (/Outlines)
+type outlineItem struct {
+	title string
+	page  int     // pagina di destinazione, da 0; -1 = nessuna
+	top   float64 // ordinata della destinazione; 0 = la pagina intera
+	level int     // livello del titolo, da 1; 0 = voce senza livello
+	kids  []*outlineItem
+}
+
+// AddBookmark aggiunge ai segnalibri una voce per la posizione corrente della
+// pagina, ad esempio per un titolo; level (da 1) annida la voce sotto l'ultima di
+// livello inferiore
+func (p *PDFWriter) AddBookmark(title string, level int) {
+	// The page where the next line is written
+	p.ensureLine()
+	p.addBookmarkAt(title, level, p.currentPage, p.yPosition)
+}
+
+// addBookmarkAt aggiunge ai segnalibri una voce per l'ordinata top della pagina
+// page, annidata come in AddBookmark
+func (p *PDFWriter) addBookmarkAt(title string, level, page int, top float64) {
+	item := &outlineItem{title: title, page: page, top: top, level: max(level, 1)}
+	// The open entries of lower level are the parents of the new one
+	for len(p.outlineOpen) > 0 && p.outlineOpen[len(p.outlineOpen)-1].level >= item.level {
+		p.outlineOpen = p.outlineOpen[:len(p.outlineOpen)-1]
+	}
+	p.addOutline(item)
+	p.outlineOpen = append(p.outlineOpen, item)
+}
+
+// addOutline aggiunge una voce sotto l'ultimo segnalibro aperto con AddBookmark,
+// o al primo livello
+func (p *PDFWriter) addOutline(item *outlineItem) {
+	if n := len(p.outlineOpen); n > 0 {
+		parent := p.outlineOpen[n-1]
+		parent.kids = append(parent.kids, item)
+		return
+	}
+	p.outline = append(p.outline, item)
+}
+
+// closeBookmarks chiude i segnalibri aperti: le voci seguenti sono al primo livello
+func (p *PDFWriter) closeBookmarks() {
+	p.outlineOpen = nil
+}
+
+// outlineObjects assegna i numeri di oggetto alle voci dei segnalibri, in ordine di visita
+func outlineObjects(items []*outlineItem, nums map[*outlineItem]int, alloc func() int) {
+	for _, item := range items {
+		nums[item] = alloc()
+		outlineObjects(item.kids, nums, alloc)
+	}
+}
+
+// writeOutline scrive le voci dei segnalibri figlie dell'oggetto parent; le
+// voci con figli sono chiuse
+func (p *PDFWriter) writeOutline(items []*outlineItem, parent int, nums map[*outlineItem]int, pageObj func(i int) int) {
+	for i, item := range items {
+		num := nums[item]
+		dict := fmt.Sprintf("<< /Title %s /Parent %d 0 R ", p.output.str(num, item.title), parent)
+		if i > 0 {
+			dict += fmt.Sprintf("/Prev %d 0 R ", nums[items[i-1]])
+		}
+		if i+1 < len(items) {
+			dict += fmt.Sprintf("/Next %d 0 R ", nums[items[i+1]])
+		}
+		if len(item.kids) > 0 {
+			dict += fmt.Sprintf("/First %d 0 R /Last %d 0 R /Count %d ", nums[item.kids[0]], nums[item.kids[len(item.kids)-1]], -len(item.kids))
+		}
+		switch {
+		case item.page >= 0 && item.top > 0:
+			// The position of the entry, at the current zoom
+			dict += fmt.Sprintf("/Dest [%d 0 R /XYZ null %.2f null] ", pageObj(item.page), item.top)
+		case item.page >= 0:
+			dict += fmt.Sprintf("/Dest [%d 0 R /Fit] ", pageObj(item.page))
+		}
+		p.output.object(num, dict+">>")
+		p.writeOutline(item.kids, num, nums, pageObj)
+	}
+}
@@ -0,0 +1,206 @@
+package mark2pdf
+
+import (
+	"regexp"
+	"strings"
+)
+
+// pageBreakCSS riconosce gli stili CSS che chiedono un'interruzione di pagina
+// prima o dopo l'elemento
+var pageBreakCSS = regexp.MustCompile(`(?i)(?:page-)?break-(before|after)\s*:\s*(?:always|page)`)
+
+// htmlTagPattern riconosce i tag HTML di apertura e di chiusura
+var htmlTagPattern = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9-]*)\b[^>]*?(/?)>`)
+
+// voidElements sono gli elementi HTML senza tag di chiusura
+var voidElements = map[string]bool{"br": true, "hr": true, "img": true, "input": true, "wbr": true}
+
+// pendingBreak è un'interruzione di pagina chiesta con page-break-after da un
+// elemento HTML che si chiude in un blocco successivo
+type pendingBreak struct {
+	tag   string
+	depth int // elementi con lo stesso nome ancora aperti
+}
+
+// blockDirective restituisce il nome di una direttiva scritta come paragrafo
+// ("\newpage") o come commento HTML ("<!-- pagebreak -->"), altrimenti ""
+func blockDirective(block Block) string {
+	switch b := block.(type) {
+	case *Paragraph:
+		if len(b.Inlines) != 1 {
+			return ""
+		}
+		if name, ok := strings.CutPrefix(strings.TrimSpace(TextContent(b)), `\`); ok && !strings.ContainsAny(name, " \t\\") {
+			return name
+		}
+	case *HTMLBlock:
+		html := strings.TrimSpace(b.Literal)
+		if comment, ok := strings.CutPrefix(html, "<!--"); ok {
+			comment, _ = strings.CutSuffix(comment, "-->")
+			return strings.ToLower(strings.TrimSpace(comment))
+		}
+	}
+	return ""
+}
+
+// pdfEmbed riconosce la direttiva ![[file.pdf]], con le pagine opzionali dopo "|"
+var pdfEmbed = regexp.MustCompile(`(?i)^!\[\[([^\[\]|]+\.pdf)(?:\|([^\[\]|]*))?\]\]$`)
+
+// pdfDirective restituisce il file e le pagine di una direttiva ![[file.pdf]] o
+// ![[file.pdf|2-5]] scritta come paragrafo
+func pdfDirective(block Block) (path, pages string, ok bool) {
+	b, isParagraph := block.(*Paragraph)
+	if !isParagraph || len(b.Inlines) != 1 {
+		return "", "", false
+	}
+	m := pdfEmbed.FindStringSubmatch(strings.TrimSpace(TextContent(b)))
+	if m == nil {
+		return "", "", false
+	}
+	return strings.TrimSpace(m[1]), strings.TrimSpace(m[2]), true
+}
+
+// isPageBreak indica se un blocco è una direttiva di interruzione di pagina:
+// "\newpage", "\pagebreak" o "<!-- pagebreak -->"
+func isPageBreak(block Block) bool {
+	switch blockDirective(block) {
+	case "newpage", "pagebreak", "page-break":
+		return true
+	}
+	return false
+}
+
+// htmlPageBreak applica le interruzioni di pagina chieste dallo stile di un blocco
+// HTML e restituisce vero se il blocco le contiene. Con page-break-after la pagina
+// si interrompe alla chiusura dell'elemento, che può trovarsi in un blocco
+// successivo, dopo il Markdown racchiuso.
+func (c *Converter) htmlPageBreak(block Block) bool {
+	html, ok := block.(*HTMLBlock)
+	if !ok || strings.HasPrefix(strings.TrimSpace(html.Literal), "<!--") {
+		return false
+	}
+	// Elements opened by earlier blocks may close here
+	for _, pending := range c.breaks {
+		pending.depth += tagBalance(html.Literal, pending.tag)
+	}
+	for n := len(c.breaks); n > 0 && c.breaks[n-1].depth <= 0; n-- {
+		c.breaks = c.breaks[:n-1]
+		c.pdf.pageBreak(false)
+	}
+
+	var before, after bool
+	for _, m := range pageBreakCSS.FindAllStringSubmatch(html.Literal, -1) {
+		if strings.EqualFold(m[1], "before") {
+			before = true
+		} else {
+			after = true
+		}
+	}
+	if before {
+		c.pdf.pageBreak(false)
+	}
+	if after {
+		tag := ""
+		if m := htmlTagPattern.FindStringSubmatch(html.Literal); m != nil && m[1] == "" {
+			tag = strings.ToLower(m[2])
+		}
+		if depth := tagBalance(html.Literal, tag); depth > 0 && !voidElements[tag] {
+			c.breaks = append(c.breaks, &pendingBreak{tag: tag, depth: depth})
+		} else {
+			// The element ends in this block, which draws nothing
+			c.pdf.pageBreak(false)
+		}
+	}
+	return before || after
+}
+
+// tagBalance restituisce i tag di apertura meno quelli di chiusura dell'elemento
+// tag in un frammento HTML
+func tagBalance(html, tag string) int {
+	balance := 0
+	for _, m := range htmlTagPattern.FindAllStringSubmatch(html, -1) {
+		if !strings.EqualFold(m[2], tag) || m[3] == "/" {
+			continue
+		}
+		if m[1] == "/" {
+			balance--
+		} else {
+			balance++
+		}
+	}
+	return balance
+}
+
+// widowOrphanBreak restituisce la riga prima della quale aprire una nuova pagina
+// perché in fondo alla pagina non restino meno di Theme.Orphans righe e in cima
+// alla successiva meno di Theme.Widows; -1 se la divisione naturale va bene
+func (c *Converter) widowOrphanBreak(lines []textLine, fontSize float64) int {
+	sizes := make([]float64, len(lines))
+	for i, line := range lines {
+		sizes[i] = lineSize(line.parts, fontSize)
+	}
+	n := len(lines)
+	fit := c.pdf.linesOnPage(sizes, fontSize)
+	if fit == 0 || fit == n || c.pdf.atPageTop() {
+		return -1
+	}
+	k := fit
+	if n-k < c.theme.Widows {
+		k = n - c.theme.Widows
+	}
+	if k < c.theme.Orphans {
+		k = 0
+	}
+	if k == fit {
+		return -1
+	}
+	return max(k, 0)
+}
+
+// keepHeadingWithNext apre una nuova pagina se il titolo e le prime
+// Theme.KeepWithNext righe del blocco successivo non entrano nella pagina corrente
+func (c *Converter) keepHeadingWithNext(heading *Heading) {
+	if c.theme.KeepWithNext == 0 {
+		return
+	}
+	c.pdf.keepTogether(c.headingHeight(heading) + c.leadHeight(c.next, c.theme.KeepWithNext))
+}
+
+// headingHeight restituisce l'altezza di un titolo con lo spazio prima e dopo
+func (c *Converter) headingHeight(heading *Heading) float64 {
+	style := c.theme.resolve(c.theme.heading(heading.Level))
+	lines := c.lineCount(heading.Inlines, style)
+	return style.SpaceBefore + float64(lines)*style.lineAdvance() + style.SpaceAfter
+}
+
+// leadHeight stima l'altezza delle prime n righe di un blocco
+func (c *Converter) leadHeight(block Block, n int) float64 {
+	theme := c.theme
+	switch b := block.(type) {
+	case nil:
+		return 0
+	case *Paragraph:
+		style := theme.resolve(theme.Body)
+		lines := min(n, c.lineCount(b.Inlines, style))
+		return style.SpaceBefore + float64(lines)*style.lineAdvance()
+	case *Heading:
+		// A heading followed by a heading: keep both with the text below
+		return c.headingHeight(b) + float64(n)*theme.text().lineAdvance()
+	case *CodeBlock:
+		style := theme.resolve(theme.Code)
+		lines := min(n, strings.Count(strings.TrimSuffix(b.Literal, "\n"), "\n")+1)
+		return style.SpaceBefore + style.Padding + float64(lines)*style.lineAdvance()
+	case *Table:
+		// Header and first row
+		style := theme.resolve(theme.Table)
+		return style.SpaceBefore + 2*(style.lineAdvance()+style.Padding)
+	}
+	return float64(n) * theme.text().lineAdvance()
+}
+
+// lineCount restituisce il numero di righe in cui gli elementi inline sono divisi con lo stile indicato
+func (c *Converter) lineCount(inlines []Inline, style Style) int {
+	parts := c.styleFonts(c.convertInlineToTextParts(inlines, style.Color), style)
+	_, breaks := c.breakParagraph(parts, style)
+	return len(breaks)
+}
@@ -0,0 +1,259 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// pageLines restituisce, per ogni pagina, il testo delle righe scritte
+func pageLines(t *testing.T, data []byte) [][]string {
+	t.Helper()
+	var pages [][]string
+	stream := regexp.MustCompile(`(?s)stream\n(.*?)\nendstream`)
+	text := regexp.MustCompile(`\((.*?)\) Tj`)
+	for _, m := range stream.FindAllSubmatch(data, -1) {
+		r, err := zlib.NewReader(bytes.NewReader(m[1]))
+		if err != nil {
+			continue
+		}
+		content, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Failed to decompress stream: %v", err)
+		}
+		var lines []string
+		for _, block := range strings.Split(string(content), "BT\n")[1:] {
+			var sb strings.Builder
+			for _, tj := range text.FindAllStringSubmatch(block, -1) {
+				sb.WriteString(tj[1])
+			}
+			lines = append(lines, sb.String())
+		}
+		pages = append(pages, lines)
+	}
+	return pages
+}
+
+// fillerLines restituisce n paragrafi di una riga
+func fillerLines(n int) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "filler %d\n\n", i)
+	}
+	return sb.String()
+}
+
+func TestKeepHeadingWithNext(t *testing.T) {
+	paragraph := strings.Repeat("body text ", 40) + "\n"
+	for n := 20; n < 40; n++ {
+		data, err := NewConverter(fillerLines(n) + "## Heading\n\n" + paragraph).Convert()
+		if err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		for _, page := range pageLines(t, data) {
+			for i, line := range page {
+				if line == "Heading" && len(page)-i-1 < 2 {
+					t.Errorf("%d fillers: expected 2 lines after the heading, got %d", n, len(page)-i-1)
+				}
+			}
+		}
+	}
+}
+
+func TestWidowsAndOrphans(t *testing.T) {
+	paragraph := strings.Repeat("body text ", 60) + "\n"
+	for n := 20; n < 70; n++ {
+		data, err := NewConverter(fillerLines(n) + paragraph).Convert()
+		if err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		var counts []int
+		for _, page := range pageLines(t, data) {
+			count := 0
+			for _, line := range page {
+				if strings.HasPrefix(line, "body") {
+					count++
+				}
+			}
+			if count > 0 {
+				counts = append(counts, count)
+			}
+		}
+		if len(counts) == 2 && (counts[0] < 2 || counts[1] < 2) {
+			t.Errorf("%d fillers: paragraph split as %v", n, counts)
+		}
+	}
+}
+
+func TestKeepCodeBlockTogether(t *testing.T) {
+	code := "```\n" + strings.Repeat("code line\n", 5) + "```\n"
+	for n := 20; n < 70; n++ {
+		data, err := NewConverter(fillerLines(n) + code).Convert()
+		if err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		for _, page := range pageLines(t, data) {
+			count := 0
+			for _, line := range page {
+				if line == "code line" {
+					count++
+				}
+			}
+			if count != 0 && count != 5 {
+				t.Errorf("%d fillers: expected the code block on one page, got %d lines", n, count)
+			}
+		}
+	}
+}
+
+func TestPageBreakDirectives(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		pages    int
+	}{
+		{"newpage", "one\n\n\\newpage\n\ntwo\n", 2},
+		{"pagebreak", "one\n\n\\pagebreak\n\ntwo\n", 2},
+		{"html comment", "one\n\n<!-- pagebreak -->\n\ntwo\n", 2},
+		{"css page break", "one\n\n<div style=\"page-break-before: always\"></div>\n\ntwo\n", 2},
+		{"css break", "one\n\n<div style=\"break-after: page\"></div>\n\ntwo\n", 2},
+		{"other comment", "one\n\n<!-- note -->\n\ntwo\n", 1},
+		{"at page top", "\\newpage\n\none\n", 1},
+		{"in text", "one \\newpage two\n", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := NewConverter(tt.markdown).Convert()
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			if pages := len(pageLines(t, data)); pages != tt.pages {
+				t.Errorf("Expected %d pages, got %d", tt.pages, pages)
+			}
+		})
+	}
+}
+
+func TestHTMLPageBreakAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		expected [][]string // lines of each page
+	}{
+		{
+			"after a wrapped section",
+			"one\n\n<div style=\"page-break-after: always\">\n\ntwo\n\n</div>\n\nthree\n",
+			[][]string{{"one", "two"}, {"three"}},
+		},
+		{
+			"before a wrapped section",
+			"one\n\n<div style=\"page-break-before: always\">\n\ntwo\n\n</div>\n\nthree\n",
+			[][]string{{"one"}, {"two", "three"}},
+		},
+		{
+			"nested elements",
+			"<section style=\"break-after: page\">\n\none\n\n<section>\n\ntwo\n\n</section>\n\nthree\n\n</section>\n\nfour\n",
+			[][]string{{"one", "two", "three"}, {"four"}},
+		},
+		{
+			"void element",
+			"one\n\n<hr style=\"page-break-after: always\">\n\ntwo\n",
+			[][]string{{"one"}, {"two"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := NewConverter(tt.markdown).Convert()
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			if pages := pageLines(t, data); !reflect.DeepEqual(pages, tt.expected) {
+				t.Errorf("Expected %q, got %q", tt.expected, pages)
+			}
+		})
+	}
+}
+
+func TestHeadingPageBreak(t *testing.T) {
+	tests := []struct {
+		name      string
+		pageBreak string
+		expected  []int // pages of the headings
+	}{
+		{"none", "", []int{0, 0, 0}},
+		{"new page", PageBreakNew, []int{0, 1, 2}},
+		{"odd page", PageBreakOdd, []int{0, 2, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			theme := DefaultTheme()
+			theme.H1.PageBreak = tt.pageBreak
+			converter := NewConverter("# One\n\ntext\n\n# Two\n\n## Sub\n\n# Three\n")
+			converter.SetTheme(theme)
+			data, err := converter.Convert()
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			var got []int
+			for i, page := range pageLines(t, data) {
+				for _, line := range page {
+					if line == "One" || line == "Two" || line == "Three" {
+						got = append(got, i)
+					}
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Errorf("Expected headings on pages %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestOddPageBreakAfterInsertedPDF(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sheet.pdf"), testDatasheet(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	theme := DefaultTheme()
+	theme.H1.PageBreak = PageBreakOdd
+	tests := []struct {
+		name     string
+		inserted int
+		expected int // page of the heading, from 0
+	}{
+		{"odd page next", 1, 2},
+		{"even page next", 2, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			markdown := "Testo.\n\n" + strings.Repeat("![[sheet.pdf]]\n\n", tt.inserted) + "# Annex\n"
+			converter := NewConverter(markdown)
+			converter.SetBaseDir(dir)
+			converter.SetTheme(theme)
+			converter.SetDebug(true)
+			data, err := converter.Convert()
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			got := -1
+			for i, page := range pageStreams(t, data) {
+				if strings.Contains(page, "(Annex) Tj") {
+					got = i
+				}
+			}
+			if got != tt.expected {
+				t.Errorf("Expected the heading on page %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
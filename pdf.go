@@ -2,24 +2,71 @@ package mark2pdf
 
 import (
 	"bytes"
-	"compress/zlib"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 // PDFWriter gestisce la creazione di documenti PDF
 type PDFWriter struct {
-	objects      [][]byte
-	pages        []int
-	currentBuf   *bytes.Buffer
-	yPosition    float64
-	pageWidth    float64
-	pageHeight   float64
-	margin       float64
-	currentPage  int
-	fontSizes    map[string]float64
-	pageContents []*bytes.Buffer
+	objects       [][]byte
+	pages         []int
+	currentBuf    *bytes.Buffer
+	yPosition     float64
+	pageWidth     float64
+	pageHeight    float64
+	margin        float64
+	indent        float64 // rientro orizzontale corrente (liste annidate, citazioni)
+	currentPage   int
+	fontSizes     map[string]float64
+	fonts         []string // font base delle risorse F1, F2, ...
+	lineHeight    float64  // interlinea, multiplo della dimensione del font
+	leading       float64  // interlinea assoluta in punti; 0 = usa lineHeight
+	grid          float64  // passo della griglia delle linee di base; 0 = disattivata
+	pageContents  []*bytes.Buffer
+	resources     resourceManager          // font, XObject e stati grafici usati dalle pagine
+	encryption    *Encryption              // cifratura del documento; nil = non cifrato
+	embedded      map[string]*trueTypeFont // font TrueType incorporati al posto dei font standard
+	pdfa          bool                     // produce un PDF/A
+	pdfaLevel     string                   // livello PDF/A: PDFA2B o PDFA3B
+	title         string
+	author        string
+	creationDate  time.Time             // data di creazione richiesta; zero = nessuna data, salvo SOURCE_DATE_EPOCH
+	lang          string                // lingua del documento (/Lang), ad esempio "it"
+	structRoot    *structElem           // radice dell'albero di struttura; nil = PDF non taggato
+	structCurrent *structElem           // elemento di struttura a cui appartiene il contenuto scritto
+	parentTree    [][]*structElem       // per ogni pagina, l'elemento di ogni MCID
+	compact       bool                  // object stream e cross-reference stream (PDF 1.5)
+	linearized    bool                  // PDF linearizzato ("fast web view")
+	compression   string                // livello di compressione degli stream; "" = predefinito
+	debug         bool                  // stream di contenuto non compressi e leggibili
+	watermarks    []*Watermark          // filigrane aggiunte alle pagine quando sono scritte
+	backgrounds   []*Background         // pagine di PDF esistenti disegnate sotto il contenuto
+	imports       []*pdfImport          // PDF esistenti da cui sono copiati oggetti
+	inserted      map[int]*insertedPage // pagine copiate da PDF esistenti, per indice di pagina
+	outline       []*outlineItem        // segnalibri del documento
+	outlineOpen   []*outlineItem        // voci di AddBookmark che possono ricevere figli
+	attachments   []*Attachment         // file incorporati (/EmbeddedFiles)
+	annotations   []*annotation         // icone degli allegati nelle pagine
+	top           float64               // ordinata in cui inizia il contenuto della pagina corrente
+	bottom        float64               // margine inferiore della pagina corrente
+
+	// Scrittura del documento, da StreamTo a Finish
+	output      *objectWriter // nil finché la scrittura non è avviata
+	version     string        // versione PDF dell'intestazione
+	created     time.Time     // data scritta nei metadati; zero = nessuna
+	fileID      []byte
+	contentHash hash.Hash // impronta degli stream di contenuto già scritti
+	nextObj     int       // primo numero di oggetto libero
+	contentObjs []int     // oggetto dello stream di contenuto di ogni pagina già scritta
 }
 
 // NewPDFWriter crea un nuovo writer PDF
@@ -34,6 +81,9 @@ func NewPDFWriter() *PDFWriter {
 		yPosition:    0,
 		currentPage:  -1,
 		pageContents: make([]*bytes.Buffer, 0),
+		fonts:        []string{"Helvetica", "Helvetica-Bold", "Helvetica-Oblique", "Courier"},
+		lineHeight:   1.5,
+		pdfaLevel:    PDFA2B,
 		fontSizes: map[string]float64{
 			"h1":     24,
 			"h2":     20,
@@ -55,58 +105,168 @@ func (p *PDFWriter) addObject(content []byte) int {
 
 // newPage crea una nuova pagina
 func (p *PDFWriter) newPage() {
+	if p.output != nil {
+		// Streaming: the previous page is complete
+		p.flushPages(true)
+	}
 	p.currentPage++
-	p.yPosition = p.pageHeight - p.margin
+	p.top, p.bottom = p.pageArea(p.currentPage)
+	p.yPosition = p.top
 	p.currentBuf = &bytes.Buffer{}
 	p.pageContents = append(p.pageContents, p.currentBuf)
 }
 
-// writeText scrive testo alla posizione corrente
-func (p *PDFWriter) writeText(text string, fontSize float64, isBold bool) {
-	p.writeTextWithFont(text, fontSize, "F1") // Default font
+// ensureLine apre una pagina se non ce n'è una o se la riga corrente è oltre il margine inferiore
+func (p *PDFWriter) ensureLine() {
+	if p.currentBuf == nil || p.yPosition < p.bottom+20 {
+		p.newPage()
+	}
 }
 
-// writeTextWithFont scrive testo con un font specifico
-func (p *PDFWriter) writeTextWithFont(text string, fontSize float64, fontName string) {
+// lineAdvance restituisce la distanza tra le linee di base per testo di dimensione size
+func (p *PDFWriter) lineAdvance(size float64) float64 {
+	if p.leading > 0 {
+		return max(p.leading, size)
+	}
+	return size * p.lineHeight
+}
+
+// snapToGrid sposta la posizione corrente sulla prossima linea della griglia delle linee di base
+func (p *PDFWriter) snapToGrid() {
+	if p.currentBuf != nil {
+		p.yPosition = p.gridY(p.yPosition)
+	}
+}
+
+// gridY restituisce la prima linea della griglia delle linee di base non sopra y
+func (p *PDFWriter) gridY(y float64) float64 {
+	if p.grid <= 0 {
+		return y
+	}
+	steps := math.Ceil((p.top-y)/p.grid - 1e-6)
+	return p.top - steps*p.grid
+}
+
+// atPageTop indica se non c'è una pagina aperta o se nella pagina non è stato scritto nulla
+func (p *PDFWriter) atPageTop() bool {
+	return p.currentBuf == nil || p.yPosition >= p.top
+}
+
+// linesOnPage restituisce quante righe, con le dimensioni indicate, writeSpacedText
+// scrive nella pagina corrente prima di aprirne una nuova
+func (p *PDFWriter) linesOnPage(sizes []float64, fontSize float64) int {
 	if p.currentBuf == nil {
+		return len(sizes)
+	}
+	y := p.yPosition
+	for i, size := range sizes {
+		if y < p.bottom+20 {
+			return i
+		}
+		y = p.gridY(y - (p.lineAdvance(size) - p.lineAdvance(fontSize)))
+		if y < p.bottom+20 {
+			return i
+		}
+		y -= p.lineAdvance(fontSize)
+	}
+	return len(sizes)
+}
+
+// pageBreak apre una nuova pagina se in quella corrente è stato scritto qualcosa;
+// con odd la nuova pagina è dispari, aggiungendo se serve una pagina vuota
+func (p *PDFWriter) pageBreak(odd bool) {
+	if !p.atPageTop() {
 		p.newPage()
 	}
+	// The page number of the next content: after an inserted PDF no page is open
+	next := len(p.pageContents)
+	if p.currentBuf == nil {
+		next++
+	}
+	if odd && next%2 == 0 {
+		if p.currentBuf == nil {
+			// The blank even page
+			p.newPage()
+		}
+		p.newPage()
+	}
+}
 
-	// Check if we need a new page
-	if p.yPosition < p.margin+20 {
+// keepTogether apre una nuova pagina se un contenuto alto height non entra nello
+// spazio rimasto della pagina corrente ma entra in una pagina nuova; come in
+// ensureLine, il limite inferiore è 20 punti sopra il margine
+func (p *PDFWriter) keepTogether(height float64) {
+	if p.atPageTop() || height > p.top-p.bottom-20 {
+		return
+	}
+	if p.yPosition-height < p.bottom+20 {
 		p.newPage()
 	}
+}
+
+// fontResource restituisce il nome della risorsa per un font standard, registrandolo se necessario
+func (p *PDFWriter) fontResource(baseFont string) string {
+	for i, f := range p.fonts {
+		if f == baseFont {
+			return fmt.Sprintf("F%d", i+1)
+		}
+	}
+	p.fonts = append(p.fonts, baseFont)
+	return fmt.Sprintf("F%d", len(p.fonts))
+}
+
+// familyFont restituisce la risorsa del font di una famiglia:
+// variant 0 è il regolare, 1 il grassetto, 2 il corsivo
+func (p *PDFWriter) familyFont(family string, variant int) string {
+	fonts, ok := fontFamilies[strings.ToLower(family)]
+	if !ok {
+		fonts = fontFamilies["helvetica"]
+	}
+	return p.fontResource(fonts[variant])
+}
+
+// setFont seleziona il font di una risorsa nello stream della pagina e lo registra come usato
+func (p *PDFWriter) setFont(resource string, size float64) {
+	p.useResource(resource)
+	p.currentBuf.WriteString(fmt.Sprintf("/%s %.2f Tf\n", resource, size))
+}
+
+// writeText scrive testo alla posizione corrente
+func (p *PDFWriter) writeText(text string, fontSize float64, isBold bool) {
+	p.writeTextWithFont(text, fontSize, "F1") // Default font
+}
+
+// writeTextWithFont scrive testo con un font specifico
+func (p *PDFWriter) writeTextWithFont(text string, fontSize float64, fontName string) {
+	p.ensureLine()
 
 	p.currentBuf.WriteString("BT\n")
-	p.currentBuf.WriteString(fmt.Sprintf("/%s %.2f Tf\n", fontName, fontSize))
-	p.currentBuf.WriteString(fmt.Sprintf("%.2f %.2f Td\n", p.margin, p.yPosition))
+	p.setFont(fontName, fontSize)
+	p.currentBuf.WriteString(fmt.Sprintf("%.2f %.2f Td\n", p.margin+p.indent, p.yPosition))
 
 	// Escape special characters in text
 	escapedText := escapeString(text)
+	p.beginMarked(nil)
 	p.currentBuf.WriteString(fmt.Sprintf("(%s) Tj\n", escapedText))
+	p.endMarked()
 	p.currentBuf.WriteString("ET\n")
 
-	p.yPosition -= fontSize * 1.5
+	p.yPosition -= p.lineAdvance(fontSize)
 }
 
 // writeInlineText scrive testo inline senza andare a capo
 func (p *PDFWriter) writeInlineText(text string, fontSize float64, fontName string, xOffset float64) {
-	if p.currentBuf == nil {
-		p.newPage()
-	}
-
-	// Check if we need a new page
-	if p.yPosition < p.margin+20 {
-		p.newPage()
-	}
+	p.ensureLine()
 
 	p.currentBuf.WriteString("BT\n")
-	p.currentBuf.WriteString(fmt.Sprintf("/%s %.2f Tf\n", fontName, fontSize))
-	p.currentBuf.WriteString(fmt.Sprintf("%.2f %.2f Td\n", p.margin+xOffset, p.yPosition))
+	p.setFont(fontName, fontSize)
+	p.currentBuf.WriteString(fmt.Sprintf("%.2f %.2f Td\n", p.margin+p.indent+xOffset, p.yPosition))
 
 	// Escape special characters in text
 	escapedText := escapeString(text)
+	p.beginMarked(nil)
 	p.currentBuf.WriteString(fmt.Sprintf("(%s) Tj\n", escapedText))
+	p.endMarked()
 	p.currentBuf.WriteString("ET\n")
 }
 
@@ -150,26 +310,82 @@ func NewColorFloat(r, g, b float64) Color {
 type TextPart struct {
 	Text  string
 	Font  string
-	Color *Color // nil = usa colore di default (nero)
+	Size  float64 // dimensione in punti; 0 = dimensione del testo circostante
+	Color *Color  // nil = usa colore di default (nero)
+	Break bool    // true = a capo forzato (hard line break), Text ignorato
+
+	scale float64     // dimensione relativa al testo circostante, risolta prima della scrittura
+	elem  *structElem // elemento di struttura della parte (Link, Figure, Lbl); nil = quello corrente
 }
 
-// writeMultiStyleText scrive testo con stili multipli sulla stessa riga
-func (p *PDFWriter) writeMultiStyleText(parts []TextPart, fontSize float64) {
-	if p.currentBuf == nil {
-		p.newPage()
+// partSize restituisce la dimensione di una parte in un testo di dimensione fontSize
+func partSize(part TextPart, fontSize float64) float64 {
+	if part.Size > 0 {
+		return part.Size
 	}
+	return fontSize
+}
 
-	// Check if we need a new page
-	if p.yPosition < p.margin+20 {
-		p.newPage()
+// lineSize restituisce la dimensione massima delle parti di una riga
+func lineSize(parts []TextPart, fontSize float64) float64 {
+	size := fontSize
+	for _, part := range parts {
+		size = max(size, partSize(part, fontSize))
 	}
+	return size
+}
+
+// Nomi delle risorse font da usare nei TextPart
+const (
+	FontRegular = "F1" // Helvetica
+	FontBold    = "F2" // Helvetica-Bold
+	FontItalic  = "F3" // Helvetica-Oblique
+	FontMono    = "F4" // Courier
+)
+
+// writeMultiStyleText scrive testo con stili multipli sulla stessa riga.
+// Le parti più grandi di fontSize abbassano la riga dello spazio in più
+// che richiedono, così non si sovrappongono alla riga precedente.
+func (p *PDFWriter) writeMultiStyleText(parts []TextPart, fontSize float64) {
+	p.writeSpacedText(parts, fontSize, 0, 0)
+}
+
+// writeSpacedText scrive una riga come writeMultiStyleText, aggiungendo wordSpacing
+// a ogni spazio (Tw) e charSpacing a ogni carattere (Tc) per giustificarla
+func (p *PDFWriter) writeSpacedText(parts []TextPart, fontSize, wordSpacing, charSpacing float64) {
+	p.ensureLine()
+	p.yPosition -= p.lineAdvance(lineSize(parts, fontSize)) - p.lineAdvance(fontSize)
+	p.snapToGrid()
+	p.ensureLine()
 
 	// Start text block
 	p.currentBuf.WriteString("BT\n")
-	p.currentBuf.WriteString(fmt.Sprintf("%.2f %.2f Td\n", p.margin, p.yPosition))
+	p.currentBuf.WriteString(fmt.Sprintf("%.2f %.2f Td\n", p.margin+p.indent, p.yPosition))
+	// Tw and Tc belong to the text state, which outlives the text block: reset them
+	spaced := wordSpacing != 0 || charSpacing != 0
+	if spaced {
+		p.currentBuf.WriteString(fmt.Sprintf("%.3f Tw %.3f Tc\n", wordSpacing, charSpacing))
+	}
+	p.writeParts(parts, fontSize)
+	if spaced {
+		p.currentBuf.WriteString("0 Tw 0 Tc\n")
+	}
+	p.currentBuf.WriteString("ET\n")
 
-	// Write each part with its font and color
-	for _, part := range parts {
+	// Move to next line
+	p.yPosition -= p.lineAdvance(fontSize)
+}
+
+// writeParts scrive le parti di testo con il loro font, dimensione e colore
+func (p *PDFWriter) writeParts(parts []TextPart, fontSize float64) {
+	for i, part := range parts {
+		// Parts with their own structure element (links, figures) get their own marked content
+		if i == 0 || part.elem != parts[i-1].elem {
+			if i > 0 {
+				p.endMarked()
+			}
+			p.beginMarked(part.elem)
+		}
 		// Set color if specified
 		if part.Color != nil {
 			p.currentBuf.WriteString(fmt.Sprintf("%.3f %.3f %.3f rg\n", part.Color.R, part.Color.G, part.Color.B))
@@ -178,57 +394,13 @@ func (p *PDFWriter) writeMultiStyleText(parts []TextPart, fontSize float64) {
 			p.currentBuf.WriteString("0 0 0 rg\n")
 		}
 
-		p.currentBuf.WriteString(fmt.Sprintf("/%s %.2f Tf\n", part.Font, fontSize))
+		p.setFont(part.Font, partSize(part, fontSize))
 		escapedText := escapeString(part.Text)
 		p.currentBuf.WriteString(fmt.Sprintf("(%s) Tj\n", escapedText))
 	}
-
-	p.currentBuf.WriteString("ET\n")
-
-	// Move to next line
-	p.yPosition -= fontSize * 1.5
-}
-
-// writeLine scrive una linea orizzontale
-func (p *PDFWriter) writeLine(width float64) {
-	if p.currentBuf == nil {
-		p.newPage()
-	}
-
-	// Check if we need a new page
-	if p.yPosition < p.margin+20 {
-		p.newPage()
-	}
-
-	p.currentBuf.WriteString(fmt.Sprintf("%.2f %.2f m\n", p.margin, p.yPosition))
-	p.currentBuf.WriteString(fmt.Sprintf("%.2f %.2f l\n", p.margin+width, p.yPosition))
-	p.currentBuf.WriteString("S\n")
-
-	p.yPosition -= 10
-}
-
-// drawRect disegna un rettangolo (bordo cella tabella)
-func (p *PDFWriter) drawRect(x, y, width, height float64) {
-	if p.currentBuf == nil {
-		p.newPage()
-	}
-
-	// Draw rectangle border with default line width
-	p.currentBuf.WriteString(fmt.Sprintf("%.2f %.2f %.2f %.2f re\n", x, y-height, width, height))
-	p.currentBuf.WriteString("S\n")
-}
-
-// drawThickRect disegna un rettangolo con bordo più spesso (per header tabelle)
-func (p *PDFWriter) drawThickRect(x, y, width, height float64) {
-	if p.currentBuf == nil {
-		p.newPage()
+	if len(parts) > 0 {
+		p.endMarked()
 	}
-
-	// Set thicker line width for header
-	p.currentBuf.WriteString("1.5 w\n") // Set line width to 1.5
-	p.currentBuf.WriteString(fmt.Sprintf("%.2f %.2f %.2f %.2f re\n", x, y-height, width, height))
-	p.currentBuf.WriteString("S\n")
-	p.currentBuf.WriteString("1 w\n") // Reset to default line width
 }
 
 // writeTextAt scrive testo a coordinate specifiche senza modificare yPosition
@@ -243,11 +415,13 @@ func (p *PDFWriter) writeTextAt(text string, x, y, fontSize float64, isBold bool
 	}
 
 	p.currentBuf.WriteString("BT\n")
-	p.currentBuf.WriteString(fmt.Sprintf("/%s %.2f Tf\n", fontName, fontSize))
+	p.setFont(fontName, fontSize)
 	p.currentBuf.WriteString(fmt.Sprintf("%.2f %.2f Td\n", x, y))
 
 	escapedText := escapeString(text)
+	p.beginMarked(nil)
 	p.currentBuf.WriteString(fmt.Sprintf("(%s) Tj\n", escapedText))
+	p.endMarked()
 	p.currentBuf.WriteString("ET\n")
 }
 
@@ -260,52 +434,131 @@ func (p *PDFWriter) writeMultiStyleTextAt(parts []TextPart, x, y, fontSize float
 	// Start text block at specific position
 	p.currentBuf.WriteString("BT\n")
 	p.currentBuf.WriteString(fmt.Sprintf("%.2f %.2f Td\n", x, y))
+	p.writeParts(parts, fontSize)
+	p.currentBuf.WriteString("ET\n")
+}
 
-	// Write each part with its font and color
-	for _, part := range parts {
-		// Set color if specified
-		if part.Color != nil {
-			p.currentBuf.WriteString(fmt.Sprintf("%.3f %.3f %.3f rg\n", part.Color.R, part.Color.G, part.Color.B))
-		} else {
-			// Default to black
-			p.currentBuf.WriteString("0 0 0 rg\n")
-		}
+// writeColoredText scrive testo con un colore specifico
+func (p *PDFWriter) writeColoredText(text string, fontSize float64, fontName string, color Color) {
+	p.ensureLine()
 
-		p.currentBuf.WriteString(fmt.Sprintf("/%s %.2f Tf\n", part.Font, fontSize))
-		escapedText := escapeString(part.Text)
-		p.currentBuf.WriteString(fmt.Sprintf("(%s) Tj\n", escapedText))
-	}
+	p.currentBuf.WriteString("BT\n")
+	p.currentBuf.WriteString(fmt.Sprintf("%.3f %.3f %.3f rg\n", color.R, color.G, color.B))
+	p.setFont(fontName, fontSize)
+	p.currentBuf.WriteString(fmt.Sprintf("%.2f %.2f Td\n", p.margin+p.indent, p.yPosition))
 
+	escapedText := escapeString(text)
+	p.beginMarked(nil)
+	p.currentBuf.WriteString(fmt.Sprintf("(%s) Tj\n", escapedText))
+	p.endMarked()
 	p.currentBuf.WriteString("ET\n")
+
+	p.yPosition -= p.lineAdvance(fontSize)
 }
 
-// writeColoredText scrive testo con un colore specifico
-func (p *PDFWriter) writeColoredText(text string, fontSize float64, fontName string, color Color) {
+// NewPage inizia una nuova pagina
+func (p *PDFWriter) NewPage() {
+	p.newPage()
+}
+
+// EnsureSpace passa a una nuova pagina se sotto la posizione corrente
+// non ci sono almeno height punti disponibili
+func (p *PDFWriter) EnsureSpace(height float64) {
+	if p.currentBuf == nil || p.yPosition-height < p.bottom {
+		p.newPage()
+	}
+}
+
+// DrawRect disegna il bordo di un rettangolo; (x, y) è l'angolo in alto a sinistra.
+// Nei PDF taggati bordi, sfondi e linee sono marcati come artefatti.
+func (p *PDFWriter) DrawRect(x, y, width, height, lineWidth float64, color Color) {
+	if p.currentBuf == nil {
+		p.newPage()
+	}
+	p.beginMarked(artifact)
+	p.currentBuf.WriteString(fmt.Sprintf("q %.2f w %.3f %.3f %.3f RG\n", lineWidth, color.R, color.G, color.B))
+	p.currentBuf.WriteString(fmt.Sprintf("%.2f %.2f %.2f %.2f re S Q\n", x, y-height, width, height))
+	p.endMarked()
+}
+
+// FillRect riempie un rettangolo; (x, y) è l'angolo in alto a sinistra
+func (p *PDFWriter) FillRect(x, y, width, height float64, color Color) {
 	if p.currentBuf == nil {
 		p.newPage()
 	}
+	p.beginMarked(artifact)
+	p.currentBuf.WriteString(fmt.Sprintf("q %.3f %.3f %.3f rg\n", color.R, color.G, color.B))
+	p.currentBuf.WriteString(fmt.Sprintf("%.2f %.2f %.2f %.2f re f Q\n", x, y-height, width, height))
+	p.endMarked()
+}
 
-	// Check if we need a new page
-	if p.yPosition < p.margin+20 {
+// DrawLine disegna una linea da (x1, y1) a (x2, y2)
+func (p *PDFWriter) DrawLine(x1, y1, x2, y2, lineWidth float64, color Color) {
+	if p.currentBuf == nil {
 		p.newPage()
 	}
+	p.beginMarked(artifact)
+	p.currentBuf.WriteString(fmt.Sprintf("q %.2f w %.3f %.3f %.3f RG\n", lineWidth, color.R, color.G, color.B))
+	p.currentBuf.WriteString(fmt.Sprintf("%.2f %.2f m %.2f %.2f l S Q\n", x1, y1, x2, y2))
+	p.endMarked()
+}
 
-	p.currentBuf.WriteString("BT\n")
-	p.currentBuf.WriteString(fmt.Sprintf("%.3f %.3f %.3f rg\n", color.R, color.G, color.B))
-	p.currentBuf.WriteString(fmt.Sprintf("/%s %.2f Tf\n", fontName, fontSize))
-	p.currentBuf.WriteString(fmt.Sprintf("%.2f %.2f Td\n", p.margin, p.yPosition))
+// WriteTextAt scrive testo multi-stile con la linea di base in (x, y), senza modificare la posizione corrente
+func (p *PDFWriter) WriteTextAt(parts []TextPart, x, y, fontSize float64) {
+	p.writeMultiStyleTextAt(parts, x, y, fontSize)
+}
 
-	escapedText := escapeString(text)
-	p.currentBuf.WriteString(fmt.Sprintf("(%s) Tj\n", escapedText))
-	p.currentBuf.WriteString("ET\n")
+// TextWidth restituisce la larghezza del testo nel font regolare (FontRegular)
+func (p *PDFWriter) TextWidth(text string, fontSize float64) float64 {
+	return p.partWidth(TextPart{Text: text, Font: FontRegular}, fontSize)
+}
+
+// partWidth restituisce la larghezza di una parte di testo con le metriche del suo font
+func (p *PDFWriter) partWidth(part TextPart, fontSize float64) float64 {
+	base := p.baseFont(part.Font)
+	if font, ok := p.embedded[base]; ok {
+		return font.stringWidth(part.Text, partSize(part, fontSize))
+	}
+	return stringWidth(base, part.Text, partSize(part, fontSize))
+}
+
+// EmbedFont incorpora un font TrueType al posto di un font standard
+// ("Helvetica", "Times-Bold", "Courier", ...): il testo usa le sue metriche e il
+// PDF non dipende più dai font del lettore
+func (p *PDFWriter) EmbedFont(baseFont string, data []byte) error {
+	standard := false
+	for _, fonts := range fontFamilies {
+		for _, f := range fonts {
+			standard = standard || f == baseFont
+		}
+	}
+	if !standard {
+		return fmt.Errorf("font standard sconosciuto %q", baseFont)
+	}
+	font, err := parseTrueType(data)
+	if err != nil {
+		return fmt.Errorf("font %s: %w", baseFont, err)
+	}
+	if p.embedded == nil {
+		p.embedded = make(map[string]*trueTypeFont)
+	}
+	p.embedded[baseFont] = font
+	return nil
+}
 
-	p.yPosition -= fontSize * 1.5
+// baseFont restituisce il nome del font standard di una risorsa ("F2" -> "Helvetica-Bold")
+func (p *PDFWriter) baseFont(resource string) string {
+	var i int
+	if _, err := fmt.Sscanf(resource, "F%d", &i); err == nil && i >= 1 && i <= len(p.fonts) {
+		return p.fonts[i-1]
+	}
+	return p.fonts[0]
 }
 
 // addSpace aggiunge spazio verticale
 func (p *PDFWriter) addSpace(points float64) {
 	p.yPosition -= points
-	if p.yPosition < p.margin+20 {
+	if p.yPosition < p.bottom+20 {
 		p.newPage()
 	}
 }
@@ -317,156 +570,511 @@ func escapeString(s string) string {
 		switch r {
 		case '(', ')', '\\':
 			result += "\\" + string(r)
-		case '\n':
-			continue
-		case '\r':
+		case '\n', '\r', softHyphen:
+			// Soft hyphens are break points, shown only when a word is divided
 			continue
 		case '\t':
 			result += "    "
 		default:
-			if r < 32 || r > 126 {
-				// Replace non-ASCII with space for simplicity
-				result += " "
-			} else {
+			if r >= 32 && r <= 126 {
 				result += string(r)
+			} else if b, ok := winAnsiByte(r); ok {
+				// Non-ASCII characters available in WinAnsiEncoding as octal escapes
+				result += fmt.Sprintf("\\%03o", b)
+			} else {
+				// Replace characters outside WinAnsiEncoding with space
+				result += " "
 			}
 		}
 	}
 	return result
 }
 
+// winAnsiSpecials mappa i caratteri Unicode nell'intervallo 0x80-0x9F di WinAnsiEncoding
+var winAnsiSpecials = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// winAnsiByte converte un carattere Unicode nel byte corrispondente di WinAnsiEncoding
+func winAnsiByte(r rune) (byte, bool) {
+	if r >= 0xA0 && r <= 0xFF {
+		return byte(r), true
+	}
+	b, ok := winAnsiSpecials[r]
+	return b, ok
+}
+
+// winAnsiRune converte un byte di WinAnsiEncoding nel carattere Unicode corrispondente
+func winAnsiRune(b byte) (rune, bool) {
+	if (b >= 32 && b <= 126) || b >= 0xA0 {
+		return rune(b), true
+	}
+	for r, special := range winAnsiSpecials {
+		if special == b {
+			return r, true
+		}
+	}
+	return 0, false
+}
+
 // Build costruisce il PDF finale
 func (p *PDFWriter) Build() ([]byte, error) {
+	output := &bytes.Buffer{}
+	if err := p.StreamTo(output); err != nil {
+		return nil, err
+	}
+	if err := p.Finish(); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+// StreamTo avvia la scrittura del PDF su w: l'intestazione è scritta subito e lo
+// stream di contenuto di ogni pagina completata è compresso e scritto all'apertura
+// della pagina successiva, così il documento non resta tutto in memoria. Finish
+// scrive le pagine rimaste, gli oggetti condivisi e la tabella xref.
+func (p *PDFWriter) StreamTo(w io.Writer) error {
+	if p.output != nil {
+		return errors.New("scrittura del PDF già avviata")
+	}
+
+	p.created = p.documentDate()
+	p.version = "1.4"
+	p.contentHash = md5.New()
+	p.fileID = nil
+	if p.encryption != nil {
+		// The encryption key depends on the identifier, which is needed before
+		// the pages are written
+		p.fileID = p.documentID()
+	}
+	var security *securityHandler
+	if p.encryption != nil {
+		var err error
+		if security, err = newSecurityHandler(p.encryption, p.fileID); err != nil {
+			return err
+		}
+		p.version = p.encryption.version()
+	}
+	if p.pdfa {
+		p.version = "1.7"
+	}
+	if p.compact && !p.linearized && p.version < "1.5" {
+		// Object streams and cross-reference streams
+		p.version = "1.5"
+	}
+
+	// Object numbers 1 and 2 are the catalog and the page tree, written last
+	p.output = newObjectWriter(w, security, p.compact && !p.linearized)
+	p.output.level = compressionLevels[p.compression]
+	if p.linearized {
+		p.output.linearized = true
+		p.output.recorded = make(map[int]*rawObject)
+	}
+	p.nextObj = 3
+	p.contentObjs = nil
+
+	// PDF Header
+	p.output.writeString("%PDF-" + p.version + "\n")
+	p.output.writeString("%âăĎÓ\n") // Binary marker
+	p.flushPages(false)
+	return p.output.err
+}
+
+// allocObject restituisce il primo numero di oggetto libero
+func (p *PDFWriter) allocObject() int {
+	p.nextObj++
+	return p.nextObj - 1
+}
+
+// flushPages scrive gli stream di contenuto delle pagine non ancora scritte e ne
+// libera i buffer; la pagina corrente è inclusa solo se all è vero
+func (p *PDFWriter) flushPages(all bool) {
+	last := len(p.pageContents)
+	if !all {
+		last--
+	}
+	for i := len(p.contentObjs); i < last; i++ {
+		num := p.allocObject()
+		p.stampPage(i)
+		p.contentHash.Write(p.pageContents[i].Bytes())
+		if p.debug {
+			// Readable operators, preceded by a comment naming the page
+			p.output.stream(num, "", append([]byte(fmt.Sprintf("%% page %d\n", i+1)), p.pageContents[i].Bytes()...))
+		} else {
+			p.output.flateStream(num, "", p.pageContents[i].Bytes())
+		}
+		p.contentObjs = append(p.contentObjs, num)
+		p.pageContents[i] = nil
+	}
+}
+
+// Finish completa il PDF avviato con StreamTo. Gli errori rilevati solo a
+// documento finito (ad esempio i font non incorporati in PDF/A) lasciano su w un
+// file incompleto.
+func (p *PDFWriter) Finish() error {
+	if p.output == nil {
+		return errors.New("scrittura del PDF non avviata: chiamare prima StreamTo")
+	}
+	objects := p.output
+	defer func() { p.output = nil }()
+
 	// If no pages were created, create an empty one
 	if len(p.pageContents) == 0 {
 		p.newPage()
 	}
+	p.flushPages(true)
+	if p.fileID == nil {
+		p.fileID = p.documentID()
+	}
 
-	output := &bytes.Buffer{}
+	// Fonts written to the file: only the ones used, which in PDF/A must be embedded
+	var fonts []int
+	for i := range p.fonts {
+		if p.resources.used[fmt.Sprintf("F%d", i+1)] {
+			fonts = append(fonts, i)
+		}
+	}
+	if p.pdfa {
+		if err := p.validatePDFA(fonts); err != nil {
+			return err
+		}
+	}
+	ua := p.conformsUA()
+	security := objects.security
+	catalog := ""
+	if security != nil && security.revision == 6 {
+		// AES-256 is an Adobe extension to PDF 1.7, standard in PDF 2.0
+		catalog = "/Extensions << /ADBE << /BaseVersion /1.7 /ExtensionLevel 8 >> >> "
+	}
+	if ua && p.version < "1.7" {
+		// PDF/UA depends on the fonts used, known only now that the header is written
+		catalog += "/Version /1.7 "
+	}
 
-	// PDF Header
-	output.WriteString("%PDF-1.4\n")
-	output.WriteString("%âăĎÓ\n") // Binary marker
-
-	xrefPositions := make([]int, 0)
-	xrefPositions = append(xrefPositions, 0) // Object 0 is always free
-
-	// Catalog (Object 1)
-	objNum := 1
-	xrefPositions = append(xrefPositions, output.Len())
-	output.WriteString(fmt.Sprintf("%d 0 obj\n", objNum))
-	output.WriteString("<< /Type /Catalog /Pages 2 0 R >>\n")
-	output.WriteString("endobj\n")
-	objNum++
-
-	// Pages object (Object 2) - must be referenced by Catalog
-	pagesObjNum := objNum
+	// Object numbers after the content streams: fonts, pages, font programs,
+	// XObjects and graphics states, PDF/A metadata, logical structure, bookmarks,
+	// attachments, document information and encryption
+	const catalogObjNum, pagesObjNum = 1, 2
+	alloc := p.allocObject
+	refs := make(map[string]int) // resource name -> object
+	fontObjNums := make(map[int]int, len(fonts))
+	fontByContent := make(map[string]int)
+	var fontObjects []int // fonts written, one for each distinct font
+	for _, i := range fonts {
+		// Resources embedding the same font program share one font object
+		key := p.fonts[i]
+		if font, ok := p.embedded[key]; ok {
+			key = fmt.Sprintf("%x", sha256.Sum256(font.data))
+		}
+		num, ok := fontByContent[key]
+		if !ok {
+			num = alloc()
+			fontByContent[key] = num
+			fontObjects = append(fontObjects, i)
+		}
+		fontObjNums[i] = num
+		refs[fmt.Sprintf("F%d", i+1)] = num
+	}
 	numPages := len(p.pageContents)
+	pageObjStart := p.nextObj
+	p.nextObj += numPages
+	descriptorObjNums := make(map[int]int)
+	for _, i := range fontObjects {
+		if _, ok := p.embedded[p.fonts[i]]; ok {
+			descriptorObjNums[i] = alloc()
+			alloc() // font program
+		}
+	}
+	xobjectNums := make(map[*xobject]int)
+	for _, x := range p.resources.xobjects {
+		if p.resources.used[x.name] {
+			for obj := x; obj != nil; obj = obj.smask {
+				xobjectNums[obj] = alloc()
+			}
+			refs[x.name] = xobjectNums[x]
+		}
+	}
+	for i := range p.resources.states {
+		if name := fmt.Sprintf("GS%d", i+1); p.resources.used[name] {
+			refs[name] = alloc()
+		}
+	}
+	var iccObjNum, metadataObjNum, infoObjNum, encryptObjNum int
+	if p.pdfa {
+		iccObjNum = alloc()
+	}
+	if p.pdfa || ua {
+		metadataObjNum = alloc()
+	}
+	var structTreeObjNum, parentTreeObjNum int
+	var structObjNums map[*structElem]int
+	if p.structRoot != nil {
+		structTreeObjNum, parentTreeObjNum = alloc(), alloc()
+		structObjNums = p.structObjects(alloc)
+	}
+	var outlineObjNum int
+	outlineNums := make(map[*outlineItem]int)
+	if len(p.outline) > 0 {
+		outlineObjNum = alloc()
+		outlineObjects(p.outline, outlineNums, alloc)
+	}
+	specNums := make(map[*Attachment]int)
+	for _, a := range p.attachments {
+		specNums[a] = alloc()
+		alloc() // embedded file stream
+	}
+	var appearanceObjNum int
+	pageAnnots := make(map[int]string)
+	if len(p.annotations) > 0 {
+		appearanceObjNum = alloc()
+		for _, a := range p.annotations {
+			a.num = alloc()
+			pageAnnots[a.page] += fmt.Sprintf("%d 0 R ", a.num)
+		}
+	}
+	if p.title != "" || p.author != "" || p.pdfa {
+		infoObjNum = alloc()
+	}
+	if security != nil {
+		encryptObjNum = alloc()
+	}
+	pdfString := objects.str
 
-	// Reserve space for Pages object - we'll write it here
-	pagesPos := output.Len()
-	xrefPositions = append(xrefPositions, pagesPos)
-
-	// Calculate object numbers
-	fontObjNum := objNum + 1
-	pageObjStart := fontObjNum + 4 // Now we have 4 fonts (F1, F2, F3, F4)
-	contentObjStart := pageObjStart + numPages
+	// Catalog
+	if p.lang != "" {
+		catalog += "/Lang " + pdfString(catalogObjNum, p.lang) + " "
+	}
+	if p.structRoot != nil {
+		catalog += fmt.Sprintf("/MarkInfo << /Marked true >> /StructTreeRoot %d 0 R /ViewerPreferences << /DisplayDocTitle true >> ", structTreeObjNum)
+	}
+	if metadataObjNum != 0 {
+		catalog += fmt.Sprintf("/Metadata %d 0 R ", metadataObjNum)
+	}
+	if outlineObjNum != 0 {
+		catalog += fmt.Sprintf("/Outlines %d 0 R ", outlineObjNum)
+	}
+	if len(p.attachments) > 0 {
+		catalog += "/Names " + p.embeddedFiles(catalogObjNum, specNums) + " "
+	}
+	if p.pdfa && p.pdfaLevel == PDFA3B && len(p.attachments) > 0 {
+		// PDF/A-3 associated files
+		var af strings.Builder
+		for _, a := range p.attachments {
+			fmt.Fprintf(&af, "%d 0 R ", specNums[a])
+		}
+		catalog += "/AF [" + af.String() + "] "
+	}
+	if p.pdfa {
+		catalog += fmt.Sprintf("/OutputIntents [<< /Type /OutputIntent /S /GTS_PDFA1 "+
+			"/OutputConditionIdentifier %s /Info %s /DestOutputProfile %d 0 R >>] ",
+			pdfString(catalogObjNum, "sRGB IEC61966-2.1"), pdfString(catalogObjNum, "sRGB IEC61966-2.1"), iccObjNum)
+	}
+	objects.object(catalogObjNum, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R %s>>", pagesObjNum, catalog))
 
-	// Write Pages object
-	output.WriteString(fmt.Sprintf("%d 0 obj\n", objNum))
-	output.WriteString("<< /Type /Pages ")
-	output.WriteString("/Kids [")
+	// Page tree
+	var kids strings.Builder
 	for i := 0; i < numPages; i++ {
-		output.WriteString(fmt.Sprintf("%d 0 R ", pageObjStart+i))
-	}
-	output.WriteString("] ")
-	output.WriteString(fmt.Sprintf("/Count %d ", numPages))
-	output.WriteString(">>\n")
-	output.WriteString("endobj\n")
-	objNum++
-
-	// Font objects (F1=Helvetica, F2=Helvetica-Bold, F3=Helvetica-Oblique, F4=Courier)
-	// F1 - Regular (Object 3)
-	xrefPositions = append(xrefPositions, output.Len())
-	output.WriteString(fmt.Sprintf("%d 0 obj\n", fontObjNum))
-	output.WriteString("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>\n")
-	output.WriteString("endobj\n")
-
-	// F2 - Bold (Object 4)
-	xrefPositions = append(xrefPositions, output.Len())
-	output.WriteString(fmt.Sprintf("%d 0 obj\n", fontObjNum+1))
-	output.WriteString("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold >>\n")
-	output.WriteString("endobj\n")
-
-	// F3 - Italic (Object 5)
-	xrefPositions = append(xrefPositions, output.Len())
-	output.WriteString(fmt.Sprintf("%d 0 obj\n", fontObjNum+2))
-	output.WriteString("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Oblique >>\n")
-	output.WriteString("endobj\n")
-
-	// F4 - Code/Monospace (Object 6)
-	xrefPositions = append(xrefPositions, output.Len())
-	output.WriteString(fmt.Sprintf("%d 0 obj\n", fontObjNum+3))
-	output.WriteString("<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>\n")
-	output.WriteString("endobj\n")
-
-	objNum = fontObjNum + 4
+		kids.WriteString(fmt.Sprintf("%d 0 R ", pageObjStart+i))
+	}
+	objects.object(pagesObjNum, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids.String(), numPages))
+
+	// Font objects: F1=Helvetica, F2=Helvetica-Bold, F3=Helvetica-Oblique, F4=Courier,
+	// then the fonts registered by the theme
+	for _, i := range fontObjects {
+		if font, ok := p.embedded[p.fonts[i]]; ok {
+			objects.object(fontObjNums[i], fmt.Sprintf("<< /Type /Font /Subtype /TrueType /BaseFont /%s /FirstChar 32 /LastChar 255 /Widths [%s] /Encoding /WinAnsiEncoding /FontDescriptor %d 0 R >>",
+				font.postScriptName, font.winAnsiWidths(), descriptorObjNums[i]))
+		} else {
+			objects.object(fontObjNums[i], fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", p.fonts[i]))
+		}
+	}
 
 	// Page objects
-	for i := range p.pageContents {
-		xrefPositions = append(xrefPositions, output.Len())
-		pageObjNum := pageObjStart + i
-		contentObjNum := contentObjStart + i
-
-		output.WriteString(fmt.Sprintf("%d 0 obj\n", pageObjNum))
-		output.WriteString("<< /Type /Page ")
-		output.WriteString(fmt.Sprintf("/Parent %d 0 R ", pagesObjNum))
-		output.WriteString(fmt.Sprintf("/MediaBox [0 0 %.2f %.2f] ", p.pageWidth, p.pageHeight))
-		output.WriteString(fmt.Sprintf("/Contents %d 0 R ", contentObjNum))
-		// Include all 4 fonts in resources
-		output.WriteString(fmt.Sprintf("/Resources << /Font << /F1 %d 0 R /F2 %d 0 R /F3 %d 0 R /F4 %d 0 R >> >> ",
-			fontObjNum, fontObjNum+1, fontObjNum+2, fontObjNum+3))
-		output.WriteString(">>\n")
-		output.WriteString("endobj\n")
-	}
-
-	// Content streams
-	for i, pageBuf := range p.pageContents {
-		content := pageBuf.Bytes()
-
-		// Compress content
-		var compressed bytes.Buffer
-		w := zlib.NewWriter(&compressed)
-		w.Write(content)
-		w.Close()
-
-		contentObjNum := contentObjStart + i
-		xrefPositions = append(xrefPositions, output.Len())
-		output.WriteString(fmt.Sprintf("%d 0 obj\n", contentObjNum))
-		output.WriteString(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\n", compressed.Len()))
-		output.WriteString("stream\n")
-		output.Write(compressed.Bytes())
-		output.WriteString("\nendstream\n")
-		output.WriteString("endobj\n")
-	}
-
-	// xref table
-	xrefPos := output.Len()
-	output.WriteString("xref\n")
-	output.WriteString(fmt.Sprintf("0 %d\n", len(xrefPositions)))
-	output.WriteString("0000000000 65535 f \n")
-	for i := 1; i < len(xrefPositions); i++ {
-		output.WriteString(fmt.Sprintf("%010d 00000 n \n", xrefPositions[i]))
-	}
-
-	// Trailer
-	output.WriteString("trailer\n")
-	output.WriteString(fmt.Sprintf("<< /Size %d /Root 1 0 R >>\n", len(xrefPositions)))
-	output.WriteString("startxref\n")
-	output.WriteString(fmt.Sprintf("%d\n", xrefPos))
-	output.WriteString("%%EOF\n")
+	for i := 0; i < numPages; i++ {
+		mediaBox := fmt.Sprintf("[0 0 %.2f %.2f]", p.pageWidth, p.pageHeight)
+		inserted := p.inserted[i]
+		if inserted != nil {
+			// Pages of other PDFs keep their size and orientation
+			box := inserted.box
+			mediaBox = fmt.Sprintf("[%g %g %g %g]", box[0], box[1], box[2], box[3])
+		}
+		page := fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox %s /Contents %d 0 R ",
+			pagesObjNum, mediaBox, p.contentObjs[i])
+		if inserted != nil && inserted.rotate != 0 {
+			page += fmt.Sprintf("/Rotate %d ", inserted.rotate)
+		}
+		page += "/Resources " + p.resourceDict(i, refs) + " "
+		if annots := pageAnnots[i]; annots != "" {
+			page += "/Annots [" + annots + "] "
+		}
+		if p.structRoot != nil {
+			page += fmt.Sprintf("/StructParents %d /Tabs /S ", i)
+		}
+		objects.object(pageObjStart+i, page+">>")
+	}
 
-	return output.Bytes(), nil
+	// Embedded font programs
+	for _, i := range fontObjects {
+		font, ok := p.embedded[p.fonts[i]]
+		if !ok {
+			continue
+		}
+		objects.object(descriptorObjNums[i], font.descriptor(descriptorObjNums[i]+1))
+		objects.flateStream(descriptorObjNums[i]+1, fmt.Sprintf(" /Length1 %d", len(font.data)), font.data)
+	}
+
+	// Shared images and graphics states
+	for _, x := range p.resources.xobjects {
+		for obj := x; obj != nil && xobjectNums[obj] != 0; obj = obj.smask {
+			dict := obj.dict
+			if obj.imported != nil {
+				dict += p.importedEntries(obj.imported, obj.entries, xobjectNums[obj])
+			}
+			if obj.smask != nil {
+				dict += fmt.Sprintf(" /SMask %d 0 R", xobjectNums[obj.smask])
+			}
+			if obj.flate {
+				objects.flateStream(xobjectNums[obj], dict, obj.data)
+			} else {
+				objects.stream(xobjectNums[obj], dict, obj.data)
+			}
+		}
+	}
+	for i, state := range p.resources.states {
+		if num := refs[fmt.Sprintf("GS%d", i+1)]; num != 0 {
+			objects.object(num, state)
+		}
+	}
+	// Objects copied from imported PDFs: fonts, images and the other resources of their pages
+	p.writeImported()
+
+	// PDF/A output intent and XMP metadata, which stays uncompressed
+	if p.pdfa {
+		objects.flateStream(iccObjNum, " /N 3", srgbProfile())
+	}
+	if metadataObjNum != 0 {
+		objects.stream(metadataObjNum, " /Type /Metadata /Subtype /XML", p.xmpMetadata(p.created, ua))
+	}
+
+	// Logical structure: the tree root, the parent tree mapping every MCID of a
+	// page back to its element, and the elements
+	if p.structRoot != nil {
+		objects.object(structTreeObjNum, fmt.Sprintf("<< /Type /StructTreeRoot /K %d 0 R /ParentTree %d 0 R /ParentTreeNextKey %d >>",
+			structObjNums[p.structRoot], parentTreeObjNum, numPages+len(p.annotations)))
+
+		var nums strings.Builder
+		for page := 0; page < numPages; page++ {
+			nums.WriteString(fmt.Sprintf("%d [", page))
+			if page < len(p.parentTree) {
+				for _, elem := range p.parentTree[page] {
+					nums.WriteString(fmt.Sprintf("%d 0 R ", structObjNums[elem]))
+				}
+			}
+			nums.WriteString("] ")
+		}
+		for k, a := range p.annotations {
+			// Annotations follow the pages in the parent tree, each with its element
+			if a.elem != nil {
+				nums.WriteString(fmt.Sprintf("%d %d 0 R ", numPages+k, structObjNums[a.elem]))
+			}
+		}
+		objects.object(parentTreeObjNum, "<< /Nums ["+nums.String()+"] >>")
+
+		pageObj := func(i int) int { return pageObjStart + i }
+		var writeElem func(e *structElem, parent int)
+		writeElem = func(e *structElem, parent int) {
+			objNum := structObjNums[e]
+			objects.object(objNum, structElemDict(e, objNum, parent, structObjNums, pageObj, pdfString))
+			for _, kid := range e.kids {
+				if kid.elem != nil {
+					writeElem(kid.elem, objNum)
+				}
+			}
+		}
+		writeElem(p.structRoot, structTreeObjNum)
+	}
+
+	// Bookmarks of the inserted PDFs, closed below their top-level entries
+	if outlineObjNum != 0 {
+		objects.object(outlineObjNum, fmt.Sprintf("<< /Type /Outlines /First %d 0 R /Last %d 0 R /Count %d >>",
+			outlineNums[p.outline[0]], outlineNums[p.outline[len(p.outline)-1]], len(p.outline)))
+		p.writeOutline(p.outline, outlineObjNum, outlineNums, func(i int) int { return pageObjStart + i })
+	}
+
+	// Embedded files and their icons on the pages
+	for _, a := range p.attachments {
+		p.writeAttachment(a, specNums[a])
+	}
+	if appearanceObjNum != 0 {
+		objects.stream(appearanceObjNum, " /Type /XObject /Subtype /Form /BBox [0 0 12 18]", []byte(paperclip))
+		for k, a := range p.annotations {
+			objects.object(a.num, p.annotationDict(a, specNums[a.file], appearanceObjNum, numPages+k))
+		}
+	}
+
+	// Document information, matching the XMP metadata
+	trailer := fmt.Sprintf("/Root %d 0 R", catalogObjNum)
+	if infoObjNum != 0 {
+		info := "<< "
+		if p.title != "" {
+			info += "/Title " + pdfString(infoObjNum, p.title) + " "
+		}
+		if p.author != "" {
+			info += "/Author " + pdfString(infoObjNum, p.author) + " "
+		}
+		info += fmt.Sprintf("/Creator %s /Producer %s ", pdfString(infoObjNum, producer), pdfString(infoObjNum, producer))
+		if !p.created.IsZero() {
+			date := pdfString(infoObjNum, pdfDate(p.created))
+			info += fmt.Sprintf("/CreationDate %s /ModDate %s ", date, date)
+		}
+		info += ">>"
+		objects.object(infoObjNum, info)
+		trailer += fmt.Sprintf(" /Info %d 0 R", infoObjNum)
+	}
+
+	// Encryption dictionary, not encrypted itself and never in an object stream
+	if security != nil {
+		objects.direct(encryptObjNum, security.dict)
+		trailer += fmt.Sprintf(" /Encrypt %d 0 R", encryptObjNum)
+	}
+	trailer += fmt.Sprintf(" /ID [<%x> <%x>]", p.fileID, p.fileID)
+
+	// Cross-reference table or stream, and trailer
+	return objects.finish(p.nextObj, trailer)
+}
+
+// documentID calcola l'identificatore del file dai metadati e dal contenuto delle
+// pagine, già scritte o ancora in memoria: documenti uguali hanno lo stesso /ID.
+// Nei documenti cifrati l'identificatore contiene anche byte casuali.
+func (p *PDFWriter) documentID() []byte {
+	h := md5.New()
+	if p.encryption != nil {
+		// The key of R3 and R4 is derived from the identifier, computed by StreamTo
+		// before the pages exist: without a nonce, documents with the same metadata
+		// and passwords would share the RC4 keystream
+		h.Write(randomBytes(16))
+	}
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00", p.title, p.author, p.lang)
+	if !p.created.IsZero() {
+		fmt.Fprint(h, p.created.Unix())
+	}
+	h.Write(p.contentHash.Sum(nil))
+	for _, imp := range p.imports {
+		// Pages drawn from other PDFs differ only in the imported file
+		h.Write(imp.key[:])
+	}
+	for _, a := range p.attachments {
+		fmt.Fprintf(h, "%s\x00%x\x00", a.Name, md5Sum(a.Data))
+	}
+	for _, page := range p.pageContents {
+		if page != nil {
+			h.Write(page.Bytes())
+		}
+	}
+	return h.Sum(nil)
 }
 
 // WriteTo implementa io.WriterTo
@@ -479,6 +1087,11 @@ func (p *PDFWriter) WriteTo(w io.Writer) (int64, error) {
 	return int64(n), err
 }
 
+// contentWidth restituisce la larghezza disponibile al rientro corrente
+func (p *PDFWriter) contentWidth() float64 {
+	return p.pageWidth - p.margin*2 - p.indent
+}
+
 // GetCurrentY restituisce la posizione Y corrente
 func (p *PDFWriter) GetCurrentY() float64 {
 	return p.yPosition
@@ -497,11 +1110,27 @@ func (p *PDFWriter) GetFontSize(textType string) float64 {
 	return p.fontSizes["normal"]
 }
 
-// WriteMetadata aggiunge metadati al PDF (semplificato)
+// WriteMetadata imposta titolo e autore del dizionario Info e dei metadati XMP
 func (p *PDFWriter) WriteMetadata(title, author string) {
-	// In una implementazione completa, questo aggiungerebbe un oggetto Info
-	// Per semplicità, lo omettiamo in questa versione base
-	_ = title
-	_ = author
-	_ = time.Now()
+	p.title = title
+	p.author = author
+}
+
+// SetCreationDate imposta la data di creazione e modifica scritta nel dizionario
+// Info e nei metadati XMP. Senza data il PDF non ne contiene, così conversioni
+// uguali producono file identici; SOURCE_DATE_EPOCH fornisce una data riproducibile.
+func (p *PDFWriter) SetCreationDate(t time.Time) {
+	p.creationDate = t
+}
+
+// documentDate restituisce la data da scrivere: quella impostata con
+// SetCreationDate, altrimenti SOURCE_DATE_EPOCH; zero se nessuna delle due
+func (p *PDFWriter) documentDate() time.Time {
+	if !p.creationDate.IsZero() {
+		return p.creationDate
+	}
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return time.Unix(epoch, 0).UTC()
+	}
+	return time.Time{}
 }
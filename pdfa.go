@@ -0,0 +1,256 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"html"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Livelli di conformità PDF/A
+const (
+	PDFA2B = "2b" // PDF/A-2b, conformità visiva (ISO 19005-2)
+	PDFA3B = "3b" // PDF/A-3b: come PDF/A-2b, con allegati di qualsiasi tipo (ISO 19005-3)
+)
+
+// PDFAError elenca i motivi per cui il documento non può essere conforme al livello PDF/A richiesto
+type PDFAError struct {
+	Level      string // PDFA2B o PDFA3B
+	Violations []string
+}
+
+// Error implementa l'interfaccia error
+func (e *PDFAError) Error() string {
+	return "PDF/A-" + e.Level + " non conforme: " + strings.Join(e.Violations, "; ")
+}
+
+// SetPDFALevel attiva l'output PDF/A al livello indicato, PDFA2B o PDFA3B; gli
+// allegati richiedono PDFA3B
+func (p *PDFWriter) SetPDFALevel(level string) error {
+	if level != PDFA2B && level != PDFA3B {
+		return fmt.Errorf("livello PDF/A sconosciuto %q (disponibili: %s, %s)", level, PDFA2B, PDFA3B)
+	}
+	p.pdfa = true
+	p.pdfaLevel = level
+	return nil
+}
+
+// validatePDFA controlla i vincoli di PDF/A che dipendono dal contenuto e dalle opzioni
+func (p *PDFWriter) validatePDFA(fonts []int) error {
+	var violations []string
+	if p.encryption != nil {
+		violations = append(violations, "la cifratura non è ammessa")
+	}
+	if p.pdfaLevel != PDFA3B && len(p.attachments) > 0 {
+		violations = append(violations, "gli allegati richiedono PDF/A-3b (SetPDFALevel(PDFA3B))")
+	}
+	for _, i := range fonts {
+		if _, ok := p.embedded[p.fonts[i]]; !ok {
+			violations = append(violations, fmt.Sprintf("il font %s non è incorporato (registra un font TrueType con EmbedFont)", p.fonts[i]))
+		}
+	}
+	for _, font := range p.importedFonts() {
+		violations = append(violations, fmt.Sprintf("il font %s di un PDF importato non è incorporato", font))
+	}
+	if violations != nil {
+		return &PDFAError{Level: p.pdfaLevel, Violations: violations}
+	}
+	return nil
+}
+
+// importedFonts restituisce i font non incorporati delle pagine importate usate nel documento
+func (p *PDFWriter) importedFonts() []string {
+	var fonts []string
+	for _, x := range p.resources.xobjects {
+		if !p.resources.used[x.name] {
+			continue
+		}
+		for _, font := range x.fonts {
+			if !containsString(fonts, font) {
+				fonts = append(fonts, font)
+			}
+		}
+	}
+	return fonts
+}
+
+// pdfDate formatta una data per il dizionario Info ("D:20240131143000+01'00'")
+func pdfDate(t time.Time) string {
+	_, offset := t.Zone()
+	sign := '+'
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	return fmt.Sprintf("D:%s%c%02d'%02d'", t.Format("20060102150405"), sign, offset/3600, offset%3600/60)
+}
+
+// textString codifica una stringa di testo del PDF: ASCII così com'è, altrimenti UTF-16BE con BOM
+func textString(s string) []byte {
+	ascii := true
+	for _, r := range s {
+		if r > 126 || r < 32 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return []byte(s)
+	}
+	b := []byte{0xFE, 0xFF}
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = binary.BigEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+// xmpMetadata crea il pacchetto XMP con l'identificazione PDF/A e, con ua, PDF/UA-1,
+// e gli stessi valori del dizionario Info
+func (p *PDFWriter) xmpMetadata(created time.Time, ua bool) []byte {
+	var sb strings.Builder
+	sb.WriteString("<?xpacket begin=\"\uFEFF\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	sb.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	sb.WriteString("<rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	sb.WriteString("<rdf:Description rdf:about=\"\"" +
+		" xmlns:pdfaid=\"http://www.aiim.org/pdfa/ns/id/\"" +
+		" xmlns:dc=\"http://purl.org/dc/elements/1.1/\"" +
+		" xmlns:xmp=\"http://ns.adobe.com/xap/1.0/\"" +
+		" xmlns:pdfuaid=\"http://www.aiim.org/pdfua/ns/id/\"" +
+		" xmlns:pdf=\"http://ns.adobe.com/pdf/1.3/\">\n")
+	if p.pdfa {
+		fmt.Fprintf(&sb, "<pdfaid:part>%s</pdfaid:part>\n", p.pdfaLevel[:1])
+		fmt.Fprintf(&sb, "<pdfaid:conformance>%s</pdfaid:conformance>\n", strings.ToUpper(p.pdfaLevel[1:]))
+	}
+	if ua {
+		sb.WriteString("<pdfuaid:part>1</pdfuaid:part>\n")
+	}
+	sb.WriteString("<dc:format>application/pdf</dc:format>\n")
+	if p.title != "" {
+		fmt.Fprintf(&sb, "<dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:title>\n", html.EscapeString(p.title))
+	}
+	if p.author != "" {
+		fmt.Fprintf(&sb, "<dc:creator><rdf:Seq><rdf:li>%s</rdf:li></rdf:Seq></dc:creator>\n", html.EscapeString(p.author))
+	}
+	if !created.IsZero() {
+		date := created.Format(time.RFC3339)
+		fmt.Fprintf(&sb, "<xmp:CreateDate>%s</xmp:CreateDate>\n", date)
+		fmt.Fprintf(&sb, "<xmp:ModifyDate>%s</xmp:ModifyDate>\n", date)
+	}
+	fmt.Fprintf(&sb, "<xmp:CreatorTool>%s</xmp:CreatorTool>\n", producer)
+	fmt.Fprintf(&sb, "<pdf:Producer>%s</pdf:Producer>\n", producer)
+	sb.WriteString("</rdf:Description>\n")
+	if p.pdfa && ua {
+		// PDF/A only accepts the pdfuaid schema with its description
+		sb.WriteString(pdfuaExtensionSchema)
+	}
+	sb.WriteString("</rdf:RDF>\n</x:xmpmeta>\n")
+	// Padding lets the metadata be edited in place
+	sb.WriteString(strings.Repeat(strings.Repeat(" ", 99)+"\n", 20))
+	sb.WriteString("<?xpacket end=\"w\"?>")
+	return []byte(sb.String())
+}
+
+// pdfuaExtensionSchema descrive lo schema pdfuaid per i validatori PDF/A
+const pdfuaExtensionSchema = `<rdf:Description rdf:about=""
+ xmlns:pdfaExtension="http://www.aiim.org/pdfa/ns/extension/"
+ xmlns:pdfaSchema="http://www.aiim.org/pdfa/ns/schema#"
+ xmlns:pdfaProperty="http://www.aiim.org/pdfa/ns/property#">
+<pdfaExtension:schemas><rdf:Bag><rdf:li rdf:parseType="Resource">
+<pdfaSchema:schema>PDF/UA Universal Accessibility Schema</pdfaSchema:schema>
+<pdfaSchema:namespaceURI>http://www.aiim.org/pdfua/ns/id/</pdfaSchema:namespaceURI>
+<pdfaSchema:prefix>pdfuaid</pdfaSchema:prefix>
+<pdfaSchema:property><rdf:Seq><rdf:li rdf:parseType="Resource">
+<pdfaProperty:name>part</pdfaProperty:name>
+<pdfaProperty:valueType>Integer</pdfaProperty:valueType>
+<pdfaProperty:category>internal</pdfaProperty:category>
+<pdfaProperty:description>Part of ISO 14289 followed by the document</pdfaProperty:description>
+</rdf:li></rdf:Seq></pdfaSchema:property>
+</rdf:li></rdf:Bag></pdfaExtension:schemas>
+</rdf:Description>
+`
+
+// producer è il nome del programma nei metadati del documento
+const producer = "Mark2PDF"
+
+// srgbProfile crea un profilo ICC v2 sRGB IEC61966-2.1 per l'output intent
+func srgbProfile() []byte {
+	s15 := func(b *bytes.Buffer, values ...float64) {
+		for _, v := range values {
+			binary.Write(b, binary.BigEndian, int32(math.Round(v*65536)))
+		}
+	}
+	xyz := func(x, y, z float64) []byte {
+		b := bytes.NewBufferString("XYZ \x00\x00\x00\x00")
+		s15(b, x, y, z)
+		return b.Bytes()
+	}
+	text := func(s string) []byte {
+		return append([]byte("text\x00\x00\x00\x00"+s), 0)
+	}
+	desc := func(s string) []byte {
+		b := bytes.NewBufferString("desc\x00\x00\x00\x00")
+		binary.Write(b, binary.BigEndian, uint32(len(s)+1))
+		b.WriteString(s + "\x00")
+		// No Unicode or ScriptCode description
+		b.Write(make([]byte, 4+4+2+1+67))
+		return b.Bytes()
+	}
+	// sRGB transfer function sampled on 1024 points
+	curve := bytes.NewBufferString("curv\x00\x00\x00\x00")
+	binary.Write(curve, binary.BigEndian, uint32(1024))
+	for i := 0; i < 1024; i++ {
+		v := float64(i) / 1023
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		binary.Write(curve, binary.BigEndian, uint16(math.Round(v*65535)))
+	}
+
+	// Colorants are adapted to the D50 illuminant of the PCS
+	tags := []struct {
+		signature string
+		data      []byte
+	}{
+		{"desc", desc("sRGB IEC61966-2.1")},
+		{"cprt", text("No copyright, use freely")},
+		{"wtpt", xyz(0.9505, 1, 1.0891)},
+		{"rXYZ", xyz(0.4361, 0.2225, 0.0139)},
+		{"gXYZ", xyz(0.3851, 0.7169, 0.0971)},
+		{"bXYZ", xyz(0.1431, 0.0606, 0.7141)},
+		{"rTRC", curve.Bytes()},
+		{"gTRC", curve.Bytes()},
+		{"bTRC", curve.Bytes()},
+	}
+
+	table := &bytes.Buffer{}
+	data := &bytes.Buffer{}
+	offset := 128 + 4 + 12*len(tags)
+	binary.Write(table, binary.BigEndian, uint32(len(tags)))
+	for _, tag := range tags {
+		table.WriteString(tag.signature)
+		binary.Write(table, binary.BigEndian, uint32(offset+data.Len()))
+		binary.Write(table, binary.BigEndian, uint32(len(tag.data)))
+		data.Write(tag.data)
+		for data.Len()%4 != 0 {
+			data.WriteByte(0)
+		}
+	}
+
+	header := &bytes.Buffer{}
+	binary.Write(header, binary.BigEndian, uint32(offset+data.Len()))
+	header.WriteString("\x00\x00\x00\x00")                                // preferred CMM
+	header.Write([]byte{0x02, 0x10, 0, 0})                                // version 2.1
+	header.WriteString("mntrRGB XYZ ")                                    // display device, RGB data, XYZ connection space
+	binary.Write(header, binary.BigEndian, []uint16{2024, 1, 1, 0, 0, 0}) // creation date
+	header.WriteString("acsp")
+	header.Write(make([]byte, 4+4+4+4+8+4)) // platform, flags, manufacturer, model, attributes, intent
+	s15(header, 0.9642, 1, 0.8249)          // D50 illuminant
+	header.Write(make([]byte, 128-header.Len()))
+
+	return append(append(header.Bytes(), table.Bytes()...), data.Bytes()...)
+}
@@ -0,0 +1,194 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// testFont crea un font TrueType minimo: i caratteri 32-255 hanno tutti larghezza advance
+func testFont(name string, advance uint16) []byte {
+	be := func(values ...any) []byte {
+		var b bytes.Buffer
+		for _, v := range values {
+			binary.Write(&b, binary.BigEndian, v)
+		}
+		return b.Bytes()
+	}
+	head := make([]byte, 54)
+	copy(head[18:], be(uint16(1000)))
+	copy(head[36:], be(int16(-100), int16(-200), int16(900), int16(800)))
+	hhea := make([]byte, 36)
+	copy(hhea[4:], be(int16(800), int16(-200)))
+	copy(hhea[34:], be(uint16(2)))
+	maxp := be(uint32(0x5000), uint16(1+224))
+	hmtx := be(uint16(500), int16(0), advance, int16(0))
+	// Segment 32-255 maps to glyphs 1-224, then the required 0xFFFF segment
+	cmap := be(uint16(0), uint16(1), uint16(3), uint16(1), uint32(12),
+		uint16(4), uint16(32), uint16(0), uint16(4), uint16(4), uint16(1), uint16(0),
+		uint16(255), uint16(0xFFFF), uint16(0), uint16(32), uint16(0xFFFF),
+		uint16(0x10000-31), uint16(1), uint16(0), uint16(0))
+	units := utf16.Encode([]rune(name))
+	nameTable := be(uint16(0), uint16(1), uint16(18), uint16(3), uint16(1), uint16(0x409), uint16(6), uint16(2*len(units)), uint16(0), units)
+	post := make([]byte, 32)
+	copy(post, be(uint32(0x30000)))
+
+	tables := []struct {
+		tag  string
+		data []byte
+	}{{"cmap", cmap}, {"head", head}, {"hhea", hhea}, {"hmtx", hmtx}, {"maxp", maxp}, {"name", nameTable}, {"post", post}}
+	font := be(uint32(0x10000), uint16(len(tables)), uint16(0), uint16(0), uint16(0))
+	offset := len(font) + 16*len(tables)
+	var data []byte
+	for _, table := range tables {
+		font = append(font, table.tag...)
+		font = append(font, be(uint32(0), uint32(offset+len(data)), uint32(len(table.data)))...)
+		data = append(data, table.data...)
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+	}
+	return append(font, data...)
+}
+
+func TestParseTrueType(t *testing.T) {
+	font, err := parseTrueType(testFont("Test Sans", 600))
+	if err != nil {
+		t.Fatalf("parseTrueType failed: %v", err)
+	}
+	if font.postScriptName != "TestSans" {
+		t.Errorf("Expected name %q, got %q", "TestSans", font.postScriptName)
+	}
+	if got := font.stringWidth("abc", 10); got != 18 {
+		t.Errorf("Expected width 18, got %v", got)
+	}
+	if got := font.charWidth('é'); got != 600 {
+		t.Errorf("Expected width 600 for a WinAnsi character, got %v", got)
+	}
+
+	for _, data := range [][]byte{nil, []byte("OTTO\x00\x00\x00\x00\x00\x00\x00\x00"), testFont("Test", 600)[:40]} {
+		if _, err := parseTrueType(data); err == nil {
+			t.Errorf("Expected an error for %q", data)
+		}
+	}
+}
+
+func TestPDFA(t *testing.T) {
+	const markdown = "---\ntitle: Relazione annuale\nauthor: Mario Rossi\n---\n\n# Risultati\n\nTesto **importante**\n"
+
+	t.Run("conforming", func(t *testing.T) {
+		converter := NewConverter(markdown)
+		converter.SetPDFA(true)
+		for _, name := range []string{"Helvetica", "Helvetica-Bold"} {
+			if err := converter.EmbedFont(name, testFont("Test-"+name, 600)); err != nil {
+				t.Fatalf("EmbedFont failed: %v", err)
+			}
+		}
+		data, err := converter.Convert()
+		if err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		for _, expected := range []string{
+			"%PDF-1.7",
+			"<pdfaid:part>2</pdfaid:part>",
+			"<pdfaid:conformance>B</pdfaid:conformance>",
+			"Relazione annuale</rdf:li>",
+			"/OutputIntents [<< /Type /OutputIntent /S /GTS_PDFA1",
+			"/Subtype /TrueType /BaseFont /Test-Helvetica-Bold",
+			"/FontFile2 ",
+			"/Length1 ",
+			"/ID [<",
+		} {
+			if !strings.Contains(string(data), expected) {
+				t.Errorf("Expected %q in the output", expected)
+			}
+		}
+		// Unused standard fonts are left out
+		if strings.Contains(string(data), "/BaseFont /Courier") {
+			t.Error("Expected unused fonts to be omitted")
+		}
+	})
+
+	t.Run("violations", func(t *testing.T) {
+		converter := NewConverter(markdown)
+		converter.SetPDFA(true)
+		converter.EmbedFont("Helvetica", testFont("Test", 600))
+		converter.SetEncryption(&Encryption{UserPassword: "secret"})
+		_, err := converter.Convert()
+		var pdfaErr *PDFAError
+		if !errors.As(err, &pdfaErr) {
+			t.Fatalf("Expected a PDFAError, got %v", err)
+		}
+		if len(pdfaErr.Violations) != 2 || !strings.Contains(err.Error(), "Helvetica-Bold") {
+			t.Errorf("Expected encryption and Helvetica-Bold violations, got %q", err)
+		}
+	})
+
+	t.Run("imported pages", func(t *testing.T) {
+		embedded := func(c *Converter) {
+			for _, name := range []string{"Helvetica", "Helvetica-Bold"} {
+				if err := c.EmbedFont(name, testFont("Test-"+name, 600)); err != nil {
+					t.Fatalf("EmbedFont failed: %v", err)
+				}
+			}
+		}
+		// A letterhead with embedded fonts, and one with the standard fonts
+		archival := NewConverter("# ACME S.p.A.\n\n**Via Roma 1, Milano**\n")
+		archival.SetPDFA(true)
+		embedded(archival)
+		conforming, err := archival.Convert()
+		if err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		standard := testLetterhead(t)
+
+		for _, tt := range []struct {
+			name       string
+			letterhead []byte
+			violation  bool
+		}{
+			{"embedded fonts", conforming, false},
+			{"standard fonts", standard, true},
+		} {
+			converter := NewConverter(markdown)
+			converter.SetPDFA(true)
+			embedded(converter)
+			if err := converter.AddBackground(&Background{PDF: tt.letterhead}); err != nil {
+				t.Fatalf("AddBackground failed: %v", err)
+			}
+			_, err := converter.Convert()
+			var pdfaErr *PDFAError
+			if errors.As(err, &pdfaErr) != tt.violation {
+				t.Fatalf("%s: expected a PDFAError only for fonts that are not embedded, got %v", tt.name, err)
+			}
+			if tt.violation && (len(pdfaErr.Violations) != 2 || !strings.Contains(err.Error(), "Helvetica-Bold di un PDF importato")) {
+				t.Errorf("%s: expected the fonts of the letterhead, got %q", tt.name, err)
+			}
+		}
+
+		// Merged pages are checked in the same way
+		path := filepath.Join(t.TempDir(), "annex.pdf")
+		if err := os.WriteFile(path, standard, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		converter := NewConverter(markdown)
+		converter.SetPDFA(true)
+		embedded(converter)
+		if err := converter.AppendPDF(path, ""); err != nil {
+			t.Fatalf("AppendPDF failed: %v", err)
+		}
+		var pdfaErr *PDFAError
+		if _, err := converter.Convert(); !errors.As(err, &pdfaErr) {
+			t.Errorf("Expected a PDFAError for the appended file, got %v", err)
+		}
+	})
+
+	if err := NewConverter("").EmbedFont("Arial", testFont("Test", 600)); err == nil {
+		t.Error("Expected an error for a font that is not standard")
+	}
+}
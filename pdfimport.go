@@ -0,0 +1,201 @@
+package mark2pdf
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pdfImport copia nel documento gli oggetti di un PDF esistente, rinumerandoli:
+// ogni oggetto del file è scritto una sola volta, anche se è usato da più pagine
+// importate (ad esempio i font di una carta intestata)
+type pdfImport struct {
+	key    [sha256.Size]byte // impronta del file
+	reader *pdfReader
+	nums   map[int]int // oggetto del file -> oggetto del documento
+	queue  []int       // oggetti del file numerati ma non ancora scritti
+}
+
+// importPDF legge un file PDF da cui importare oggetti; lo stesso file è letto una volta sola
+func (p *PDFWriter) importPDF(data []byte) (*pdfImport, error) {
+	key := sha256.Sum256(data)
+	for _, imp := range p.imports {
+		if imp.key == key {
+			return imp, nil
+		}
+	}
+	reader, err := readPDF(data)
+	if err != nil {
+		return nil, fmt.Errorf("PDF importato: %w", err)
+	}
+	imp := &pdfImport{key: key, reader: reader, nums: make(map[int]int)}
+	p.imports = append(p.imports, imp)
+	return imp, nil
+}
+
+// importedRef restituisce il numero nel documento dell'oggetto num del file,
+// assegnandolo alla prima richiesta; 0 se l'oggetto non esiste
+func (p *PDFWriter) importedRef(imp *pdfImport, num int) int {
+	if n, ok := imp.nums[num]; ok {
+		return n
+	}
+	if obj, err := imp.reader.object(num); err != nil || obj == nil {
+		return 0
+	}
+	n := p.allocObject()
+	imp.nums[num] = n
+	imp.queue = append(imp.queue, num)
+	return n
+}
+
+// importedValue scrive un valore del file nella sintassi PDF, rinumerando i
+// riferimenti; num è l'oggetto del documento che lo contiene
+func (p *PDFWriter) importedValue(imp *pdfImport, v any, num int) string {
+	switch v := v.(type) {
+	case bool:
+		return strconv.FormatBool(v)
+	case pdfNumber:
+		return string(v)
+	case pdfName:
+		return nameString(v)
+	case pdfString:
+		return p.output.byteString(num, v)
+	case pdfArray:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = p.importedValue(imp, item, num)
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	case pdfDict:
+		return "<<" + p.importedEntries(imp, v, num) + " >>"
+	case pdfRef:
+		if n := p.importedRef(imp, v.num); n != 0 {
+			return fmt.Sprintf("%d 0 R", n)
+		}
+	}
+	return "null"
+}
+
+// importedEntries scrive le voci di un dizionario del file, in ordine di chiave
+// e tranne quelle in skip, ciascuna preceduta da uno spazio
+func (p *PDFWriter) importedEntries(imp *pdfImport, dict pdfDict, num int, skip ...string) string {
+	keys := make([]string, 0, len(dict))
+	for key := range dict {
+		if !containsString(skip, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&sb, " %s %s", nameString(pdfName(key)), p.importedValue(imp, dict[key], num))
+	}
+	return sb.String()
+}
+
+// writeImported scrive gli oggetti dei file importati a cui fanno riferimento gli
+// oggetti già scritti, e quelli a cui questi fanno riferimento a loro volta
+func (p *PDFWriter) writeImported() {
+	for _, imp := range p.imports {
+		for len(imp.queue) > 0 {
+			src := imp.queue[0]
+			imp.queue = imp.queue[1:]
+			num := imp.nums[src]
+			obj, _ := imp.reader.object(src)
+			if stream, ok := obj.(*pdfStream); ok {
+				// The data is copied still encoded, with its /Filter
+				p.output.stream(num, p.importedEntries(imp, stream.dict, num, "Length"), stream.data)
+			} else {
+				p.output.object(num, p.importedValue(imp, obj, num))
+			}
+		}
+	}
+}
+
+// unembeddedFonts restituisce i nomi dei font non incorporati in un dizionario
+// di risorse del file e nei Form XObject che usa
+func (r *pdfReader) unembeddedFonts(resources any) []string {
+	var names []string
+	seen := make(map[pdfRef]bool)
+	var visit func(resources any)
+	visit = func(resources any) {
+		if ref, ok := resources.(pdfRef); ok {
+			if seen[ref] {
+				return
+			}
+			seen[ref] = true
+		}
+		res, _ := r.resolve(resources).(pdfDict)
+		fonts, _ := r.resolve(res["Font"]).(pdfDict)
+		keys := make([]string, 0, len(fonts))
+		for key := range fonts {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			font, _ := r.resolve(fonts[key]).(pdfDict)
+			if font == nil || r.fontEmbedded(font) {
+				continue
+			}
+			name := key
+			if base, ok := r.resolve(font["BaseFont"]).(pdfName); ok {
+				name = string(base)
+			}
+			if !containsString(names, name) {
+				names = append(names, name)
+			}
+		}
+		xobjects, _ := r.resolve(res["XObject"]).(pdfDict)
+		keys = keys[:0]
+		for key := range xobjects {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if form, ok := r.resolve(xobjects[key]).(*pdfStream); ok && form.dict["Subtype"] == pdfName("Form") {
+				visit(form.dict["Resources"])
+			}
+		}
+	}
+	visit(resources)
+	return names
+}
+
+// fontEmbedded indica se il programma di un font del file è incorporato; i font
+// Type 3 sono descritti nel file stesso
+func (r *pdfReader) fontEmbedded(font pdfDict) bool {
+	switch r.resolve(font["Subtype"]) {
+	case pdfName("Type3"):
+		return true
+	case pdfName("Type0"):
+		descendants, _ := r.resolve(font["DescendantFonts"]).(pdfArray)
+		if len(descendants) == 0 {
+			return false
+		}
+		font, _ = r.resolve(descendants[0]).(pdfDict)
+	}
+	descriptor, _ := r.resolve(font["FontDescriptor"]).(pdfDict)
+	for _, key := range []string{"FontFile", "FontFile2", "FontFile3"} {
+		if descriptor[key] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// nameString scrive un nome PDF, con le sequenze #xx per i caratteri speciali
+func nameString(name pdfName) string {
+	var sb strings.Builder
+	sb.WriteByte('/')
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c < '!' || c > '~' || c == '#' || isPDFDelimiter(c) {
+			fmt.Fprintf(&sb, "#%02X", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
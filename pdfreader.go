@@ -0,0 +1,1053 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Valori di un PDF letto da pdfReader; i booleani sono bool e null è nil
+type (
+	pdfName   string // nome, senza la barra iniziale
+	pdfNumber string // numero, come scritto nel file
+	pdfString []byte
+	pdfArray  []any
+	pdfDict   map[string]any // chiavi senza la barra iniziale
+	pdfRef    struct{ num, gen int }
+)
+
+// pdfStream è un oggetto stream, con i dati ancora codificati secondo /Filter
+type pdfStream struct {
+	dict pdfDict
+	data []byte
+}
+
+// pdfReader legge gli oggetti di un file PDF esistente. Supporta le tabelle xref
+// classiche e i cross-reference stream, gli object stream e gli aggiornamenti
+// incrementali; se la tabella xref è danneggiata gli oggetti sono cercati nel file.
+// I PDF cifrati non sono supportati.
+type pdfReader struct {
+	data     []byte
+	xref     map[int]xrefEntry
+	trailer  pdfDict
+	objects  map[int]any     // oggetti già letti
+	objStms  map[int]*objStm // object stream già decodificati
+	loading  map[int]bool    // oggetti in lettura, per riconoscere i riferimenti circolari
+	pageNums map[int]int     // oggetto di ogni pagina -> indice della pagina, impostato da pages
+}
+
+// xrefEntry è la posizione di un oggetto: un offset nel file o un object stream
+type xrefEntry struct {
+	offset   int // offset nel file, o numero dell'object stream
+	index    int // indice nell'object stream
+	inStream bool
+	free     bool
+}
+
+// objStm è un object stream decodificato
+type objStm struct {
+	data    []byte
+	offsets map[int]int // indice -> posizione dell'oggetto nei dati
+}
+
+var errPDFEnd = errors.New("fine inattesa del file")
+
+// readPDF analizza un file PDF
+func readPDF(data []byte) (*pdfReader, error) {
+	// The header may follow some garbage, as viewers tolerate
+	if !bytes.Contains(data[:min(len(data), 1024)], []byte("%PDF-")) {
+		return nil, errors.New("il file non è un PDF")
+	}
+	r := &pdfReader{
+		data:    data,
+		xref:    make(map[int]xrefEntry),
+		objects: make(map[int]any),
+		objStms: make(map[int]*objStm),
+		loading: make(map[int]bool),
+	}
+	if err := r.readXrefChain(); err != nil || r.root() == nil {
+		// Damaged cross-reference data: the objects are located by scanning the file
+		if err := r.reconstruct(); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := r.trailer["Encrypt"]; ok {
+		return nil, errors.New("i PDF cifrati non sono supportati")
+	}
+	if r.root() == nil {
+		return nil, errors.New("catalogo del PDF non trovato")
+	}
+	return r, nil
+}
+
+// root restituisce il catalogo del documento
+func (r *pdfReader) root() pdfDict {
+	root, _ := r.resolve(r.trailer["Root"]).(pdfDict)
+	return root
+}
+
+// readXrefChain legge le sezioni xref a partire da startxref, seguendo /Prev
+func (r *pdfReader) readXrefChain() error {
+	i := bytes.LastIndex(r.data, []byte("startxref"))
+	if i < 0 {
+		return errors.New("startxref non trovato")
+	}
+	p := &pdfParser{data: r.data, pos: i + len("startxref")}
+	offset, err := strconv.Atoi(p.token())
+	if err != nil {
+		return errors.New("startxref non valido")
+	}
+	seen := make(map[int]bool)
+	for offset > 0 && offset < len(r.data) && !seen[offset] {
+		seen[offset] = true
+		var trailer pdfDict
+		p := &pdfParser{data: r.data, pos: offset}
+		if p.token() == "xref" {
+			if trailer, err = r.readXrefTable(p); err != nil {
+				return err
+			}
+			// Hybrid files also have a cross-reference stream for the compressed objects
+			if stm, ok := intValue(trailer["XRefStm"]); ok {
+				if _, err := r.readXrefStream(stm); err != nil {
+					return err
+				}
+			}
+		} else if trailer, err = r.readXrefStream(offset); err != nil {
+			return err
+		}
+		if r.trailer == nil {
+			r.trailer = trailer
+		}
+		offset, _ = intValue(trailer["Prev"])
+	}
+	if r.trailer == nil {
+		return errors.New("trailer non trovato")
+	}
+	return nil
+}
+
+// addXref registra la posizione di un oggetto; le sezioni più recenti, lette per
+// prime, prevalgono su quelle precedenti
+func (r *pdfReader) addXref(num int, e xrefEntry) {
+	if _, ok := r.xref[num]; !ok {
+		r.xref[num] = e
+	}
+}
+
+// readXrefTable legge una tabella xref classica e il trailer che la segue
+func (r *pdfReader) readXrefTable(p *pdfParser) (pdfDict, error) {
+	for {
+		tok := p.token()
+		if tok == "trailer" {
+			v, err := p.value()
+			trailer, ok := v.(pdfDict)
+			if err != nil || !ok {
+				return nil, errors.New("trailer non valido")
+			}
+			return trailer, nil
+		}
+		start, err1 := strconv.Atoi(tok)
+		count, err2 := strconv.Atoi(p.token())
+		if err1 != nil || err2 != nil {
+			return nil, errors.New("tabella xref non valida")
+		}
+		for i := 0; i < count; i++ {
+			offset, err := strconv.Atoi(p.token())
+			p.token() // generation
+			kind := p.token()
+			if err != nil || (kind != "n" && kind != "f") {
+				return nil, errors.New("voce xref non valida")
+			}
+			r.addXref(start+i, xrefEntry{offset: offset, free: kind == "f"})
+		}
+	}
+}
+
+// readXrefStream legge un cross-reference stream all'offset indicato
+func (r *pdfReader) readXrefStream(offset int) (pdfDict, error) {
+	v, err := r.objectAt(offset, -1)
+	if err != nil {
+		return nil, err
+	}
+	stream, ok := v.(*pdfStream)
+	if !ok || stream.dict["Type"] != pdfName("XRef") {
+		return nil, errors.New("cross-reference stream non valido")
+	}
+	data, err := r.decode(stream)
+	if err != nil {
+		return nil, err
+	}
+	var widths [3]int
+	w, _ := r.resolve(stream.dict["W"]).(pdfArray)
+	for i := 0; i < len(w) && i < 3; i++ {
+		widths[i], _ = intValue(w[i])
+	}
+	size, _ := intValue(stream.dict["Size"])
+	index := []int{0, size}
+	if idx, ok := r.resolve(stream.dict["Index"]).(pdfArray); ok {
+		index = index[:0]
+		for _, n := range idx {
+			i, _ := intValue(n)
+			index = append(index, i)
+		}
+	}
+	field := func(row []byte) int {
+		n := 0
+		for _, b := range row {
+			n = n<<8 | int(b)
+		}
+		return n
+	}
+	rowLen := widths[0] + widths[1] + widths[2]
+	pos := 0
+	for i := 0; i+1 < len(index); i += 2 {
+		for num := index[i]; num < index[i]+index[i+1] && pos+rowLen <= len(data); num++ {
+			row := data[pos : pos+rowLen]
+			pos += rowLen
+			kind := 1
+			if widths[0] > 0 {
+				kind = field(row[:widths[0]])
+			}
+			a, b := field(row[widths[0]:widths[0]+widths[1]]), field(row[widths[0]+widths[1]:])
+			switch kind {
+			case 0:
+				r.addXref(num, xrefEntry{free: true})
+			case 1:
+				r.addXref(num, xrefEntry{offset: a})
+			case 2:
+				r.addXref(num, xrefEntry{offset: a, index: b, inStream: true})
+			}
+		}
+	}
+	return stream.dict, nil
+}
+
+// objectHeader riconosce l'inizio di un oggetto: "12 0 obj"
+var objectHeader = regexp.MustCompile(`(?m)(?:^|[\s>])(\d+)\s+(\d+)\s+obj\b`)
+
+// reconstruct ricostruisce la tabella xref cercando gli oggetti nel file; il
+// trailer è l'ultimo del file o, in sua assenza, punta al catalogo trovato
+func (r *pdfReader) reconstruct() error {
+	r.xref = make(map[int]xrefEntry)
+	r.objects = make(map[int]any)
+	r.objStms = make(map[int]*objStm)
+	r.trailer = nil
+	for _, m := range objectHeader.FindAllSubmatchIndex(r.data, -1) {
+		num, _ := strconv.Atoi(string(r.data[m[2]:m[3]]))
+		r.xref[num] = xrefEntry{offset: m[2]} // the last definition wins
+	}
+	if i := bytes.LastIndex(r.data, []byte("trailer")); i >= 0 {
+		p := &pdfParser{data: r.data, pos: i + len("trailer")}
+		if v, err := p.value(); err == nil {
+			r.trailer, _ = v.(pdfDict)
+		}
+	}
+	if r.trailer == nil || r.root() == nil {
+		nums := make([]int, 0, len(r.xref))
+		for num := range r.xref {
+			nums = append(nums, num)
+		}
+		sort.Ints(nums)
+		for _, num := range nums {
+			if dict, ok := r.resolve(pdfRef{num: num}).(pdfDict); ok && dict["Type"] == pdfName("Catalog") {
+				r.trailer = pdfDict{"Root": pdfRef{num: num}}
+				break
+			}
+		}
+	}
+	if r.trailer == nil {
+		return errors.New("struttura del PDF non riconosciuta")
+	}
+	return nil
+}
+
+// object restituisce l'oggetto indiretto num; un oggetto mancante è null
+func (r *pdfReader) object(num int) (any, error) {
+	if v, ok := r.objects[num]; ok {
+		return v, nil
+	}
+	e, ok := r.xref[num]
+	if !ok || e.free {
+		return nil, nil
+	}
+	if r.loading[num] {
+		return nil, fmt.Errorf("riferimento circolare all'oggetto %d", num)
+	}
+	r.loading[num] = true
+	defer delete(r.loading, num)
+
+	var v any
+	var err error
+	if e.inStream {
+		v, err = r.compressedObject(e.offset, e.index)
+	} else {
+		v, err = r.objectAt(e.offset, num)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("oggetto %d: %w", num, err)
+	}
+	r.objects[num] = v
+	return v, nil
+}
+
+// resolve restituisce il valore di un riferimento, o il valore stesso; gli
+// oggetti illeggibili valgono null
+func (r *pdfReader) resolve(v any) any {
+	if ref, ok := v.(pdfRef); ok {
+		v, _ = r.object(ref.num)
+	}
+	return v
+}
+
+// objectAt legge l'oggetto che inizia all'offset indicato; num < 0 accetta qualsiasi numero
+func (r *pdfReader) objectAt(offset, num int) (any, error) {
+	if offset < 0 || offset >= len(r.data) {
+		// Cross-reference entries of truncated or damaged files
+		return nil, fmt.Errorf("offset %d fuori dal file", offset)
+	}
+	p := &pdfParser{data: r.data, pos: offset}
+	n, err := strconv.Atoi(p.token())
+	p.token() // generation
+	if err != nil || p.token() != "obj" || (num >= 0 && n != num) {
+		return nil, fmt.Errorf("oggetto non trovato all'offset %d", offset)
+	}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	dict, ok := v.(pdfDict)
+	if !ok {
+		return v, nil
+	}
+	save := p.pos
+	if p.token() != "stream" {
+		p.pos = save
+		return dict, nil
+	}
+	// The data starts after the end of line following the keyword
+	if p.pos < len(r.data) && r.data[p.pos] == '\r' {
+		p.pos++
+	}
+	if p.pos < len(r.data) && r.data[p.pos] == '\n' {
+		p.pos++
+	}
+	start := p.pos
+	length, ok := intValue(r.resolve(dict["Length"]))
+	if !ok || length < 0 || start+length > len(r.data) ||
+		!bytes.HasPrefix(bytes.TrimLeft(r.data[start+length:], "\r\n \t"), []byte("endstream")) {
+		// Wrong /Length: the data ends at the endstream keyword
+		end := bytes.Index(r.data[start:], []byte("endstream"))
+		if end < 0 {
+			return nil, errors.New("endstream non trovato")
+		}
+		length = len(bytes.TrimRight(r.data[start:start+end], "\r\n"))
+	}
+	return &pdfStream{dict: dict, data: r.data[start : start+length]}, nil
+}
+
+// compressedObject legge l'oggetto di indice index dell'object stream stm
+func (r *pdfReader) compressedObject(stm, index int) (any, error) {
+	s, ok := r.objStms[stm]
+	if !ok {
+		v, err := r.object(stm)
+		if err != nil {
+			return nil, err
+		}
+		stream, ok := v.(*pdfStream)
+		if !ok {
+			return nil, fmt.Errorf("object stream %d non valido", stm)
+		}
+		data, err := r.decode(stream)
+		if err != nil {
+			return nil, err
+		}
+		n, _ := intValue(stream.dict["N"])
+		first, _ := intValue(stream.dict["First"])
+		s = &objStm{data: data, offsets: make(map[int]int)}
+		p := &pdfParser{data: data}
+		for i := 0; i < n; i++ {
+			p.token() // object number
+			offset, err := strconv.Atoi(p.token())
+			if err != nil {
+				return nil, fmt.Errorf("object stream %d non valido", stm)
+			}
+			s.offsets[i] = first + offset
+		}
+		r.objStms[stm] = s
+	}
+	offset, ok := s.offsets[index]
+	if !ok || offset < 0 || offset >= len(s.data) {
+		return nil, fmt.Errorf("oggetto %d mancante nell'object stream %d", index, stm)
+	}
+	p := &pdfParser{data: s.data, pos: offset}
+	return p.value()
+}
+
+// decode restituisce i dati decodificati di uno stream; è supportato FlateDecode,
+// con i predittori PNG
+func (r *pdfReader) decode(s *pdfStream) ([]byte, error) {
+	var filters, params pdfArray
+	switch f := r.resolve(s.dict["Filter"]).(type) {
+	case pdfName:
+		filters = pdfArray{f}
+	case pdfArray:
+		filters = f
+	}
+	switch d := r.resolve(s.dict["DecodeParms"]).(type) {
+	case pdfDict:
+		params = pdfArray{d}
+	case pdfArray:
+		params = d
+	}
+	data := s.data
+	for i, f := range filters {
+		var parms pdfDict
+		if i < len(params) {
+			parms, _ = r.resolve(params[i]).(pdfDict)
+		}
+		switch r.resolve(f) {
+		case pdfName("FlateDecode"), pdfName("Fl"):
+			zr, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("stream FlateDecode non valido: %w", err)
+			}
+			out, err := io.ReadAll(zr)
+			if err != nil && (err != io.ErrUnexpectedEOF || len(out) == 0) {
+				// Truncated streams are accepted, as viewers do
+				return nil, fmt.Errorf("stream FlateDecode non valido: %w", err)
+			}
+			if data, err = unpredict(out, parms); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("filtro %v non supportato", f)
+		}
+	}
+	return data, nil
+}
+
+// unpredict annulla i predittori PNG (/Predictor 10-15) applicati prima della compressione
+func unpredict(data []byte, parms pdfDict) ([]byte, error) {
+	predictor, _ := intValue(parms["Predictor"])
+	if predictor < 10 {
+		if predictor > 1 {
+			return nil, fmt.Errorf("predittore %d non supportato", predictor)
+		}
+		return data, nil
+	}
+	param := func(key string, def int) int {
+		if v, ok := intValue(parms[key]); ok {
+			return v
+		}
+		return def
+	}
+	colors, bits, columns := param("Colors", 1), param("BitsPerComponent", 8), param("Columns", 1)
+	bpp := max(1, colors*bits/8)
+	rowLen := (colors*bits*columns + 7) / 8
+	var out []byte
+	prev := make([]byte, rowLen)
+	for pos := 0; pos+1+rowLen <= len(data); pos += 1 + rowLen {
+		kind, row := data[pos], append([]byte{}, data[pos+1:pos+1+rowLen]...)
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = row[i-bpp], prev[i-bpp]
+			}
+			up := prev[i]
+			switch kind {
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+// paeth è il predittore Paeth dei PNG
+func paeth(a, b, c byte) byte {
+	distance := func(n int) int {
+		if n < 0 {
+			return -n
+		}
+		return n
+	}
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := distance(p-int(a)), distance(p-int(b)), distance(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+// pages restituisce i dizionari delle pagine in ordine, con gli attributi
+// ereditati dai nodi dell'albero (/Resources, /MediaBox, /CropBox, /Rotate)
+func (r *pdfReader) pages() ([]pdfDict, error) {
+	var pages []pdfDict
+	r.pageNums = make(map[int]int)
+	visited := make(map[pdfRef]bool)
+	var walk func(node any, inherited pdfDict) error
+	walk = func(node any, inherited pdfDict) error {
+		if ref, ok := node.(pdfRef); ok {
+			if visited[ref] {
+				return errors.New("albero delle pagine circolare")
+			}
+			visited[ref] = true
+		}
+		dict, ok := r.resolve(node).(pdfDict)
+		if !ok {
+			return nil
+		}
+		attrs := make(pdfDict)
+		for k, v := range inherited {
+			attrs[k] = v
+		}
+		for _, key := range []string{"Resources", "MediaBox", "CropBox", "Rotate"} {
+			if v, ok := dict[key]; ok {
+				attrs[key] = v
+			}
+		}
+		if kids, ok := r.resolve(dict["Kids"]).(pdfArray); ok && dict["Type"] != pdfName("Page") {
+			for _, kid := range kids {
+				if err := walk(kid, attrs); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		page := make(pdfDict, len(dict)+len(attrs))
+		for k, v := range attrs {
+			page[k] = v
+		}
+		for k, v := range dict {
+			page[k] = v
+		}
+		if ref, ok := node.(pdfRef); ok {
+			r.pageNums[ref.num] = len(pages)
+		}
+		pages = append(pages, page)
+		return nil
+	}
+	if err := walk(r.root()["Pages"], nil); err != nil {
+		return nil, err
+	}
+	if len(pages) == 0 {
+		return nil, errors.New("il PDF non ha pagine")
+	}
+	return pages, nil
+}
+
+// pageContent restituisce il contenuto decodificato di una pagina, concatenando
+// gli stream di /Contents
+func (r *pdfReader) pageContent(page pdfDict) ([]byte, error) {
+	var streams pdfArray
+	switch c := r.resolve(page["Contents"]).(type) {
+	case *pdfStream:
+		streams = pdfArray{c}
+	case pdfArray:
+		streams = c
+	}
+	var content bytes.Buffer
+	for _, s := range streams {
+		stream, ok := r.resolve(s).(*pdfStream)
+		if !ok {
+			continue
+		}
+		data, err := r.decode(stream)
+		if err != nil {
+			return nil, err
+		}
+		content.Write(data)
+		content.WriteByte('\n')
+	}
+	return content.Bytes(), nil
+}
+
+// pageBox restituisce il riquadro visibile della pagina: /CropBox o /MediaBox
+func (r *pdfReader) pageBox(page pdfDict) [4]float64 {
+	box := [4]float64{0, 0, 595.28, 841.89}
+	for _, key := range []string{"CropBox", "MediaBox"} {
+		arr, ok := r.resolve(page[key]).(pdfArray)
+		if !ok || len(arr) != 4 {
+			continue
+		}
+		for i, v := range arr {
+			box[i], _ = floatValue(r.resolve(v))
+		}
+		// Normalize the corners
+		box = [4]float64{min(box[0], box[2]), min(box[1], box[3]), max(box[0], box[2]), max(box[1], box[3])}
+		break
+	}
+	return box
+}
+
+// info restituisce una voce di testo del dizionario /Info, "" se manca
+func (r *pdfReader) info(key string) string {
+	info, _ := r.resolve(r.trailer["Info"]).(pdfDict)
+	s, _ := r.resolve(info[key]).(pdfString)
+	return textValue(s)
+}
+
+// outline legge i segnalibri del PDF (dopo pages); page converte l'indice di una
+// pagina del file in quello della pagina del documento, -1 se non è importata.
+// Le voci senza destinazione importata sono omesse, salvo quelle con figli.
+func (r *pdfReader) outline(page func(i int) int) []*outlineItem {
+	root, _ := r.resolve(r.root()["Outlines"]).(pdfDict)
+	seen := make(map[int]bool)
+	var items func(first any) []*outlineItem
+	items = func(first any) []*outlineItem {
+		var list []*outlineItem
+		for node := first; ; {
+			ref, ok := node.(pdfRef)
+			if !ok || seen[ref.num] {
+				return list
+			}
+			seen[ref.num] = true
+			dict, ok := r.resolve(ref).(pdfDict)
+			if !ok {
+				return list
+			}
+			item := &outlineItem{page: -1, kids: items(dict["First"])}
+			if title, ok := r.resolve(dict["Title"]).(pdfString); ok {
+				item.title = textValue(title)
+			}
+			dest := dict["Dest"]
+			if action, ok := r.resolve(dict["A"]).(pdfDict); ok && action["S"] == pdfName("GoTo") {
+				dest = action["D"]
+			}
+			if i, ok := r.destPage(dest); ok {
+				item.page = page(i)
+			}
+			if item.page >= 0 || len(item.kids) > 0 {
+				list = append(list, item)
+			}
+			node = dict["Next"]
+		}
+	}
+	if root == nil {
+		return nil
+	}
+	return items(root["First"])
+}
+
+// destPage restituisce l'indice della pagina di una destinazione: un array
+// [pagina /Fit ...], un nome di /Dests o una stringa dell'albero dei nomi /Dests
+func (r *pdfReader) destPage(dest any) (int, bool) {
+	dest = r.resolve(dest)
+	switch d := dest.(type) {
+	case pdfName:
+		dests, _ := r.resolve(r.root()["Dests"]).(pdfDict)
+		dest = r.resolve(dests[string(d)])
+	case pdfString:
+		names, _ := r.resolve(r.root()["Names"]).(pdfDict)
+		dest = r.nameTree(names["Dests"], string(d))
+	}
+	if d, ok := dest.(pdfDict); ok {
+		dest = r.resolve(d["D"])
+	}
+	arr, ok := dest.(pdfArray)
+	if !ok || len(arr) == 0 {
+		return 0, false
+	}
+	ref, ok := arr[0].(pdfRef)
+	if !ok {
+		return 0, false
+	}
+	i, ok := r.pageNums[ref.num]
+	return i, ok
+}
+
+// nameTree cerca una chiave in un albero dei nomi; nil se non c'è
+func (r *pdfReader) nameTree(node any, key string) any {
+	seen := make(map[int]bool)
+	var find func(node any) any
+	find = func(node any) any {
+		if ref, ok := node.(pdfRef); ok {
+			if seen[ref.num] {
+				return nil
+			}
+			seen[ref.num] = true
+		}
+		dict, ok := r.resolve(node).(pdfDict)
+		if !ok {
+			return nil
+		}
+		names, _ := r.resolve(dict["Names"]).(pdfArray)
+		for i := 0; i+1 < len(names); i += 2 {
+			if k, ok := r.resolve(names[i]).(pdfString); ok && string(k) == key {
+				return r.resolve(names[i+1])
+			}
+		}
+		kids, _ := r.resolve(dict["Kids"]).(pdfArray)
+		for _, kid := range kids {
+			if v := find(kid); v != nil {
+				return v
+			}
+		}
+		return nil
+	}
+	return find(node)
+}
+
+// textValue decodifica una stringa di testo: UTF-16BE o UTF-8 con BOM, altrimenti
+// PDFDocEncoding, approssimata con WinAnsiEncoding
+func textValue(s pdfString) string {
+	if len(s) >= 2 && s[0] == 0xFE && s[1] == 0xFF {
+		units := make([]uint16, 0, len(s)/2)
+		for i := 2; i+1 < len(s); i += 2 {
+			units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	if text, ok := bytes.CutPrefix(s, []byte("\xEF\xBB\xBF")); ok {
+		return string(text)
+	}
+	var sb strings.Builder
+	for _, b := range s {
+		if r, ok := winAnsiRune(b); ok {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteRune(rune(b))
+		}
+	}
+	return sb.String()
+}
+
+// closure legge tutti gli oggetti raggiungibili da v, così che un file
+// danneggiato sia segnalato prima di iniziare a copiarne gli oggetti
+func (r *pdfReader) closure(v any, skip ...string) error {
+	seen := make(map[int]bool)
+	var visit func(v any) error
+	visit = func(v any) error {
+		switch v := v.(type) {
+		case pdfRef:
+			if seen[v.num] {
+				return nil
+			}
+			seen[v.num] = true
+			obj, err := r.object(v.num)
+			if err != nil {
+				return err
+			}
+			return visit(obj)
+		case *pdfStream:
+			return visit(v.dict)
+		case pdfArray:
+			for _, item := range v {
+				if err := visit(item); err != nil {
+					return err
+				}
+			}
+		case pdfDict:
+			for k, item := range v {
+				if !containsString(skip, k) {
+					if err := visit(item); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}
+	return visit(v)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// intValue restituisce il valore intero di un numero
+func intValue(v any) (int, bool) {
+	n, ok := v.(pdfNumber)
+	if !ok {
+		return 0, false
+	}
+	if i, err := strconv.Atoi(string(n)); err == nil {
+		return i, true
+	}
+	f, err := strconv.ParseFloat(string(n), 64)
+	return int(f), err == nil
+}
+
+// floatValue restituisce il valore di un numero
+func floatValue(v any) (float64, bool) {
+	n, ok := v.(pdfNumber)
+	if !ok {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(string(n), 64)
+	return f, err == nil
+}
+
+// maxPDFNesting è il numero massimo di array e dizionari annidati: un file
+// costruito ad arte potrebbe altrimenti esaurire lo stack
+const maxPDFNesting = 512
+
+// pdfParser legge token e oggetti della sintassi PDF
+type pdfParser struct {
+	data  []byte
+	pos   int
+	depth int // array e dizionari aperti
+}
+
+func isPDFSpace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// skipSpace salta spazi e commenti
+func (p *pdfParser) skipSpace() {
+	for p.pos < len(p.data) {
+		switch c := p.data[p.pos]; {
+		case isPDFSpace(c):
+			p.pos++
+		case c == '%':
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' && p.data[p.pos] != '\r' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// token legge un token regolare (numero o parola chiave)
+func (p *pdfParser) token() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.data) && !isPDFSpace(p.data[p.pos]) && !isPDFDelimiter(p.data[p.pos]) {
+		p.pos++
+	}
+	return string(p.data[start:p.pos])
+}
+
+// value legge un oggetto diretto, o un riferimento "12 0 R"
+func (p *pdfParser) value() (any, error) {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, errPDFEnd
+	}
+	switch p.data[p.pos] {
+	case '/':
+		return p.name(), nil
+	case '(':
+		return p.literalString()
+	case '<':
+		if p.pos+1 < len(p.data) && p.data[p.pos+1] == '<' {
+			return p.dict()
+		}
+		return p.hexString()
+	case '[':
+		if err := p.nest(); err != nil {
+			return nil, err
+		}
+		defer p.unnest()
+		p.pos++
+		var arr pdfArray
+		for {
+			p.skipSpace()
+			if p.pos < len(p.data) && p.data[p.pos] == ']' {
+				p.pos++
+				return arr, nil
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+	}
+	start := p.pos
+	tok := p.token()
+	switch tok {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	if _, err := strconv.ParseFloat(tok, 64); err != nil {
+		return nil, fmt.Errorf("token inatteso %q alla posizione %d", p.data[start:min(start+20, len(p.data))], start)
+	}
+	// An integer followed by an integer and R is a reference
+	if num, err := strconv.Atoi(tok); err == nil {
+		save := p.pos
+		if gen, err := strconv.Atoi(p.token()); err == nil && p.token() == "R" {
+			return pdfRef{num: num, gen: gen}, nil
+		}
+		p.pos = save
+	}
+	return pdfNumber(tok), nil
+}
+
+// name legge un nome, decodificando le sequenze #xx
+func (p *pdfParser) name() pdfName {
+	p.pos++
+	var sb strings.Builder
+	for p.pos < len(p.data) && !isPDFSpace(p.data[p.pos]) && !isPDFDelimiter(p.data[p.pos]) {
+		c := p.data[p.pos]
+		if c == '#' && p.pos+2 < len(p.data) {
+			if b, err := strconv.ParseUint(string(p.data[p.pos+1:p.pos+3]), 16, 8); err == nil {
+				sb.WriteByte(byte(b))
+				p.pos += 3
+				continue
+			}
+		}
+		sb.WriteByte(c)
+		p.pos++
+	}
+	return pdfName(sb.String())
+}
+
+// literalString legge una stringa tra parentesi, con parentesi annidate e sequenze di escape
+func (p *pdfParser) literalString() (pdfString, error) {
+	p.pos++
+	var s []byte
+	depth := 1
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return s, nil
+			}
+		case '\\':
+			if p.pos >= len(p.data) {
+				return nil, errPDFEnd
+			}
+			e := p.data[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// Line continuation
+				if e == '\r' && p.pos < len(p.data) && p.data[p.pos] == '\n' {
+					p.pos++
+				}
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					n := int(e - '0')
+					for i := 0; i < 2 && p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '7'; i++ {
+						n = n*8 + int(p.data[p.pos]-'0')
+						p.pos++
+					}
+					c = byte(n)
+				} else {
+					c = e
+				}
+			}
+		}
+		s = append(s, c)
+	}
+	return nil, errPDFEnd
+}
+
+// hexString legge una stringa esadecimale
+func (p *pdfParser) hexString() (pdfString, error) {
+	p.pos++
+	var digits []byte
+	for p.pos < len(p.data) && p.data[p.pos] != '>' {
+		if c := p.data[p.pos]; !isPDFSpace(c) {
+			digits = append(digits, c)
+		}
+		p.pos++
+	}
+	if p.pos >= len(p.data) {
+		return nil, errPDFEnd
+	}
+	p.pos++
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	s := make([]byte, len(digits)/2)
+	for i := range s {
+		b, err := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("stringa esadecimale non valida alla posizione %d", p.pos)
+		}
+		s[i] = byte(b)
+	}
+	return s, nil
+}
+
+// nest entra in un array o in un dizionario, entro maxPDFNesting livelli
+func (p *pdfParser) nest() error {
+	if p.depth >= maxPDFNesting {
+		return fmt.Errorf("più di %d array e dizionari annidati alla posizione %d", maxPDFNesting, p.pos)
+	}
+	p.depth++
+	return nil
+}
+
+// unnest esce da un array o da un dizionario aperto con nest
+func (p *pdfParser) unnest() {
+	p.depth--
+}
+
+// dict legge un dizionario
+func (p *pdfParser) dict() (pdfDict, error) {
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	defer p.unnest()
+	p.pos += 2
+	dict := make(pdfDict)
+	for {
+		p.skipSpace()
+		if p.pos+1 < len(p.data) && p.data[p.pos] == '>' && p.data[p.pos+1] == '>' {
+			p.pos += 2
+			return dict, nil
+		}
+		if p.pos >= len(p.data) {
+			return nil, errPDFEnd
+		}
+		if p.data[p.pos] != '/' {
+			return nil, fmt.Errorf("chiave del dizionario attesa alla posizione %d", p.pos)
+		}
+		key := p.name()
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		dict[string(key)] = v
+	}
+}
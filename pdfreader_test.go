@@ -0,0 +1,226 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParsePDFValues(t *testing.T) {
+	p := &pdfParser{data: []byte(`<< /Name#20x (a\(b\)\\c\101
+(nested)) /Hex <48656C6C6F7> % comment
+/Array [1 2 0 R -3.5 true null /N] /Dict << /K /V >> >>`)}
+	v, err := p.value()
+	if err != nil {
+		t.Fatalf("value failed: %v", err)
+	}
+	expected := pdfDict{
+		"Name x": pdfString("a(b)\\cA\n(nested)"),
+		"Hex":    pdfString("Hellop"),
+		"Array":  pdfArray{pdfNumber("1"), pdfRef{num: 2}, pdfNumber("-3.5"), true, nil, pdfName("N")},
+		"Dict":   pdfDict{"K": pdfName("V")},
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, v)
+	}
+
+	for _, invalid := range []string{"<< /K >>", "[1 2", "(open", "<< 1 2 >>"} {
+		if _, err := (&pdfParser{data: []byte(invalid)}).value(); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+
+	// Deep nesting is an error, not a stack overflow
+	for _, open := range []string{"[", "<< /K "} {
+		nested := strings.Repeat(open, 1_000_000)
+		if _, err := (&pdfParser{data: []byte(nested)}).value(); err == nil || !strings.Contains(err.Error(), "annidati") {
+			t.Errorf("Expected a nesting error for %q, got %v", open, err)
+		}
+	}
+	nested := strings.Repeat("[", maxPDFNesting) + strings.Repeat("]", maxPDFNesting)
+	if _, err := (&pdfParser{data: []byte(nested)}).value(); err != nil {
+		t.Errorf("Expected %d nested arrays to be read, got %v", maxPDFNesting, err)
+	}
+}
+
+func TestReadPDF(t *testing.T) {
+	markdown := "# Titolo\n\n" + fillerLines(80)
+	plain, err := NewConverter(markdown).Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	numPages := len(pageLines(t, plain))
+	tests := []struct {
+		name  string
+		setup func(c *Converter)
+	}{
+		{"classic xref", func(c *Converter) {}},
+		{"cross-reference stream", func(c *Converter) {
+			c.SetTagged(true)
+			c.SetCompact(true)
+		}},
+		{"linearized", func(c *Converter) { c.SetLinearized(true) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			converter := NewConverter(markdown)
+			tt.setup(converter)
+			data, err := converter.Convert()
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			r, err := readPDF(data)
+			if err != nil {
+				t.Fatalf("readPDF failed: %v", err)
+			}
+			pages, err := r.pages()
+			if err != nil {
+				t.Fatalf("pages failed: %v", err)
+			}
+			if len(pages) != numPages {
+				t.Fatalf("Expected %d pages, got %d", numPages, len(pages))
+			}
+			content, err := r.pageContent(pages[0])
+			if err != nil {
+				t.Fatalf("pageContent failed: %v", err)
+			}
+			if !bytes.Contains(content, []byte("(Titolo) Tj")) {
+				t.Errorf("Expected the first page content, got %q", content)
+			}
+			if box := r.pageBox(pages[1]); box != [4]float64{0, 0, 595.28, 841.89} {
+				t.Errorf("Expected an A4 page, got %v", box)
+			}
+		})
+	}
+}
+
+func TestReadPDFUpdates(t *testing.T) {
+	data, err := NewConverter("Originale\n").Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	// An incremental update replaces the content stream of the page (object 3)
+	startxref := regexp.MustCompile(`startxref\n(\d+)`).FindSubmatch(data)
+	prev, _ := strconv.Atoi(string(startxref[1]))
+	size := regexp.MustCompile(`/Size (\d+)`).FindSubmatch(data)
+	content := "BT /F1 10 Tf (Aggiornato) Tj ET"
+	offset := len(data)
+	update := fmt.Sprintf("3 0 obj\n<< /Length %d >>\nstream\n%s\nendstream\nendobj\n", len(content), content)
+	xref := len(data) + len(update)
+	update += fmt.Sprintf("xref\n3 1\n%010d 00000 n \ntrailer\n<< /Size %s /Root 1 0 R /Prev %d >>\nstartxref\n%d\n%%%%EOF\n",
+		offset, size[1], prev, xref)
+	updated := append(append([]byte{}, data...), update...)
+
+	r, err := readPDF(updated)
+	if err != nil {
+		t.Fatalf("readPDF failed: %v", err)
+	}
+	pages, _ := r.pages()
+	if got, _ := r.pageContent(pages[0]); !strings.Contains(string(got), "(Aggiornato) Tj") {
+		t.Errorf("Expected the updated content, got %q", got)
+	}
+
+	// With a damaged cross-reference table the objects are found by scanning the file
+	damaged := bytes.Replace(updated, []byte(fmt.Sprintf("startxref\n%d", xref)), []byte("startxref\n12"), 1)
+	r, err = readPDF(damaged)
+	if err != nil {
+		t.Fatalf("readPDF failed on a damaged file: %v", err)
+	}
+	pages, _ = r.pages()
+	if got, _ := r.pageContent(pages[0]); !strings.Contains(string(got), "(Aggiornato) Tj") {
+		t.Errorf("Expected the last definition of the object, got %q", got)
+	}
+}
+
+func TestReadPDFErrors(t *testing.T) {
+	converter := NewConverter("Segreto\n")
+	converter.SetEncryption(&Encryption{UserPassword: "secret"})
+	encrypted, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	for name, data := range map[string][]byte{
+		"not a PDF": []byte("hello"),
+		"encrypted": encrypted,
+		"truncated": []byte("%PDF-1.4\n1 0 obj\n<< /Type /Catalog"),
+	} {
+		if _, err := readPDF(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestReadPDFCorruptXref(t *testing.T) {
+	data, err := NewConverter("Testo\n").Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	xref := bytes.Index(data, []byte("\nxref\n")) + 1
+	entries := regexp.MustCompile(`(?m)^\d{10} \d{5} n $`).FindAllIndex(data[xref:], -1)
+	corrupt := func(offset string) []byte {
+		out := append([]byte{}, data...)
+		for _, e := range entries {
+			copy(out[xref+e[0]:], offset)
+		}
+		return out
+	}
+	// The body is cut, while the cross-reference table and the trailer remain
+	catalog := bytes.Index(data, []byte("1 0 obj"))
+	truncated := append(append([]byte{}, data[:catalog/2]...), data[xref:]...)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		readable bool // the objects are found by scanning the file
+	}{
+		{"offsets past the end", corrupt("9999999999"), true},
+		{"negative offsets", corrupt("-000000001"), true},
+		{"truncated body", truncated, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := readPDF(tt.data)
+			if err == nil {
+				_, err = r.pages()
+			}
+			if (err == nil) != tt.readable {
+				t.Errorf("Expected readable=%v, got %v", tt.readable, err)
+			}
+			// The converter reports the file instead of panicking
+			path := filepath.Join(t.TempDir(), "broken.pdf")
+			if err := os.WriteFile(path, tt.data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			converter := NewConverter("Testo\n")
+			err = converter.AppendPDF(path, "")
+			if err == nil {
+				_, err = converter.Convert()
+			}
+			if (err == nil) != tt.readable {
+				t.Errorf("Expected readable=%v, got %v", tt.readable, err)
+			}
+		})
+	}
+
+	// A single entry past the end of the file leaves that object unreadable
+	r, err := readPDF(data)
+	if err != nil {
+		t.Fatalf("readPDF failed: %v", err)
+	}
+	delete(r.objects, 3)
+	r.xref[3] = xrefEntry{offset: len(data) + 100}
+	if _, err := r.object(3); err == nil {
+		t.Error("Expected an error for an offset past the end of the file")
+	}
+	delete(r.objects, 4)
+	r.xref[4] = xrefEntry{offset: -1}
+	if _, err := r.object(4); err == nil {
+		t.Error("Expected an error for a negative offset")
+	}
+}
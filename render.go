@@ -0,0 +1,235 @@
+package mark2pdf
+
+import "reflect"
+
+// NodeRenderer disegna un blocco (o un ListItem) al posto del renderer di default.
+// Può delegare al renderer di default con ctx.RenderDefault.
+type NodeRenderer func(ctx *RenderContext, node Node) error
+
+// InlineRenderer converte un nodo inline nelle parti di testo da disegnare.
+// Può delegare al renderer di default con ctx.DefaultInlineParts.
+type InlineRenderer func(ctx *RenderContext, node Inline) []TextPart
+
+// rendererRegistry associa ai tipi di nodo i renderer personalizzati
+type rendererRegistry struct {
+	blocks  map[reflect.Type]NodeRenderer
+	inlines map[reflect.Type]InlineRenderer
+}
+
+func newRendererRegistry() *rendererRegistry {
+	return &rendererRegistry{
+		blocks:  make(map[reflect.Type]NodeRenderer),
+		inlines: make(map[reflect.Type]InlineRenderer),
+	}
+}
+
+// RegisterRenderer registra un renderer per il tipo di kind, ad esempio
+// &Heading{} o &ListItem{}. Un renderer nil ripristina quello di default.
+func (c *Converter) RegisterRenderer(kind Node, fn NodeRenderer) {
+	if fn == nil {
+		delete(c.renderers.blocks, reflect.TypeOf(kind))
+		return
+	}
+	c.renderers.blocks[reflect.TypeOf(kind)] = fn
+}
+
+// RegisterInlineRenderer registra un renderer per il tipo inline di kind, ad esempio
+// &Link{}. Un renderer nil ripristina quello di default.
+func (c *Converter) RegisterInlineRenderer(kind Inline, fn InlineRenderer) {
+	if fn == nil {
+		delete(c.renderers.inlines, reflect.TypeOf(kind))
+		return
+	}
+	c.renderers.inlines[reflect.TypeOf(kind)] = fn
+}
+
+// RenderContext è il contesto di disegno passato ai renderer personalizzati
+type RenderContext struct {
+	conv   *Converter
+	color  *Color // colore ereditato dagli inline in conversione
+	marker string // marcatore dell'item di lista corrente
+}
+
+// PDF restituisce il writer con le primitive di disegno (DrawRect, FillRect, DrawLine, WriteTextAt)
+func (ctx *RenderContext) PDF() *PDFWriter {
+	return ctx.conv.pdf
+}
+
+// X restituisce il bordo sinistro del contenuto, rientro compreso
+func (ctx *RenderContext) X() float64 {
+	return ctx.conv.pdf.margin + ctx.conv.pdf.indent
+}
+
+// Y restituisce la posizione verticale corrente (linea di base della prossima riga)
+func (ctx *RenderContext) Y() float64 {
+	return ctx.conv.pdf.GetCurrentY()
+}
+
+// SetY imposta la posizione verticale corrente
+func (ctx *RenderContext) SetY(y float64) {
+	ctx.conv.pdf.SetY(y)
+}
+
+// Width restituisce la larghezza disponibile a partire da X
+func (ctx *RenderContext) Width() float64 {
+	return ctx.conv.pdf.contentWidth()
+}
+
+// Theme restituisce il tema del convertitore
+func (ctx *RenderContext) Theme() *Theme {
+	return ctx.conv.theme
+}
+
+// FontSize restituisce la dimensione del font per un tipo di testo ("h1".."h6", "normal", "code")
+func (ctx *RenderContext) FontSize(textType string) float64 {
+	return ctx.conv.pdf.GetFontSize(textType)
+}
+
+// AddSpace aggiunge spazio verticale, passando a una nuova pagina se necessario
+func (ctx *RenderContext) AddSpace(points float64) {
+	ctx.conv.pdf.addSpace(points)
+}
+
+// EnsureSpace passa a una nuova pagina se non ci sono almeno height punti disponibili
+func (ctx *RenderContext) EnsureSpace(height float64) {
+	ctx.conv.pdf.EnsureSpace(height)
+}
+
+// WriteInlines scrive elementi inline con word wrapping a partire dalla posizione corrente
+func (ctx *RenderContext) WriteInlines(inlines []Inline, fontSize float64) {
+	style := ctx.conv.theme.text()
+	style.Size = fontSize
+	ctx.conv.renderStyledInlines("", inlines, style)
+}
+
+// WriteParts scrive parti di testo con word wrapping a partire dalla posizione corrente
+func (ctx *RenderContext) WriteParts(parts []TextPart, fontSize float64) {
+	style := ctx.conv.theme.text()
+	style.Size = fontSize
+	ctx.conv.writeStyledParts(parts, style)
+}
+
+// InlineParts converte elementi inline in parti di testo, usando i renderer registrati
+func (ctx *RenderContext) InlineParts(inlines []Inline) []TextPart {
+	return ctx.conv.convertInlineToTextParts(inlines, ctx.color)
+}
+
+// DefaultInlineParts converte un nodo inline con il renderer di default
+func (ctx *RenderContext) DefaultInlineParts(node Inline) []TextPart {
+	return ctx.conv.defaultInlineParts(node, ctx.color)
+}
+
+// RenderBlocks renderizza blocchi usando i renderer registrati
+func (ctx *RenderContext) RenderBlocks(blocks []Block) error {
+	return ctx.conv.renderBlocks(blocks)
+}
+
+// RenderDefault renderizza node con il renderer di default; i figli usano comunque i renderer registrati
+func (ctx *RenderContext) RenderDefault(node Node) error {
+	switch n := node.(type) {
+	case *ListItem:
+		return ctx.conv.renderListItemDefault(n, ctx.marker)
+	case Block:
+		return ctx.conv.renderBlockDefault(n)
+	}
+	return nil
+}
+
+// Indent esegue fn con il contenuto rientrato di points punti
+func (ctx *RenderContext) Indent(points float64, fn func() error) error {
+	ctx.conv.pdf.indent += points
+	defer func() { ctx.conv.pdf.indent -= points }()
+	return fn()
+}
+
+// Marker restituisce il marcatore dell'item di lista in rendering ("-", "3.", "[ ]", "[x]")
+func (ctx *RenderContext) Marker() string {
+	return ctx.marker
+}
+
+// HeadingNumber restituisce il numero del titolo ("2.3.1") con Theme.Numbering, "" se non è numerato
+func (ctx *RenderContext) HeadingNumber(heading *Heading) string {
+	return ctx.conv.numbers[heading]
+}
+
+// Tag disegna con fn il contenuto di un elemento di struttura del PDF taggato
+// (ad esempio "Figure", "Note" o "Div"); senza tagging esegue solo fn
+func (ctx *RenderContext) Tag(role string, fn func() error) error {
+	ctx.conv.pdf.beginStruct(role)
+	defer ctx.conv.pdf.endStruct()
+	return fn()
+}
+
+// renderBlock renderizza un blocco con il renderer registrato o con quello di default,
+// dentro il suo elemento di struttura nei PDF taggati; le direttive di interruzione
+// di pagina, di appendice e di inserimento di un PDF non passano dai renderer
+func (c *Converter) renderBlock(block Block) error {
+	if isPageBreak(block) {
+		c.pdf.pageBreak(false)
+		return nil
+	}
+	if c.htmlPageBreak(block) {
+		return nil
+	}
+	if path, pages, ok := pdfDirective(block); ok {
+		return c.insertPDF(path, pages)
+	}
+	if blockDirective(block) == "appendix" {
+		return nil
+	}
+	if role := structRole(block); role != "" {
+		c.pdf.beginStruct(role)
+		defer c.pdf.endStruct()
+	}
+	if fn, ok := c.renderers.blocks[reflect.TypeOf(block)]; ok {
+		return fn(c.ctx, block)
+	}
+	return c.renderBlockDefault(block)
+}
+
+// renderBlocks renderizza blocchi fratelli, rendendo disponibili al renderer
+// di default il precedente e il successivo
+func (c *Converter) renderBlocks(blocks []Block) error {
+	prev, next := c.prev, c.next
+	defer func() { c.prev, c.next = prev, next }()
+	for i, block := range blocks {
+		c.prev, c.next = nil, nil
+		if i > 0 {
+			c.prev = blocks[i-1]
+		}
+		if i+1 < len(blocks) {
+			c.next = blocks[i+1]
+		}
+		if err := c.renderBlock(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderListItem renderizza un item di lista con il renderer registrato o con quello di default
+func (c *Converter) renderListItem(item *ListItem, marker string) error {
+	previous := c.ctx.marker
+	c.ctx.marker = marker
+	defer func() { c.ctx.marker = previous }()
+	c.pdf.beginStruct("LI")
+	defer c.pdf.endStruct()
+
+	if fn, ok := c.renderers.blocks[reflect.TypeOf(item)]; ok {
+		return fn(c.ctx, item)
+	}
+	return c.renderListItemDefault(item, marker)
+}
+
+// inlineParts converte un nodo inline con il renderer registrato o con quello di default
+func (c *Converter) inlineParts(node Inline, baseColor *Color) []TextPart {
+	fn, ok := c.renderers.inlines[reflect.TypeOf(node)]
+	if !ok {
+		return c.defaultInlineParts(node, baseColor)
+	}
+
+	previous := c.ctx.color
+	c.ctx.color = baseColor
+	defer func() { c.ctx.color = previous }()
+	return fn(c.ctx, node)
+}
@@ -0,0 +1,133 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// pdfContent restituisce il contenuto decompresso di tutti gli stream del PDF
+func pdfContent(t *testing.T, data []byte) string {
+	t.Helper()
+	var sb strings.Builder
+	// The writer always uses \n: a trailing \r belongs to the compressed data
+	re := regexp.MustCompile(`(?s)stream\n(.*?)\nendstream`)
+	for _, m := range re.FindAllSubmatch(data, -1) {
+		r, err := zlib.NewReader(bytes.NewReader(m[1]))
+		if err != nil {
+			continue
+		}
+		content, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Failed to decompress stream: %v", err)
+		}
+		sb.Write(content)
+	}
+	return sb.String()
+}
+
+func TestCustomBlockRenderer(t *testing.T) {
+	converter := NewConverter("# Title\n\nBody text\n")
+	calls := 0
+	converter.RegisterRenderer(&Heading{}, func(ctx *RenderContext, node Node) error {
+		calls++
+		if node.(*Heading).Level != 1 {
+			t.Errorf("Expected level 1 heading, got %d", node.(*Heading).Level)
+		}
+		// Colored bar on the left of the heading, then the default rendering
+		ctx.PDF().FillRect(ctx.X()-8, ctx.Y()+20, 4, 30, ColorBlue)
+		return ctx.RenderDefault(node)
+	})
+
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	content := pdfContent(t, data)
+
+	if calls != 1 {
+		t.Errorf("Expected renderer to be called once, got %d", calls)
+	}
+	for _, expected := range []string{"0.000 0.000 1.000 rg", "re f", "(Title) Tj", "(Body text) Tj"} {
+		if !strings.Contains(content, expected) {
+			t.Errorf("Expected content to contain %q", expected)
+		}
+	}
+}
+
+func TestCustomListItemRenderer(t *testing.T) {
+	converter := NewConverter("- [x] done\n- [ ] todo\n- plain\n")
+	var markers []string
+	converter.RegisterRenderer(&ListItem{}, func(ctx *RenderContext, node Node) error {
+		markers = append(markers, ctx.Marker())
+		item := node.(*ListItem)
+		if !item.Task {
+			return ctx.RenderDefault(node)
+		}
+
+		// Draw a checkbox and the item text next to it
+		size := ctx.FontSize("normal")
+		ctx.PDF().DrawRect(ctx.X(), ctx.Y()+size, size, size, 0.8, ColorGray)
+		return ctx.Indent(size*1.5, func() error {
+			return ctx.RenderBlocks(item.Blocks)
+		})
+	})
+
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	content := pdfContent(t, data)
+
+	if strings.Join(markers, " ") != "[x] [ ] -" {
+		t.Errorf("Expected markers %q, got %q", "[x] [ ] -", markers)
+	}
+	if strings.Contains(content, "[x]") || strings.Contains(content, "[ ]") {
+		t.Error("Expected textual checkboxes to be replaced")
+	}
+	if !strings.Contains(content, "(- plain) Tj") {
+		t.Error("Expected plain item to use the default renderer")
+	}
+}
+
+func TestCustomInlineRenderer(t *testing.T) {
+	converter := NewConverter("See [the docs](http://example.com) and **bold**\n")
+	converter.RegisterInlineRenderer(&Link{}, func(ctx *RenderContext, node Inline) []TextPart {
+		parts := ctx.InlineParts(node.(*Link).Inlines)
+		for i := range parts {
+			parts[i].Color = &ColorBlue
+		}
+		return parts
+	})
+
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	content := pdfContent(t, data)
+
+	if strings.Contains(content, "http://example.com") {
+		t.Error("Expected link destination to be hidden by the custom renderer")
+	}
+	if !strings.Contains(content, "0.000 0.000 1.000 rg\n/F1 10.00 Tf\n( the docs) Tj") {
+		t.Errorf("Expected blue link text, got %q", content)
+	}
+	if !strings.Contains(content, "/F2 10.00 Tf\n( bold) Tj") {
+		t.Error("Expected other inlines to use the default renderer")
+	}
+
+	// A nil renderer restores the default
+	converter = NewConverter("[x](http://example.com)\n")
+	converter.RegisterInlineRenderer(&Link{}, func(ctx *RenderContext, node Inline) []TextPart { return nil })
+	converter.RegisterInlineRenderer(&Link{}, nil)
+	data, err = converter.Convert()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(pdfContent(t, data), "http://example.com") {
+		t.Error("Expected default link rendering after unregistering")
+	}
+}
@@ -0,0 +1,106 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestReproducibleOutput(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+	const markdown = "---\ntitle: Relazione annuale\nauthor: Mario Rossi\n---\n\n# Risultati\n\nTesto **importante**\n\n- uno\n- due\n"
+
+	tests := []struct {
+		name  string
+		setup func(c *Converter)
+	}{
+		{"plain", func(c *Converter) {}},
+		{"tagged compact", func(c *Converter) {
+			c.SetTagged(true)
+			c.SetCompact(true)
+		}},
+		{"linearized", func(c *Converter) { c.SetLinearized(true) }},
+		{"pdfa", func(c *Converter) {
+			c.SetPDFA(true)
+			c.EmbedFont("Helvetica", testFont("Test", 600))
+			c.EmbedFont("Helvetica-Bold", testFont("Test-Bold", 600))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var outputs [][]byte
+			for i := 0; i < 2; i++ {
+				converter := NewConverter(markdown)
+				tt.setup(converter)
+				data, err := converter.Convert()
+				if err != nil {
+					t.Fatalf("Convert failed: %v", err)
+				}
+				outputs = append(outputs, data)
+			}
+			// The streaming writer produces the same bytes as Convert
+			converter := NewConverter(markdown)
+			tt.setup(converter)
+			var streamed bytes.Buffer
+			if err := converter.ConvertToWriter(&streamed); err != nil {
+				t.Fatalf("ConvertToWriter failed: %v", err)
+			}
+
+			if !bytes.Equal(outputs[0], outputs[1]) {
+				t.Error("Expected two conversions to produce identical bytes")
+			}
+			if !bytes.Equal(outputs[0], streamed.Bytes()) {
+				t.Error("Expected the streamed output to match Convert")
+			}
+			for _, leak := range []string{"/CreationDate", "/ModDate", "<xmp:CreateDate>"} {
+				if bytes.Contains(outputs[0], []byte(leak)) {
+					t.Errorf("Expected no %s without a requested date", leak)
+				}
+			}
+			if !bytes.Contains(outputs[0], []byte("/ID [<")) {
+				t.Error("Expected a trailer /ID")
+			}
+		})
+	}
+
+	t.Run("different content", func(t *testing.T) {
+		a, _ := NewConverter(markdown).Convert()
+		b, _ := NewConverter(markdown + "\nAltro\n").Convert()
+		id := func(data []byte) string {
+			i := bytes.Index(data, []byte("/ID [<"))
+			return string(data[i : i+40])
+		}
+		if id(a) == id(b) {
+			t.Error("Expected different documents to have different identifiers")
+		}
+	})
+}
+
+func TestSourceDateEpoch(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	const markdown = "---\ntitle: Prova\n---\n\nTesto\n"
+	creationDate := func(date time.Time) []byte {
+		return []byte(fmt.Sprintf("/CreationDate <%x>", textString(pdfDate(date))))
+	}
+
+	data, err := NewConverter(markdown).Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if !bytes.Contains(data, creationDate(time.Unix(1700000000, 0).UTC())) {
+		t.Error("Expected the CreationDate from SOURCE_DATE_EPOCH")
+	}
+
+	// An explicit date takes precedence over the environment
+	date := time.Date(2024, 1, 31, 14, 30, 0, 0, time.UTC)
+	converter := NewConverter(markdown)
+	converter.SetCreationDate(date)
+	data, err = converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if !bytes.Contains(data, creationDate(date)) {
+		t.Error("Expected the CreationDate set with SetCreationDate")
+	}
+}
@@ -0,0 +1,251 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg" // decoder JPEG per DrawImage
+	_ "image/png"  // decoder PNG per DrawImage
+	"strings"
+)
+
+// resourceManager registra le risorse delle pagine: font, XObject e stati grafici.
+// XObject e stati grafici sono identificati dal contenuto, così una risorsa
+// registrata più volte è scritta una sola volta e condivisa tra le pagine; ogni
+// pagina elenca nel proprio /Resources solo le risorse che usa.
+type resourceManager struct {
+	xobjects []*xobject
+	states   []string                     // dizionari ExtGState: GS1, GS2, ...
+	byHash   map[[sha256.Size]byte]string // contenuto -> nome della risorsa
+	pages    []map[string]bool            // risorse usate da ogni pagina
+	used     map[string]bool              // risorse usate nel documento
+}
+
+// xobject è un XObject (immagine o modulo) da scrivere come stream
+type xobject struct {
+	name  string
+	dict  string // voci del dizionario dopo /Length
+	data  []byte // dati codificati secondo il filtro del dizionario, o da comprimere
+	flate bool   // data è da comprimere con FlateDecode alla scrittura
+	smask *xobject
+
+	// Form XObject di una pagina importata: voci del dizionario copiate dal file
+	origin   string // file e pagina di provenienza
+	imported *pdfImport
+	entries  pdfDict
+	fonts    []string // font non incorporati nella pagina importata
+}
+
+// lookup restituisce il nome della risorsa con il contenuto indicato, se registrata
+func (r *resourceManager) lookup(key [sha256.Size]byte) (string, bool) {
+	name, ok := r.byHash[key]
+	return name, ok
+}
+
+// register associa un contenuto al nome della sua risorsa
+func (r *resourceManager) register(key [sha256.Size]byte, name string) {
+	if r.byHash == nil {
+		r.byHash = make(map[[sha256.Size]byte]string)
+	}
+	r.byHash[key] = name
+}
+
+// useResource registra l'uso di una risorsa ("F1", "Im2", "GS1") nella pagina corrente
+func (p *PDFWriter) useResource(name string) {
+	p.usePageResource(p.currentPage, name)
+}
+
+// usePageResource registra l'uso di una risorsa nella pagina indicata
+func (p *PDFWriter) usePageResource(page int, name string) {
+	r := &p.resources
+	if r.used == nil {
+		r.used = make(map[string]bool)
+	}
+	r.used[name] = true
+	for len(r.pages) <= page {
+		r.pages = append(r.pages, make(map[string]bool))
+	}
+	r.pages[page][name] = true
+}
+
+// addXObject registra un XObject; prefix è il prefisso del nome ("Im" per le
+// immagini). Un XObject identico a uno già registrato ne riusa il nome.
+func (p *PDFWriter) addXObject(prefix string, x *xobject) string {
+	h := sha256.New()
+	for obj := x; obj != nil; obj = obj.smask {
+		fmt.Fprintf(h, "%s\x00%s\x00%t\x00%s\x00", prefix, obj.dict, obj.flate, obj.origin)
+		h.Write(obj.data)
+	}
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	if name, ok := p.resources.lookup(key); ok {
+		return name
+	}
+	p.resources.xobjects = append(p.resources.xobjects, x)
+	x.name = fmt.Sprintf("%s%d", prefix, len(p.resources.xobjects))
+	p.resources.register(key, x.name)
+	return x.name
+}
+
+// graphicsState registra un dizionario ExtGState e ne restituisce il nome
+func (p *PDFWriter) graphicsState(dict string) string {
+	key := sha256.Sum256([]byte("ExtGState\x00" + dict))
+	if name, ok := p.resources.lookup(key); ok {
+		return name
+	}
+	p.resources.states = append(p.resources.states, dict)
+	name := fmt.Sprintf("GS%d", len(p.resources.states))
+	p.resources.register(key, name)
+	return name
+}
+
+// opacityState registra lo stato grafico con l'opacità indicata, limitata tra 0 e 1
+func (p *PDFWriter) opacityState(opacity float64) string {
+	opacity = min(max(opacity, 0), 1)
+	return p.graphicsState(fmt.Sprintf("<< /Type /ExtGState /ca %.3f /CA %.3f >>", opacity, opacity))
+}
+
+// SetOpacity imposta l'opacità di riempimento e tratto di ciò che è disegnato in
+// seguito nella pagina corrente: 0 trasparente, 1 opaco
+func (p *PDFWriter) SetOpacity(opacity float64) {
+	if p.currentBuf == nil {
+		p.newPage()
+	}
+	name := p.opacityState(opacity)
+	p.useResource(name)
+	p.currentBuf.WriteString(fmt.Sprintf("/%s gs\n", name))
+}
+
+// DrawImage disegna un'immagine JPEG o PNG nel rettangolo con l'angolo in alto a
+// sinistra in (x, y). Un'immagine disegnata più volte, anche in pagine diverse, è
+// incorporata una sola volta.
+func (p *PDFWriter) DrawImage(data []byte, x, y, width, height float64) error {
+	name, err := p.imageResource(data)
+	if err != nil {
+		return err
+	}
+	if p.currentBuf == nil {
+		p.newPage()
+	}
+	p.useResource(name)
+	p.beginMarked(artifact)
+	p.currentBuf.WriteString(fmt.Sprintf("q %.2f 0 0 %.2f %.2f %.2f cm /%s Do Q\n", width, height, x, y-height, name))
+	p.endMarked()
+	return nil
+}
+
+// imageResource registra un'immagine JPEG o PNG come XObject e ne restituisce il nome
+func (p *PDFWriter) imageResource(data []byte) (string, error) {
+	if name, ok := p.resources.lookup(sha256.Sum256(data)); ok {
+		return name, nil
+	}
+	img, err := imageXObject(data)
+	if err != nil {
+		return "", err
+	}
+	name := p.addXObject("Im", img)
+	// The file itself is registered too, so drawing it again skips decoding
+	p.resources.register(sha256.Sum256(data), name)
+	return name, nil
+}
+
+// imageXObject converte un'immagine in XObject: i JPEG RGB e in scala di grigi
+// sono incorporati così come sono, le altre immagini sono decodificate e compresse,
+// con il canale alfa come maschera di trasparenza
+func imageXObject(data []byte) (*xobject, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("immagine non supportata: %w", err)
+	}
+	if format == "jpeg" {
+		switch config.ColorModel {
+		case color.GrayModel:
+			return &xobject{dict: jpegDict(config, "/DeviceGray"), data: data}, nil
+		case color.YCbCrModel, color.RGBAModel:
+			return &xobject{dict: jpegDict(config, "/DeviceRGB"), data: data}, nil
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("immagine non valida: %w", err)
+	}
+	bounds := img.Bounds()
+	gray := false
+	switch img.ColorModel() {
+	case color.GrayModel, color.Gray16Model:
+		gray = true
+	}
+	var pixels, alpha []byte
+	opaque := true
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if gray {
+				pixels = append(pixels, c.R)
+			} else {
+				pixels = append(pixels, c.R, c.G, c.B)
+			}
+			alpha = append(alpha, c.A)
+			opaque = opaque && c.A == 255
+		}
+	}
+	colorSpace := "/DeviceRGB"
+	if gray {
+		colorSpace = "/DeviceGray"
+	}
+	xobj := &xobject{dict: rawImageDict(bounds.Dx(), bounds.Dy(), colorSpace), data: pixels, flate: true}
+	if !opaque {
+		xobj.smask = &xobject{dict: rawImageDict(bounds.Dx(), bounds.Dy(), "/DeviceGray"), data: alpha, flate: true}
+	}
+	return xobj, nil
+}
+
+func jpegDict(config image.Config, colorSpace string) string {
+	return fmt.Sprintf(" /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode",
+		config.Width, config.Height, colorSpace)
+}
+
+func rawImageDict(width, height int, colorSpace string) string {
+	return fmt.Sprintf(" /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8",
+		width, height, colorSpace)
+}
+
+// resourceDict restituisce il dizionario /Resources di una pagina con le sole
+// risorse che usa; refs associa a ogni nome di risorsa il suo oggetto
+func (p *PDFWriter) resourceDict(page int, refs map[string]int) string {
+	var used map[string]bool
+	if page < len(p.resources.pages) {
+		used = p.resources.pages[page]
+	}
+	var sb strings.Builder
+	sb.WriteString("<< ")
+	category := func(key string, names []string) {
+		var entries strings.Builder
+		for _, name := range names {
+			if used[name] {
+				fmt.Fprintf(&entries, "/%s %d 0 R ", name, refs[name])
+			}
+		}
+		if entries.Len() > 0 {
+			fmt.Fprintf(&sb, "/%s << %s>> ", key, entries.String())
+		}
+	}
+	var fonts, states, xobjects []string
+	for i := range p.fonts {
+		fonts = append(fonts, fmt.Sprintf("F%d", i+1))
+	}
+	for i := range p.resources.states {
+		states = append(states, fmt.Sprintf("GS%d", i+1))
+	}
+	for _, x := range p.resources.xobjects {
+		xobjects = append(xobjects, x.name)
+	}
+	category("Font", fonts)
+	category("ExtGState", states)
+	category("XObject", xobjects)
+	sb.WriteString(">>")
+	return sb.String()
+}
@@ -0,0 +1,155 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// pageResources restituisce il dizionario /Resources di ogni pagina, in ordine
+func pageResources(t *testing.T, data []byte) []string {
+	t.Helper()
+	var pages []string
+	for _, m := range regexp.MustCompile(`/Type /Page /Parent .*?/Resources (<< .*?>> >>|<< >>)`).FindAllSubmatch(data, -1) {
+		pages = append(pages, string(m[1]))
+	}
+	return pages
+}
+
+// testPNG restituisce un'immagine PNG 2x2, semitrasparente se alpha < 255
+func testPNG(t *testing.T, alpha uint8) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	for i := 0; i < 4; i++ {
+		img.Set(i%2, i/2, color.NRGBA{R: 200, G: 10, B: 10, A: alpha})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestPerPageResources(t *testing.T) {
+	markdown := fillerLines(60) + "```\ncode\n```\n"
+	data, err := NewConverter(markdown).Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	pages := pageResources(t, data)
+	if len(pages) != 2 {
+		t.Fatalf("Expected 2 pages, got %d", len(pages))
+	}
+	if strings.Contains(pages[0], "/F4 ") {
+		t.Errorf("Expected the first page not to list Courier, got %s", pages[0])
+	}
+	if !strings.Contains(pages[1], "/F4 ") {
+		t.Errorf("Expected the second page to list Courier, got %s", pages[1])
+	}
+	if bytes.Contains(data, []byte("/BaseFont /Helvetica-Oblique")) {
+		t.Error("Expected unused fonts not to be written")
+	}
+}
+
+func TestSharedResources(t *testing.T) {
+	tests := []struct {
+		name  string
+		alpha uint8
+		masks int
+	}{
+		{"opaque", 255, 0},
+		{"transparent", 128, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logo := testPNG(t, tt.alpha)
+			p := NewPDFWriter()
+			for page := 0; page < 2; page++ {
+				p.newPage()
+				p.SetOpacity(0.5)
+				if err := p.DrawImage(logo, 50, 800, 40, 40); err != nil {
+					t.Fatalf("DrawImage failed: %v", err)
+				}
+				// A copy of the same image is still the same resource
+				if err := p.DrawImage(append([]byte{}, logo...), 100, 800, 40, 40); err != nil {
+					t.Fatalf("DrawImage failed: %v", err)
+				}
+			}
+			data, err := p.Build()
+			if err != nil {
+				t.Fatalf("Build failed: %v", err)
+			}
+
+			if n := bytes.Count(data, []byte("/Subtype /Image")); n != 1+tt.masks {
+				t.Errorf("Expected %d image objects, got %d", 1+tt.masks, n)
+			}
+			if n := bytes.Count(data, []byte("/Type /ExtGState")); n != 1 {
+				t.Errorf("Expected 1 graphics state, got %d", n)
+			}
+			pages := pageResources(t, data)
+			if len(pages) != 2 || pages[0] != pages[1] {
+				t.Fatalf("Expected two pages sharing the resources, got %q", pages)
+			}
+			for _, expected := range []string{"/ExtGState << /GS1 ", "/XObject << /Im1 "} {
+				if !strings.Contains(pages[0], expected) {
+					t.Errorf("Expected %q in %s", expected, pages[0])
+				}
+			}
+			if strings.Contains(pages[0], "/Font") {
+				t.Errorf("Expected no fonts on pages without text, got %s", pages[0])
+			}
+		})
+	}
+}
+
+func TestSharedEmbeddedFont(t *testing.T) {
+	converter := NewConverter("Testo *corsivo* e **grassetto**\n")
+	font := testFont("Test", 600)
+	for _, name := range []string{"Helvetica", "Helvetica-Oblique"} {
+		if err := converter.EmbedFont(name, font); err != nil {
+			t.Fatalf("EmbedFont failed: %v", err)
+		}
+	}
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if n := bytes.Count(data, []byte("/Length1 ")); n != 1 {
+		t.Errorf("Expected the font program written once, got %d", n)
+	}
+	refs := regexp.MustCompile(`/F1 (\d+) 0 R /F2 \d+ 0 R /F3 (\d+) 0 R`).FindSubmatch(data)
+	if refs == nil || string(refs[1]) != string(refs[2]) {
+		t.Errorf("Expected F1 and F3 to share the font object, got %q", pageResources(t, data))
+	}
+}
+
+func TestDrawImageFormats(t *testing.T) {
+	// JPEG images are embedded as they are
+	var photo bytes.Buffer
+	if err := jpeg.Encode(&photo, image.NewRGBA(image.Rect(0, 0, 3, 2)), nil); err != nil {
+		t.Fatalf("jpeg.Encode failed: %v", err)
+	}
+	p := NewPDFWriter()
+	if err := p.DrawImage(photo.Bytes(), 50, 800, 30, 20); err != nil {
+		t.Fatalf("DrawImage failed: %v", err)
+	}
+	data, err := p.Build()
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if !bytes.Contains(data, []byte("/Width 3 /Height 2 /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /DCTDecode")) {
+		t.Error("Expected the JPEG data with /DCTDecode")
+	}
+	if !bytes.Contains(data, photo.Bytes()) {
+		t.Error("Expected the JPEG data unchanged")
+	}
+
+	if err := NewPDFWriter().DrawImage([]byte("not an image"), 0, 0, 10, 10); err == nil {
+		t.Error("Expected an error for data that is not an image")
+	}
+}
@@ -0,0 +1,402 @@
+package mark2pdf
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// specExample è un esempio della specifica CommonMark
+type specExample struct {
+	Markdown string `json:"markdown"`
+	HTML     string `json:"html"`
+	Example  int    `json:"example"`
+	Section  string `json:"section"`
+}
+
+func TestCommonMarkSpec(t *testing.T) {
+	data, err := os.ReadFile("testdata/commonmark_spec.json")
+	if err != nil {
+		t.Fatalf("Failed to read spec: %v", err)
+	}
+
+	var examples []specExample
+	if err := json.Unmarshal(data, &examples); err != nil {
+		t.Fatalf("Failed to decode spec: %v", err)
+	}
+
+	for _, ex := range examples {
+		t.Run(fmt.Sprintf("%d_%s", ex.Example, ex.Section), func(t *testing.T) {
+			parser := &MarkdownParser{source: ex.Markdown, strict: true}
+			got := renderSpecHTML(parser.Parse())
+			if got != ex.HTML {
+				t.Errorf("Example %d\nmarkdown: %q\nexpected: %q\ngot:      %q", ex.Example, ex.Markdown, ex.HTML, got)
+			}
+		})
+	}
+}
+
+func TestGFMExtensions(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			"table",
+			"| a | b |\n|:--|--:|\n| c | d |\n",
+			"<table>\n<thead>\n<tr>\n<th align=\"left\">a</th>\n<th align=\"right\">b</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td align=\"left\">c</td>\n<td align=\"right\">d</td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		{
+			"table escaped pipe",
+			"| a |\n| --- |\n| b \\| c |\n",
+			"<table>\n<thead>\n<tr>\n<th>a</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td>b | c</td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		{
+			"table interrupted by blank line",
+			"| a |\n| - |\n| b |\n\nc\n",
+			"<table>\n<thead>\n<tr>\n<th>a</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td>b</td>\n</tr>\n</tbody>\n</table>\n<p>c</p>\n",
+		},
+		{
+			"strikethrough",
+			"~~gone~~ and ~also~\n",
+			"<p><del>gone</del> and <del>also</del></p>\n",
+		},
+		{
+			"strikethrough triple tilde",
+			"a ~~~not~~~ struck\n",
+			"<p>a ~~~not~~~ struck</p>\n",
+		},
+		{
+			"task list",
+			"- [ ] todo\n- [x] done\n",
+			"<ul>\n<li><input disabled=\"\" type=\"checkbox\"> todo</li>\n<li><input checked=\"\" disabled=\"\" type=\"checkbox\"> done</li>\n</ul>\n",
+		},
+		{
+			"autolink www",
+			"visit www.example.com.\n",
+			"<p>visit <a href=\"http://www.example.com\">www.example.com</a>.</p>\n",
+		},
+		{
+			"autolink url",
+			"see https://example.com/path?q=1)\n",
+			"<p>see <a href=\"https://example.com/path?q=1\">https://example.com/path?q=1</a>)</p>\n",
+		},
+		{
+			"autolink email",
+			"mail foo@bar.example\n",
+			"<p>mail <a href=\"mailto:foo@bar.example\">foo@bar.example</a></p>\n",
+		},
+		{
+			"color",
+			"{red}**hot**{/red}\n",
+			"<p><span style=\"color:red\"><strong>hot</strong></span></p>\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := renderSpecHTML(NewMarkdownParser(tt.input).Parse())
+			if got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestPathologicalInput(t *testing.T) {
+	// Inputs that made the parser quadratic: each one took seconds
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"unclosed link destinations", strings.Repeat("[a](", 30000)},
+		{"unclosed image destinations", strings.Repeat("![a](b", 30000)},
+		{"unmatched emphasis closers", strings.Repeat("_a* ", 50000)},
+		{"unmatched emphasis openers", strings.Repeat("*a_ ", 50000)},
+		{"nested list markers", strings.Repeat("- ", 5000) + "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			parser := &MarkdownParser{source: tt.input}
+			parser.Parse()
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("Parsing %d bytes took %v", len(tt.input), elapsed)
+			}
+		})
+	}
+}
+
+// specRenderer produce HTML dall'AST nello stesso formato del renderer di riferimento commonmark.js
+type specRenderer struct {
+	sb           strings.Builder
+	disableTags  int
+	lastOut      string
+	colorsByName map[Color]string
+}
+
+func renderSpecHTML(doc *Document) string {
+	r := &specRenderer{lastOut: "\n", colorsByName: map[Color]string{}}
+	for name, color := range map[string]Color{"red": ColorRed, "blue": ColorBlue, "green": ColorGreen} {
+		r.colorsByName[color] = name
+	}
+	r.blocks(doc.Blocks, false)
+	return r.sb.String()
+}
+
+func (r *specRenderer) out(s string) {
+	r.sb.WriteString(s)
+	r.lastOut = s
+}
+
+func (r *specRenderer) tag(s string) {
+	if r.disableTags > 0 {
+		return
+	}
+	r.out(s)
+}
+
+func (r *specRenderer) cr() {
+	if r.lastOut != "\n" {
+		r.out("\n")
+	}
+}
+
+func (r *specRenderer) blocks(blocks []Block, tight bool) {
+	for _, b := range blocks {
+		r.block(b, tight)
+	}
+}
+
+func (r *specRenderer) inlines(inlines []Inline) {
+	for _, in := range inlines {
+		r.inline(in)
+	}
+}
+
+func (r *specRenderer) block(b Block, tight bool) {
+	switch n := b.(type) {
+	case *Paragraph:
+		if tight {
+			r.inlines(n.Inlines)
+			return
+		}
+		r.cr()
+		r.tag("<p>")
+		r.inlines(n.Inlines)
+		r.tag("</p>")
+		r.cr()
+
+	case *Heading:
+		r.cr()
+		r.tag("<h" + strconv.Itoa(n.Level) + ">")
+		r.inlines(n.Inlines)
+		r.tag("</h" + strconv.Itoa(n.Level) + ">")
+		r.cr()
+
+	case *ThematicBreak:
+		r.cr()
+		r.tag("<hr />")
+		r.cr()
+
+	case *BlockQuote:
+		r.cr()
+		r.tag("<blockquote>")
+		r.cr()
+		r.blocks(n.Blocks, false)
+		r.cr()
+		r.tag("</blockquote>")
+		r.cr()
+
+	case *List:
+		tag := "ul"
+		r.cr()
+		if n.Ordered {
+			tag = "ol"
+			if n.Start != 1 {
+				r.tag(`<ol start="` + strconv.Itoa(n.Start) + `">`)
+			} else {
+				r.tag("<ol>")
+			}
+		} else {
+			r.tag("<ul>")
+		}
+		r.cr()
+		for _, item := range n.Items {
+			r.cr()
+			r.tag("<li>")
+			if item.Task {
+				if item.Checked {
+					r.tag(`<input checked="" disabled="" type="checkbox"> `)
+				} else {
+					r.tag(`<input disabled="" type="checkbox"> `)
+				}
+			}
+			r.blocks(item.Blocks, n.Tight)
+			r.tag("</li>")
+			r.cr()
+		}
+		r.cr()
+		r.tag("</" + tag + ">")
+		r.cr()
+
+	case *CodeBlock:
+		r.cr()
+		if language := n.Language(); language != "" {
+			r.tag(`<pre><code class="language-` + specEscape(language) + `">`)
+		} else {
+			r.tag("<pre><code>")
+		}
+		r.out(specEscape(n.Literal))
+		r.tag("</code></pre>")
+		r.cr()
+
+	case *HTMLBlock:
+		r.cr()
+		r.out(n.Literal)
+		r.cr()
+
+	case *Table:
+		r.cr()
+		r.tag("<table>")
+		r.cr()
+		for i, row := range n.Rows {
+			if row.Header {
+				r.tag("<thead>")
+				r.cr()
+			} else if i > 0 && n.Rows[i-1].Header {
+				r.tag("<tbody>")
+				r.cr()
+			}
+			r.tag("<tr>")
+			r.cr()
+			name := "td"
+			if row.Header {
+				name = "th"
+			}
+			for j, cell := range row.Cells {
+				align := map[Alignment]string{AlignLeft: "left", AlignCenter: "center", AlignRight: "right"}[n.Align[j]]
+				if align != "" {
+					r.tag("<" + name + ` align="` + align + `">`)
+				} else {
+					r.tag("<" + name + ">")
+				}
+				r.inlines(cell.Inlines)
+				r.tag("</" + name + ">")
+				r.cr()
+			}
+			r.tag("</tr>")
+			r.cr()
+			if row.Header {
+				r.tag("</thead>")
+				r.cr()
+			}
+		}
+		if len(n.Rows) > 1 {
+			r.tag("</tbody>")
+			r.cr()
+		}
+		r.tag("</table>")
+		r.cr()
+	}
+}
+
+func (r *specRenderer) inline(in Inline) {
+	switch n := in.(type) {
+	case *Text:
+		r.out(specEscape(n.Value))
+
+	case *SoftBreak:
+		r.out("\n")
+
+	case *LineBreak:
+		r.tag("<br />")
+		r.cr()
+
+	case *CodeSpan:
+		r.tag("<code>")
+		r.out(specEscape(n.Value))
+		r.tag("</code>")
+
+	case *HTMLInline:
+		r.out(n.Literal)
+
+	case *Emphasis:
+		r.tag("<em>")
+		r.inlines(n.Inlines)
+		r.tag("</em>")
+
+	case *Strong:
+		r.tag("<strong>")
+		r.inlines(n.Inlines)
+		r.tag("</strong>")
+
+	case *Strikethrough:
+		r.tag("<del>")
+		r.inlines(n.Inlines)
+		r.tag("</del>")
+
+	case *ColorSpan:
+		r.tag(`<span style="color:` + r.colorsByName[n.Color] + `">`)
+		r.inlines(n.Inlines)
+		r.tag("</span>")
+
+	case *Link:
+		r.tag(`<a href="` + specEscape(specNormalizeURI(n.Destination)) + `"`)
+		if n.Title != "" {
+			r.tag(` title="` + specEscape(n.Title) + `"`)
+		}
+		r.tag(">")
+		r.inlines(n.Inlines)
+		r.tag("</a>")
+
+	case *Image:
+		if r.disableTags == 0 {
+			r.out(`<img src="` + specEscape(specNormalizeURI(n.Destination)) + `" alt="`)
+		}
+		r.disableTags++
+		r.inlines(n.Inlines)
+		r.disableTags--
+		if r.disableTags == 0 {
+			if n.Title != "" {
+				r.out(`" title="` + specEscape(n.Title))
+			}
+			r.out(`" />`)
+		}
+	}
+}
+
+func specEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(s)
+}
+
+// specNormalizeURI applica il percent-encoding come mdurl.encode
+func specNormalizeURI(uri string) string {
+	const safe = ";/?:@&=+$,-_.!~*'()#"
+	const hex = "0123456789ABCDEF"
+	isHex := func(c byte) bool {
+		return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(uri); i++ {
+		c := uri[i]
+		switch {
+		case c == '%' && i+2 < len(uri) && isHex(uri[i+1]) && isHex(uri[i+2]):
+			sb.WriteString(uri[i : i+3])
+			i += 2
+		case c < 0x80 && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte(safe, c) >= 0):
+			sb.WriteByte(c)
+		default:
+			sb.WriteByte('%')
+			sb.WriteByte(hex[c>>4])
+			sb.WriteByte(hex[c&15])
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,87 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestStreamingOutput(t *testing.T) {
+	markdown := fillerLines(3000) + "## Fine\n\nUltima riga\n"
+
+	expected, err := NewConverter(markdown).Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+
+	// Pages already laid out reach the writer before the document is finished
+	var out bytes.Buffer
+	written := -1
+	converter := NewConverter(markdown)
+	converter.RegisterRenderer(&Heading{}, func(ctx *RenderContext, node Node) error {
+		written = out.Len()
+		return ctx.RenderDefault(node)
+	})
+	if err := converter.ConvertToWriter(&out); err != nil {
+		t.Fatalf("ConvertToWriter failed: %v", err)
+	}
+	data := out.Bytes()
+	if written < len(data)/2 {
+		t.Errorf("Expected most of the %d bytes written before the last heading, got %d", len(data), written)
+	}
+
+	if got, want := pageLines(t, data), pageLines(t, expected); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the same %d pages as Convert, got %d", len(want), len(got))
+	}
+
+	// Every xref entry points at its object
+	m := regexp.MustCompile(`(?s)\nxref\n0 (\d+)\n(.*?)trailer\n`).FindSubmatch(data)
+	if m == nil {
+		t.Fatal("Missing xref table")
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(m[2], -1)
+	if size, _ := strconv.Atoi(string(m[1])); len(entries) != size-1 {
+		t.Fatalf("Expected %d xref entries, got %d", size-1, len(entries))
+	}
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		if prefix := strconv.Itoa(i+1) + " 0 obj\n"; !bytes.HasPrefix(data[offset:], []byte(prefix)) {
+			t.Errorf("Object %d is not at offset %d", i+1, offset)
+		}
+	}
+}
+
+func TestConvertToFileRemovesIncompleteOutput(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "out.pdf")
+
+	// PDF/A without embedded fonts fails only once the document is finished
+	converter := NewConverter("# Titolo\n\nTesto\n")
+	converter.SetPDFA(true)
+	if err := converter.ConvertToFile(filename); err == nil {
+		t.Fatal("Expected an error for PDF/A without embedded fonts")
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("Expected the incomplete file to be removed, got %v", err)
+	}
+}
+
+func TestStreamToTwice(t *testing.T) {
+	p := NewPDFWriter()
+	var out bytes.Buffer
+	if err := p.StreamTo(&out); err != nil {
+		t.Fatalf("StreamTo failed: %v", err)
+	}
+	if err := p.StreamTo(&out); err == nil {
+		t.Error("Expected an error when the output is already started")
+	}
+	if err := p.Finish(); err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+	if err := p.Finish(); err == nil {
+		t.Error("Expected an error when the output is not started")
+	}
+}
@@ -0,0 +1,175 @@
+package mark2pdf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// structElem è un elemento dell'albero di struttura logica di un PDF taggato
+type structElem struct {
+	role     string // tipo di struttura standard: Document, H1, P, L, LI, Table, Link, ...
+	alt      string // testo alternativo (Figure)
+	attrs    string // attributi /A, ad esempio lo /Scope delle celle di intestazione
+	parent   *structElem
+	kids     []structKid
+	attached bool // aggiunto ai figli del genitore, alla prima sequenza di contenuto
+}
+
+// structKid è un figlio di un elemento di struttura: un altro elemento, una
+// sequenza di contenuto marcato (MCID) in una pagina o un'annotazione
+type structKid struct {
+	elem  *structElem
+	page  int
+	mcid  int
+	annot *annotation
+}
+
+// artifact marca il contenuto decorativo (bordi, sfondi, righe) escluso dalla struttura
+var artifact = &structElem{role: "Artifact"}
+
+// attach aggiunge l'elemento e i suoi antenati all'albero: gli elementi senza
+// contenuto non compaiono e i figli seguono l'ordine di lettura
+func (e *structElem) attach() {
+	if e.attached || e.parent == nil {
+		return
+	}
+	e.attached = true
+	e.parent.kids = append(e.parent.kids, structKid{elem: e})
+	e.parent.attach()
+}
+
+// structRole restituisce il tipo di struttura di un blocco, "" per i blocchi senza elemento
+func structRole(block Block) string {
+	switch b := block.(type) {
+	case *Heading:
+		return fmt.Sprintf("H%d", min(max(b.Level, 1), 6))
+	case *Paragraph:
+		return "P"
+	case *CodeBlock:
+		return "Code"
+	case *List:
+		return "L"
+	case *BlockQuote:
+		return "BlockQuote"
+	case *Table:
+		return "Table"
+	}
+	return ""
+}
+
+// SetTagged attiva il PDF taggato: il contenuto è marcato e collegato all'albero di struttura
+func (p *PDFWriter) SetTagged(enabled bool) {
+	p.structRoot, p.structCurrent = nil, nil
+	if enabled {
+		p.structRoot = &structElem{role: "Document", attached: true}
+		p.structCurrent = p.structRoot
+	}
+}
+
+// conformsUA indica se il documento può dichiararsi PDF/UA-1: taggato, con un
+// titolo e con tutti i font usati incorporati, anche quelli delle pagine importate
+func (p *PDFWriter) conformsUA() bool {
+	if p.structRoot == nil || p.title == "" || len(p.importedFonts()) > 0 {
+		return false
+	}
+	for i, font := range p.fonts {
+		if _, ok := p.embedded[font]; !ok && p.resources.used[fmt.Sprintf("F%d", i+1)] {
+			return false
+		}
+	}
+	return true
+}
+
+// beginStruct apre un elemento di struttura figlio di quello corrente; nil se il PDF non è taggato
+func (p *PDFWriter) beginStruct(role string) *structElem {
+	if p.structRoot == nil {
+		return nil
+	}
+	p.structCurrent = p.newStruct(role)
+	return p.structCurrent
+}
+
+// endStruct chiude l'elemento di struttura corrente
+func (p *PDFWriter) endStruct() {
+	if p.structCurrent != nil && p.structCurrent != p.structRoot {
+		p.structCurrent = p.structCurrent.parent
+	}
+}
+
+// newStruct crea un elemento figlio di quello corrente senza aprirlo, per le parti
+// di testo con un proprio elemento (Lbl, Link, Figure); nil se il PDF non è taggato
+func (p *PDFWriter) newStruct(role string) *structElem {
+	if p.structRoot == nil {
+		return nil
+	}
+	return &structElem{role: role, parent: p.structCurrent}
+}
+
+// beginMarked apre una sequenza di contenuto marcato per elem (nil = elemento corrente)
+func (p *PDFWriter) beginMarked(elem *structElem) {
+	if p.structRoot == nil {
+		return
+	}
+	if elem == nil {
+		elem = p.structCurrent
+	}
+	if elem == artifact {
+		p.currentBuf.WriteString("/Artifact BMC\n")
+		return
+	}
+	page := p.currentPage
+	for len(p.parentTree) <= page {
+		p.parentTree = append(p.parentTree, nil)
+	}
+	mcid := len(p.parentTree[page])
+	p.parentTree[page] = append(p.parentTree[page], elem)
+	elem.kids = append(elem.kids, structKid{page: page, mcid: mcid})
+	elem.attach()
+	p.currentBuf.WriteString(fmt.Sprintf("/%s <</MCID %d>> BDC\n", elem.role, mcid))
+}
+
+// endMarked chiude la sequenza di contenuto marcato aperta da beginMarked
+func (p *PDFWriter) endMarked() {
+	if p.structRoot != nil {
+		p.currentBuf.WriteString("EMC\n")
+	}
+}
+
+// structObjects assegna i numeri di oggetto agli elementi di struttura, in ordine di visita
+func (p *PDFWriter) structObjects(alloc func() int) map[*structElem]int {
+	nums := make(map[*structElem]int)
+	var visit func(e *structElem)
+	visit = func(e *structElem) {
+		nums[e] = alloc()
+		for _, kid := range e.kids {
+			if kid.elem != nil {
+				visit(kid.elem)
+			}
+		}
+	}
+	visit(p.structRoot)
+	return nums
+}
+
+// structElemDict restituisce il dizionario di un elemento di struttura; parent è
+// il numero di oggetto del genitore, pageObj quello della pagina i
+func structElemDict(e *structElem, objNum, parent int, nums map[*structElem]int, pageObj func(i int) int, str func(objNum int, s string) string) string {
+	var kids strings.Builder
+	for _, kid := range e.kids {
+		if kid.elem != nil {
+			fmt.Fprintf(&kids, "%d 0 R ", nums[kid.elem])
+		} else if kid.annot != nil {
+			fmt.Fprintf(&kids, "<< /Type /OBJR /Pg %d 0 R /Obj %d 0 R >> ", pageObj(kid.annot.page), kid.annot.num)
+		} else {
+			fmt.Fprintf(&kids, "<< /Type /MCR /Pg %d 0 R /MCID %d >> ", pageObj(kid.page), kid.mcid)
+		}
+	}
+	dict := fmt.Sprintf("<< /Type /StructElem /S /%s /P %d 0 R /K [%s]", e.role, parent, kids.String())
+	if e.alt != "" {
+		dict += " /Alt " + str(objNum, e.alt)
+	}
+	if e.attrs != "" {
+		dict += " /A " + e.attrs
+	}
+	return dict + " >>\n"
+}
@@ -0,0 +1,113 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const taggedMarkdown = `---
+title: Relazione
+lang: it
+---
+# Risultati
+
+Testo con [un link](https://example.com) e ![Logo aziendale](logo.png).
+
+- primo
+- secondo
+
+> Citazione
+
+| Nome | Valore |
+|------|--------|
+| a    | 1      |
+
+` + "```\ncodice\n```\n"
+
+func TestTaggedPDF(t *testing.T) {
+	converter := NewConverter(taggedMarkdown)
+	converter.SetTagged(true)
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+
+	roles := make(map[string]bool)
+	for _, m := range regexp.MustCompile(`/Type /StructElem /S /(\w+)`).FindAllSubmatch(data, -1) {
+		roles[string(m[1])] = true
+	}
+	for _, role := range []string{"Document", "H1", "P", "Link", "Figure", "L", "LI", "Lbl", "LBody", "BlockQuote", "Table", "TR", "TH", "TD", "Code"} {
+		if !roles[role] {
+			t.Errorf("Expected a %s structure element", role)
+		}
+	}
+
+	for _, expected := range []string{
+		"/MarkInfo << /Marked true >>",
+		"/StructTreeRoot ",
+		"/ViewerPreferences << /DisplayDocTitle true >>",
+		"/Lang <" + hex.EncodeToString([]byte("it")) + ">",
+		"/StructParents 0",
+		"/Alt <" + hex.EncodeToString([]byte("Logo aziendale")) + ">",
+		"/Scope /Column",
+	} {
+		if !bytes.Contains(data, []byte(expected)) {
+			t.Errorf("Expected %q in the output", expected)
+		}
+	}
+
+	// Every marked content sequence is referenced by exactly one structure element
+	var content strings.Builder
+	for _, m := range regexp.MustCompile(`(?s)stream\n(.*?)\nendstream`).FindAllSubmatch(data, -1) {
+		if r, err := zlib.NewReader(bytes.NewReader(m[1])); err == nil {
+			b, _ := io.ReadAll(r)
+			content.Write(b)
+		}
+	}
+	marked := strings.Count(content.String(), "BDC\n")
+	references := bytes.Count(data, []byte("/Type /MCR"))
+	if marked == 0 || marked != references {
+		t.Errorf("Expected %d marked content references, got %d", marked, references)
+	}
+	if opened, closed := marked+strings.Count(content.String(), "BMC\n"), strings.Count(content.String(), "EMC\n"); opened != closed {
+		t.Errorf("Expected %d EMC operators, got %d", opened, closed)
+	}
+}
+
+func TestTaggedPDFConformance(t *testing.T) {
+	tests := []struct {
+		name   string
+		tagged bool
+		embed  bool
+		ua     bool
+	}{
+		{"untagged", false, true, false},
+		{"standard fonts", true, false, false},
+		{"embedded fonts", true, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			converter := NewConverter("---\ntitle: Relazione\n---\n# Risultati\n\nTesto\n")
+			converter.SetTagged(tt.tagged)
+			if tt.embed {
+				converter.EmbedFont("Helvetica", testFont("Test", 600))
+			}
+			data, err := converter.Convert()
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			if got := bytes.Contains(data, []byte("<pdfuaid:part>1</pdfuaid:part>")); got != tt.ua {
+				t.Errorf("Expected PDF/UA identification %v, got %v", tt.ua, got)
+			}
+			if got := bytes.Contains(data, []byte("/StructTreeRoot")); got != tt.tagged {
+				t.Errorf("Expected a structure tree %v, got %v", tt.tagged, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,374 @@
+package mark2pdf
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Style è lo stile di un tipo di elemento. I campi vuoti di Font, Size,
+// LineHeight e Color ereditano dallo stile Body del tema.
+type Style struct {
+	Font            string  `json:"font,omitempty"`              // famiglia: helvetica, times, courier
+	Size            float64 `json:"size,omitempty"`              // dimensione in punti
+	FontScale       float64 `json:"font_scale,omitempty"`        // dimensione relativa al testo circostante (inline)
+	Color           *Color  `json:"color,omitempty"`             // colore del testo
+	Bold            bool    `json:"bold,omitempty"`              // testo in grassetto
+	Italic          bool    `json:"italic,omitempty"`            // testo in corsivo
+	Justify         bool    `json:"justify,omitempty"`           // testo giustificato (righe a piena larghezza)
+	Hyphenate       bool    `json:"hyphenate,omitempty"`         // sillabazione nella lingua del documento
+	LineHeight      float64 `json:"line_height,omitempty"`       // interlinea, multiplo di Size
+	Leading         float64 `json:"leading,omitempty"`           // interlinea assoluta in punti, prevale su LineHeight
+	FirstLineIndent float64 `json:"first_line_indent,omitempty"` // rientro della prima riga (paragrafi in stile indent)
+	SpaceBefore     float64 `json:"space_before,omitempty"`      // spazio prima del blocco
+	SpaceAfter      float64 `json:"space_after,omitempty"`       // spazio dopo il blocco
+	Indent          float64 `json:"indent,omitempty"`            // rientro sinistro
+	Padding         float64 `json:"padding,omitempty"`           // spazio interno (celle, sfondo del codice)
+	Background      *Color  `json:"background,omitempty"`        // colore di sfondo
+	BorderColor     *Color  `json:"border_color,omitempty"`      // colore di bordi e linee
+	BorderWidth     float64 `json:"border_width,omitempty"`      // spessore di bordi e linee
+	PageBreak       string  `json:"page_break,omitempty"`        // titoli: PageBreakNew o PageBreakOdd prima del titolo
+}
+
+// Theme raccoglie gli stili degli elementi del documento
+type Theme struct {
+	Name   string  `json:"name"`
+	Margin float64 `json:"margin"` // margine della pagina in punti
+
+	// ParagraphStyle separa i paragrafi consecutivi con Body.SpaceAfter
+	// (ParagraphBlock) o con il rientro Body.FirstLineIndent (ParagraphIndent)
+	ParagraphStyle string `json:"paragraph_style,omitempty"`
+	// BaselineGrid allinea le righe di testo a una griglia con passo pari
+	// all'interlinea di Body, per un ritmo verticale costante
+	BaselineGrid bool `json:"baseline_grid,omitempty"`
+	// LineBreaking sceglie come dividere i paragrafi in righe: LineBreakGreedy
+	// o LineBreakOptimal (Knuth–Plass, consigliato con il testo giustificato)
+	LineBreaking string `json:"line_breaking,omitempty"`
+	// HyphenMinLeft e HyphenMinRight sono i caratteri minimi che la sillabazione
+	// lascia prima e dopo il trattino; 0 usa i valori della lingua
+	HyphenMinLeft  int `json:"hyphen_min_left,omitempty"`
+	HyphenMinRight int `json:"hyphen_min_right,omitempty"`
+	// KeepWithNext è il numero di righe del blocco successivo che restano sulla
+	// stessa pagina di un titolo; 0 disattiva il controllo
+	KeepWithNext int `json:"keep_with_next"`
+	// Orphans e Widows sono le righe minime di un paragrafo diviso tra due pagine
+	// in fondo alla prima e in cima alla seconda
+	Orphans int `json:"orphans"`
+	Widows  int `json:"widows"`
+	// KeepTogether è il numero massimo di righe di un blocco di codice che non
+	// viene diviso tra due pagine
+	KeepTogether int `json:"keep_together"`
+	// Numbering aggiunge ai titoli numeri gerarchici ("2.3.1")
+	Numbering Numbering `json:"numbering"`
+
+	Body        Style `json:"body"` // paragrafi e stile di base
+	H1          Style `json:"h1"`   // titoli di livello 1-6
+	H2          Style `json:"h2"`
+	H3          Style `json:"h3"`
+	H4          Style `json:"h4"`
+	H5          Style `json:"h5"`
+	H6          Style `json:"h6"`
+	Code        Style `json:"code"`         // blocchi di codice
+	InlineCode  Style `json:"inline_code"`  // codice inline: solo Font, FontScale e Color
+	Link        Style `json:"link"`         // link: solo FontScale, Color, Bold e Italic
+	Blockquote  Style `json:"blockquote"`   // citazioni
+	List        Style `json:"list"`         // liste; Indent è il rientro dei livelli annidati
+	Table       Style `json:"table"`        // celle di tabella e bordi
+	TableHeader Style `json:"table_header"` // celle di intestazione
+	Rule        Style `json:"rule"`         // linee orizzontali
+}
+
+// Interruzioni di pagina prima dei titoli (Style.PageBreak)
+const (
+	PageBreakNew = "page" // il titolo inizia una nuova pagina
+	PageBreakOdd = "odd"  // il titolo inizia una nuova pagina dispari, per la stampa fronte-retro
+)
+
+// Stili di separazione dei paragrafi
+const (
+	ParagraphBlock  = "block"
+	ParagraphIndent = "indent"
+)
+
+// fontFamilies associa a ogni famiglia i font standard regolare, grassetto e corsivo
+var fontFamilies = map[string][3]string{
+	"helvetica": {"Helvetica", "Helvetica-Bold", "Helvetica-Oblique"},
+	"times":     {"Times-Roman", "Times-Bold", "Times-Italic"},
+	"courier":   {"Courier", "Courier-Bold", "Courier-Oblique"},
+}
+
+// builtinThemes sono i temi inclusi; ogni chiamata restituisce una copia nuova
+var builtinThemes = map[string]func() *Theme{
+	"default":  DefaultTheme,
+	"github":   githubTheme,
+	"academic": academicTheme,
+	"compact":  compactTheme,
+}
+
+// colorPtr restituisce un puntatore a una copia del colore
+func colorPtr(c Color) *Color {
+	return &c
+}
+
+// DefaultTheme restituisce il tema di default
+func DefaultTheme() *Theme {
+	return &Theme{
+		Name:           "default",
+		Margin:         50,
+		ParagraphStyle: ParagraphBlock,
+		LineBreaking:   LineBreakGreedy,
+		KeepWithNext:   2,
+		Orphans:        2,
+		Widows:         2,
+		KeepTogether:   15,
+		Body:           Style{Font: "helvetica", Size: 10, LineHeight: 1.5, SpaceAfter: 8},
+		H1:             Style{Size: 24, SpaceBefore: 10, SpaceAfter: 5},
+		H2:             Style{Size: 20, SpaceBefore: 8, SpaceAfter: 4},
+		H3:             Style{Size: 16, SpaceBefore: 6, SpaceAfter: 3},
+		H4:             Style{Size: 14, SpaceBefore: 5, SpaceAfter: 2},
+		H5:             Style{Size: 12, SpaceBefore: 4, SpaceAfter: 2},
+		H6:             Style{Size: 11, SpaceBefore: 3, SpaceAfter: 2},
+		Code:           Style{Font: "courier", Size: 9, SpaceBefore: 5, SpaceAfter: 5, Indent: 10},
+		InlineCode:     Style{Font: "courier"},
+		Blockquote:     Style{SpaceBefore: 5, SpaceAfter: 5},
+		List:           Style{SpaceBefore: 3, SpaceAfter: 5, Indent: 15},
+		Table:          Style{SpaceBefore: 5, SpaceAfter: 10, Padding: 10, BorderWidth: 1},
+		TableHeader:    Style{Bold: true, BorderWidth: 1.5},
+		Rule:           Style{SpaceBefore: 15, SpaceAfter: 25, BorderWidth: 1},
+	}
+}
+
+// githubTheme ricorda lo stile dei README su GitHub
+func githubTheme() *Theme {
+	t := DefaultTheme()
+	t.Name = "github"
+	t.Body = Style{Font: "helvetica", Size: 10.5, Color: colorPtr(NewColor(0x1f, 0x23, 0x28)), LineHeight: 1.5, SpaceAfter: 10}
+	t.H1 = Style{Size: 21, Bold: true, SpaceBefore: 16, SpaceAfter: 10}
+	t.H2 = Style{Size: 16, Bold: true, SpaceBefore: 16, SpaceAfter: 8}
+	t.H3 = Style{Size: 13, Bold: true, SpaceBefore: 12, SpaceAfter: 6}
+	t.H4 = Style{Size: 10.5, Bold: true, SpaceBefore: 12, SpaceAfter: 6}
+	t.H5 = Style{Size: 9.5, Bold: true, SpaceBefore: 10, SpaceAfter: 4}
+	t.H6 = Style{Size: 9, Bold: true, Color: colorPtr(NewColor(0x59, 0x63, 0x6e)), SpaceBefore: 10, SpaceAfter: 4}
+	t.Code = Style{Font: "courier", Size: 9, SpaceBefore: 4, SpaceAfter: 12, Indent: 8, Padding: 8,
+		Background: colorPtr(NewColor(0xf6, 0xf8, 0xfa))}
+	t.InlineCode = Style{Font: "courier", FontScale: 0.9}
+	t.Link = Style{Color: colorPtr(NewColor(0x09, 0x69, 0xda))}
+	t.Blockquote = Style{Color: colorPtr(NewColor(0x59, 0x63, 0x6e)), SpaceBefore: 4, SpaceAfter: 8}
+	t.Table = Style{SpaceBefore: 4, SpaceAfter: 12, Padding: 8, BorderWidth: 0.75,
+		BorderColor: colorPtr(NewColor(0xd1, 0xd9, 0xe0))}
+	t.TableHeader = Style{Bold: true, BorderWidth: 0.75, BorderColor: colorPtr(NewColor(0xd1, 0xd9, 0xe0)),
+		Background: colorPtr(NewColor(0xf6, 0xf8, 0xfa))}
+	t.Rule = Style{SpaceBefore: 12, SpaceAfter: 22, BorderWidth: 3, BorderColor: colorPtr(NewColor(0xd1, 0xd9, 0xe0))}
+	return t
+}
+
+// academicTheme è uno stile da articolo: Times, margini ampi, colori sobri
+func academicTheme() *Theme {
+	t := DefaultTheme()
+	t.Name = "academic"
+	t.Margin = 72
+	t.ParagraphStyle = ParagraphIndent
+	t.BaselineGrid = true
+	t.LineBreaking = LineBreakOptimal
+	t.Body = Style{Font: "times", Size: 11, Leading: 16, FirstLineIndent: 18, SpaceAfter: 8, Justify: true, Hyphenate: true}
+	t.H1 = Style{Size: 18, Bold: true, SpaceBefore: 18, SpaceAfter: 8}
+	t.H2 = Style{Size: 14, Bold: true, SpaceBefore: 14, SpaceAfter: 6}
+	t.H3 = Style{Size: 12, Bold: true, SpaceBefore: 12, SpaceAfter: 4}
+	t.H4 = Style{Size: 11, Bold: true, SpaceBefore: 10, SpaceAfter: 3}
+	t.H5 = Style{Size: 11, Italic: true, SpaceBefore: 8, SpaceAfter: 2}
+	t.H6 = Style{Size: 11, Italic: true, SpaceBefore: 6, SpaceAfter: 2}
+	t.Code = Style{Font: "courier", Size: 9, LineHeight: 1.3, SpaceBefore: 6, SpaceAfter: 8, Indent: 18}
+	t.Link = Style{Color: colorPtr(NewColor(0x1a, 0x3d, 0x7c))}
+	t.Blockquote = Style{Italic: true, Indent: 18, SpaceBefore: 6, SpaceAfter: 6}
+	t.List = Style{SpaceBefore: 3, SpaceAfter: 6, Indent: 18}
+	t.Table = Style{SpaceBefore: 6, SpaceAfter: 10, Padding: 6, BorderWidth: 0.5}
+	t.TableHeader = Style{Bold: true, BorderWidth: 1}
+	t.Rule = Style{SpaceBefore: 12, SpaceAfter: 18, BorderWidth: 0.5}
+	return t
+}
+
+// compactTheme riduce dimensioni e spazi per documenti densi
+func compactTheme() *Theme {
+	t := DefaultTheme()
+	t.Name = "compact"
+	t.Margin = 36
+	t.Body = Style{Font: "helvetica", Size: 9, LineHeight: 1.25, SpaceAfter: 4}
+	t.H1 = Style{Size: 16, Bold: true, SpaceBefore: 6, SpaceAfter: 3}
+	t.H2 = Style{Size: 14, Bold: true, SpaceBefore: 5, SpaceAfter: 2}
+	t.H3 = Style{Size: 12, Bold: true, SpaceBefore: 4, SpaceAfter: 2}
+	t.H4 = Style{Size: 10, Bold: true, SpaceBefore: 3, SpaceAfter: 1}
+	t.H5 = Style{Size: 9, Bold: true, SpaceBefore: 3, SpaceAfter: 1}
+	t.H6 = Style{Size: 9, Italic: true, SpaceBefore: 3, SpaceAfter: 1}
+	t.Code = Style{Font: "courier", Size: 8, LineHeight: 1.2, SpaceBefore: 2, SpaceAfter: 4, Indent: 8}
+	t.Blockquote = Style{SpaceBefore: 2, SpaceAfter: 2}
+	t.List = Style{SpaceBefore: 1, SpaceAfter: 3, Indent: 10}
+	t.Table = Style{SpaceBefore: 2, SpaceAfter: 6, Padding: 5, BorderWidth: 0.5}
+	t.TableHeader = Style{Bold: true, BorderWidth: 1}
+	t.Rule = Style{SpaceBefore: 6, SpaceAfter: 10, BorderWidth: 0.5}
+	return t
+}
+
+// BuiltinTheme restituisce una copia del tema incluso con il nome indicato
+func BuiltinTheme(name string) (*Theme, bool) {
+	newTheme, ok := builtinThemes[strings.ToLower(name)]
+	if !ok {
+		return nil, false
+	}
+	return newTheme(), true
+}
+
+// ThemeNames restituisce i nomi dei temi inclusi, in ordine alfabetico
+func ThemeNames() []string {
+	names := make([]string, 0, len(builtinThemes))
+	for name := range builtinThemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseTheme legge un tema in formato JSON. I campi assenti sono presi dal
+// tema indicato da "extends" (default se non specificato).
+func ParseTheme(data []byte) (*Theme, error) {
+	var header struct {
+		Extends string `json:"extends"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("tema non valido: %w", err)
+	}
+
+	base := "default"
+	if header.Extends != "" {
+		base = header.Extends
+	}
+	theme, ok := BuiltinTheme(base)
+	if !ok {
+		return nil, fmt.Errorf("tema base sconosciuto: %q", base)
+	}
+	if err := json.Unmarshal(data, theme); err != nil {
+		return nil, fmt.Errorf("tema non valido: %w", err)
+	}
+	if err := theme.validate(); err != nil {
+		return nil, err
+	}
+	return theme, nil
+}
+
+// LoadTheme carica un tema da un file JSON
+func LoadTheme(path string) (*Theme, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("errore lettura tema: %w", err)
+	}
+	return ParseTheme(data)
+}
+
+// styles restituisce gli stili del tema con il loro nome JSON
+func (t *Theme) styles() map[string]*Style {
+	return map[string]*Style{
+		"body": &t.Body, "h1": &t.H1, "h2": &t.H2, "h3": &t.H3, "h4": &t.H4, "h5": &t.H5, "h6": &t.H6,
+		"code": &t.Code, "inline_code": &t.InlineCode, "link": &t.Link, "blockquote": &t.Blockquote,
+		"list": &t.List, "table": &t.Table, "table_header": &t.TableHeader, "rule": &t.Rule,
+	}
+}
+
+// validate controlla famiglie di font e valori numerici del tema
+func (t *Theme) validate() error {
+	if t.Margin < 0 {
+		return fmt.Errorf("tema %q: margine negativo", t.Name)
+	}
+	if t.HyphenMinLeft < 0 || t.HyphenMinRight < 0 {
+		return fmt.Errorf("tema %q: hyphen_min_left e hyphen_min_right non possono essere negativi", t.Name)
+	}
+	if err := t.Numbering.validate(); err != nil {
+		return fmt.Errorf("tema %q: %v", t.Name, err)
+	}
+	if t.KeepWithNext < 0 || t.Orphans < 0 || t.Widows < 0 || t.KeepTogether < 0 {
+		return fmt.Errorf("tema %q: keep_with_next, orphans, widows e keep_together non possono essere negativi", t.Name)
+	}
+	if t.Body.Size <= 0 || t.Body.LineHeight <= 0 && t.Body.Leading <= 0 {
+		return fmt.Errorf("tema %q: body richiede size e line_height (o leading) positivi", t.Name)
+	}
+	if t.ParagraphStyle != "" && t.ParagraphStyle != ParagraphBlock && t.ParagraphStyle != ParagraphIndent {
+		return fmt.Errorf("tema %q: paragraph_style sconosciuto %q", t.Name, t.ParagraphStyle)
+	}
+	if t.LineBreaking != "" && t.LineBreaking != LineBreakGreedy && t.LineBreaking != LineBreakOptimal {
+		return fmt.Errorf("tema %q: line_breaking sconosciuto %q", t.Name, t.LineBreaking)
+	}
+	for name, s := range t.styles() {
+		if _, ok := fontFamilies[strings.ToLower(s.Font)]; s.Font != "" && !ok {
+			return fmt.Errorf("tema %q: font sconosciuto %q in %s", t.Name, s.Font, name)
+		}
+		if s.Size < 0 || s.FontScale < 0 || s.LineHeight < 0 || s.Leading < 0 || s.Padding < 0 || s.BorderWidth < 0 {
+			return fmt.Errorf("tema %q: valori negativi in %s", t.Name, name)
+		}
+		if s.PageBreak != "" && s.PageBreak != PageBreakNew && s.PageBreak != PageBreakOdd {
+			return fmt.Errorf("tema %q: page_break sconosciuto %q in %s", t.Name, s.PageBreak, name)
+		}
+	}
+	return nil
+}
+
+// heading restituisce lo stile del titolo di livello 1-6
+func (t *Theme) heading(level int) Style {
+	return [6]Style{t.H1, t.H2, t.H3, t.H4, t.H5, t.H6}[min(max(level, 1), 6)-1]
+}
+
+// resolve completa uno stile con i valori ereditati da Body
+func (t *Theme) resolve(s Style) Style {
+	if s.Font == "" {
+		s.Font = t.Body.Font
+	}
+	if s.Size == 0 {
+		s.Size = t.Body.Size
+	}
+	if s.LineHeight == 0 {
+		s.LineHeight = t.Body.LineHeight
+		if s.LineHeight == 0 {
+			// Body has an absolute leading: derive the ratio for other sizes
+			s.LineHeight = t.Body.Leading / t.Body.Size
+		}
+	}
+	if s.Color == nil {
+		s.Color = t.Body.Color
+	}
+	return s
+}
+
+// text restituisce lo stile del corpo per il testo che non è un paragrafo
+// (voci di lista, etichette), senza rientro della prima riga
+func (t *Theme) text() Style {
+	s := t.resolve(t.Body)
+	s.FirstLineIndent = 0
+	return s
+}
+
+// lineAdvance restituisce la distanza tra le linee di base dello stile
+func (s Style) lineAdvance() float64 {
+	if s.Leading > 0 {
+		return max(s.Leading, s.Size)
+	}
+	return s.Size * s.LineHeight
+}
+
+// MarshalJSON scrive il colore come "#rrggbb"
+func (c Color) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("#%02x%02x%02x",
+		int(c.R*255+0.5), int(c.G*255+0.5), int(c.B*255+0.5)))
+}
+
+// UnmarshalJSON legge un colore per nome, "#rrggbb" o "rgb(r,g,b)"
+func (c *Color) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	color := parseColorName(s)
+	if color == nil {
+		return fmt.Errorf("colore non valido: %q", s)
+	}
+	*c = *color
+	return nil
+}
@@ -0,0 +1,197 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinThemes(t *testing.T) {
+	for _, name := range ThemeNames() {
+		t.Run(name, func(t *testing.T) {
+			theme, ok := BuiltinTheme(name)
+			if !ok {
+				t.Fatalf("Expected built-in theme %q", name)
+			}
+			if err := theme.validate(); err != nil {
+				t.Errorf("Expected valid theme, got %v", err)
+			}
+
+			converter := NewConverter("# Title\n\nText with `code`\n\n```\ncode\n```\n\n| a |\n|---|\n| b |\n")
+			converter.SetTheme(theme)
+			if _, err := converter.Convert(); err != nil {
+				t.Errorf("Convert failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseTheme(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		check   func(*Theme) bool
+		wantErr string
+	}{
+		{
+			"override on default",
+			`{"name": "mine", "body": {"size": 12}}`,
+			func(th *Theme) bool { return th.Body.Size == 12 && th.Body.Font == "helvetica" && th.H1.Size == 24 },
+			"",
+		},
+		{
+			"extends built-in",
+			`{"extends": "academic", "h1": {"size": 20, "color": "#336699"}}`,
+			func(th *Theme) bool {
+				return th.Body.Font == "times" && th.H1.Size == 20 && th.H1.Bold && *th.H1.Color == NewColor(0x33, 0x66, 0x99)
+			},
+			"",
+		},
+		{"unknown base", `{"extends": "nope"}`, nil, "tema base sconosciuto"},
+		{"unknown font", `{"code": {"font": "comic"}}`, nil, "font sconosciuto"},
+		{"invalid color", `{"link": {"color": "blu"}}`, nil, "colore non valido"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			theme, err := ParseTheme([]byte(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTheme failed: %v", err)
+			}
+			if !tt.check(theme) {
+				t.Errorf("Unexpected theme: %+v", theme)
+			}
+		})
+	}
+}
+
+func TestThemeJSONRoundTrip(t *testing.T) {
+	theme, _ := BuiltinTheme("github")
+	data, err := json.Marshal(theme)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !bytes.Contains(data, []byte(`"background":"#f6f8fa"`)) {
+		t.Errorf("Expected hex colors in JSON, got %s", data)
+	}
+
+	parsed, err := ParseTheme(data)
+	if err != nil {
+		t.Fatalf("ParseTheme failed: %v", err)
+	}
+	if *parsed.Code.Background != *theme.Code.Background || parsed.H1 != (Style{Size: 21, Bold: true, SpaceBefore: 16, SpaceAfter: 10}) {
+		t.Errorf("Expected round trip to preserve the theme, got %+v", parsed)
+	}
+}
+
+func TestConvertWithTheme(t *testing.T) {
+	theme, err := ParseTheme([]byte(`{"body": {"font": "times", "color": "#800000"}, "h2": {"bold": true}}`))
+	if err != nil {
+		t.Fatalf("ParseTheme failed: %v", err)
+	}
+	converter := NewConverter("## Heading\n\nplain *emphasis*\n")
+	converter.SetTheme(theme)
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+
+	// F5 is the first font registered after the four built-in ones
+	content := pdfContent(t, data)
+	for _, want := range []string{
+		"/F5 20.00 Tf\n(Heading) Tj",
+		"0.502 0.000 0.000 rg\n/F6 10.00 Tf\n(plain) Tj",
+		"/F7 10.00 Tf\n( emphasis) Tj",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("Expected %q in content:\n%s", want, content)
+		}
+	}
+	for _, font := range []string{"/BaseFont /Times-Bold", "/BaseFont /Times-Roman", "/BaseFont /Times-Italic"} {
+		if !bytes.Contains(data, []byte(font)) {
+			t.Errorf("Expected %s in the PDF", font)
+		}
+	}
+}
+
+// textPositions restituisce le coordinate degli operatori Td nel contenuto
+func textPositions(content string) [][2]float64 {
+	var positions [][2]float64
+	for _, line := range strings.Split(content, "\n") {
+		var x, y float64
+		if _, err := fmt.Sscanf(line, "%f %f Td", &x, &y); err == nil {
+			positions = append(positions, [2]float64{x, y})
+		}
+	}
+	return positions
+}
+
+func TestLineSpacing(t *testing.T) {
+	tests := []struct {
+		name     string
+		theme    string
+		markdown string
+		expected [][2]float64
+	}{
+		{
+			"line height ratio",
+			`{"body": {"size": 10, "line_height": 2}}`,
+			"one\\\ntwo\n",
+			[][2]float64{{50, 791.89}, {50, 771.89}},
+		},
+		{
+			"absolute leading",
+			`{"body": {"size": 10, "leading": 14}}`,
+			"one\\\ntwo\n",
+			[][2]float64{{50, 791.89}, {50, 777.89}},
+		},
+		{
+			// The larger inline code lowers its line by the difference in leading
+			"mixed sizes",
+			`{"inline_code": {"font_scale": 2}}`,
+			"one\\\n`two`\\\nthree\n",
+			[][2]float64{{50, 791.89}, {50, 761.89}, {50, 746.89}},
+		},
+		{
+			"indent paragraphs",
+			`{"paragraph_style": "indent", "body": {"first_line_indent": 20}}`,
+			"one\n\ntwo\n\n- item\n\nthree\n",
+			[][2]float64{{50, 791.89}, {70, 776.89}, {50, 750.89}, {50, 730.89}},
+		},
+		{
+			// Headings of any size keep the following text on the 15pt grid
+			"baseline grid",
+			`{"baseline_grid": true}`,
+			"one\n\n## Two\n\nthree\n",
+			[][2]float64{{50, 791.89}, {50, 746.89}, {50, 701.89}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			theme, err := ParseTheme([]byte(tt.theme))
+			if err != nil {
+				t.Fatalf("ParseTheme failed: %v", err)
+			}
+			converter := NewConverter(tt.markdown)
+			converter.SetTheme(theme)
+			data, err := converter.Convert()
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+
+			got := textPositions(pdfContent(t, data))
+			if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Errorf("Expected positions %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
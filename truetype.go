@@ -0,0 +1,302 @@
+package mark2pdf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// trueTypeFont è un font TrueType da incorporare nel PDF al posto di un font standard
+type trueTypeFont struct {
+	data           []byte
+	postScriptName string
+	unitsPerEm     int
+	bbox           [4]int // xMin, yMin, xMax, yMax in unità del font
+	ascent         int
+	descent        int
+	capHeight      int
+	weight         int
+	italicAngle    float64
+	fixedPitch     bool
+	advances       []uint16        // larghezza di ogni glifo
+	glyphs         map[rune]uint16 // cmap Unicode
+}
+
+// errFontTable segnala una tabella mancante o troncata
+var errFontTable = errors.New("font TrueType non valido")
+
+// parseTrueType legge le tabelle di un font TrueType necessarie per incorporarlo
+func parseTrueType(data []byte) (*trueTypeFont, error) {
+	if len(data) < 12 {
+		return nil, errFontTable
+	}
+	switch string(data[:4]) {
+	case "\x00\x01\x00\x00", "true":
+	case "OTTO":
+		return nil, errors.New("font OpenType con contorni CFF non supportato: serve un font TrueType")
+	case "ttcf":
+		return nil, errors.New("collezioni TrueType non supportate: estrai un singolo font")
+	default:
+		return nil, errFontTable
+	}
+
+	tables := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint16(data[4:]))
+	for i := 0; i < count; i++ {
+		record := 12 + 16*i
+		if record+16 > len(data) {
+			return nil, errFontTable
+		}
+		offset := int(binary.BigEndian.Uint32(data[record+8:]))
+		length := int(binary.BigEndian.Uint32(data[record+12:]))
+		if offset < 0 || length < 0 || offset+length > len(data) {
+			return nil, errFontTable
+		}
+		tables[string(data[record:record+4])] = data[offset : offset+length]
+	}
+	for _, name := range []string{"head", "hhea", "hmtx", "maxp", "cmap", "name", "post"} {
+		if _, ok := tables[name]; !ok {
+			return nil, fmt.Errorf("%w: manca la tabella %s", errFontTable, name)
+		}
+	}
+	head, hhea, maxp, post := tables["head"], tables["hhea"], tables["maxp"], tables["post"]
+	if len(head) < 54 || len(hhea) < 36 || len(maxp) < 6 || len(post) < 16 {
+		return nil, errFontTable
+	}
+
+	f := &trueTypeFont{
+		data:        data,
+		unitsPerEm:  int(binary.BigEndian.Uint16(head[18:])),
+		ascent:      int(int16(binary.BigEndian.Uint16(hhea[4:]))),
+		descent:     int(int16(binary.BigEndian.Uint16(hhea[6:]))),
+		weight:      400,
+		italicAngle: float64(int32(binary.BigEndian.Uint32(post[4:]))) / 65536,
+		fixedPitch:  binary.BigEndian.Uint32(post[12:]) != 0,
+	}
+	if f.unitsPerEm == 0 {
+		return nil, errFontTable
+	}
+	for i := range f.bbox {
+		f.bbox[i] = int(int16(binary.BigEndian.Uint16(head[36+2*i:])))
+	}
+	f.capHeight = f.ascent
+
+	if os2 := tables["OS/2"]; len(os2) >= 10 {
+		// Bit 1 (restricted license) and bit 9 (bitmap only) forbid embedding the outlines
+		if fsType := binary.BigEndian.Uint16(os2[8:]); fsType&0x0002 != 0 || fsType&0x0200 != 0 {
+			return nil, errors.New("la licenza del font non ne permette l'incorporazione")
+		}
+		f.weight = int(binary.BigEndian.Uint16(os2[4:]))
+		if version := binary.BigEndian.Uint16(os2); version >= 2 && len(os2) >= 90 {
+			f.capHeight = int(int16(binary.BigEndian.Uint16(os2[88:])))
+		}
+	}
+
+	numGlyphs := int(binary.BigEndian.Uint16(maxp[4:]))
+	numMetrics := int(binary.BigEndian.Uint16(hhea[34:]))
+	hmtx := tables["hmtx"]
+	if numMetrics == 0 || len(hmtx) < 4*numMetrics {
+		return nil, errFontTable
+	}
+	f.advances = make([]uint16, max(numGlyphs, numMetrics))
+	for i := range f.advances {
+		// Glyphs after the last metric share its advance
+		f.advances[i] = binary.BigEndian.Uint16(hmtx[4*min(i, numMetrics-1):])
+	}
+
+	var err error
+	if f.glyphs, err = parseCmap(tables["cmap"]); err != nil {
+		return nil, err
+	}
+	f.postScriptName = parsePostScriptName(tables["name"])
+	if f.postScriptName == "" {
+		return nil, fmt.Errorf("%w: manca il nome PostScript", errFontTable)
+	}
+	return f, nil
+}
+
+// parseCmap legge la cmap Unicode di Windows (3,1 formato 4 o 3,10 formato 12)
+func parseCmap(cmap []byte) (map[rune]uint16, error) {
+	if len(cmap) < 4 {
+		return nil, errFontTable
+	}
+	var subtable []byte
+	for i := 0; i < int(binary.BigEndian.Uint16(cmap[2:])); i++ {
+		record := 4 + 8*i
+		if record+8 > len(cmap) {
+			return nil, errFontTable
+		}
+		platform, encoding := binary.BigEndian.Uint16(cmap[record:]), binary.BigEndian.Uint16(cmap[record+2:])
+		offset := int(binary.BigEndian.Uint32(cmap[record+4:]))
+		if platform == 3 && (encoding == 1 || encoding == 10) && offset+4 <= len(cmap) {
+			// Prefer the full Unicode table when both are present
+			if subtable == nil || encoding == 10 {
+				subtable = cmap[offset:]
+			}
+		}
+	}
+	if subtable == nil {
+		return nil, errors.New("il font non ha una cmap Unicode (3,1): i font simbolici non sono supportati")
+	}
+
+	glyphs := make(map[rune]uint16)
+	switch binary.BigEndian.Uint16(subtable) {
+	case 4:
+		if len(subtable) < 14 {
+			return nil, errFontTable
+		}
+		segments := int(binary.BigEndian.Uint16(subtable[6:])) / 2
+		ends, starts := 14, 16+2*segments
+		deltas, ranges := starts+2*segments, starts+4*segments
+		if ranges+2*segments > len(subtable) {
+			return nil, errFontTable
+		}
+		for s := 0; s < segments; s++ {
+			end := int(binary.BigEndian.Uint16(subtable[ends+2*s:]))
+			start := int(binary.BigEndian.Uint16(subtable[starts+2*s:]))
+			delta := binary.BigEndian.Uint16(subtable[deltas+2*s:])
+			rangeOffset := int(binary.BigEndian.Uint16(subtable[ranges+2*s:]))
+			for c := start; c <= end && c != 0xFFFF; c++ {
+				glyph := uint16(c) + delta
+				if rangeOffset != 0 {
+					// The offset is relative to the idRangeOffset entry itself
+					at := ranges + 2*s + rangeOffset + 2*(c-start)
+					if at+2 > len(subtable) {
+						continue
+					}
+					if glyph = binary.BigEndian.Uint16(subtable[at:]); glyph != 0 {
+						glyph += delta
+					}
+				}
+				if glyph != 0 {
+					glyphs[rune(c)] = glyph
+				}
+			}
+		}
+	case 12:
+		if len(subtable) < 16 {
+			return nil, errFontTable
+		}
+		groups := int(binary.BigEndian.Uint32(subtable[12:]))
+		for g := 0; g < groups && 16+12*g+12 <= len(subtable); g++ {
+			group := subtable[16+12*g:]
+			start, end := binary.BigEndian.Uint32(group), binary.BigEndian.Uint32(group[4:])
+			glyph := binary.BigEndian.Uint32(group[8:])
+			// Only the BMP matters: WinAnsiEncoding has no other characters
+			for c := start; c <= end && c <= 0xFFFF; c++ {
+				glyphs[rune(c)] = uint16(glyph + c - start)
+			}
+		}
+	default:
+		return nil, errors.New("formato della cmap non supportato")
+	}
+	return glyphs, nil
+}
+
+// parsePostScriptName legge il nome PostScript (nameID 6) dalla tabella name
+func parsePostScriptName(name []byte) string {
+	if len(name) < 6 {
+		return ""
+	}
+	count := int(binary.BigEndian.Uint16(name[2:]))
+	storage := int(binary.BigEndian.Uint16(name[4:]))
+	for i := 0; i < count; i++ {
+		record := 6 + 12*i
+		if record+12 > len(name) {
+			break
+		}
+		platform := binary.BigEndian.Uint16(name[record:])
+		nameID := binary.BigEndian.Uint16(name[record+6:])
+		length := int(binary.BigEndian.Uint16(name[record+8:]))
+		offset := storage + int(binary.BigEndian.Uint16(name[record+10:]))
+		if nameID != 6 || offset+length > len(name) {
+			continue
+		}
+		raw := name[offset : offset+length]
+		text := string(raw)
+		if platform == 0 || platform == 3 {
+			units := make([]uint16, len(raw)/2)
+			for j := range units {
+				units[j] = binary.BigEndian.Uint16(raw[2*j:])
+			}
+			text = string(utf16.Decode(units))
+		}
+		// PDF names cannot contain spaces or delimiters
+		return strings.Map(func(r rune) rune {
+			if r <= ' ' || r > '~' || strings.ContainsRune("()<>[]{}/%#", r) {
+				return -1
+			}
+			return r
+		}, text)
+	}
+	return ""
+}
+
+// charWidth restituisce la larghezza di un carattere in millesimi della dimensione
+func (f *trueTypeFont) charWidth(r rune) float64 {
+	switch r {
+	case '\n', '\r', softHyphen:
+		return 0
+	case '\t':
+		return 4 * f.charWidth(' ')
+	}
+	if _, ok := winAnsiByte(r); !ok && (r < 32 || r > 126) {
+		// Written as a space, like with the standard fonts
+		r = ' '
+	}
+	return f.glyphWidth(f.glyphs[r])
+}
+
+// glyphWidth restituisce la larghezza di un glifo in millesimi della dimensione
+func (f *trueTypeFont) glyphWidth(glyph uint16) float64 {
+	if int(glyph) >= len(f.advances) {
+		glyph = 0
+	}
+	return f.scale(int(f.advances[glyph]))
+}
+
+// stringWidth restituisce la larghezza del testo in punti
+func (f *trueTypeFont) stringWidth(text string, fontSize float64) float64 {
+	width := 0.0
+	for _, r := range text {
+		width += f.charWidth(r)
+	}
+	return width * fontSize / 1000
+}
+
+// scale converte unità del font in millesimi della dimensione
+func (f *trueTypeFont) scale(units int) float64 {
+	return float64(units) * 1000 / float64(f.unitsPerEm)
+}
+
+// winAnsiWidths restituisce l'array /Widths per i codici 32-255 di WinAnsiEncoding
+func (f *trueTypeFont) winAnsiWidths() string {
+	widths := make([]string, 0, 224)
+	for c := 32; c <= 255; c++ {
+		w := 0.0
+		if r, ok := winAnsiRune(byte(c)); ok {
+			w = f.glyphWidth(f.glyphs[r])
+		}
+		widths = append(widths, fmt.Sprintf("%.0f", w))
+	}
+	return strings.Join(widths, " ")
+}
+
+// descriptor restituisce il dizionario /FontDescriptor; fileObjNum è lo stream /FontFile2
+func (f *trueTypeFont) descriptor(fileObjNum int) string {
+	flags := 32 // nonsymbolic
+	if f.fixedPitch {
+		flags |= 1
+	}
+	if f.italicAngle != 0 {
+		flags |= 64
+	}
+	// Rough stem width from the weight class, as most PDF producers do
+	stemV := 10 + 220*(max(f.weight, 50)-50)/900
+	return fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags %d /FontBBox [%.0f %.0f %.0f %.0f] "+
+		"/ItalicAngle %g /Ascent %.0f /Descent %.0f /CapHeight %.0f /StemV %d /FontFile2 %d 0 R >>\n",
+		f.postScriptName, flags, f.scale(f.bbox[0]), f.scale(f.bbox[1]), f.scale(f.bbox[2]), f.scale(f.bbox[3]),
+		f.italicAngle, f.scale(f.ascent), f.scale(f.descent), f.scale(f.capHeight), stemV, fileObjNum)
+}
@@ -0,0 +1,169 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Watermark è una filigrana o un timbro ripetuto sulle pagine: un testo ruotato e
+// semitrasparente ("BOZZA", "RISERVATO") o un'immagine, dietro o sopra il contenuto
+type Watermark struct {
+	Text     string  // testo, in Helvetica-Bold
+	Image    []byte  // immagine JPEG o PNG, al posto del testo
+	FontSize float64 // dimensione del testo in punti
+	Width    float64 // larghezza dell'immagine in punti; 0 = metà della pagina
+	Color    Color   // colore del testo
+	Opacity  float64 // 0 trasparente, 1 opaco
+	Angle    float64 // rotazione in gradi, in senso antiorario, attorno al centro della pagina
+	Front    bool    // sopra il contenuto invece che dietro
+	Pages    string  // pagine: "" = tutte, "1", "2-5", "3-", "1,4-6"
+
+	ranges pageRanges
+	image  string  // risorsa dell'immagine
+	aspect float64 // rapporto altezza/larghezza dell'immagine
+}
+
+// NewWatermark crea una filigrana di testo diagonale, grigia e semitrasparente,
+// dietro il contenuto di tutte le pagine
+func NewWatermark(text string) *Watermark {
+	return &Watermark{Text: text, FontSize: 72, Color: ColorGray, Opacity: 0.2, Angle: 45}
+}
+
+// AddWatermark aggiunge una filigrana alle pagine del documento, comprese quelle
+// già scritte ma non ancora inviate all'output; si possono combinare più filigrane
+func (p *PDFWriter) AddWatermark(watermark *Watermark) error {
+	if watermark.Text == "" && len(watermark.Image) == 0 {
+		return errors.New("filigrana senza testo né immagine")
+	}
+	w := *watermark
+	ranges, err := parsePageRanges(w.Pages)
+	if err != nil {
+		return err
+	}
+	w.ranges = ranges
+	if len(w.Image) > 0 {
+		if w.image, err = p.imageResource(w.Image); err != nil {
+			return fmt.Errorf("filigrana: %w", err)
+		}
+		// Already decoded once by imageResource
+		config, _, _ := image.DecodeConfig(bytes.NewReader(w.Image))
+		w.aspect = float64(config.Height) / float64(config.Width)
+	}
+	p.watermarks = append(p.watermarks, &w)
+	return nil
+}
+
+// pageRanges è un elenco di intervalli di pagine, numerate da 1; 0 come fine
+// dell'intervallo indica l'ultima pagina e un elenco vuoto tutte le pagine
+type pageRanges [][2]int
+
+// parsePageRanges legge un elenco di pagine come "1,3-5,8-"
+func parsePageRanges(spec string) (pageRanges, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	var ranges pageRanges
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		first, last, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(strings.TrimSpace(first))
+		to := from
+		if err == nil && isRange {
+			to = 0
+			if last = strings.TrimSpace(last); last != "" {
+				to, err = strconv.Atoi(last)
+			}
+		}
+		if err != nil || from < 1 || (to != 0 && to < from) {
+			return nil, fmt.Errorf("intervallo di pagine non valido %q", part)
+		}
+		ranges = append(ranges, [2]int{from, to})
+	}
+	return ranges, nil
+}
+
+// includes indica se l'elenco comprende la pagina (numerata da 1)
+func (ranges pageRanges) includes(page int) bool {
+	if len(ranges) == 0 {
+		return true
+	}
+	for _, r := range ranges {
+		if page >= r[0] && (r[1] == 0 || page <= r[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// stampPage aggiunge sfondi e filigrane allo stream di contenuto completo della pagina i
+func (p *PDFWriter) stampPage(i int) {
+	var behind, front bytes.Buffer
+	for _, bg := range p.backgrounds {
+		// Pages inserted from other PDFs have their own layout
+		if bg.ranges.includes(i+1) && p.inserted[i] == nil {
+			p.drawBackground(&behind, i, bg)
+		}
+	}
+	for _, w := range p.watermarks {
+		if !w.ranges.includes(i + 1) {
+			continue
+		}
+		if w.Front {
+			p.drawWatermark(&front, i, w)
+		} else {
+			p.drawWatermark(&behind, i, w)
+		}
+	}
+	if behind.Len() == 0 && front.Len() == 0 {
+		return
+	}
+	content := &behind
+	if front.Len() > 0 {
+		// The page content is isolated, so the graphics state it leaves does not affect the overlay
+		content.WriteString("q\n")
+		content.Write(p.pageContents[i].Bytes())
+		content.WriteString("Q\n")
+		content.Write(front.Bytes())
+	} else {
+		content.Write(p.pageContents[i].Bytes())
+	}
+	p.pageContents[i] = content
+}
+
+// drawWatermark disegna una filigrana ruotata attorno al centro della pagina i
+func (p *PDFWriter) drawWatermark(out *bytes.Buffer, page int, w *Watermark) {
+	state := p.opacityState(w.Opacity)
+	p.usePageResource(page, state)
+	if p.structRoot != nil {
+		out.WriteString("/Artifact <</Type /Pagination /Subtype /Watermark>> BDC\n")
+	}
+	box := p.mediaBox(page)
+	angle := w.Angle * math.Pi / 180
+	cos, sin := math.Cos(angle), math.Sin(angle)
+	// 0-sin rather than -sin, so an unrotated watermark does not print -0.0000
+	fmt.Fprintf(out, "q /%s gs %.4f %.4f %.4f %.4f %.2f %.2f cm\n", state, cos, sin, 0-sin, cos, (box[0]+box[2])/2, (box[1]+box[3])/2)
+	if w.image != "" {
+		width := w.Width
+		if width <= 0 {
+			width = (box[2] - box[0]) / 2
+		}
+		height := width * w.aspect
+		p.usePageResource(page, w.image)
+		fmt.Fprintf(out, "%.2f 0 0 %.2f %.2f %.2f cm /%s Do\n", width, height, -width/2, -height/2, w.image)
+	} else {
+		p.usePageResource(page, FontBold)
+		width := p.partWidth(TextPart{Text: w.Text, Font: FontBold}, w.FontSize)
+		// The text is centered on its width and, roughly, on its cap height
+		fmt.Fprintf(out, "BT /%s %.2f Tf %.3f %.3f %.3f rg %.2f %.2f Td (%s) Tj ET\n",
+			FontBold, w.FontSize, w.Color.R, w.Color.G, w.Color.B, -width/2, -w.FontSize*0.35, escapeString(w.Text))
+	}
+	out.WriteString("Q\n")
+	if p.structRoot != nil {
+		out.WriteString("EMC\n")
+	}
+}
@@ -0,0 +1,133 @@
+package mark2pdf
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// pageStreams restituisce gli stream di contenuto di ogni pagina di un PDF scritto con SetDebug
+func pageStreams(t *testing.T, data []byte) []string {
+	t.Helper()
+	var pages []string
+	for _, m := range regexp.MustCompile(`(?s)stream\n% page \d+\n(.*?)endstream`).FindAllSubmatch(data, -1) {
+		pages = append(pages, string(m[1]))
+	}
+	return pages
+}
+
+func TestWatermarkPages(t *testing.T) {
+	markdown := "# Contratto\n\n" + fillerLines(120)
+	tests := []struct {
+		name     string
+		pages    string
+		front    bool
+		expected []bool
+	}{
+		{"all pages behind", "", false, []bool{true, true, true, true}},
+		{"from the second page", "2-", false, []bool{false, true, true, true}},
+		{"list in front", "1,3", true, []bool{true, false, true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			converter := NewConverter(markdown)
+			converter.SetDebug(true)
+			w := NewWatermark("DRAFT")
+			w.Pages = tt.pages
+			w.Front = tt.front
+			converter.SetWatermark(w)
+			data, err := converter.Convert()
+			if err != nil {
+				t.Fatalf("Convert failed: %v", err)
+			}
+			pages := pageStreams(t, data)
+			if len(pages) != len(tt.expected) {
+				t.Fatalf("Expected %d pages, got %d", len(tt.expected), len(pages))
+			}
+			for i, page := range pages {
+				stamp := strings.Index(page, "(DRAFT) Tj")
+				if (stamp >= 0) != tt.expected[i] {
+					t.Errorf("Page %d: expected watermark %v, got %v", i+1, tt.expected[i], stamp >= 0)
+					continue
+				}
+				if stamp < 0 {
+					continue
+				}
+				// Behind the content the watermark comes first, in front it comes last
+				content := strings.Index(page, "Td\n")
+				if tt.front != (stamp > content) {
+					t.Errorf("Page %d: expected front=%v, got the stamp at %d and the content at %d", i+1, tt.front, stamp, content)
+				}
+				if !strings.Contains(page, "0.7071 0.7071 -0.7071 0.7071 297.64 420.94 cm") {
+					t.Errorf("Page %d: expected a 45 degree rotation around the page center", i+1)
+				}
+			}
+			if n := bytes.Count(data, []byte("/Type /ExtGState /ca 0.200 /CA 0.200")); n != 1 {
+				t.Errorf("Expected one shared graphics state, got %d", n)
+			}
+		})
+	}
+}
+
+func TestWatermarkFrontMatter(t *testing.T) {
+	markdown := "---\nwatermark: RISERVATO\nwatermark-pages: 1\n---\n\nTesto\n"
+	converter := NewConverter(markdown)
+	converter.SetDebug(true)
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if pages := pageStreams(t, data); len(pages) != 1 || !strings.Contains(pages[0], "(RISERVATO) Tj") {
+		t.Errorf("Expected the watermark from the front matter, got %q", pages)
+	}
+
+	// SetWatermark takes precedence over the front matter
+	converter = NewConverter(markdown)
+	converter.SetDebug(true)
+	converter.SetWatermark(NewWatermark("BOZZA"))
+	data, err = converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if bytes.Contains(data, []byte("RISERVATO")) || !bytes.Contains(data, []byte("(BOZZA) Tj")) {
+		t.Error("Expected the watermark set with SetWatermark")
+	}
+
+	if _, err := NewConverter("---\nwatermark: BOZZA\nwatermark-pages: 3-1\n---\n").Convert(); err == nil {
+		t.Error("Expected an error for an invalid page range")
+	}
+}
+
+func TestImageWatermark(t *testing.T) {
+	converter := NewConverter("Testo\n\n" + fillerLines(60))
+	converter.SetDebug(true)
+	converter.SetTagged(true)
+	converter.SetWatermark(&Watermark{Image: testPNG(t, 255), Opacity: 0.5, Front: true})
+	data, err := converter.Convert()
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if n := bytes.Count(data, []byte("/Subtype /Image")); n != 1 {
+		t.Errorf("Expected the image written once, got %d", n)
+	}
+	for i, page := range pageStreams(t, data) {
+		for _, expected := range []string{
+			"/Artifact <</Type /Pagination /Subtype /Watermark>> BDC\nq /GS1 gs 1.0000 0.0000 0.0000 1.0000 ",
+			"297.64 0 0 297.64 -148.82 -148.82 cm /Im1 Do\nQ\nEMC\n",
+		} {
+			if !strings.Contains(page, expected) {
+				t.Errorf("Page %d: expected %q", i+1, expected)
+			}
+		}
+	}
+	for _, page := range pageResources(t, data) {
+		if !strings.Contains(page, "/XObject << /Im1 ") {
+			t.Errorf("Expected the image in the page resources, got %s", page)
+		}
+	}
+
+	if err := NewPDFWriter().AddWatermark(&Watermark{Opacity: 0.5}); err == nil {
+		t.Error("Expected an error for a watermark without text or image")
+	}
+}